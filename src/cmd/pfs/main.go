@@ -1,19 +1,31 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pachyderm/pachyderm"
 	"github.com/pachyderm/pachyderm/src/pfs"
 	"github.com/pachyderm/pachyderm/src/pfs/fuse"
+	"github.com/pachyderm/pachyderm/src/pfs/mirror"
 	"github.com/pachyderm/pachyderm/src/pfs/pfsutil"
 	"github.com/pachyderm/pachyderm/src/pkg/cobramainutil"
 	"github.com/pachyderm/pachyderm/src/pkg/mainutil"
+	"github.com/pachyderm/pachyderm/src/pkg/protoutil"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
 )
 
+const (
+	progressInterval = time.Second
+)
+
 var (
 	defaultEnv = map[string]string{
 		"PFS_ADDRESS": "0.0.0.0:650",
@@ -39,6 +51,7 @@ func do(appEnvObj interface{}) error {
 
 	var shard int
 	var modulus int
+	var message string
 
 	initCmd := cobramainutil.Command{
 		Use:     "init repository-name",
@@ -49,6 +62,47 @@ func do(appEnvObj interface{}) error {
 		},
 	}.ToCobraCommand()
 
+	listReposCmd := cobramainutil.Command{
+		Use:  "list-repos",
+		Long: "List repositories.",
+		Run: func(cmd *cobra.Command, args []string) error {
+			listRepositoriesResponse, err := pfsutil.ListRepositories(apiClient)
+			if err != nil {
+				return err
+			}
+			for _, repository := range listRepositoriesResponse.Repository {
+				fmt.Println(repository.Name)
+			}
+			return nil
+		},
+	}.ToCobraCommand()
+
+	inspectRepoCmd := cobramainutil.Command{
+		Use:     "inspect-repo repository-name",
+		Long:    "Get info for a repository.",
+		NumArgs: 1,
+		Run: func(cmd *cobra.Command, args []string) error {
+			inspectRepositoryResponse, err := pfsutil.InspectRepository(apiClient, args[0])
+			if err != nil {
+				return err
+			}
+			if inspectRepositoryResponse.RepositoryInfo == nil {
+				return fmt.Errorf("repository %s not found", args[0])
+			}
+			fmt.Printf("%+v\n", inspectRepositoryResponse.RepositoryInfo)
+			return nil
+		},
+	}.ToCobraCommand()
+
+	deleteRepoCmd := cobramainutil.Command{
+		Use:     "delete-repo repository-name",
+		Long:    "Delete a repository and all of its commits.",
+		NumArgs: 1,
+		Run: func(cmd *cobra.Command, args []string) error {
+			return pfsutil.DeleteRepository(apiClient, args[0])
+		},
+	}.ToCobraCommand()
+
 	mkdirCmd := cobramainutil.Command{
 		Use:     "mkdir repository-name commit-id path/to/dir",
 		Long:    "Make a directory. Sub directories must already exist.",
@@ -58,49 +112,170 @@ func do(appEnvObj interface{}) error {
 		},
 	}.ToCobraCommand()
 
+	var quiet bool
 	putCmd := cobramainutil.Command{
 		Use:     "put repository-name branch-id path/to/file",
-		Long:    "Put a file from stdin. Directories must exist. branch-id must be a writeable commit.",
+		Long:    "Put a file from stdin. Directories must exist. branch-id must be a writeable commit. Progress is reported on stderr.",
 		NumArgs: 3,
 		Run: func(cmd *cobra.Command, args []string) error {
-			_, err := pfsutil.PutFile(apiClient, args[0], args[1], args[2], 0, os.Stdin)
-			return err
+			if quiet {
+				_, err := pfsutil.PutFile(apiClient, args[0], args[1], args[2], 0, os.Stdin)
+				return err
+			}
+			written, err := pfsutil.PutFile(apiClient, args[0], args[1], args[2], 0, &progressReader{reader: os.Stdin})
+			if err != nil {
+				fmt.Fprintln(os.Stderr)
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "\r%d bytes written\n", written)
+			return nil
 		},
 	}.ToCobraCommand()
+	putCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "don't report progress")
 
+	var verify bool
+	var readConsistencyName string
 	getCmd := cobramainutil.Command{
 		Use:     "get repository-name commit-id path/to/file",
 		Long:    "Get a file from stdout. commit-id must be a readable commit.",
 		NumArgs: 3,
 		Run: func(cmd *cobra.Command, args []string) error {
-			return pfsutil.GetFile(apiClient, args[0], args[1], args[2], 0, pfsutil.GetAll, os.Stdout)
+			value, ok := pfs.ReadConsistency_value["READ_CONSISTENCY_"+strings.ToUpper(readConsistencyName)]
+			if !ok {
+				return fmt.Errorf("unknown read consistency %s", readConsistencyName)
+			}
+			readConsistency := pfs.ReadConsistency(value)
+			if !verify {
+				return pfsutil.GetFileWithReadConsistency(apiClient, args[0], args[1], args[2], 0, pfsutil.GetAll, readConsistency, os.Stdout)
+			}
+			getFileInfoResponse, err := pfsutil.GetFileInfoWithReadConsistency(apiClient, args[0], args[1], args[2], readConsistency)
+			if err != nil {
+				return err
+			}
+			if len(getFileInfoResponse.FileInfo.Checksum) == 0 {
+				return fmt.Errorf("%s has no checksum, commit %s must be committed", args[2], args[1])
+			}
+			hash := sha256.New()
+			if err := pfsutil.GetFileWithReadConsistency(apiClient, args[0], args[1], args[2], 0, pfsutil.GetAll, readConsistency, io.MultiWriter(os.Stdout, hash)); err != nil {
+				return err
+			}
+			if checksum := hash.Sum(nil); !bytes.Equal(checksum, getFileInfoResponse.FileInfo.Checksum) {
+				return fmt.Errorf("checksum mismatch for %s: got %x, expected %x", args[2], checksum, getFileInfoResponse.FileInfo.Checksum)
+			}
+			return nil
+		},
+	}.ToCobraCommand()
+	getCmd.Flags().BoolVarP(&verify, "verify", "v", false, "verify the file against its SHA-256 checksum")
+	getCmd.Flags().StringVar(&readConsistencyName, "read-consistency", "any", "servers to read from: any reads committed files from a replica if there is one, master always reads from the master")
+
+	rmCmd := cobramainutil.Command{
+		Use:     "rm repository-name branch-id path/to/file",
+		Long:    "Remove a file or directory. Directories are removed recursively. branch-id must be a writeable commit.",
+		NumArgs: 3,
+		Run: func(cmd *cobra.Command, args []string) error {
+			return pfsutil.DeleteFile(apiClient, args[0], args[1], args[2])
 		},
 	}.ToCobraCommand()
 
+	var recursive bool
+	var pattern string
 	lsCmd := cobramainutil.Command{
 		Use:     "ls repository-name branch-id path/to/dir",
-		Long:    "List a directory. Directory must exist.",
+		Long:    "List a directory. Directory must exist. Files are listed in path order.",
 		NumArgs: 3,
 		Run: func(cmd *cobra.Command, args []string) error {
-			listFilesResponse, err := pfsutil.ListFiles(apiClient, args[0], args[1], args[2], uint64(shard), uint64(modulus))
+			return pfsutil.ListFilesStream(apiClient, args[0], args[1], args[2], recursive, pattern, uint64(shard), uint64(modulus),
+				func(fileInfo *pfs.FileInfo) error {
+					fmt.Printf("%+v\n", fileInfo)
+					return nil
+				},
+			)
+		},
+	}.ToCobraCommand()
+	lsCmd.Flags().IntVarP(&shard, "shard", "s", 0, "shard to read from")
+	lsCmd.Flags().IntVarP(&modulus, "modulus", "m", 1, "modulus of the shards")
+	lsCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "list everything below the directory")
+	lsCmd.Flags().StringVarP(&pattern, "pattern", "p", "", "only list paths matching a pattern, * matches within a path element and ** matches any number of elements")
+
+	diffCmd := cobramainutil.Command{
+		Use:        "diff repository-name commit-id [from-commit-id]",
+		Long:       "List the files changed in a commit. Changes are relative to the commit's parent unless from-commit-id is given.",
+		MinNumArgs: 2,
+		MaxNumArgs: 3,
+		Run: func(cmd *cobra.Command, args []string) error {
+			var fromCommitID string
+			if len(args) == 3 {
+				fromCommitID = args[2]
+			}
+			listChangedFilesResponse, err := pfsutil.ListChangedFiles(apiClient, args[0], fromCommitID, args[1], uint64(shard), uint64(modulus))
 			if err != nil {
 				return err
 			}
-			for _, fileInfo := range listFilesResponse.FileInfo {
-				fmt.Printf("%+v\n", fileInfo)
+			for _, change := range listChangedFilesResponse.Change {
+				fmt.Printf("%s %s\n", change.ChangeType, change.Path.Path)
 			}
 			return nil
 		},
 	}.ToCobraCommand()
-	lsCmd.Flags().IntVarP(&shard, "shard", "s", 0, "shard to read from")
-	lsCmd.Flags().IntVarP(&modulus, "modulus", "m", 1, "modulus of the shards")
+	diffCmd.Flags().IntVarP(&shard, "shard", "s", 0, "shard to read from")
+	diffCmd.Flags().IntVarP(&modulus, "modulus", "m", 1, "modulus of the shards")
+
+	var commitID string
+	logCmd := cobramainutil.Command{
+		Use:     "log repository-name path/to/file",
+		Long:    "List the commits that changed a file, newest first.",
+		NumArgs: 2,
+		Run: func(cmd *cobra.Command, args []string) error {
+			listFileHistoryResponse, err := pfsutil.ListFileHistory(apiClient, args[0], commitID, args[1])
+			if err != nil {
+				return err
+			}
+			for _, fileRevision := range listFileHistoryResponse.FileRevision {
+				finished := "-"
+				if fileRevision.Finished != nil {
+					finished = protoutil.TimestampToTime(fileRevision.Finished).String()
+				}
+				fmt.Printf("%s %s %d %s\n", fileRevision.Commit.Id, fileRevision.ChangeType, fileRevision.SizeBytes, finished)
+			}
+			return nil
+		},
+	}.ToCobraCommand()
+	logCmd.Flags().StringVarP(&commitID, "commit", "c", "master", "commit or branch to start from")
+
+	exportCmd := cobramainutil.Command{
+		Use:        "export repository-name commit-id [path/to/dir]",
+		Long:       "Write a tar archive of a readable commit to stdout, limited to path/to/dir if it is given.",
+		MinNumArgs: 2,
+		MaxNumArgs: 3,
+		Run: func(cmd *cobra.Command, args []string) error {
+			var path string
+			if len(args) == 3 {
+				path = args[2]
+			}
+			return pfsutil.ExportCommit(apiClient, args[0], args[1], path, os.Stdout)
+		},
+	}.ToCobraCommand()
+
+	importCmd := cobramainutil.Command{
+		Use:     "import repository-name commit-id",
+		Long:    "Unpack a tar archive from stdin into a writable commit.",
+		NumArgs: 2,
+		Run: func(cmd *cobra.Command, args []string) error {
+			importTarResponse, err := pfsutil.ImportTar(apiClient, args[0], args[1], os.Stdin)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("imported %d files, %d bytes\n", importTarResponse.NumFiles, importTarResponse.SizeBytes)
+			return nil
+		},
+	}.ToCobraCommand()
 
 	branchCmd := cobramainutil.Command{
 		Use:     "branch repository-name commit-id",
-		Long:    "Branch a commit. commit-id must be a readable commit.",
+		Long:    "Branch a commit. commit-id must be a readable commit or a branch, committing a commit made from a branch advances the branch.",
 		NumArgs: 2,
 		Run: func(cmd *cobra.Command, args []string) error {
-			branchResponse, err := pfsutil.Branch(apiClient, args[0], args[1])
+			branchResponse, err := pfsutil.Branch(apiClient, args[0], args[1], message)
 			if err != nil {
 				return err
 			}
@@ -108,13 +283,60 @@ func do(appEnvObj interface{}) error {
 			return nil
 		},
 	}.ToCobraCommand()
+	branchCmd.Flags().StringVarP(&message, "message", "m", "", "commit message")
+
+	var conflictPolicy string
+	mergeCmd := cobramainutil.Command{
+		Use:     "merge repository-name commit-id other-commit-id",
+		Long:    "Merge the changes other-commit-id made into a new commit branched from commit-id. Both must be readable commits or branches, committing a merge made from a branch advances the branch.",
+		NumArgs: 3,
+		Run: func(cmd *cobra.Command, args []string) error {
+			policy, ok := pfs.ConflictPolicy_value["CONFLICT_POLICY_"+strings.ToUpper(conflictPolicy)]
+			if !ok {
+				return fmt.Errorf("unknown conflict policy %s", conflictPolicy)
+			}
+			mergeResponse, err := pfsutil.Merge(apiClient, args[0], args[1], args[2], pfs.ConflictPolicy(policy), message)
+			if err != nil {
+				return err
+			}
+			fmt.Println(mergeResponse.Commit.Id)
+			return nil
+		},
+	}.ToCobraCommand()
+	mergeCmd.Flags().StringVarP(&conflictPolicy, "conflict-policy", "c", "fail", "what to do with paths both commits changed: fail, ours or theirs")
+	mergeCmd.Flags().StringVarP(&message, "message", "m", "", "commit message")
 
 	commitCmd := cobramainutil.Command{
 		Use:     "commit repository-name branch-id",
 		Long:    "Commit a branch. branch-id must be a writeable commit.",
 		NumArgs: 2,
 		Run: func(cmd *cobra.Command, args []string) error {
-			return pfsutil.Commit(apiClient, args[0], args[1])
+			return pfsutil.Commit(apiClient, args[0], args[1], message)
+		},
+	}.ToCobraCommand()
+	commitCmd.Flags().StringVarP(&message, "message", "m", "", "commit message, replaces the message given to branch")
+
+	squashCmd := cobramainutil.Command{
+		Use:     "squash repository-name from-commit-id to-commit-id",
+		Long:    "Squash the read commits from from-commit-id to to-commit-id into to-commit-id, which takes the parent of from-commit-id. The other commits are deleted.",
+		NumArgs: 3,
+		Run: func(cmd *cobra.Command, args []string) error {
+			squashCommitsResponse, err := pfsutil.SquashCommits(apiClient, args[0], args[1], args[2], message)
+			if err != nil {
+				return err
+			}
+			fmt.Println(squashCommitsResponse.Commit.Id)
+			return nil
+		},
+	}.ToCobraCommand()
+	squashCmd.Flags().StringVarP(&message, "message", "m", "", "commit message, replaces the message of to-commit-id")
+
+	deleteCommitCmd := cobramainutil.Command{
+		Use:     "delete-commit repository-name commit-id",
+		Long:    "Delete a commit and its contents, no branch or other commit may refer to it.",
+		NumArgs: 2,
+		Run: func(cmd *cobra.Command, args []string) error {
+			return pfsutil.DeleteCommit(apiClient, args[0], args[1])
 		},
 	}.ToCobraCommand()
 
@@ -127,7 +349,10 @@ func do(appEnvObj interface{}) error {
 			if err != nil {
 				return err
 			}
-			fmt.Printf("%+v\n", commitInfoResponse.CommitInfo)
+			if commitInfoResponse.CommitInfo == nil {
+				return fmt.Errorf("commit %s not found", args[1])
+			}
+			printCommitInfo(commitInfoResponse.CommitInfo)
 			return nil
 		},
 	}.ToCobraCommand()
@@ -148,6 +373,180 @@ func do(appEnvObj interface{}) error {
 		},
 	}.ToCobraCommand()
 
+	createBranchCmd := cobramainutil.Command{
+		Use:     "create-branch repository-name branch-name commit-id",
+		Long:    "Point a branch at a readable commit, creating the branch if it does not exist.",
+		NumArgs: 3,
+		Run: func(cmd *cobra.Command, args []string) error {
+			return pfsutil.CreateBranch(apiClient, args[0], args[1], args[2])
+		},
+	}.ToCobraCommand()
+
+	listBranchesCmd := cobramainutil.Command{
+		Use:     "list-branches repository-name",
+		Long:    "List branches on the repository.",
+		NumArgs: 1,
+		Run: func(cmd *cobra.Command, args []string) error {
+			listBranchesResponse, err := pfsutil.ListBranches(apiClient, args[0])
+			if err != nil {
+				return err
+			}
+			for _, branchInfo := range listBranchesResponse.BranchInfo {
+				fmt.Printf("%s %s\n", branchInfo.Name, branchInfo.Commit.Id)
+			}
+			return nil
+		},
+	}.ToCobraCommand()
+
+	deleteBranchCmd := cobramainutil.Command{
+		Use:     "delete-branch repository-name branch-name",
+		Long:    "Delete a branch, the commits on it are kept.",
+		NumArgs: 2,
+		Run: func(cmd *cobra.Command, args []string) error {
+			return pfsutil.DeleteBranch(apiClient, args[0], args[1])
+		},
+	}.ToCobraCommand()
+
+	var keepLast uint64
+	var keepNewerThan time.Duration
+	var writeCommitTimeout time.Duration
+	setRetentionCmd := cobramainutil.Command{
+		Use:     "set-retention repository-name",
+		Long:    "Set the retention policy garbage collection applies to the repository, setting no rules removes the policy.",
+		NumArgs: 1,
+		Run: func(cmd *cobra.Command, args []string) error {
+			var retentionPolicy *pfs.RetentionPolicy
+			if keepLast != 0 || keepNewerThan != 0 || writeCommitTimeout != 0 {
+				retentionPolicy = &pfs.RetentionPolicy{
+					KeepLast: keepLast,
+				}
+				if keepNewerThan != 0 {
+					retentionPolicy.KeepNewerThan = protoutil.DurationToProtoDuration(keepNewerThan)
+				}
+				if writeCommitTimeout != 0 {
+					retentionPolicy.WriteCommitTimeout = protoutil.DurationToProtoDuration(writeCommitTimeout)
+				}
+			}
+			return pfsutil.SetRetentionPolicy(apiClient, args[0], retentionPolicy)
+		},
+	}.ToCobraCommand()
+	setRetentionCmd.Flags().Uint64VarP(&keepLast, "keep-last", "l", 0, "keep the newest read commits")
+	setRetentionCmd.Flags().DurationVarP(&keepNewerThan, "keep-newer-than", "n", 0, "keep the read commits finished within this duration")
+	setRetentionCmd.Flags().DurationVarP(&writeCommitTimeout, "write-commit-timeout", "w", 0, "delete the write commits started longer ago than this duration")
+
+	getRetentionCmd := cobramainutil.Command{
+		Use:     "get-retention repository-name",
+		Long:    "Get the retention policy of the repository.",
+		NumArgs: 1,
+		Run: func(cmd *cobra.Command, args []string) error {
+			getRetentionPolicyResponse, err := pfsutil.GetRetentionPolicy(apiClient, args[0])
+			if err != nil {
+				return err
+			}
+			if getRetentionPolicyResponse.RetentionPolicy == nil {
+				return fmt.Errorf("repository %s has no retention policy", args[0])
+			}
+			fmt.Printf("%+v\n", getRetentionPolicyResponse.RetentionPolicy)
+			return nil
+		},
+	}.ToCobraCommand()
+
+	var dryRun bool
+	gcCmd := cobramainutil.Command{
+		Use:        "gc [repository-name]",
+		Long:       "Garbage collect the commits the retention policy doesn't keep, every repository is collected if none is given.",
+		MaxNumArgs: 1,
+		Run: func(cmd *cobra.Command, args []string) error {
+			var repositoryName string
+			if len(args) == 1 {
+				repositoryName = args[0]
+			}
+			garbageCollectResponse, err := pfsutil.GarbageCollect(apiClient, repositoryName, dryRun)
+			if err != nil {
+				return err
+			}
+			for _, commit := range garbageCollectResponse.Deleted {
+				fmt.Printf("deleted %s %s\n", commit.Repository.Name, commit.Id)
+			}
+			for _, commit := range garbageCollectResponse.Squashed {
+				fmt.Printf("squashed %s %s\n", commit.Repository.Name, commit.Id)
+			}
+			return nil
+		},
+	}.ToCobraCommand()
+	gcCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "report what would be collected without collecting it")
+
+	reshardCmd := cobramainutil.Command{
+		Use:     "reshard num-shards",
+		Long:    "Grow the cluster to num-shards shards, the new shards must already have masters and the cluster is read only until it is done.",
+		NumArgs: 1,
+		Run: func(cmd *cobra.Command, args []string) error {
+			numShards, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return err
+			}
+			reshardResponse, err := pfsutil.Reshard(apiClient, numShards)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("copied %d files\n", reshardResponse.NumFiles)
+			return nil
+		},
+	}.ToCobraCommand()
+
+	pushCmd := cobramainutil.Command{
+		Use:     "push repository-name address",
+		Long:    "Copy the commits and branches of a repository that the cluster at address doesn't have to it.",
+		NumArgs: 2,
+		Run: func(cmd *cobra.Command, args []string) error {
+			otherClientConn, err := grpc.Dial(args[1])
+			if err != nil {
+				return err
+			}
+			commits, err := mirror.NewMirrorer(apiClient, pfs.NewApiClient(otherClientConn)).Mirror(args[0])
+			printCommits(commits)
+			return err
+		},
+	}.ToCobraCommand()
+
+	pullCmd := cobramainutil.Command{
+		Use:     "pull repository-name address",
+		Long:    "Copy the commits and branches of a repository that this cluster doesn't have from the cluster at address.",
+		NumArgs: 2,
+		Run: func(cmd *cobra.Command, args []string) error {
+			otherClientConn, err := grpc.Dial(args[1])
+			if err != nil {
+				return err
+			}
+			commits, err := mirror.NewMirrorer(pfs.NewApiClient(otherClientConn), apiClient).Mirror(args[0])
+			printCommits(commits)
+			return err
+		},
+	}.ToCobraCommand()
+
+	var interval time.Duration
+	mirrorCmd := cobramainutil.Command{
+		Use:     "mirror repository-name address",
+		Long:    "Pull a repository from the cluster at address every interval until interrupted, failed pulls are retried.",
+		NumArgs: 2,
+		Run: func(cmd *cobra.Command, args []string) error {
+			otherClientConn, err := grpc.Dial(args[1])
+			if err != nil {
+				return err
+			}
+			mirrorer := mirror.NewMirrorer(pfs.NewApiClient(otherClientConn), apiClient)
+			for {
+				commits, err := mirrorer.Mirror(args[0])
+				printCommits(commits)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+				}
+				time.Sleep(interval)
+			}
+		},
+	}.ToCobraCommand()
+	mirrorCmd.Flags().DurationVarP(&interval, "interval", "i", time.Minute, "how long to wait between pulls")
+
 	mountCmd := cobramainutil.Command{
 		Use:     "mount repository-name",
 		Long:    "Mount a repository as a local file system.",
@@ -169,14 +568,82 @@ The environment variable PFS_ADDRESS controls what server the CLI connects to, t
 
 	rootCmd.AddCommand(cobramainutil.NewVersionCommand(clientConn, pachyderm.Version))
 	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(listReposCmd)
+	rootCmd.AddCommand(inspectRepoCmd)
+	rootCmd.AddCommand(deleteRepoCmd)
 	rootCmd.AddCommand(mkdirCmd)
 	rootCmd.AddCommand(putCmd)
 	rootCmd.AddCommand(getCmd)
+	rootCmd.AddCommand(rmCmd)
 	rootCmd.AddCommand(lsCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(logCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
 	rootCmd.AddCommand(branchCmd)
+	rootCmd.AddCommand(mergeCmd)
 	rootCmd.AddCommand(commitCmd)
+	rootCmd.AddCommand(squashCmd)
+	rootCmd.AddCommand(deleteCommitCmd)
 	rootCmd.AddCommand(commitInfoCmd)
 	rootCmd.AddCommand(listCommitsCmd)
+	rootCmd.AddCommand(createBranchCmd)
+	rootCmd.AddCommand(listBranchesCmd)
+	rootCmd.AddCommand(deleteBranchCmd)
+	rootCmd.AddCommand(setRetentionCmd)
+	rootCmd.AddCommand(getRetentionCmd)
+	rootCmd.AddCommand(gcCmd)
+	rootCmd.AddCommand(reshardCmd)
+	rootCmd.AddCommand(pushCmd)
+	rootCmd.AddCommand(pullCmd)
+	rootCmd.AddCommand(mirrorCmd)
 	rootCmd.AddCommand(mountCmd)
 	return rootCmd.Execute()
 }
+
+func printCommitInfo(commitInfo *pfs.CommitInfo) {
+	fmt.Printf("Commit: %s\n", commitInfo.Commit.Id)
+	fmt.Printf("Type: %s\n", commitInfo.CommitType)
+	if commitInfo.ParentCommit != nil {
+		fmt.Printf("Parent: %s\n", commitInfo.ParentCommit.Id)
+	}
+	if commitInfo.MergeParentCommit != nil {
+		fmt.Printf("Merge parent: %s\n", commitInfo.MergeParentCommit.Id)
+	}
+	if commitInfo.Branch != "" {
+		fmt.Printf("Branch: %s\n", commitInfo.Branch)
+	}
+	if commitInfo.Created != nil {
+		fmt.Printf("Created: %s\n", protoutil.TimestampToTime(commitInfo.Created))
+	}
+	if commitInfo.Finished != nil {
+		fmt.Printf("Finished: %s\n", protoutil.TimestampToTime(commitInfo.Finished))
+	}
+	fmt.Printf("Size: %d\n", commitInfo.SizeBytes)
+	if commitInfo.Message != "" {
+		fmt.Printf("Message: %s\n", commitInfo.Message)
+	}
+}
+
+func printCommits(commits []*pfs.Commit) {
+	for _, commit := range commits {
+		fmt.Println(commit.Id)
+	}
+}
+
+// progressReader reports how many bytes have been read from reader on stderr.
+type progressReader struct {
+	reader   io.Reader
+	read     int64
+	reported time.Time
+}
+
+func (p *progressReader) Read(buffer []byte) (int, error) {
+	n, err := p.reader.Read(buffer)
+	p.read += int64(n)
+	if now := time.Now(); now.Sub(p.reported) >= progressInterval {
+		fmt.Fprintf(os.Stderr, "\r%d bytes sent", p.read)
+		p.reported = now
+	}
+	return n, err
+}
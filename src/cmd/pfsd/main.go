@@ -5,12 +5,16 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log"
 	"os"
+	"time"
 
 	"github.com/pachyderm/pachyderm"
 	"github.com/pachyderm/pachyderm/src/pfs"
 	"github.com/pachyderm/pachyderm/src/pfs/drive"
 	"github.com/pachyderm/pachyderm/src/pfs/drive/btrfs"
+	"github.com/pachyderm/pachyderm/src/pfs/drive/local"
+	"github.com/pachyderm/pachyderm/src/pfs/role"
 	"github.com/pachyderm/pachyderm/src/pfs/route"
 	"github.com/pachyderm/pachyderm/src/pfs/server"
 	"github.com/pachyderm/pachyderm/src/pkg/discovery"
@@ -24,15 +28,51 @@ var (
 		"PFS_NUM_SHARDS":  "16",
 		"PFS_API_PORT":    "650",
 		"PFS_DRIVER_TYPE": "btrfs",
+		// the host the other servers reach this one at, the hostname if
+		// empty
+		"PFS_HOST": "",
+		// replicas per shard, the servers only claim replica roles until
+		// every shard has this many, with 0 the data of a server that dies
+		// is lost
+		"PFS_NUM_REPLICAS": "0",
+		// 0 disables garbage collection
+		"PFS_GC_INTERVAL_SECONDS": "3600",
+		// 0 disables the timeout of calls between servers
+		"PFS_HOP_TIMEOUT_SECONDS": "60",
+		// 0 makes calls to every other server at once
+		"PFS_FAN_OUT_PARALLELISM": "16",
+		// the virtual nodes of each shard on the consistent hash ring, 0
+		// maps paths to shards by their hash modulo the number of shards,
+		// which can't be resharded, clusters started with 0 must keep it
+		"PFS_VIRTUAL_NODES": "64",
+		// shards past PFS_NUM_SHARDS get masters but no paths until a
+		// reshard, after which PFS_NUM_SHARDS must be raised to match,
+		// 0 is PFS_NUM_SHARDS
+		"PFS_MAX_SHARDS": "0",
+		// path or directory, directory keeps the files of a directory on
+		// one shard
+		"PFS_SHARD_BY": "path",
+		// with PFS_SHARD_BY directory, directories below this depth share
+		// the shard of their ancestor at it, 0 is unlimited
+		"PFS_SHARD_DEPTH": "0",
 	}
 )
 
 type appEnv struct {
-	DriverRoot string `env:"PFS_DRIVER_ROOT,required"`
-	DriverType string `env:"PFS_DRIVER_TYPE"`
-	NumShards  int    `env:"PFS_NUM_SHARDS"`
-	APIPort    int    `env:"PFS_API_PORT"`
-	TracePort  int    `env:"PFS_TRACE_PORT"`
+	DriverRoot   string `env:"PFS_DRIVER_ROOT,required"`
+	DriverType   string `env:"PFS_DRIVER_TYPE"`
+	NumShards    int    `env:"PFS_NUM_SHARDS"`
+	NumReplicas  int    `env:"PFS_NUM_REPLICAS"`
+	APIPort      int    `env:"PFS_API_PORT"`
+	Host         string `env:"PFS_HOST"`
+	TracePort    int    `env:"PFS_TRACE_PORT"`
+	GCInterval   int    `env:"PFS_GC_INTERVAL_SECONDS"`
+	HopTimeout   int    `env:"PFS_HOP_TIMEOUT_SECONDS"`
+	FanOut       int    `env:"PFS_FAN_OUT_PARALLELISM"`
+	VirtualNodes int    `env:"PFS_VIRTUAL_NODES"`
+	MaxShards    int    `env:"PFS_MAX_SHARDS"`
+	ShardBy      string `env:"PFS_SHARD_BY"`
+	ShardDepth   int    `env:"PFS_SHARD_DEPTH"`
 }
 
 func main() {
@@ -45,16 +85,18 @@ func do(appEnvObj interface{}) error {
 	if err != nil {
 		return err
 	}
-	address := fmt.Sprintf("0.0.0.0:%d", appEnv.APIPort)
+	// the other servers dial the address we advertise
+	host := appEnv.Host
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	address := fmt.Sprintf("%s:%d", host, appEnv.APIPort)
 	addresser := route.NewDiscoveryAddresser(
 		discoveryClient,
 		"namespace",
 	)
-	for i := 0; i < appEnv.NumShards; i++ {
-		if err := addresser.SetMasterAddress(i, address, 0); err != nil {
-			return err
-		}
-	}
 	var driver drive.Driver
 	switch appEnv.DriverType {
 	case "btrfs":
@@ -62,29 +104,93 @@ func do(appEnvObj interface{}) error {
 		if err != nil {
 			return err
 		}
+	case "local":
+		driver, err = local.NewDriver(appEnv.DriverRoot, "")
+		if err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unknown value for PFS_DRIVER_TYPE: %s", appEnv.DriverType)
 	}
+	sharder := route.NewSharder(appEnv.NumShards)
+	if appEnv.VirtualNodes > 0 {
+		sharder = route.NewConsistentHashSharder(appEnv.NumShards, appEnv.VirtualNodes)
+	}
+	switch appEnv.ShardBy {
+	case "path":
+	case "directory":
+		sharder = route.NewDirectorySharder(sharder, appEnv.ShardDepth)
+	default:
+		return fmt.Errorf("unknown value for PFS_SHARD_BY: %s", appEnv.ShardBy)
+	}
+	// a Reshard stores the number of shards in discovery, it overrides
+	// PFS_NUM_SHARDS
+	numShards, ok, err := addresser.GetNumShards()
+	if err != nil {
+		return err
+	}
+	if ok {
+		if sharder, err = sharder.WithNumShards(numShards); err != nil {
+			return err
+		}
+	} else if err := addresser.SetNumShards(appEnv.NumShards); err != nil {
+		return err
+	}
+	maxShards := appEnv.MaxShards
+	if maxShards < sharder.NumShards() {
+		maxShards = sharder.NumShards()
+	}
 	combinedAPIServer := server.NewCombinedAPIServer(
-		route.NewSharder(
-			appEnv.NumShards,
-		),
+		sharder,
 		route.NewRouter(
 			addresser,
 			grpcutil.NewDialer(),
 			address,
 		),
 		driver,
+		time.Duration(appEnv.HopTimeout)*time.Second,
+		appEnv.FanOut,
 	)
-	return grpcutil.GrpcDo(
-		appEnv.APIPort,
-		appEnv.TracePort,
-		pachyderm.Version,
-		func(s *grpc.Server) {
-			pfs.RegisterApiServer(s, combinedAPIServer)
-			pfs.RegisterInternalApiServer(s, combinedAPIServer)
-		},
-	)
+	// the other servers may be waiting on this one to recover, so it serves
+	// while recovering
+	go func() {
+		if err := combinedAPIServer.Recover(); err != nil {
+			log.Printf("recovery failed: %v", err)
+		}
+	}()
+	go func() {
+		if err := combinedAPIServer.WatchNumShards(nil); err != nil {
+			log.Printf("watching the number of shards failed: %v", err)
+		}
+	}()
+	if appEnv.GCInterval > 0 {
+		go func() {
+			for range time.Tick(time.Duration(appEnv.GCInterval) * time.Second) {
+				if err := combinedAPIServer.CollectGarbage(); err != nil {
+					log.Printf("garbage collection failed: %v", err)
+				}
+			}
+		}()
+	}
+	// the roler claims roles for every shard that can get paths, a server
+	// without it would serve none of them
+	roler := role.NewRoler(addresser, route.NewSharder(maxShards), combinedAPIServer, address, appEnv.NumReplicas)
+	errC := make(chan error, 2)
+	go func() {
+		errC <- roler.Run()
+	}()
+	go func() {
+		errC <- grpcutil.GrpcDo(
+			appEnv.APIPort,
+			appEnv.TracePort,
+			pachyderm.Version,
+			func(s *grpc.Server) {
+				pfs.RegisterApiServer(s, combinedAPIServer)
+				pfs.RegisterInternalApiServer(s, combinedAPIServer)
+			},
+		)
+	}()
+	return <-errC
 }
 
 func getEtcdClient() (discovery.Client, error) {
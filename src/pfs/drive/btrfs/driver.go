@@ -5,12 +5,25 @@
 directory structure
 
   .
+  |-- .pfs
+	  |-- operations
+		  |-- operationID // an operation this server is coordinating
   |-- repositoryName
+	  |-- .pfs
+		  |-- created // when the repository was created
+		  |-- branches
+			  |-- branchName // the id of the commit the branch points at
+		  |-- retention_policy // the repository's RetentionPolicy, if it has one
 	  |-- scratch
 		  |-- shardNum // the read-only read created on InitRepository, this is where to start branching
       |-- commitID
 	      |-- shardNum // this is where subvolumes are
 
+The .pfs directory of a commit holds its metadata. checksums mirrors the
+commit's files with the hex SHA-256 of each, it is kept when a commit is
+branched and the checksums of the files that are written to are removed,
+Commit then fills in the missing ones.
+
 */
 
 package btrfs
@@ -18,23 +31,23 @@ package btrfs
 import (
 	"bufio"
 	"bytes"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/pachyderm/pachyderm/src/pfs"
 	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pfs/drive/driveutil"
 	"github.com/pachyderm/pachyderm/src/pkg/executil"
-	"github.com/peter-edge/go-google-protobuf"
-	"github.com/satori/go.uuid"
-)
-
-const (
-	metadataDir = ".pfs"
+	"github.com/pachyderm/pachyderm/src/pkg/protoutil"
+	"golang.org/x/net/context"
 )
 
 type driver struct {
@@ -51,14 +64,112 @@ func newDriver(rootDir string, namespace string) (*driver, error) {
 	return &driver{rootDir, namespace}, nil
 }
 
-func (d *driver) InitRepository(repository *pfs.Repository, shards map[int]bool) error {
-	if err := execSubvolumeCreate(d.repositoryPath(repository)); err != nil && !execSubvolumeExists(d.repositoryPath(repository)) {
+func (d *driver) InitRepository(ctx context.Context, repository *pfs.Repository, shards map[int]bool) error {
+	if err := execSubvolumeCreate(ctx, d.repositoryPath(repository)); err != nil && !execSubvolumeExists(d.repositoryPath(repository)) {
 		return err
 	}
-	return nil
+	createdPath := filepath.Join(d.repositoryPath(repository), driveutil.MetadataDir, "created")
+	if _, err := os.Stat(createdPath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(createdPath), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(createdPath, []byte(time.Now().UTC().Format(time.RFC3339Nano)), 0600)
+}
+
+func (d *driver) ListRepositories(ctx context.Context) ([]*pfs.Repository, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(d.rootDir, d.namespace))
+	if err != nil {
+		return nil, err
+	}
+	var repositories []*pfs.Repository
+	for _, info := range infos {
+		if info.IsDir() && info.Name() != driveutil.MetadataDir {
+			repositories = append(repositories, &pfs.Repository{Name: info.Name()})
+		}
+	}
+	return repositories, nil
 }
 
-func (d *driver) GetFile(path *pfs.Path, shard int) (drive.ReaderAtCloser, error) {
+func (d *driver) InspectRepository(ctx context.Context, repository *pfs.Repository, shard int) (*pfs.RepositoryInfo, bool, error) {
+	if !execSubvolumeExists(d.repositoryPath(repository)) {
+		return nil, false, nil
+	}
+	repositoryInfo := &pfs.RepositoryInfo{
+		Repository: repository,
+	}
+	created, err := driveutil.ReadTimestamp(filepath.Join(d.repositoryPath(repository), driveutil.MetadataDir, "created"))
+	if err != nil {
+		return nil, false, err
+	}
+	repositoryInfo.Created = created
+	commits, err := d.commits(ctx, repository, shard)
+	if err != nil {
+		return nil, false, err
+	}
+	repositoryInfo.CommitCount = uint64(len(commits))
+	// snapshots share extents with their parent, so only count the files
+	// each commit added or modified
+	for _, commit := range commits {
+		changes, err := d.ListChangedFiles(ctx, nil, commit, shard)
+		if err != nil {
+			return nil, false, err
+		}
+		commitPath, readOnly, err := d.getCommitPath(commit, shard)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, change := range changes {
+			if change.ChangeType == pfs.ChangeType_CHANGE_TYPE_DELETED || change.FileType != pfs.FileType_FILE_TYPE_REGULAR {
+				continue
+			}
+			fileInfo, err := d.stat(change.Path, commitPath, readOnly)
+			if err != nil {
+				return nil, false, err
+			}
+			repositoryInfo.SizeBytes += fileInfo.SizeBytes
+		}
+	}
+	return repositoryInfo, true, nil
+}
+
+func (d *driver) DeleteRepository(ctx context.Context, repository *pfs.Repository, shards map[int]bool) error {
+	repositoryPath := d.repositoryPath(repository)
+	if !execSubvolumeExists(repositoryPath) {
+		return fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	commitInfos, err := ioutil.ReadDir(repositoryPath)
+	if err != nil {
+		return err
+	}
+	// every commit is a subvolume nested in the repository, with a subvolume
+	// nested in it for each shard, DeleteCommit deletes the commit's
+	// subvolume with its last shard
+	remaining := false
+	for _, commitInfo := range commitInfos {
+		if !commitInfo.IsDir() || commitInfo.Name() == driveutil.MetadataDir {
+			continue
+		}
+		commit := &pfs.Commit{
+			Repository: repository,
+			Id:         commitInfo.Name(),
+		}
+		if err := d.DeleteCommit(ctx, commit, shards); err != nil {
+			return err
+		}
+		if execSubvolumeExists(d.commitPathNoShard(commit)) {
+			remaining = true
+		}
+	}
+	// the repository goes with its last shard
+	if remaining {
+		return nil
+	}
+	return execSubvolumeDelete(ctx, repositoryPath)
+}
+
+func (d *driver) GetFile(ctx context.Context, path *pfs.Path, shard int) (drive.ReaderAtCloser, error) {
 	filePath, err := d.filePath(path, shard)
 	if err != nil {
 		return nil, err
@@ -66,21 +177,31 @@ func (d *driver) GetFile(path *pfs.Path, shard int) (drive.ReaderAtCloser, error
 	return os.Open(filePath)
 }
 
-func (d *driver) GetFileInfo(path *pfs.Path, shard int) (_ *pfs.FileInfo, ok bool, _ error) {
-	filePath, err := d.stat(path, shard)
+func (d *driver) GetFileInfo(ctx context.Context, path *pfs.Path, shard int) (_ *pfs.FileInfo, ok bool, _ error) {
+	commitPath, readOnly, err := d.getCommitPath(path.Commit, shard)
+	if err != nil {
+		return nil, false, err
+	}
+	fileInfo, err := d.stat(path, commitPath, readOnly)
 	if err != nil && os.IsNotExist(err) {
 		return nil, false, nil
 	}
 	if err != nil {
 		return nil, false, err
 	}
-	return filePath, true, nil
+	return fileInfo, true, nil
 }
 
-func (d *driver) MakeDirectory(path *pfs.Path, shards map[int]bool) error {
-	// TODO(pedge): if PutFile fails here or on another shard, the directories
-	// will still exist and be returned from ListFiles, we want to do this
-	// iteratively and with rollback
+func (d *driver) MakeDirectory(ctx context.Context, path *pfs.Path, shards map[int]bool) (retErr error) {
+	// the directories made on earlier shards are removed if a shard fails
+	var made []string
+	defer func() {
+		if retErr != nil {
+			for _, dirPath := range made {
+				_ = os.RemoveAll(dirPath)
+			}
+		}
+	}()
 	for shard := range shards {
 		if err := d.checkWrite(path.Commit, shard); err != nil {
 			return err
@@ -89,14 +210,21 @@ func (d *driver) MakeDirectory(path *pfs.Path, shards map[int]bool) error {
 		if err != nil {
 			return err
 		}
+		missing := driveutil.MissingDir(filePath)
 		if err := os.MkdirAll(filePath, 0700); err != nil {
 			return err
 		}
+		if missing != "" {
+			made = append(made, missing)
+		}
 	}
 	return nil
 }
 
-func (d *driver) PutFile(path *pfs.Path, shard int, offset int64, reader io.Reader) error {
+func (d *driver) PutFile(ctx context.Context, path *pfs.Path, shard int, offset int64, reader io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if err := d.checkWrite(path.Commit, shard); err != nil {
 		return err
 	}
@@ -104,6 +232,9 @@ func (d *driver) PutFile(path *pfs.Path, shard int, offset int64, reader io.Read
 	if err != nil {
 		return err
 	}
+	if err := d.removeChecksum(path, shard); err != nil {
+		return err
+	}
 	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
 		return err
@@ -116,11 +247,58 @@ func (d *driver) PutFile(path *pfs.Path, shard int, offset int64, reader io.Read
 	return err
 }
 
-func (d *driver) ListFiles(path *pfs.Path, shard int) (_ []*pfs.FileInfo, retErr error) {
-	filePath, err := d.filePath(path, shard)
+func (d *driver) DeleteFile(ctx context.Context, path *pfs.Path, shards map[int]bool) error {
+	if err := driveutil.CheckDeletable(path.Path); err != nil {
+		return err
+	}
+	for shard := range shards {
+		if err := d.checkWrite(path.Commit, shard); err != nil {
+			return err
+		}
+		filePath, err := d.filePath(path, shard)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filePath); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filePath); err != nil {
+			return err
+		}
+		if err := d.removeChecksum(path, shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *driver) PruneCommit(ctx context.Context, commit *pfs.Commit, shard int, remove func(path string) bool) error {
+	commitPath, readOnly, err := d.getCommitPath(commit, shard)
+	if err != nil {
+		return err
+	}
+	paths, err := driveutil.PrunedFiles(commitPath, remove)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	if !readOnly {
+		return driveutil.RemoveFiles(commitPath, paths)
+	}
+	return d.rewriteReadCommit(ctx, commit, shard, func(commitPath string) error {
+		return driveutil.RemoveFiles(commitPath, paths)
+	})
+}
+
+func (d *driver) ListFiles(ctx context.Context, path *pfs.Path, shard int) (_ []*pfs.FileInfo, retErr error) {
+	// looking the commit up runs btrfs, so it's only done once
+	commitPath, readOnly, err := d.getCommitPath(path.Commit, shard)
 	if err != nil {
 		return nil, err
 	}
+	filePath := filepath.Join(commitPath, path.Path)
 	stat, err := os.Stat(filePath)
 	if err != nil {
 		return nil, err
@@ -144,7 +322,7 @@ func (d *driver) ListFiles(path *pfs.Path, shard int) (_ []*pfs.FileInfo, retErr
 			return nil, err
 		}
 		for _, name := range names {
-			if inMetadataDir(name) {
+			if driveutil.InMetadataDir(name) {
 				continue
 			}
 			fileInfo, err := d.stat(
@@ -152,7 +330,8 @@ func (d *driver) ListFiles(path *pfs.Path, shard int) (_ []*pfs.FileInfo, retErr
 					Commit: path.Commit,
 					Path:   filepath.Join(path.Path, name),
 				},
-				shard,
+				commitPath,
+				readOnly,
 			)
 			if err != nil {
 				return nil, err
@@ -163,12 +342,65 @@ func (d *driver) ListFiles(path *pfs.Path, shard int) (_ []*pfs.FileInfo, retErr
 	return fileInfos, nil
 }
 
-func (d *driver) stat(path *pfs.Path, shard int) (*pfs.FileInfo, error) {
-	filePath, err := d.filePath(path, shard)
+func (d *driver) ListChangedFiles(ctx context.Context, from *pfs.Commit, to *pfs.Commit, shard int) ([]*pfs.Change, error) {
+	toPath, err := d.commitPath(to, shard)
 	if err != nil {
 		return nil, err
 	}
-	stat, err := os.Stat(filePath)
+	if from == nil {
+		from, err = d.getParent(to, shard)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var fromPath string
+	modified := func(relPath string, fromInfo os.FileInfo, toInfo os.FileInfo) (bool, error) {
+		same, err := driveutil.SameContents(filepath.Join(fromPath, relPath), fromInfo, filepath.Join(toPath, relPath), toInfo)
+		return !same, err
+	}
+	if from != nil {
+		fromPath, err = d.commitPath(from, shard)
+		if err != nil {
+			return nil, err
+		}
+		ancestor, err := d.isAncestor(from, to, shard)
+		if err != nil {
+			return nil, err
+		}
+		// to shares the extents of the files it didn't write with from, a
+		// read commit never changes so the files with newer extents are
+		// the ones written since, truncating doesn't write an extent
+		if ancestor && fromPath == d.readCommitPath(from, shard) {
+			written, err := execFindNew(ctx, toPath, fromPath)
+			if err != nil {
+				return nil, err
+			}
+			modified = func(relPath string, fromInfo os.FileInfo, toInfo os.FileInfo) (bool, error) {
+				return written[relPath] || fromInfo.Size() != toInfo.Size(), nil
+			}
+		}
+	}
+	return driveutil.ChangedFiles(fromPath, toPath, to, modified)
+}
+
+// isAncestor returns true if ancestor is commit or one of its parents.
+func (d *driver) isAncestor(ancestor *pfs.Commit, commit *pfs.Commit, shard int) (bool, error) {
+	for commit != nil {
+		if commit.Id == ancestor.Id {
+			return true, nil
+		}
+		var err error
+		if commit, err = d.getParent(commit, shard); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// stat returns the info of path in the commit at commitPath, readOnly is true if
+// it's a read commit.
+func (d *driver) stat(path *pfs.Path, commitPath string, readOnly bool) (*pfs.FileInfo, error) {
+	stat, err := os.Stat(filepath.Join(commitPath, path.Path))
 	if err != nil {
 		return nil, err
 	}
@@ -179,33 +411,99 @@ func (d *driver) stat(path *pfs.Path, shard int) (*pfs.FileInfo, error) {
 	if stat.Mode().IsDir() {
 		fileType = pfs.FileType_FILE_TYPE_DIR
 	}
-	return &pfs.FileInfo{
-		Path:      path,
-		FileType:  fileType,
-		SizeBytes: uint64(stat.Size()),
-		Perm:      uint32(stat.Mode() & os.ModePerm),
-		LastModified: &google_protobuf.Timestamp{
-			Seconds: stat.ModTime().UnixNano() / int64(time.Second),
-			Nanos:   int32(stat.ModTime().UnixNano() % int64(time.Second)),
-		},
-	}, nil
+	fileInfo := &pfs.FileInfo{
+		Path:         path,
+		FileType:     fileType,
+		SizeBytes:    uint64(stat.Size()),
+		Perm:         uint32(stat.Mode() & os.ModePerm),
+		LastModified: protoutil.TimeToTimestamp(stat.ModTime()),
+	}
+	if fileType != pfs.FileType_FILE_TYPE_REGULAR {
+		return fileInfo, nil
+	}
+	// a write commit may still have checksums of files it has changed
+	if !readOnly {
+		return fileInfo, nil
+	}
+	checksum, err := ioutil.ReadFile(filepath.Join(commitPath, driveutil.MetadataDir, driveutil.ChecksumsDir, filepath.Clean("/"+path.Path)))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if fileInfo.Checksum, err = hex.DecodeString(string(checksum)); err != nil {
+			return nil, err
+		}
+	}
+	return fileInfo, nil
+}
+
+func (d *driver) ListFileHistory(ctx context.Context, path *pfs.Path, shard int) ([]*pfs.FileRevision, error) {
+	relPath := filepath.Clean("/" + path.Path)
+	var fileRevisions []*pfs.FileRevision
+	for commit := path.Commit; commit != nil; {
+		commitPath, err := d.commitPath(commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		parent, err := d.getParent(commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		var parentPath string
+		if parent != nil {
+			if parentPath, err = d.commitPath(parent, shard); err != nil {
+				return nil, err
+			}
+		}
+		changeType, info, err := driveutil.FileChangeType(parentPath, commitPath, relPath)
+		if err != nil {
+			return nil, err
+		}
+		if changeType != pfs.ChangeType_CHANGE_TYPE_NONE {
+			fileRevision := &pfs.FileRevision{
+				Commit:     commit,
+				ChangeType: changeType,
+			}
+			if info != nil && info.Mode().IsRegular() {
+				fileRevision.SizeBytes = uint64(info.Size())
+			}
+			if fileRevision.Finished, err = driveutil.ReadTimestamp(filepath.Join(commitPath, driveutil.MetadataDir, "finished")); err != nil {
+				return nil, err
+			}
+			fileRevisions = append(fileRevisions, fileRevision)
+		}
+		commit = parent
+	}
+	return fileRevisions, nil
 }
 
-func (d *driver) Branch(commit *pfs.Commit, newCommit *pfs.Commit, shards map[int]bool) (*pfs.Commit, error) {
+func (d *driver) Branch(ctx context.Context, commit *pfs.Commit, newCommit *pfs.Commit, branch string, message string, shards map[int]bool) (_ *pfs.Commit, retErr error) {
 	if commit == nil && newCommit == nil {
 		return nil, fmt.Errorf("pachyderm: must specify either commit or newCommit")
 	}
 	if newCommit == nil {
 		newCommit = &pfs.Commit{
 			Repository: commit.Repository,
-			Id:         newCommitID(),
+			Id:         driveutil.NewCommitID(),
 		}
 	}
-	if err := execSubvolumeCreate(d.commitPathNoShard(newCommit)); err != nil && !execSubvolumeExists(d.commitPathNoShard(newCommit)) {
+	if err := execSubvolumeCreate(ctx, d.commitPathNoShard(newCommit)); err != nil && !execSubvolumeExists(d.commitPathNoShard(newCommit)) {
 		return nil, err
 	}
+	// the commit is removed from the shards it was made on if a shard fails,
+	// even if that is because ctx is done
+	branched := make(map[int]bool)
+	defer func() {
+		if retErr != nil {
+			_ = d.DeleteCommit(context.Background(), newCommit, branched)
+		}
+	}()
+	created := time.Now().UTC().Format(time.RFC3339Nano)
 	for shard := range shards {
 		newCommitPath := d.writeCommitPath(newCommit, shard)
+		if execSubvolumeExists(d.readCommitPath(newCommit, shard)) {
+			return nil, fmt.Errorf("pachyderm: commit %s already exists", newCommit.Id)
+		}
 		if commit != nil {
 			if err := d.checkReadOnly(commit, shard); err != nil {
 				return nil, err
@@ -214,21 +512,31 @@ func (d *driver) Branch(commit *pfs.Commit, newCommit *pfs.Commit, shards map[in
 			if err != nil {
 				return nil, err
 			}
-			if err := execSubvolumeSnapshot(commitPath, newCommitPath, false); err != nil {
+			if err := execSubvolumeSnapshot(ctx, commitPath, newCommitPath, false); err != nil {
 				return nil, err
 			}
-			filePath, err := d.filePath(&pfs.Path{Commit: newCommit, Path: filepath.Join(metadataDir, "parent")}, shard)
+			// the snapshot carries the metadata of the base commit, only
+			// its checksums still apply
+			infos, err := ioutil.ReadDir(filepath.Join(newCommitPath, driveutil.MetadataDir))
 			if err != nil {
 				return nil, err
 			}
-			if err := ioutil.WriteFile(filePath, []byte(commit.Id), 0600); err != nil {
+			for _, info := range infos {
+				if info.Name() == driveutil.ChecksumsDir {
+					continue
+				}
+				if err := os.RemoveAll(filepath.Join(newCommitPath, driveutil.MetadataDir, info.Name())); err != nil {
+					return nil, err
+				}
+			}
+			if err := driveutil.WriteMetadata(newCommitPath, "parent", commit.Id); err != nil {
 				return nil, err
 			}
 		} else {
-			if err := execSubvolumeCreate(newCommitPath); err != nil {
+			if err := execSubvolumeCreate(ctx, newCommitPath); err != nil {
 				return nil, err
 			}
-			filePath, err := d.filePath(&pfs.Path{Commit: newCommit, Path: metadataDir}, shard)
+			filePath, err := d.filePath(&pfs.Path{Commit: newCommit, Path: driveutil.MetadataDir}, shard)
 			if err != nil {
 				return nil, err
 			}
@@ -236,41 +544,219 @@ func (d *driver) Branch(commit *pfs.Commit, newCommit *pfs.Commit, shards map[in
 				return nil, err
 			}
 		}
+		if err := driveutil.WriteMetadata(newCommitPath, "created", created); err != nil {
+			return nil, err
+		}
+		if branch != "" {
+			if err := driveutil.WriteMetadata(newCommitPath, "branch", branch); err != nil {
+				return nil, err
+			}
+		}
+		if message != "" {
+			if err := driveutil.WriteMetadata(newCommitPath, "message", message); err != nil {
+				return nil, err
+			}
+		}
+		branched[shard] = true
+	}
+	return newCommit, nil
+}
+
+func (d *driver) Merge(ctx context.Context, ours *pfs.Commit, theirs *pfs.Commit, newCommit *pfs.Commit, paths []string, branch string, message string, shards map[int]bool) (*pfs.Commit, error) {
+	if ours == nil || theirs == nil {
+		return nil, fmt.Errorf("pachyderm: must specify both ours and theirs")
+	}
+	for shard := range shards {
+		if err := d.checkReadOnly(theirs, shard); err != nil {
+			return nil, err
+		}
+	}
+	newCommit, err := d.Branch(ctx, ours, newCommit, branch, message, shards)
+	if err != nil {
+		return nil, err
+	}
+	for shard := range shards {
+		newCommitPath := d.writeCommitPath(newCommit, shard)
+		if err := driveutil.WriteMetadata(newCommitPath, "merge_parent", theirs.Id); err != nil {
+			return nil, err
+		}
+		theirsPath, err := d.commitPath(theirs, shard)
+		if err != nil {
+			return nil, err
+		}
+		if err := driveutil.MergePaths(theirsPath, newCommitPath, paths, false); err != nil {
+			return nil, err
+		}
 	}
 	return newCommit, nil
 }
 
-func (d *driver) Commit(commit *pfs.Commit, shards map[int]bool) error {
+func (d *driver) Commit(ctx context.Context, commit *pfs.Commit, message string, shards map[int]bool) (retErr error) {
+	// the shards that were committed are uncommitted if a shard fails
+	committed := make(map[int]bool)
+	defer func() {
+		if retErr != nil {
+			_ = d.Uncommit(context.Background(), commit, committed)
+		}
+	}()
+	finished := time.Now().UTC().Format(time.RFC3339Nano)
+	for shard := range shards {
+		if err := d.checkWrite(commit, shard); err != nil {
+			return err
+		}
+		writeCommitPath := d.writeCommitPath(commit, shard)
+		if message != "" {
+			if err := driveutil.WriteMetadata(writeCommitPath, "message", message); err != nil {
+				return err
+			}
+		}
+		size, err := driveutil.CommitSize(writeCommitPath)
+		if err != nil {
+			return err
+		}
+		if err := driveutil.WriteMetadata(writeCommitPath, "size", fmt.Sprint(size)); err != nil {
+			return err
+		}
+		if err := driveutil.WriteChecksums(writeCommitPath, ""); err != nil {
+			return err
+		}
+		if err := driveutil.WriteMetadata(writeCommitPath, "finished", finished); err != nil {
+			return err
+		}
+		if err := execSubvolumeSnapshot(ctx, d.writeCommitPath(commit, shard), d.readCommitPath(commit, shard), true); err != nil {
+			return err
+		}
+		if err := execSubvolumeDelete(ctx, d.writeCommitPath(commit, shard)); err != nil {
+			return err
+		}
+		committed[shard] = true
+	}
+	return nil
+}
+
+func (d *driver) Uncommit(ctx context.Context, commit *pfs.Commit, shards map[int]bool) error {
+	for shard := range shards {
+		readCommitPath := d.readCommitPath(commit, shard)
+		if !execSubvolumeExists(readCommitPath) {
+			continue
+		}
+		writeCommitPath := d.writeCommitPath(commit, shard)
+		if err := execSubvolumeSnapshot(ctx, readCommitPath, writeCommitPath, false); err != nil {
+			return err
+		}
+		for _, name := range []string{"finished", "size"} {
+			if err := os.RemoveAll(filepath.Join(writeCommitPath, driveutil.MetadataDir, name)); err != nil {
+				return err
+			}
+		}
+		if err := execSubvolumeDelete(ctx, readCommitPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *driver) DeleteCommit(ctx context.Context, commit *pfs.Commit, shards map[int]bool) error {
 	for shard := range shards {
-		if err := execSubvolumeSnapshot(d.writeCommitPath(commit, shard), d.readCommitPath(commit, shard), true); err != nil {
+		for _, commitPath := range []string{d.readCommitPath(commit, shard), d.writeCommitPath(commit, shard)} {
+			if !execSubvolumeExists(commitPath) {
+				continue
+			}
+			if err := execSubvolumeDelete(ctx, commitPath); err != nil {
+				return err
+			}
+		}
+	}
+	// the commit's subvolume goes with its last shard
+	infos, err := ioutil.ReadDir(d.commitPathNoShard(commit))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && len(infos) == 0 {
+		return execSubvolumeDelete(ctx, d.commitPathNoShard(commit))
+	}
+	return nil
+}
+
+func (d *driver) SquashCommits(ctx context.Context, commits []*pfs.Commit, message string, shards map[int]bool) error {
+	if len(commits) == 0 {
+		return fmt.Errorf("pachyderm: must specify commits to squash")
+	}
+	// check every shard before changing anything
+	for shard := range shards {
+		for i, commit := range commits {
+			if err := d.checkReadOnly(commit, shard); err != nil {
+				return err
+			}
+			if i == 0 {
+				continue
+			}
+			parent, err := d.getParent(commit, shard)
+			if err != nil {
+				return err
+			}
+			if parent == nil || parent.Id != commits[i-1].Id {
+				return fmt.Errorf("pachyderm: commit %s is not the parent of %s", commits[i-1].Id, commit.Id)
+			}
+		}
+	}
+	last := commits[len(commits)-1]
+	squashed := make(map[string]bool)
+	for _, commit := range commits[:len(commits)-1] {
+		squashed[commit.Id] = true
+	}
+	for shard := range shards {
+		parent, err := d.getParent(commits[0], shard)
+		if err != nil {
 			return err
 		}
-		if err := execSubvolumeDelete(d.writeCommitPath(commit, shard)); err != nil {
+		if err := d.rewriteReadCommit(ctx, last, shard, func(commitPath string) error {
+			if err := os.RemoveAll(filepath.Join(commitPath, driveutil.MetadataDir, "parent")); err != nil {
+				return err
+			}
+			if parent != nil {
+				if err := driveutil.WriteMetadata(commitPath, "parent", parent.Id); err != nil {
+					return err
+				}
+			}
+			if message != "" {
+				return driveutil.WriteMetadata(commitPath, "message", message)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := d.reparent(ctx, last, squashed, shard); err != nil {
 			return err
 		}
+		for _, commit := range commits[:len(commits)-1] {
+			if err := d.DeleteCommit(ctx, commit, map[int]bool{shard: true}); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (d *driver) PullDiff(commit *pfs.Commit, shard int, diff io.Writer) error {
+func (d *driver) PullDiff(ctx context.Context, commit *pfs.Commit, shard int, diff io.Writer) error {
 	parent, err := d.getParent(commit, shard)
 	if err != nil {
 		return err
 	}
 	if parent == nil {
-		return execSend(d.readCommitPath(commit, shard), "", diff)
+		return execSend(ctx, d.readCommitPath(commit, shard), "", diff)
 	}
-	return execSend(d.readCommitPath(commit, shard), d.readCommitPath(parent, shard), diff)
+	return execSend(ctx, d.readCommitPath(commit, shard), d.readCommitPath(parent, shard), diff)
 }
 
-func (d *driver) PushDiff(commit *pfs.Commit, diff io.Reader) error {
-	if err := execSubvolumeCreate(d.commitPathNoShard(commit)); err != nil && !execSubvolumeExists(d.commitPathNoShard(commit)) {
+func (d *driver) PushDiff(ctx context.Context, commit *pfs.Commit, diff io.Reader) error {
+	if err := execSubvolumeCreate(ctx, d.commitPathNoShard(commit)); err != nil && !execSubvolumeExists(d.commitPathNoShard(commit)) {
 		return err
 	}
-	return execRecv(d.commitPathNoShard(commit), diff)
+	return execRecv(ctx, d.commitPathNoShard(commit), diff)
 }
 
-func (d *driver) GetCommitInfo(commit *pfs.Commit, shard int) (_ *pfs.CommitInfo, ok bool, _ error) {
+func (d *driver) GetCommitInfo(ctx context.Context, commit *pfs.Commit, shard int) (_ *pfs.CommitInfo, ok bool, _ error) {
 	_, readErr := os.Stat(d.readCommitPath(commit, shard))
 	_, writeErr := os.Stat(d.writeCommitPath(commit, shard))
 	if readErr != nil && os.IsNotExist(readErr) && writeErr != nil && os.IsNotExist(writeErr) {
@@ -288,24 +774,72 @@ func (d *driver) GetCommitInfo(commit *pfs.Commit, shard int) (_ *pfs.CommitInfo
 	if readOnly {
 		commitType = pfs.CommitType_COMMIT_TYPE_READ
 	}
-	return &pfs.CommitInfo{
+	commitPath, err := d.commitPath(commit, shard)
+	if err != nil {
+		return nil, false, err
+	}
+	commitInfo := &pfs.CommitInfo{
 		Commit:       commit,
 		CommitType:   commitType,
 		ParentCommit: parent,
-	}, true, nil
+	}
+	if commitInfo.Created, err = driveutil.ReadTimestamp(filepath.Join(commitPath, driveutil.MetadataDir, "created")); err != nil {
+		return nil, false, err
+	}
+	if commitInfo.Finished, err = driveutil.ReadTimestamp(filepath.Join(commitPath, driveutil.MetadataDir, "finished")); err != nil {
+		return nil, false, err
+	}
+	message, err := ioutil.ReadFile(filepath.Join(commitPath, driveutil.MetadataDir, "message"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	commitInfo.Message = string(message)
+	branch, err := ioutil.ReadFile(filepath.Join(commitPath, driveutil.MetadataDir, "branch"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	commitInfo.Branch = string(branch)
+	mergeParent, err := ioutil.ReadFile(filepath.Join(commitPath, driveutil.MetadataDir, "merge_parent"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	if err == nil {
+		commitInfo.MergeParentCommit = &pfs.Commit{
+			Repository: commit.Repository,
+			Id:         string(mergeParent),
+		}
+	}
+	if !readOnly {
+		// the size of a write commit is only known once it is committed
+		if commitInfo.SizeBytes, err = driveutil.CommitSize(commitPath); err != nil {
+			return nil, false, err
+		}
+		return commitInfo, true, nil
+	}
+	size, err := ioutil.ReadFile(filepath.Join(commitPath, driveutil.MetadataDir, "size"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	if err == nil {
+		if commitInfo.SizeBytes, err = strconv.ParseUint(string(size), 10, 64); err != nil {
+			return nil, false, err
+		}
+	}
+	return commitInfo, true, nil
 }
 
-func (d *driver) ListCommits(repository *pfs.Repository, shard int) (_ []*pfs.CommitInfo, retErr error) {
+func (d *driver) ListCommits(ctx context.Context, repository *pfs.Repository, shard int) (_ []*pfs.CommitInfo, retErr error) {
 	var commitInfos []*pfs.CommitInfo
 	//TODO this buffer might get too big
 	var buffer bytes.Buffer
-	if err := execSubvolumeList(d.repositoryPath(repository), "", false, &buffer); err != nil {
+	if err := execSubvolumeList(ctx, d.repositoryPath(repository), "", false, &buffer); err != nil {
 		return nil, err
 	}
 	commitScanner := newCommitScanner(&buffer, d.namespace, repository.Name)
 	for commitScanner.Scan() {
 		commitID := commitScanner.Commit()
 		commitInfo, ok, err := d.GetCommitInfo(
+			ctx,
 			&pfs.Commit{
 				Repository: repository,
 				Id:         commitID,
@@ -326,8 +860,242 @@ func (d *driver) ListCommits(repository *pfs.Repository, shard int) (_ []*pfs.Co
 	return commitInfos, nil
 }
 
+func (d *driver) CreateBranch(ctx context.Context, repository *pfs.Repository, name string, commit *pfs.Commit) (retErr error) {
+	if !execSubvolumeExists(d.repositoryPath(repository)) {
+		return fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	branchesPath := d.branchesPath(repository)
+	if err := os.MkdirAll(branchesPath, 0700); err != nil {
+		return err
+	}
+	// write to a temporary file and rename it so that readers never see a
+	// partially written branch
+	file, err := ioutil.TempFile(branchesPath, ".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err := file.WriteString(commit.Id); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(branchesPath, name))
+}
+
+func (d *driver) ListBranches(ctx context.Context, repository *pfs.Repository) ([]*pfs.BranchInfo, error) {
+	if !execSubvolumeExists(d.repositoryPath(repository)) {
+		return nil, fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	infos, err := ioutil.ReadDir(d.branchesPath(repository))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var branchInfos []*pfs.BranchInfo
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			// an unfinished CreateBranch
+			continue
+		}
+		commitID, err := ioutil.ReadFile(filepath.Join(d.branchesPath(repository), info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		branchInfos = append(
+			branchInfos,
+			&pfs.BranchInfo{
+				Name: info.Name(),
+				Commit: &pfs.Commit{
+					Repository: repository,
+					Id:         string(commitID),
+				},
+			},
+		)
+	}
+	return branchInfos, nil
+}
+
+func (d *driver) DeleteBranch(ctx context.Context, repository *pfs.Repository, name string) error {
+	if !execSubvolumeExists(d.repositoryPath(repository)) {
+		return fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	if err := os.Remove(filepath.Join(d.branchesPath(repository), name)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("pachyderm: branch %s not found", name)
+		}
+		return err
+	}
+	return nil
+}
+
+// commits returns the commits of repository that exist on shard.
+func (d *driver) commits(ctx context.Context, repository *pfs.Repository, shard int) ([]*pfs.Commit, error) {
+	infos, err := ioutil.ReadDir(d.repositoryPath(repository))
+	if err != nil {
+		return nil, err
+	}
+	var commits []*pfs.Commit
+	for _, info := range infos {
+		if !info.IsDir() || info.Name() == driveutil.MetadataDir {
+			continue
+		}
+		commit := &pfs.Commit{
+			Repository: repository,
+			Id:         info.Name(),
+		}
+		_, ok, err := d.GetCommitInfo(ctx, commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			commits = append(commits, commit)
+		}
+	}
+	return commits, nil
+}
+
+func (d *driver) SetRetentionPolicy(ctx context.Context, repository *pfs.Repository, retentionPolicy *pfs.RetentionPolicy) (retErr error) {
+	if !execSubvolumeExists(d.repositoryPath(repository)) {
+		return fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	retentionPolicyPath := d.retentionPolicyPath(repository)
+	if retentionPolicy == nil {
+		if err := os.Remove(retentionPolicyPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := proto.Marshal(retentionPolicy)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(retentionPolicyPath), 0700); err != nil {
+		return err
+	}
+	// write to a temporary file and rename it so that readers never see a
+	// partially written policy
+	file, err := ioutil.TempFile(filepath.Dir(retentionPolicyPath), ".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), retentionPolicyPath)
+}
+
+func (d *driver) GetRetentionPolicy(ctx context.Context, repository *pfs.Repository) (*pfs.RetentionPolicy, error) {
+	if !execSubvolumeExists(d.repositoryPath(repository)) {
+		return nil, fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	data, err := ioutil.ReadFile(d.retentionPolicyPath(repository))
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	retentionPolicy := &pfs.RetentionPolicy{}
+	if err := proto.Unmarshal(data, retentionPolicy); err != nil {
+		return nil, err
+	}
+	return retentionPolicy, nil
+}
+
+func (d *driver) StartOperation(ctx context.Context, operation *pfs.Operation) error {
+	return driveutil.StartOperation(d.operationsPath(), operation)
+}
+
+func (d *driver) FinishOperation(ctx context.Context, operation *pfs.Operation) error {
+	return driveutil.FinishOperation(d.operationsPath(), operation)
+}
+
+func (d *driver) ListOperations(ctx context.Context) ([]*pfs.Operation, error) {
+	return driveutil.ListOperations(d.operationsPath())
+}
+
+// rewriteReadCommit calls f with the path of a writable snapshot of the read
+// commit, which then replaces it.
+func (d *driver) rewriteReadCommit(ctx context.Context, commit *pfs.Commit, shard int, f func(commitPath string) error) error {
+	readCommitPath := d.readCommitPath(commit, shard)
+	writeCommitPath := d.writeCommitPath(commit, shard)
+	if err := execSubvolumeSnapshot(ctx, readCommitPath, writeCommitPath, false); err != nil {
+		return err
+	}
+	if err := f(writeCommitPath); err != nil {
+		return err
+	}
+	if err := execSubvolumeDelete(ctx, readCommitPath); err != nil {
+		return err
+	}
+	if err := execSubvolumeSnapshot(ctx, writeCommitPath, readCommitPath, true); err != nil {
+		return err
+	}
+	return execSubvolumeDelete(ctx, writeCommitPath)
+}
+
+// reparent makes the children of the squashed commits on shard children of
+// commit.
+func (d *driver) reparent(ctx context.Context, commit *pfs.Commit, squashed map[string]bool, shard int) error {
+	commitInfos, err := d.ListCommits(ctx, commit.Repository, shard)
+	if err != nil {
+		return err
+	}
+	for _, commitInfo := range commitInfos {
+		if squashed[commitInfo.Commit.Id] {
+			continue
+		}
+		var names []string
+		if commitInfo.ParentCommit != nil && squashed[commitInfo.ParentCommit.Id] {
+			names = append(names, "parent")
+		}
+		if commitInfo.MergeParentCommit != nil && squashed[commitInfo.MergeParentCommit.Id] {
+			names = append(names, "merge_parent")
+		}
+		if len(names) == 0 {
+			continue
+		}
+		setParents := func(commitPath string) error {
+			for _, name := range names {
+				if err := os.RemoveAll(filepath.Join(commitPath, driveutil.MetadataDir, name)); err != nil {
+					return err
+				}
+				if err := driveutil.WriteMetadata(commitPath, name, commit.Id); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if commitInfo.CommitType == pfs.CommitType_COMMIT_TYPE_WRITE {
+			if err := setParents(d.writeCommitPath(commitInfo.Commit, shard)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.rewriteReadCommit(ctx, commitInfo.Commit, shard, setParents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *driver) getParent(commit *pfs.Commit, shard int) (*pfs.Commit, error) {
-	filePath, err := d.filePath(&pfs.Path{Commit: commit, Path: filepath.Join(metadataDir, "parent")}, shard)
+	filePath, err := d.filePath(&pfs.Path{Commit: commit, Path: filepath.Join(driveutil.MetadataDir, "parent")}, shard)
 	if err != nil {
 		return nil, err
 	}
@@ -376,10 +1144,28 @@ func (d *driver) getReadOnly(commit *pfs.Commit, shard int) (bool, error) {
 	}
 }
 
+// removeChecksum removes the checksums of path and anything below it from
+// the write commit, Commit computes them again.
+func (d *driver) removeChecksum(path *pfs.Path, shard int) error {
+	return os.RemoveAll(filepath.Join(d.writeCommitPath(path.Commit, shard), driveutil.MetadataDir, driveutil.ChecksumsDir, filepath.Clean("/"+path.Path)))
+}
+
 func (d *driver) repositoryPath(repository *pfs.Repository) string {
 	return filepath.Join(d.rootDir, d.namespace, repository.Name)
 }
 
+func (d *driver) operationsPath() string {
+	return filepath.Join(d.rootDir, d.namespace, driveutil.MetadataDir, "operations")
+}
+
+func (d *driver) branchesPath(repository *pfs.Repository) string {
+	return filepath.Join(d.repositoryPath(repository), driveutil.MetadataDir, "branches")
+}
+
+func (d *driver) retentionPolicyPath(repository *pfs.Repository) string {
+	return filepath.Join(d.repositoryPath(repository), driveutil.MetadataDir, "retention_policy")
+}
+
 func (d *driver) commitPathNoShard(commit *pfs.Commit) string {
 	return filepath.Join(d.repositoryPath(commit.Repository), commit.Id)
 }
@@ -393,14 +1179,20 @@ func (d *driver) writeCommitPath(commit *pfs.Commit, shard int) string {
 }
 
 func (d *driver) commitPath(commit *pfs.Commit, shard int) (string, error) {
+	commitPath, _, err := d.getCommitPath(commit, shard)
+	return commitPath, err
+}
+
+// getCommitPath returns the path of commit and true if it's a read commit.
+func (d *driver) getCommitPath(commit *pfs.Commit, shard int) (string, bool, error) {
 	readOnly, err := d.getReadOnly(commit, shard)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 	if readOnly {
-		return d.readCommitPath(commit, shard), nil
+		return d.readCommitPath(commit, shard), true, nil
 	}
-	return d.writeCommitPath(commit, shard), nil
+	return d.writeCommitPath(commit, shard), false, nil
 }
 
 func (d *driver) filePath(path *pfs.Path, shard int) (string, error) {
@@ -411,21 +1203,12 @@ func (d *driver) filePath(path *pfs.Path, shard int) (string, error) {
 	return filepath.Join(commitPath, path.Path), nil
 }
 
-func newCommitID() string {
-	return strings.Replace(uuid.NewV4().String(), "-", "", -1)
-}
-
-func inMetadataDir(name string) bool {
-	parts := strings.Split(name, "/")
-	return (len(parts) > 0 && parts[0] == metadataDir)
-}
-
-func execSubvolumeCreate(path string) error {
-	return executil.Run("btrfs", "subvolume", "create", path)
+func execSubvolumeCreate(ctx context.Context, path string) error {
+	return executil.RunContext(ctx, "btrfs", "subvolume", "create", path)
 }
 
-func execSubvolumeDelete(path string) error {
-	return executil.Run("btrfs", "subvolume", "delete", path)
+func execSubvolumeDelete(ctx context.Context, path string) error {
+	return executil.RunContext(ctx, "btrfs", "subvolume", "delete", path)
 }
 
 func execSubvolumeExists(path string) bool {
@@ -435,19 +1218,19 @@ func execSubvolumeExists(path string) bool {
 	return true
 }
 
-func execSubvolumeSnapshot(src string, dest string, readOnly bool) error {
+func execSubvolumeSnapshot(ctx context.Context, src string, dest string, readOnly bool) error {
 	if readOnly {
-		return executil.Run("btrfs", "subvolume", "snapshot", "-r", src, dest)
+		return executil.RunContext(ctx, "btrfs", "subvolume", "snapshot", "-r", src, dest)
 	}
-	return executil.Run("btrfs", "subvolume", "snapshot", src, dest)
+	return executil.RunContext(ctx, "btrfs", "subvolume", "snapshot", src, dest)
 }
 
-func execTransID(path string) (string, error) {
+func execTransID(ctx context.Context, path string) (string, error) {
 	//  "9223372036854775810" == 2 ** 63 we use a very big number there so that
 	//  we get the transid of the from path. According to the internet this is
 	//  the nicest way to get it from btrfs.
 	var buffer bytes.Buffer
-	if err := executil.RunStdout(&buffer, "btrfs", "subvolume", "find-new", path, "9223372036854775808"); err != nil {
+	if err := executil.RunStdoutContext(ctx, &buffer, "btrfs", "subvolume", "find-new", path, "9223372036854775808"); err != nil {
 		return "", err
 	}
 	scanner := bufio.NewScanner(&buffer)
@@ -470,7 +1253,47 @@ func execTransID(path string) (string, error) {
 	return "", fmt.Errorf("pachyderm: empty output from find-new")
 }
 
-func execSubvolumeList(path string, fromCommit string, ascending bool, out io.Writer) error {
+// execFindNew returns the slash separated paths of the files in the
+// subvolume at path that have data written after the subvolume at fromPath
+// last changed.
+func execFindNew(ctx context.Context, path string, fromPath string) (map[string]bool, error) {
+	transid, err := execTransID(ctx, fromPath)
+	if err != nil {
+		return nil, err
+	}
+	generation, err := strconv.ParseUint(transid, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	// find-new includes the generation it's given
+	if err := executil.RunStdoutContext(ctx, &buffer, "btrfs", "subvolume", "find-new", path, fmt.Sprint(generation+1)); err != nil {
+		return nil, err
+	}
+	written := make(map[string]bool)
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		// scanner.Text() looks like this:
+		// inode 257 file offset 0 len 5 disk start 0 offset 0 gen 910 flags INLINE dir/file
+		// the last line is the transid marker, which has no flags
+		line := scanner.Text()
+		i := strings.Index(line, " flags ")
+		if i == -1 {
+			continue
+		}
+		tokens := strings.SplitN(line[i+len(" flags "):], " ", 2)
+		if len(tokens) != 2 || driveutil.InMetadataDir(tokens[1]) {
+			continue
+		}
+		written[tokens[1]] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+func execSubvolumeList(ctx context.Context, path string, fromCommit string, ascending bool, out io.Writer) error {
 	var sort string
 	if ascending {
 		sort = "+ogen"
@@ -479,13 +1302,13 @@ func execSubvolumeList(path string, fromCommit string, ascending bool, out io.Wr
 	}
 
 	if fromCommit == "" {
-		return executil.RunStdout(out, "btrfs", "subvolume", "list", "-a", "--sort", sort, path)
+		return executil.RunStdoutContext(ctx, out, "btrfs", "subvolume", "list", "-a", "--sort", sort, path)
 	}
-	transid, err := execTransID(fromCommit)
+	transid, err := execTransID(ctx, fromCommit)
 	if err != nil {
 		return err
 	}
-	return executil.RunStdout(out, "btrfs", "subvolume", "list", "-aC", "+"+transid, "--sort", sort, path)
+	return executil.RunStdoutContext(ctx, out, "btrfs", "subvolume", "list", "-aC", "+"+transid, "--sort", sort, path)
 }
 
 type commitScanner struct {
@@ -532,13 +1355,13 @@ func (c *commitScanner) parseCommit(listLine string) (string, bool) {
 	return "", false
 }
 
-func execSend(path string, parent string, diff io.Writer) error {
+func execSend(ctx context.Context, path string, parent string, diff io.Writer) error {
 	if parent == "" {
-		return executil.RunStdout(diff, "btrfs", "send", path)
+		return executil.RunStdoutContext(ctx, diff, "btrfs", "send", path)
 	}
-	return executil.RunStdout(diff, "btrfs", "send", "-p", parent, path)
+	return executil.RunStdoutContext(ctx, diff, "btrfs", "send", "-p", parent, path)
 }
 
-func execRecv(path string, diff io.Reader) error {
-	return executil.RunStdin(diff, "btrfs", "receive", path)
+func execRecv(ctx context.Context, path string, diff io.Reader) error {
+	return executil.RunStdinContext(ctx, diff, "btrfs", "receive", path)
 }
@@ -0,0 +1,27 @@
+// +build linux
+
+package btrfs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pfs/drive/drivertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver(t *testing.T) {
+	rootDir := os.Getenv("PFS_DRIVER_ROOT")
+	if rootDir == "" {
+		t.Skip("PFS_DRIVER_ROOT not set")
+	}
+	counter := 0
+	drivertest.RunDriverTests(t, func(t *testing.T) drive.Driver {
+		counter++
+		driver, err := NewDriver(rootDir, fmt.Sprintf("drivertest-%d-%d", os.Getpid(), counter))
+		require.NoError(t, err)
+		return driver
+	})
+}
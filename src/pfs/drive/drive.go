@@ -7,6 +7,7 @@ import (
 	"io"
 
 	"github.com/pachyderm/pachyderm/src/pfs"
+	"golang.org/x/net/context"
 )
 
 // ReaderAtCloser is an interface that implements both io.ReaderAt and io.Closer.
@@ -16,17 +17,55 @@ type ReaderAtCloser interface {
 }
 
 // Driver represents a low-level pfs storage driver.
+//
+// PutFile, PullDiff and PushDiff, which move file data, fail with ctx's error
+// once ctx is done, the other methods may ignore ctx.
 type Driver interface {
-	InitRepository(repository *pfs.Repository, shard map[int]bool) error
-	GetFile(path *pfs.Path, shard int) (ReaderAtCloser, error)
-	GetFileInfo(path *pfs.Path, shard int) (*pfs.FileInfo, bool, error)
-	MakeDirectory(path *pfs.Path, shards map[int]bool) error
-	PutFile(path *pfs.Path, shard int, offset int64, reader io.Reader) error
-	ListFiles(path *pfs.Path, shard int) ([]*pfs.FileInfo, error)
-	Branch(commit *pfs.Commit, newCommit *pfs.Commit, shards map[int]bool) (*pfs.Commit, error)
-	Commit(commit *pfs.Commit, shards map[int]bool) error
-	PullDiff(commit *pfs.Commit, shard int, diff io.Writer) error
-	PushDiff(commit *pfs.Commit, diff io.Reader) error
-	GetCommitInfo(commit *pfs.Commit, shard int) (*pfs.CommitInfo, bool, error)
-	ListCommits(repository *pfs.Repository, shard int) ([]*pfs.CommitInfo, error)
+	InitRepository(ctx context.Context, repository *pfs.Repository, shard map[int]bool) error
+	ListRepositories(ctx context.Context) ([]*pfs.Repository, error)
+	InspectRepository(ctx context.Context, repository *pfs.Repository, shard int) (*pfs.RepositoryInfo, bool, error)
+	// DeleteRepository deletes the commits of repository on shards, the
+	// repository itself goes once no shard has a commit of it.
+	DeleteRepository(ctx context.Context, repository *pfs.Repository, shards map[int]bool) error
+	GetFile(ctx context.Context, path *pfs.Path, shard int) (ReaderAtCloser, error)
+	GetFileInfo(ctx context.Context, path *pfs.Path, shard int) (*pfs.FileInfo, bool, error)
+	MakeDirectory(ctx context.Context, path *pfs.Path, shards map[int]bool) error
+	PutFile(ctx context.Context, path *pfs.Path, shard int, offset int64, reader io.Reader) error
+	DeleteFile(ctx context.Context, path *pfs.Path, shards map[int]bool) error
+	// PruneCommit removes the regular files of commit on shard that remove
+	// accepts, read commits included, so the files a Reshard moved to
+	// other shards don't stay behind.
+	PruneCommit(ctx context.Context, commit *pfs.Commit, shard int, remove func(path string) bool) error
+	ListFiles(ctx context.Context, path *pfs.Path, shard int) ([]*pfs.FileInfo, error)
+	ListChangedFiles(ctx context.Context, from *pfs.Commit, to *pfs.Commit, shard int) ([]*pfs.Change, error)
+	ListFileHistory(ctx context.Context, path *pfs.Path, shard int) ([]*pfs.FileRevision, error)
+	Branch(ctx context.Context, commit *pfs.Commit, newCommit *pfs.Commit, branch string, message string, shards map[int]bool) (*pfs.Commit, error)
+	Merge(ctx context.Context, ours *pfs.Commit, theirs *pfs.Commit, newCommit *pfs.Commit, paths []string, branch string, message string, shards map[int]bool) (*pfs.Commit, error)
+	Commit(ctx context.Context, commit *pfs.Commit, message string, shards map[int]bool) error
+	// Uncommit turns commit back into a write commit, shards where it is not
+	// a read commit are skipped.
+	Uncommit(ctx context.Context, commit *pfs.Commit, shards map[int]bool) error
+	// DeleteCommit removes commit, shards that don't have it are skipped.
+	DeleteCommit(ctx context.Context, commit *pfs.Commit, shards map[int]bool) error
+	// SquashCommits collapses commits, a chain of read commits oldest first,
+	// into the last of them, which takes the parent of the first, the others
+	// are removed and their other children become children of the last.
+	SquashCommits(ctx context.Context, commits []*pfs.Commit, message string, shards map[int]bool) error
+	PullDiff(ctx context.Context, commit *pfs.Commit, shard int, diff io.Writer) error
+	PushDiff(ctx context.Context, commit *pfs.Commit, diff io.Reader) error
+	GetCommitInfo(ctx context.Context, commit *pfs.Commit, shard int) (*pfs.CommitInfo, bool, error)
+	ListCommits(ctx context.Context, repository *pfs.Repository, shard int) ([]*pfs.CommitInfo, error)
+	CreateBranch(ctx context.Context, repository *pfs.Repository, name string, commit *pfs.Commit) error
+	ListBranches(ctx context.Context, repository *pfs.Repository) ([]*pfs.BranchInfo, error)
+	DeleteBranch(ctx context.Context, repository *pfs.Repository, name string) error
+	// SetRetentionPolicy sets the retention policy of repository, a nil
+	// retentionPolicy removes it.
+	SetRetentionPolicy(ctx context.Context, repository *pfs.Repository, retentionPolicy *pfs.RetentionPolicy) error
+	// GetRetentionPolicy returns nil if repository has no retention policy.
+	GetRetentionPolicy(ctx context.Context, repository *pfs.Repository) (*pfs.RetentionPolicy, error)
+	// StartOperation journals operation until FinishOperation is called
+	// with it, the journal survives restarts.
+	StartOperation(ctx context.Context, operation *pfs.Operation) error
+	FinishOperation(ctx context.Context, operation *pfs.Operation) error
+	ListOperations(ctx context.Context) ([]*pfs.Operation, error)
 }
@@ -0,0 +1,1142 @@
+/*
+Package drivertest provides a test suite that every drive.Driver implementation should pass.
+
+A driver package only needs a test that calls RunDriverTests:
+
+	func TestDriver(t *testing.T) {
+		drivertest.RunDriverTests(t, func(t *testing.T) drive.Driver {
+			return NewDriver()
+		})
+	}
+
+newDriver is called at least once per test and must return a driver that
+shares no state with drivers returned by previous calls.
+*/
+package drivertest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pachyderm/pachyderm/src/pfs"
+	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pkg/protoutil"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/context"
+)
+
+const (
+	initialCommitID = "scratch"
+)
+
+var (
+	counter int32
+)
+
+// RunDriverTests runs the drive.Driver test suite against drivers returned by newDriver.
+func RunDriverTests(t *testing.T, newDriver func(t *testing.T) drive.Driver) {
+	suite.Run(t, &driverSuite{newDriver: newDriver})
+}
+
+type driverSuite struct {
+	suite.Suite
+	newDriver  func(t *testing.T) drive.Driver
+	ctx        context.Context
+	driver     drive.Driver
+	repository *pfs.Repository
+	scratch    *pfs.Commit
+}
+
+// SetupTest gives every test a new driver with an initialized repository
+// whose scratch commit is committed on shard 0.
+func (s *driverSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.driver = s.newDriver(s.T())
+	s.repository = &pfs.Repository{
+		Name: fmt.Sprintf("drivertest-%d", atomic.AddInt32(&counter, 1)),
+	}
+	s.scratch = &pfs.Commit{
+		Repository: s.repository,
+		Id:         initialCommitID,
+	}
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(0)))
+	_, err := s.driver.Branch(s.ctx, nil, s.scratch, "", "", shards(0))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.driver.Commit(s.ctx, s.scratch, "", shards(0)))
+}
+
+func (s *driverSuite) TestInitRepositoryIsIdempotent() {
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(0)))
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(1)))
+	commitInfo, ok, err := s.driver.GetCommitInfo(s.ctx, s.scratch, 0)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_READ, commitInfo.CommitType)
+}
+
+func (s *driverSuite) TestListRepositories() {
+	other := &pfs.Repository{Name: s.repository.Name + "-other"}
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, other, shards(0)))
+	repositories, err := s.driver.ListRepositories(s.ctx)
+	require.NoError(s.T(), err)
+	var names []string
+	for _, repository := range repositories {
+		names = append(names, repository.Name)
+	}
+	sort.Strings(names)
+	require.Equal(s.T(), []string{s.repository.Name, other.Name}, names)
+}
+
+func (s *driverSuite) TestInspectRepository() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	s.commit(commit)
+	child := s.branch(commit)
+	s.putFile(child, 0, "bar", "bar")
+	s.commit(child)
+	repositoryInfo, ok, err := s.driver.InspectRepository(s.ctx, s.repository, 0)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), s.repository.Name, repositoryInfo.Repository.Name)
+	require.NotNil(s.T(), repositoryInfo.Created)
+	require.Equal(s.T(), uint64(3), repositoryInfo.CommitCount)
+	// foo is shared by commit and child so it is only counted once
+	require.Equal(s.T(), uint64(6), repositoryInfo.SizeBytes)
+
+	repositoryInfo, ok, err = s.driver.InspectRepository(s.ctx, s.repository, 1)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), uint64(0), repositoryInfo.CommitCount)
+	require.Equal(s.T(), uint64(0), repositoryInfo.SizeBytes)
+}
+
+func (s *driverSuite) TestInspectRepositoryMissingRepository() {
+	repositoryInfo, ok, err := s.driver.InspectRepository(s.ctx, &pfs.Repository{Name: s.repository.Name + "-missing"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+	require.Nil(s.T(), repositoryInfo)
+}
+
+func (s *driverSuite) TestDeleteRepository() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	require.NoError(s.T(), s.driver.CreateBranch(s.ctx, s.repository, "master", s.scratch))
+	require.NoError(s.T(), s.driver.DeleteRepository(s.ctx, s.repository, shards(0)))
+	_, ok, err := s.driver.InspectRepository(s.ctx, s.repository, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+	_, ok, err = s.driver.GetCommitInfo(s.ctx, s.scratch, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+
+	// the name can be reused
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(0)))
+	_, err = s.driver.Branch(s.ctx, nil, s.scratch, "", "", shards(0))
+	require.NoError(s.T(), err)
+	branchInfos, err := s.driver.ListBranches(s.ctx, s.repository)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, len(branchInfos))
+}
+
+func (s *driverSuite) TestDeleteRepositoryShard() {
+	sharded := &pfs.Commit{
+		Repository: s.repository,
+		Id:         "sharded",
+	}
+	_, err := s.driver.Branch(s.ctx, nil, sharded, "", "", shards(0, 1))
+	require.NoError(s.T(), err)
+	s.putFile(sharded, 0, "foo", "foo")
+	s.putFile(sharded, 1, "bar", "bar")
+	require.NoError(s.T(), s.driver.Commit(s.ctx, sharded, "", shards(0, 1)))
+	require.NoError(s.T(), s.driver.DeleteRepository(s.ctx, s.repository, shards(0)))
+	for _, commit := range []*pfs.Commit{s.scratch, sharded} {
+		_, ok, err := s.driver.GetCommitInfo(s.ctx, commit, 0)
+		require.NoError(s.T(), err)
+		require.False(s.T(), ok)
+	}
+	// the other shards keep their commits
+	s.getCommitInfo(sharded, 1)
+	require.Equal(s.T(), "bar", s.getFile(sharded, 1, "bar"))
+	repositoryInfo, ok, err := s.driver.InspectRepository(s.ctx, s.repository, 1)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), uint64(1), repositoryInfo.CommitCount)
+
+	// the repository goes with its last shard
+	require.NoError(s.T(), s.driver.DeleteRepository(s.ctx, s.repository, shards(1)))
+	_, ok, err = s.driver.InspectRepository(s.ctx, s.repository, 1)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+}
+
+func (s *driverSuite) TestDeleteRepositoryMissingRepositoryFails() {
+	require.Error(s.T(), s.driver.DeleteRepository(s.ctx, &pfs.Repository{Name: s.repository.Name + "-missing"}, shards(0)))
+}
+
+func (s *driverSuite) TestBranchRequiresCommitOrNewCommit() {
+	_, err := s.driver.Branch(s.ctx, nil, nil, "", "", shards(0))
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestBranchGeneratesCommitID() {
+	commit := s.branch(s.scratch)
+	require.NotEqual(s.T(), "", commit.Id)
+	require.Equal(s.T(), s.repository.Name, commit.Repository.Name)
+	other := s.branch(s.scratch)
+	require.NotEqual(s.T(), commit.Id, other.Id)
+}
+
+func (s *driverSuite) TestBranchUsesNewCommitID() {
+	newCommit := &pfs.Commit{
+		Repository: s.repository,
+		Id:         "foo",
+	}
+	commit, err := s.driver.Branch(s.ctx, s.scratch, newCommit, "", "", shards(0))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "foo", commit.Id)
+	commitInfo := s.getCommitInfo(newCommit, 0)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_WRITE, commitInfo.CommitType)
+	require.Equal(s.T(), initialCommitID, commitInfo.ParentCommit.Id)
+}
+
+func (s *driverSuite) TestBranchFromWriteCommitFails() {
+	commit := s.branch(s.scratch)
+	_, err := s.driver.Branch(s.ctx, commit, nil, "", "", shards(0))
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestBranchToExistingCommitFails() {
+	commit := s.branch(s.scratch)
+	_, err := s.driver.Branch(s.ctx, s.scratch, commit, "", "", shards(0))
+	require.Error(s.T(), err)
+	_, err = s.driver.Branch(s.ctx, s.scratch, s.scratch, "", "", shards(0))
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestBranchCopiesFiles() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	s.commit(commit)
+	child := s.branch(commit)
+	require.Equal(s.T(), "foo", s.getFile(child, 0, "foo"))
+}
+
+func (s *driverSuite) TestBranchIsIsolatedFromParent() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "dir"}, shards(0)))
+	s.putFile(commit, 0, "dir/foo", "foo")
+	s.commit(commit)
+	child := s.branch(commit)
+	s.putFile(child, 0, "dir/foo", "bar")
+	s.putFile(child, 0, "dir/bar", "bar")
+	require.Equal(s.T(), "bar", s.getFile(child, 0, "dir/foo"))
+	require.Equal(s.T(), "foo", s.getFile(commit, 0, "dir/foo"))
+	_, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: commit, Path: "dir/bar"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+}
+
+func (s *driverSuite) TestCommit() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	s.commit(commit)
+	commitInfo := s.getCommitInfo(commit, 0)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_READ, commitInfo.CommitType)
+	require.Equal(s.T(), "foo", s.getFile(commit, 0, "foo"))
+}
+
+func (s *driverSuite) TestCommitReadCommitFails() {
+	require.Error(s.T(), s.driver.Commit(s.ctx, s.scratch, "", shards(0)))
+}
+
+func (s *driverSuite) TestCommitMissingCommitFails() {
+	require.Error(s.T(), s.driver.Commit(s.ctx, &pfs.Commit{Repository: s.repository, Id: "missing"}, "", shards(0)))
+}
+
+func (s *driverSuite) TestUncommit() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	require.NoError(s.T(), s.driver.Commit(s.ctx, commit, "message", shards(0)))
+	require.NoError(s.T(), s.driver.Uncommit(s.ctx, commit, shards(0)))
+	commitInfo := s.getCommitInfo(commit, 0)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_WRITE, commitInfo.CommitType)
+	require.Nil(s.T(), commitInfo.Finished)
+	s.putFile(commit, 0, "bar", "bar")
+	require.Equal(s.T(), "foo", s.getFile(commit, 0, "foo"))
+	s.commit(commit)
+}
+
+func (s *driverSuite) TestUncommitWriteCommitIsSkipped() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.Uncommit(s.ctx, commit, shards(0)))
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_WRITE, s.getCommitInfo(commit, 0).CommitType)
+}
+
+func (s *driverSuite) TestDeleteCommit() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	s.commit(commit)
+	require.NoError(s.T(), s.driver.DeleteCommit(s.ctx, commit, shards(0)))
+	_, ok, err := s.driver.GetCommitInfo(s.ctx, commit, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+	// the id can be used again
+	_, err = s.driver.Branch(s.ctx, s.scratch, commit, "", "", shards(0))
+	require.NoError(s.T(), err)
+}
+
+func (s *driverSuite) TestDeleteCommitMissingShardIsSkipped() {
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(0, 1)))
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.DeleteCommit(s.ctx, commit, shards(0, 1)))
+	_, ok, err := s.driver.GetCommitInfo(s.ctx, commit, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+}
+
+func (s *driverSuite) TestSquashCommits() {
+	first := s.branch(s.scratch)
+	s.putFile(first, 0, "foo", "foo")
+	s.putFile(first, 0, "bar", "bar")
+	s.commit(first)
+	second := s.branch(first)
+	s.putFile(second, 0, "foo", "FOO")
+	require.NoError(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: second, Path: "bar"}, shards(0)))
+	s.commit(second)
+	third := s.branch(second)
+	s.putFile(third, 0, "baz", "baz")
+	s.commit(third)
+	child := s.branch(third)
+	// other children of the squashed commits become children of third
+	sibling := s.branch(second)
+	s.putFile(sibling, 0, "sibling", "sibling")
+	s.commit(sibling)
+	writeSibling := s.branch(first)
+
+	require.NoError(s.T(), s.driver.SquashCommits(s.ctx, []*pfs.Commit{first, second, third}, "squashed", shards(0)))
+	for _, commit := range []*pfs.Commit{first, second} {
+		_, ok, err := s.driver.GetCommitInfo(s.ctx, commit, 0)
+		require.NoError(s.T(), err)
+		require.False(s.T(), ok)
+	}
+	commitInfo := s.getCommitInfo(third, 0)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_READ, commitInfo.CommitType)
+	require.Equal(s.T(), initialCommitID, commitInfo.ParentCommit.Id)
+	require.Equal(s.T(), "squashed", commitInfo.Message)
+	require.Equal(s.T(), []string{"baz", "foo"}, s.listFiles(third, ""))
+	require.Equal(s.T(), "FOO", s.getFile(third, 0, "foo"))
+	require.Equal(s.T(), []string{"CHANGE_TYPE_ADDED baz", "CHANGE_TYPE_ADDED foo"}, s.listChangedFiles(nil, third))
+	require.Equal(s.T(), third.Id, s.getCommitInfo(child, 0).ParentCommit.Id)
+	require.Equal(s.T(), "baz", s.getFile(child, 0, "baz"))
+	for _, commit := range []*pfs.Commit{sibling, writeSibling} {
+		require.Equal(s.T(), third.Id, s.getCommitInfo(commit, 0).ParentCommit.Id)
+	}
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_READ, s.getCommitInfo(sibling, 0).CommitType)
+	require.Equal(s.T(), "sibling", s.getFile(sibling, 0, "sibling"))
+	require.Equal(s.T(), "FOO", s.getFile(sibling, 0, "foo"))
+
+	// the squashed commit can be pushed to a replica that has its new parent
+	replica := s.newDriver(s.T())
+	require.NoError(s.T(), replica.InitRepository(s.ctx, s.repository, shards(0)))
+	for _, c := range []*pfs.Commit{s.scratch, third} {
+		var buffer bytes.Buffer
+		require.NoError(s.T(), s.driver.PullDiff(s.ctx, c, 0, &buffer))
+		require.NoError(s.T(), replica.PushDiff(s.ctx, c, &buffer))
+	}
+	require.Equal(s.T(), "FOO", readFile(s.T(), replica, &pfs.Path{Commit: third, Path: "foo"}, 0))
+	_, ok, err := replica.GetFileInfo(s.ctx, &pfs.Path{Commit: third, Path: "bar"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+}
+
+func (s *driverSuite) TestSquashCommitsNotAChainFails() {
+	first := s.branch(s.scratch)
+	s.commit(first)
+	second := s.branch(first)
+	s.commit(second)
+	third := s.branch(second)
+	s.commit(third)
+	require.Error(s.T(), s.driver.SquashCommits(s.ctx, []*pfs.Commit{first, third}, "", shards(0)))
+	require.Equal(s.T(), second.Id, s.getCommitInfo(third, 0).ParentCommit.Id)
+	s.getCommitInfo(first, 0)
+}
+
+func (s *driverSuite) TestSquashCommitsWriteCommitFails() {
+	first := s.branch(s.scratch)
+	s.commit(first)
+	second := s.branch(first)
+	require.Error(s.T(), s.driver.SquashCommits(s.ctx, []*pfs.Commit{first, second}, "", shards(0)))
+	s.getCommitInfo(first, 0)
+}
+
+func (s *driverSuite) TestGetCommitInfo() {
+	commitInfo := s.getCommitInfo(s.scratch, 0)
+	require.Equal(s.T(), initialCommitID, commitInfo.Commit.Id)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_READ, commitInfo.CommitType)
+	require.Nil(s.T(), commitInfo.ParentCommit)
+	commit := s.branch(s.scratch)
+	commitInfo = s.getCommitInfo(commit, 0)
+	require.Equal(s.T(), commit.Id, commitInfo.Commit.Id)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_WRITE, commitInfo.CommitType)
+	require.Equal(s.T(), initialCommitID, commitInfo.ParentCommit.Id)
+}
+
+func (s *driverSuite) TestGetCommitInfoMetadata() {
+	commit, err := s.driver.Branch(s.ctx, s.scratch, nil, "master", "branch message", shards(0))
+	require.NoError(s.T(), err)
+	s.putFile(commit, 0, "foo", "hello")
+	commitInfo := s.getCommitInfo(commit, 0)
+	require.NotNil(s.T(), commitInfo.Created)
+	require.Nil(s.T(), commitInfo.Finished)
+	require.Equal(s.T(), "master", commitInfo.Branch)
+	require.Equal(s.T(), "branch message", commitInfo.Message)
+	require.Equal(s.T(), uint64(5), commitInfo.SizeBytes)
+
+	s.putFile(commit, 0, "bar", "hi")
+	s.commit(commit)
+	commitInfo = s.getCommitInfo(commit, 0)
+	require.NotNil(s.T(), commitInfo.Finished)
+	require.False(s.T(), protoutil.TimestampLess(commitInfo.Finished, commitInfo.Created))
+	require.Equal(s.T(), "master", commitInfo.Branch)
+	require.Equal(s.T(), "branch message", commitInfo.Message)
+	require.Equal(s.T(), uint64(7), commitInfo.SizeBytes)
+
+	// the child does not inherit the metadata of its parent
+	child := s.branch(commit)
+	commitInfo = s.getCommitInfo(child, 0)
+	require.Nil(s.T(), commitInfo.Finished)
+	require.Equal(s.T(), "", commitInfo.Branch)
+	require.Equal(s.T(), "", commitInfo.Message)
+	require.NoError(s.T(), s.driver.Commit(s.ctx, child, "commit message", shards(0)))
+	commitInfo = s.getCommitInfo(child, 0)
+	require.Equal(s.T(), "commit message", commitInfo.Message)
+	require.Equal(s.T(), uint64(7), commitInfo.SizeBytes)
+}
+
+func (s *driverSuite) TestGetCommitInfoMissingCommit() {
+	commitInfo, ok, err := s.driver.GetCommitInfo(s.ctx, &pfs.Commit{Repository: s.repository, Id: "missing"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+	require.Nil(s.T(), commitInfo)
+}
+
+func (s *driverSuite) TestListCommitsNewestFirst() {
+	commit := s.branch(s.scratch)
+	s.commit(commit)
+	child := s.branch(commit)
+	commitInfos, err := s.driver.ListCommits(s.ctx, s.repository, 0)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 3, len(commitInfos))
+	require.Equal(s.T(), child.Id, commitInfos[0].Commit.Id)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_WRITE, commitInfos[0].CommitType)
+	require.Equal(s.T(), commit.Id, commitInfos[1].Commit.Id)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_READ, commitInfos[1].CommitType)
+	require.Equal(s.T(), initialCommitID, commitInfos[2].Commit.Id)
+}
+
+func (s *driverSuite) TestCreateBranch() {
+	require.NoError(s.T(), s.driver.CreateBranch(s.ctx, s.repository, "master", s.scratch))
+	commit := s.branch(s.scratch)
+	s.commit(commit)
+	require.NoError(s.T(), s.driver.CreateBranch(s.ctx, s.repository, "other", commit))
+	branchInfos, err := s.driver.ListBranches(s.ctx, s.repository)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2, len(branchInfos))
+	require.Equal(s.T(), "master", branchInfos[0].Name)
+	require.Equal(s.T(), initialCommitID, branchInfos[0].Commit.Id)
+	require.Equal(s.T(), "other", branchInfos[1].Name)
+	require.Equal(s.T(), commit.Id, branchInfos[1].Commit.Id)
+
+	// creating an existing branch moves it
+	require.NoError(s.T(), s.driver.CreateBranch(s.ctx, s.repository, "master", commit))
+	branchInfos, err = s.driver.ListBranches(s.ctx, s.repository)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2, len(branchInfos))
+	require.Equal(s.T(), commit.Id, branchInfos[0].Commit.Id)
+}
+
+func (s *driverSuite) TestListBranchesEmpty() {
+	branchInfos, err := s.driver.ListBranches(s.ctx, s.repository)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, len(branchInfos))
+}
+
+func (s *driverSuite) TestDeleteBranch() {
+	require.NoError(s.T(), s.driver.CreateBranch(s.ctx, s.repository, "master", s.scratch))
+	require.NoError(s.T(), s.driver.DeleteBranch(s.ctx, s.repository, "master"))
+	branchInfos, err := s.driver.ListBranches(s.ctx, s.repository)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, len(branchInfos))
+	require.Error(s.T(), s.driver.DeleteBranch(s.ctx, s.repository, "master"))
+	// the commit is not deleted with the branch
+	s.getCommitInfo(s.scratch, 0)
+}
+
+func (s *driverSuite) TestBranchesMissingRepositoryFail() {
+	repository := &pfs.Repository{Name: "missing"}
+	require.Error(s.T(), s.driver.CreateBranch(s.ctx, repository, "master", &pfs.Commit{Repository: repository, Id: initialCommitID}))
+	_, err := s.driver.ListBranches(s.ctx, repository)
+	require.Error(s.T(), err)
+	require.Error(s.T(), s.driver.DeleteBranch(s.ctx, repository, "master"))
+}
+
+func (s *driverSuite) TestRetentionPolicy() {
+	retentionPolicy, err := s.driver.GetRetentionPolicy(s.ctx, s.repository)
+	require.NoError(s.T(), err)
+	require.Nil(s.T(), retentionPolicy)
+	require.NoError(s.T(), s.driver.SetRetentionPolicy(
+		s.ctx,
+		s.repository,
+		&pfs.RetentionPolicy{
+			KeepLast:           3,
+			KeepNewerThan:      protoutil.DurationToProtoDuration(time.Hour),
+			WriteCommitTimeout: protoutil.DurationToProtoDuration(time.Minute),
+		},
+	))
+	retentionPolicy, err = s.driver.GetRetentionPolicy(s.ctx, s.repository)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), uint64(3), retentionPolicy.KeepLast)
+	require.Equal(s.T(), time.Hour, protoutil.ProtoDurationToDuration(retentionPolicy.KeepNewerThan))
+	require.Equal(s.T(), time.Minute, protoutil.ProtoDurationToDuration(retentionPolicy.WriteCommitTimeout))
+
+	// a nil policy removes it
+	require.NoError(s.T(), s.driver.SetRetentionPolicy(s.ctx, s.repository, nil))
+	retentionPolicy, err = s.driver.GetRetentionPolicy(s.ctx, s.repository)
+	require.NoError(s.T(), err)
+	require.Nil(s.T(), retentionPolicy)
+	require.NoError(s.T(), s.driver.SetRetentionPolicy(s.ctx, s.repository, nil))
+}
+
+func (s *driverSuite) TestRetentionPolicyMissingRepositoryFails() {
+	repository := &pfs.Repository{Name: "missing"}
+	require.Error(s.T(), s.driver.SetRetentionPolicy(s.ctx, repository, &pfs.RetentionPolicy{KeepLast: 1}))
+	_, err := s.driver.GetRetentionPolicy(s.ctx, repository)
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestMerge() {
+	ours := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: ours, Path: "dir"}, shards(0)))
+	s.putFile(ours, 0, "dir/foo", "foo")
+	s.putFile(ours, 0, "bar", "bar")
+	s.commit(ours)
+	theirs := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: theirs, Path: "dir/sub"}, shards(0)))
+	s.putFile(theirs, 0, "dir/sub/baz", "baz")
+	s.putFile(theirs, 0, "dir/foo", "FOO")
+	s.commit(theirs)
+	newCommit, err := s.driver.Merge(s.ctx, ours, theirs, nil, []string{"bar", "dir/foo", "dir/sub", "dir/sub/baz"}, "master", "merge", shards(0))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "FOO", s.getFile(newCommit, 0, "dir/foo"))
+	require.Equal(s.T(), "baz", s.getFile(newCommit, 0, "dir/sub/baz"))
+	require.Equal(s.T(), []string{"dir"}, s.listFiles(newCommit, ""))
+	s.commit(newCommit)
+	commitInfo := s.getCommitInfo(newCommit, 0)
+	require.Equal(s.T(), ours, commitInfo.ParentCommit)
+	require.Equal(s.T(), theirs, commitInfo.MergeParentCommit)
+	require.Equal(s.T(), "master", commitInfo.Branch)
+	require.Equal(s.T(), "merge", commitInfo.Message)
+	// neither parent is changed
+	require.Equal(s.T(), "foo", s.getFile(ours, 0, "dir/foo"))
+	require.Equal(s.T(), []string{"dir"}, s.listFiles(theirs, ""))
+
+	replica := s.newDriver(s.T())
+	require.NoError(s.T(), replica.InitRepository(s.ctx, s.repository, shards(0)))
+	for _, c := range []*pfs.Commit{s.scratch, ours, theirs, newCommit} {
+		var buffer bytes.Buffer
+		require.NoError(s.T(), s.driver.PullDiff(s.ctx, c, 0, &buffer))
+		require.NoError(s.T(), replica.PushDiff(s.ctx, c, &buffer))
+	}
+	replicaCommitInfo, ok, err := replica.GetCommitInfo(s.ctx, newCommit, 0)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), theirs, replicaCommitInfo.MergeParentCommit)
+	require.Equal(s.T(), "FOO", readFile(s.T(), replica, &pfs.Path{Commit: newCommit, Path: "dir/foo"}, 0))
+	_, ok, err = replica.GetFileInfo(s.ctx, &pfs.Path{Commit: newCommit, Path: "bar"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+}
+
+func (s *driverSuite) TestMergeWriteCommitFails() {
+	ours := s.branch(s.scratch)
+	s.commit(ours)
+	theirs := s.branch(s.scratch)
+	_, err := s.driver.Merge(s.ctx, ours, theirs, nil, nil, "", "", shards(0))
+	require.Error(s.T(), err)
+	_, err = s.driver.Merge(s.ctx, theirs, ours, nil, nil, "", "", shards(0))
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestMakeDirectory() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "a/b"}, shards(0)))
+	for _, path := range []string{"a", "a/b"} {
+		fileInfo, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: commit, Path: path}, 0)
+		require.NoError(s.T(), err)
+		require.True(s.T(), ok)
+		require.Equal(s.T(), pfs.FileType_FILE_TYPE_DIR, fileInfo.FileType)
+	}
+	// making an existing directory is not an error
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "a"}, shards(0)))
+}
+
+func (s *driverSuite) TestMakeDirectoryOnAllShards() {
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(0, 1)))
+	newCommit := &pfs.Commit{Repository: s.repository, Id: "multi"}
+	_, err := s.driver.Branch(s.ctx, nil, newCommit, "", "", shards(0, 1))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: newCommit, Path: "dir"}, shards(0, 1)))
+	for _, shard := range []int{0, 1} {
+		_, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: newCommit, Path: "dir"}, shard)
+		require.NoError(s.T(), err)
+		require.True(s.T(), ok)
+	}
+}
+
+func (s *driverSuite) TestMakeDirectoryFailureLeavesNoDirectory() {
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(0, 1)))
+	// the commit is only on shard 0 so shard 1 fails
+	commit := s.branch(s.scratch)
+	require.Error(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "a/b"}, shards(0, 1)))
+	_, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: commit, Path: "a"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+}
+
+func (s *driverSuite) TestMakeDirectoryReadCommitFails() {
+	require.Error(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: s.scratch, Path: "dir"}, shards(0)))
+}
+
+func (s *driverSuite) TestPutFileAndGetFile() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "hello")
+	// GetFile works on write commits
+	require.Equal(s.T(), "hello", s.getFile(commit, 0, "foo"))
+}
+
+func (s *driverSuite) TestPutFileAtOffset() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "hello")
+	require.NoError(s.T(), s.driver.PutFile(s.ctx, &pfs.Path{Commit: commit, Path: "foo"}, 0, 5, strings.NewReader("world")))
+	require.Equal(s.T(), "helloworld", s.getFile(commit, 0, "foo"))
+}
+
+func (s *driverSuite) TestPutFileNegativeOffsetFails() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "hello")
+	require.Error(s.T(), s.driver.PutFile(s.ctx, &pfs.Path{Commit: commit, Path: "foo"}, 0, -1, strings.NewReader("world")))
+}
+
+func (s *driverSuite) TestPutFileIsPerShard() {
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(0, 1)))
+	newCommit := &pfs.Commit{Repository: s.repository, Id: "multi"}
+	_, err := s.driver.Branch(s.ctx, nil, newCommit, "", "", shards(0, 1))
+	require.NoError(s.T(), err)
+	s.putFile(newCommit, 0, "foo", "foo")
+	_, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: newCommit, Path: "foo"}, 1)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+}
+
+func (s *driverSuite) TestPutFileReadCommitFails() {
+	require.Error(s.T(), s.driver.PutFile(s.ctx, &pfs.Path{Commit: s.scratch, Path: "foo"}, 0, 0, strings.NewReader("foo")))
+}
+
+func (s *driverSuite) TestPutFileMissingDirectoryFails() {
+	commit := s.branch(s.scratch)
+	require.Error(s.T(), s.driver.PutFile(s.ctx, &pfs.Path{Commit: commit, Path: "dir/foo"}, 0, 0, strings.NewReader("foo")))
+}
+
+func (s *driverSuite) TestDeleteFile() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	s.putFile(commit, 0, "bar", "bar")
+	require.NoError(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: commit, Path: "foo"}, shards(0)))
+	require.Equal(s.T(), []string{"bar"}, s.listFiles(commit, ""))
+}
+
+func (s *driverSuite) TestDeleteFileDirectory() {
+	require.NoError(s.T(), s.driver.InitRepository(s.ctx, s.repository, shards(0, 1)))
+	newCommit := &pfs.Commit{Repository: s.repository, Id: "multi"}
+	_, err := s.driver.Branch(s.ctx, nil, newCommit, "", "", shards(0, 1))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: newCommit, Path: "dir/sub"}, shards(0, 1)))
+	s.putFile(newCommit, 0, "dir/sub/foo", "foo")
+	s.putFile(newCommit, 1, "dir/bar", "bar")
+	require.NoError(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: newCommit, Path: "dir"}, shards(0, 1)))
+	for _, shard := range []int{0, 1} {
+		for _, path := range []string{"dir", "dir/sub", "dir/sub/foo", "dir/bar"} {
+			_, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: newCommit, Path: path}, shard)
+			require.NoError(s.T(), err)
+			require.False(s.T(), ok)
+		}
+	}
+}
+
+func (s *driverSuite) TestDeleteFileDoesNotChangeParent() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	s.commit(commit)
+	child := s.branch(commit)
+	require.NoError(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: child, Path: "foo"}, shards(0)))
+	require.Equal(s.T(), "foo", s.getFile(commit, 0, "foo"))
+}
+
+func (s *driverSuite) TestDeleteFileMissingFileFails() {
+	commit := s.branch(s.scratch)
+	require.Error(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: commit, Path: "missing"}, shards(0)))
+}
+
+func (s *driverSuite) TestDeleteFileRootFails() {
+	commit := s.branch(s.scratch)
+	require.Error(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: commit, Path: ""}, shards(0)))
+}
+
+func (s *driverSuite) TestDeleteFileReadCommitFails() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	s.commit(commit)
+	require.Error(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: commit, Path: "foo"}, shards(0)))
+}
+
+func (s *driverSuite) TestPruneCommit() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "dir"}, shards(0)))
+	s.putFile(commit, 0, "dir/foo", "foo")
+	s.putFile(commit, 0, "bar", "bar")
+	s.commit(commit)
+	child := s.branch(commit)
+	s.putFile(child, 0, "dir/baz", "baz")
+	moved := func(path string) bool {
+		return path != "bar"
+	}
+	for _, c := range []*pfs.Commit{commit, child} {
+		require.NoError(s.T(), s.driver.PruneCommit(s.ctx, c, 0, moved))
+		require.Equal(s.T(), []string{"bar", "dir"}, s.listFiles(c, ""))
+		require.Equal(s.T(), "bar", s.getFile(c, 0, "bar"))
+	}
+	// a read commit stays one and no longer counts the pruned files
+	commitInfo := s.getCommitInfo(commit, 0)
+	require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_READ, commitInfo.CommitType)
+	require.Equal(s.T(), uint64(len("bar")), commitInfo.SizeBytes)
+	s.putFile(child, 0, "dir/baz", "baz")
+	s.commit(child)
+	require.Equal(s.T(), []string{"CHANGE_TYPE_ADDED dir/baz"}, s.listChangedFiles(commit, child))
+}
+
+func (s *driverSuite) TestGetFileMissingFileFails() {
+	commit := s.branch(s.scratch)
+	_, err := s.driver.GetFile(s.ctx, &pfs.Path{Commit: commit, Path: "missing"}, 0)
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestGetFileInfo() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "hello")
+	fileInfo, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: commit, Path: "foo"}, 0)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), "foo", fileInfo.Path.Path)
+	require.Equal(s.T(), pfs.FileType_FILE_TYPE_REGULAR, fileInfo.FileType)
+	require.Equal(s.T(), uint64(5), fileInfo.SizeBytes)
+	require.NotNil(s.T(), fileInfo.LastModified)
+}
+
+func (s *driverSuite) TestGetFileInfoMissingFile() {
+	commit := s.branch(s.scratch)
+	fileInfo, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: commit, Path: "missing"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+	require.Nil(s.T(), fileInfo)
+}
+
+func (s *driverSuite) TestGetFileInfoChecksum() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	s.putFile(commit, 0, "bar", "bar")
+	require.Nil(s.T(), s.getFileInfo(commit, "foo").Checksum)
+	s.commit(commit)
+	require.Equal(s.T(), checksum("foo"), s.getFileInfo(commit, "foo").Checksum)
+	require.Equal(s.T(), checksum("bar"), s.getFileInfo(commit, "bar").Checksum)
+
+	child := s.branch(commit)
+	s.putFile(child, 0, "foo", "FOO")
+	require.Nil(s.T(), s.getFileInfo(child, "foo").Checksum)
+	s.commit(child)
+	require.Equal(s.T(), checksum("FOO"), s.getFileInfo(child, "foo").Checksum)
+	require.Equal(s.T(), checksum("bar"), s.getFileInfo(child, "bar").Checksum)
+	require.Equal(s.T(), checksum("foo"), s.getFileInfo(commit, "foo").Checksum)
+}
+
+func (s *driverSuite) TestGetFileInfoChecksumDirectory() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "dir"}, shards(0)))
+	s.commit(commit)
+	require.Nil(s.T(), s.getFileInfo(commit, "dir").Checksum)
+}
+
+func (s *driverSuite) TestListFiles() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "dir/sub"}, shards(0)))
+	s.putFile(commit, 0, "dir/foo", "foo")
+	s.putFile(commit, 0, "dir/sub/bar", "bar")
+	s.putFile(commit, 0, "baz", "baz")
+	require.Equal(s.T(), []string{"baz", "dir"}, s.listFiles(commit, ""))
+	require.Equal(s.T(), []string{"dir/foo", "dir/sub"}, s.listFiles(commit, "dir"))
+	require.Equal(s.T(), []string{"dir/sub/bar"}, s.listFiles(commit, "dir/sub"))
+}
+
+func (s *driverSuite) TestListFilesEmptyCommit() {
+	commit := s.branch(s.scratch)
+	fileInfos, err := s.driver.ListFiles(s.ctx, &pfs.Path{Commit: commit, Path: ""}, 0)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, len(fileInfos))
+}
+
+func (s *driverSuite) TestListFilesMissingDirectoryFails() {
+	commit := s.branch(s.scratch)
+	_, err := s.driver.ListFiles(s.ctx, &pfs.Path{Commit: commit, Path: "missing"}, 0)
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestListFilesOnFileFails() {
+	commit := s.branch(s.scratch)
+	s.putFile(commit, 0, "foo", "foo")
+	_, err := s.driver.ListFiles(s.ctx, &pfs.Path{Commit: commit, Path: "foo"}, 0)
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestListChangedFiles() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "dir"}, shards(0)))
+	s.putFile(commit, 0, "dir/foo", "foo")
+	s.putFile(commit, 0, "bar", "bar")
+	s.putFile(commit, 0, "same", "same")
+	s.commit(commit)
+	child, err := s.driver.Branch(s.ctx, commit, nil, "master", "", shards(0))
+	require.NoError(s.T(), err)
+	s.putFile(child, 0, "dir/foo", "FOO")
+	s.putFile(child, 0, "baz", "baz")
+	s.putFile(child, 0, "same", "same")
+	require.NoError(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: child, Path: "bar"}, shards(0)))
+	expected := []string{
+		"CHANGE_TYPE_DELETED bar",
+		"CHANGE_TYPE_ADDED baz",
+		"CHANGE_TYPE_MODIFIED dir/foo",
+	}
+	// write commits can be diffed too
+	require.Equal(s.T(), expected, s.listChangedFiles(nil, child))
+	s.commit(child)
+	require.Equal(s.T(), expected, s.listChangedFiles(nil, child))
+	require.Equal(s.T(), expected, s.listChangedFiles(commit, child))
+}
+
+func (s *driverSuite) TestListChangedFilesFromAncestor() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "dir"}, shards(0)))
+	s.putFile(commit, 0, "dir/foo", "foo")
+	s.commit(commit)
+	child := s.branch(commit)
+	s.putFile(child, 0, "bar", "bar")
+	s.commit(child)
+	require.Equal(
+		s.T(),
+		[]string{
+			"CHANGE_TYPE_ADDED bar",
+			"CHANGE_TYPE_ADDED dir",
+			"CHANGE_TYPE_ADDED dir/foo",
+		},
+		s.listChangedFiles(s.scratch, child),
+	)
+	require.Equal(
+		s.T(),
+		[]string{
+			"CHANGE_TYPE_DELETED bar",
+			"CHANGE_TYPE_DELETED dir",
+			"CHANGE_TYPE_DELETED dir/foo",
+		},
+		s.listChangedFiles(child, s.scratch),
+	)
+}
+
+func (s *driverSuite) TestListChangedFilesWithoutParent() {
+	require.Equal(s.T(), []string(nil), s.listChangedFiles(nil, s.scratch))
+}
+
+func (s *driverSuite) TestListChangedFilesDirectoryReplacedByFile() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "foo"}, shards(0)))
+	s.putFile(commit, 0, "foo/bar", "bar")
+	s.commit(commit)
+	child := s.branch(commit)
+	require.NoError(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: child, Path: "foo"}, shards(0)))
+	s.putFile(child, 0, "foo", "foo")
+	changes, err := s.driver.ListChangedFiles(s.ctx, nil, child, 0)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 3, len(changes))
+	require.Equal(s.T(), pfs.ChangeType_CHANGE_TYPE_DELETED, changes[0].ChangeType)
+	require.Equal(s.T(), pfs.FileType_FILE_TYPE_DIR, changes[0].FileType)
+	require.Equal(s.T(), pfs.ChangeType_CHANGE_TYPE_ADDED, changes[1].ChangeType)
+	require.Equal(s.T(), pfs.FileType_FILE_TYPE_REGULAR, changes[1].FileType)
+	require.Equal(s.T(), "foo/bar", changes[2].Path.Path)
+	require.Equal(s.T(), child, changes[2].Path.Commit)
+}
+
+func (s *driverSuite) TestListChangedFilesMissingCommitFails() {
+	_, err := s.driver.ListChangedFiles(s.ctx, nil, &pfs.Commit{Repository: s.repository, Id: "missing"}, 0)
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestListFileHistory() {
+	commit1 := s.branch(s.scratch)
+	s.putFile(commit1, 0, "foo", "foo")
+	s.putFile(commit1, 0, "bar", "bar")
+	s.commit(commit1)
+	commit2 := s.branch(commit1)
+	s.putFile(commit2, 0, "bar", "BAR")
+	s.commit(commit2)
+	commit3 := s.branch(commit2)
+	s.putFile(commit3, 0, "foo", "FOO!")
+	s.commit(commit3)
+	commit4 := s.branch(commit3)
+	require.NoError(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: commit4, Path: "foo"}, shards(0)))
+
+	fileRevisions, err := s.driver.ListFileHistory(s.ctx, &pfs.Path{Commit: commit4, Path: "foo"}, 0)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 3, len(fileRevisions))
+	require.Equal(s.T(), commit4.Id, fileRevisions[0].Commit.Id)
+	require.Equal(s.T(), pfs.ChangeType_CHANGE_TYPE_DELETED, fileRevisions[0].ChangeType)
+	require.Nil(s.T(), fileRevisions[0].Finished)
+	require.Equal(s.T(), commit3.Id, fileRevisions[1].Commit.Id)
+	require.Equal(s.T(), pfs.ChangeType_CHANGE_TYPE_MODIFIED, fileRevisions[1].ChangeType)
+	require.Equal(s.T(), uint64(4), fileRevisions[1].SizeBytes)
+	require.Equal(s.T(), s.getCommitInfo(commit3, 0).Finished, fileRevisions[1].Finished)
+	require.Equal(s.T(), commit1.Id, fileRevisions[2].Commit.Id)
+	require.Equal(s.T(), pfs.ChangeType_CHANGE_TYPE_ADDED, fileRevisions[2].ChangeType)
+	require.Equal(s.T(), uint64(3), fileRevisions[2].SizeBytes)
+
+	fileRevisions, err = s.driver.ListFileHistory(s.ctx, &pfs.Path{Commit: commit3, Path: "bar"}, 0)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2, len(fileRevisions))
+	require.Equal(s.T(), commit2.Id, fileRevisions[0].Commit.Id)
+	require.Equal(s.T(), commit1.Id, fileRevisions[1].Commit.Id)
+
+	fileRevisions, err = s.driver.ListFileHistory(s.ctx, &pfs.Path{Commit: commit3, Path: "foo/missing"}, 0)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, len(fileRevisions))
+}
+
+func (s *driverSuite) TestListFileHistoryMissingCommitFails() {
+	_, err := s.driver.ListFileHistory(s.ctx, &pfs.Path{Commit: &pfs.Commit{Repository: s.repository, Id: "missing"}, Path: "foo"}, 0)
+	require.Error(s.T(), err)
+}
+
+func (s *driverSuite) TestPullDiffWriteCommitFails() {
+	commit := s.branch(s.scratch)
+	require.Error(s.T(), s.driver.PullDiff(s.ctx, commit, 0, ioutil.Discard))
+}
+
+func (s *driverSuite) TestPullDiffPushDiff() {
+	commit := s.branch(s.scratch)
+	require.NoError(s.T(), s.driver.MakeDirectory(s.ctx, &pfs.Path{Commit: commit, Path: "dir"}, shards(0)))
+	s.putFile(commit, 0, "dir/foo", "foo")
+	s.putFile(commit, 0, "bar", "bar")
+	s.commit(commit)
+	child := s.branch(commit)
+	s.putFile(child, 0, "dir/foo", "FOO")
+	s.putFile(child, 0, "baz", "baz")
+	require.NoError(s.T(), s.driver.DeleteFile(s.ctx, &pfs.Path{Commit: child, Path: "bar"}, shards(0)))
+	require.NoError(s.T(), s.driver.Commit(s.ctx, child, "child", shards(0)))
+
+	replica := s.newDriver(s.T())
+	require.NoError(s.T(), replica.InitRepository(s.ctx, s.repository, shards(0)))
+	for _, c := range []*pfs.Commit{s.scratch, commit, child} {
+		var buffer bytes.Buffer
+		require.NoError(s.T(), s.driver.PullDiff(s.ctx, c, 0, &buffer))
+		require.NoError(s.T(), replica.PushDiff(s.ctx, c, &buffer))
+	}
+
+	for _, c := range []*pfs.Commit{s.scratch, commit, child} {
+		expected := s.getCommitInfo(c, 0)
+		commitInfo, ok, err := replica.GetCommitInfo(s.ctx, c, 0)
+		require.NoError(s.T(), err)
+		require.True(s.T(), ok)
+		require.Equal(s.T(), pfs.CommitType_COMMIT_TYPE_READ, commitInfo.CommitType)
+		require.Equal(s.T(), expected.ParentCommit, commitInfo.ParentCommit)
+		require.Equal(s.T(), expected.Created, commitInfo.Created)
+		require.Equal(s.T(), expected.Finished, commitInfo.Finished)
+		require.Equal(s.T(), expected.Branch, commitInfo.Branch)
+		require.Equal(s.T(), expected.Message, commitInfo.Message)
+		require.Equal(s.T(), expected.SizeBytes, commitInfo.SizeBytes)
+	}
+	require.Equal(s.T(), "foo", readFile(s.T(), replica, &pfs.Path{Commit: commit, Path: "dir/foo"}, 0))
+	require.Equal(s.T(), "bar", readFile(s.T(), replica, &pfs.Path{Commit: commit, Path: "bar"}, 0))
+	require.Equal(s.T(), "FOO", readFile(s.T(), replica, &pfs.Path{Commit: child, Path: "dir/foo"}, 0))
+	require.Equal(s.T(), "baz", readFile(s.T(), replica, &pfs.Path{Commit: child, Path: "baz"}, 0))
+	fileInfo, ok, err := replica.GetFileInfo(s.ctx, &pfs.Path{Commit: child, Path: "dir/foo"}, 0)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), checksum("FOO"), fileInfo.Checksum)
+	_, ok, err = replica.GetFileInfo(s.ctx, &pfs.Path{Commit: commit, Path: "baz"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+	_, ok, err = replica.GetFileInfo(s.ctx, &pfs.Path{Commit: child, Path: "bar"}, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+
+	// the replica can branch from pushed commits
+	grandchild, err := replica.Branch(s.ctx, child, nil, "", "", shards(0))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "FOO", readFile(s.T(), replica, &pfs.Path{Commit: grandchild, Path: "dir/foo"}, 0))
+}
+
+func (s *driverSuite) TestPushDiffExistingCommitFails() {
+	var buffer bytes.Buffer
+	require.NoError(s.T(), s.driver.PullDiff(s.ctx, s.scratch, 0, &buffer))
+	require.Error(s.T(), s.driver.PushDiff(s.ctx, s.scratch, &buffer))
+}
+
+func (s *driverSuite) TestDoneContextFails() {
+	ctx, cancel := context.WithCancel(s.ctx)
+	cancel()
+	commit := s.branch(s.scratch)
+	path := &pfs.Path{Commit: commit, Path: "foo"}
+	require.Error(s.T(), s.driver.PutFile(ctx, path, 0, 0, strings.NewReader("foo")))
+	_, ok, err := s.driver.GetFileInfo(s.ctx, path, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+
+	var buffer bytes.Buffer
+	require.Error(s.T(), s.driver.PullDiff(ctx, s.scratch, 0, &buffer))
+	require.NoError(s.T(), s.driver.PullDiff(s.ctx, s.scratch, 0, &buffer))
+	replica := s.newDriver(s.T())
+	require.NoError(s.T(), replica.InitRepository(s.ctx, s.repository, shards(0)))
+	require.Error(s.T(), replica.PushDiff(ctx, s.scratch, &buffer))
+	_, ok, err = replica.GetCommitInfo(s.ctx, s.scratch, 0)
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+}
+
+func (s *driverSuite) TestOperations() {
+	operations, err := s.driver.ListOperations(s.ctx)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, len(operations))
+	for _, id := range []string{"b", "a"} {
+		require.NoError(s.T(), s.driver.StartOperation(s.ctx, &pfs.Operation{
+			Id:            id,
+			OperationType: pfs.OperationType_OPERATION_TYPE_COMMIT,
+			Commit:        s.scratch,
+			Branch:        "master",
+		}))
+	}
+	operations, err = s.driver.ListOperations(s.ctx)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2, len(operations))
+	require.Equal(s.T(), "a", operations[0].Id)
+	require.Equal(s.T(), pfs.OperationType_OPERATION_TYPE_COMMIT, operations[0].OperationType)
+	require.Equal(s.T(), s.scratch, operations[0].Commit)
+	require.Equal(s.T(), "master", operations[0].Branch)
+	require.NoError(s.T(), s.driver.FinishOperation(s.ctx, operations[0]))
+	operations, err = s.driver.ListOperations(s.ctx)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1, len(operations))
+	require.Equal(s.T(), "b", operations[0].Id)
+	// the journal is not a repository
+	repositories, err := s.driver.ListRepositories(s.ctx)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), []*pfs.Repository{s.repository}, repositories)
+}
+
+func (s *driverSuite) TestStartOperationTwiceFails() {
+	require.NoError(s.T(), s.driver.StartOperation(s.ctx, &pfs.Operation{Id: "a"}))
+	require.Error(s.T(), s.driver.StartOperation(s.ctx, &pfs.Operation{Id: "a"}))
+}
+
+func (s *driverSuite) TestFinishOperationMissingFails() {
+	require.Error(s.T(), s.driver.FinishOperation(s.ctx, &pfs.Operation{Id: "missing"}))
+}
+
+func (s *driverSuite) branch(commit *pfs.Commit) *pfs.Commit {
+	newCommit, err := s.driver.Branch(s.ctx, commit, nil, "", "", shards(0))
+	require.NoError(s.T(), err)
+	return newCommit
+}
+
+func (s *driverSuite) commit(commit *pfs.Commit) {
+	require.NoError(s.T(), s.driver.Commit(s.ctx, commit, "", shards(0)))
+}
+
+func (s *driverSuite) getCommitInfo(commit *pfs.Commit, shard int) *pfs.CommitInfo {
+	commitInfo, ok, err := s.driver.GetCommitInfo(s.ctx, commit, shard)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	return commitInfo
+}
+
+func (s *driverSuite) putFile(commit *pfs.Commit, shard int, path string, content string) {
+	require.NoError(s.T(), s.driver.PutFile(s.ctx, &pfs.Path{Commit: commit, Path: path}, shard, 0, strings.NewReader(content)))
+}
+
+func (s *driverSuite) getFileInfo(commit *pfs.Commit, path string) *pfs.FileInfo {
+	fileInfo, ok, err := s.driver.GetFileInfo(s.ctx, &pfs.Path{Commit: commit, Path: path}, 0)
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	return fileInfo
+}
+
+func (s *driverSuite) getFile(commit *pfs.Commit, shard int, path string) string {
+	return readFile(s.T(), s.driver, &pfs.Path{Commit: commit, Path: path}, shard)
+}
+
+func (s *driverSuite) listFiles(commit *pfs.Commit, path string) []string {
+	fileInfos, err := s.driver.ListFiles(s.ctx, &pfs.Path{Commit: commit, Path: path}, 0)
+	require.NoError(s.T(), err)
+	var paths []string
+	for _, fileInfo := range fileInfos {
+		paths = append(paths, fileInfo.Path.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *driverSuite) listChangedFiles(from *pfs.Commit, to *pfs.Commit) []string {
+	changes, err := s.driver.ListChangedFiles(s.ctx, from, to, 0)
+	require.NoError(s.T(), err)
+	var result []string
+	for _, change := range changes {
+		result = append(result, fmt.Sprintf("%s %s", change.ChangeType, change.Path.Path))
+	}
+	return result
+}
+
+func readFile(t *testing.T, driver drive.Driver, path *pfs.Path, shard int) string {
+	reader, err := driver.GetFile(context.Background(), path, shard)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(io.NewSectionReader(reader, 0, math.MaxInt64))
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	return string(data)
+}
+
+func checksum(content string) []byte {
+	checksum := sha256.Sum256([]byte(content))
+	return checksum[:]
+}
+
+func shards(shards ...int) map[int]bool {
+	m := make(map[int]bool, len(shards))
+	for _, shard := range shards {
+		m[shard] = true
+	}
+	return m
+}
@@ -0,0 +1,598 @@
+/*
+Package driveutil holds the helpers the drivers that keep each commit in a
+directory share. A commit's metadata is in its MetadataDir, which every walk
+of the commit's files skips.
+*/
+package driveutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pachyderm/pachyderm/src/pfs"
+	"github.com/pachyderm/pachyderm/src/pkg/protoutil"
+	"github.com/peter-edge/go-google-protobuf"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// MetadataDir is the directory of a commit that holds its metadata.
+	MetadataDir = ".pfs"
+	// ChecksumsDir is the directory of MetadataDir that mirrors the commit's
+	// files with the hex SHA-256 of each.
+	ChecksumsDir = "checksums"
+)
+
+// ChangesByPath sorts changes by path, a deletion comes before an addition
+// of the same path.
+type ChangesByPath []*pfs.Change
+
+func (c ChangesByPath) Len() int {
+	return len(c)
+}
+
+func (c ChangesByPath) Less(i, j int) bool {
+	if c[i].Path.Path != c[j].Path.Path {
+		return c[i].Path.Path < c[j].Path.Path
+	}
+	return c[i].ChangeType > c[j].ChangeType
+}
+
+func (c ChangesByPath) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+
+func NewChange(commit *pfs.Commit, relPath string, info os.FileInfo, changeType pfs.ChangeType) *pfs.Change {
+	fileType := pfs.FileType_FILE_TYPE_OTHER
+	if info.Mode().IsRegular() {
+		fileType = pfs.FileType_FILE_TYPE_REGULAR
+	}
+	if info.Mode().IsDir() {
+		fileType = pfs.FileType_FILE_TYPE_DIR
+	}
+	return &pfs.Change{
+		Path: &pfs.Path{
+			Commit: commit,
+			Path:   relPath,
+		},
+		ChangeType: changeType,
+		FileType:   fileType,
+	}
+}
+
+// ChangedFiles returns the changes from the commit at fromPath to to, the
+// commit at toPath, sorted by path. fromPath is empty if there is no from
+// commit. modified is called for the regular files that are in both commits.
+func ChangedFiles(fromPath string, toPath string, to *pfs.Commit, modified func(relPath string, fromInfo os.FileInfo, toInfo os.FileInfo) (bool, error)) ([]*pfs.Change, error) {
+	toInfos, err := WalkCommit(toPath)
+	if err != nil {
+		return nil, err
+	}
+	fromInfos := make(map[string]os.FileInfo)
+	if fromPath != "" {
+		fromInfos, err = WalkCommit(fromPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var changes []*pfs.Change
+	for relPath, info := range toInfos {
+		fromInfo, ok := fromInfos[relPath]
+		switch {
+		case ok && fromInfo.IsDir() != info.IsDir():
+			changes = append(changes, NewChange(to, relPath, fromInfo, pfs.ChangeType_CHANGE_TYPE_DELETED))
+			changes = append(changes, NewChange(to, relPath, info, pfs.ChangeType_CHANGE_TYPE_ADDED))
+		case !ok:
+			changes = append(changes, NewChange(to, relPath, info, pfs.ChangeType_CHANGE_TYPE_ADDED))
+		case !info.IsDir():
+			changed, err := modified(relPath, fromInfo, info)
+			if err != nil {
+				return nil, err
+			}
+			if changed {
+				changes = append(changes, NewChange(to, relPath, info, pfs.ChangeType_CHANGE_TYPE_MODIFIED))
+			}
+		}
+	}
+	for relPath, info := range fromInfos {
+		if _, ok := toInfos[relPath]; !ok {
+			changes = append(changes, NewChange(to, relPath, info, pfs.ChangeType_CHANGE_TYPE_DELETED))
+		}
+	}
+	sort.Sort(ChangesByPath(changes))
+	return changes, nil
+}
+
+func NewCommitID() string {
+	return strings.Replace(uuid.NewV4().String(), "-", "", -1)
+}
+
+// InMetadataDir returns true if name, a slash separated path relative to a
+// commit, is the commit's metadata.
+func InMetadataDir(name string) bool {
+	parts := strings.Split(name, "/")
+	return (len(parts) > 0 && parts[0] == MetadataDir)
+}
+
+// MissingDir returns the first directory os.MkdirAll makes for dirPath, it
+// returns "" if dirPath already exists.
+func MissingDir(dirPath string) string {
+	var missing string
+	for {
+		if _, err := os.Stat(dirPath); err == nil {
+			return missing
+		}
+		missing = dirPath
+		dirPath = filepath.Dir(dirPath)
+	}
+}
+
+// MergePaths makes each of paths in the commit at commitPath the same as it is
+// in the commit at theirsPath, paths must be sorted so parents come first.
+// Files are hard linked from theirs if link is true and the link succeeds,
+// and copied otherwise.
+func MergePaths(theirsPath string, commitPath string, paths []string, link bool) error {
+	for _, path := range paths {
+		if err := CheckDeletable(path); err != nil {
+			return err
+		}
+		theirsFilePath, err := SafeJoin(theirsPath, path)
+		if err != nil {
+			return err
+		}
+		filePath, err := SafeJoin(commitPath, path)
+		if err != nil {
+			return err
+		}
+		theirsInfo, err := os.Lstat(theirsFilePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		theirsExists := err == nil
+		info, err := os.Lstat(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && theirsExists && info.IsDir() && theirsInfo.IsDir() {
+			continue
+		}
+		if err := os.RemoveAll(filePath); err != nil {
+			return err
+		}
+		checksumPath, err := SafeJoin(filepath.Join(commitPath, MetadataDir, ChecksumsDir), path)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(checksumPath); err != nil {
+			return err
+		}
+		if !theirsExists {
+			continue
+		}
+		// ours may have deleted the directories theirs added to
+		if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+			return err
+		}
+		switch {
+		case theirsInfo.IsDir():
+			if err := os.Mkdir(filePath, theirsInfo.Mode().Perm()); err != nil {
+				return err
+			}
+		case theirsInfo.Mode().IsRegular():
+			if link && os.Link(theirsFilePath, filePath) == nil {
+				continue
+			}
+			if err := CopyFile(theirsFilePath, filePath, theirsInfo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CopyFile copies the file at src, described by info, to dest, which must not
+// exist.
+func CopyFile(src string, dest string, info os.FileInfo) (retErr error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := srcFile.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		destFile.Close()
+		return err
+	}
+	if err := destFile.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
+
+func WriteMetadata(commitPath string, name string, value string) error {
+	return ioutil.WriteFile(filepath.Join(commitPath, MetadataDir, name), []byte(value), 0600)
+}
+
+// ReadTimestamp reads a time written in RFC3339Nano, it returns nil if filePath does not exist.
+func ReadTimestamp(filePath string) (*google_protobuf.Timestamp, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(data))
+	if err != nil {
+		return nil, err
+	}
+	return protoutil.TimeToTimestamp(t), nil
+}
+
+// WalkCommit returns the info of every file and directory in the commit at
+// commitPath keyed by slash separated relative path, metadata is skipped.
+func WalkCommit(commitPath string) (map[string]os.FileInfo, error) {
+	infos := make(map[string]os.FileInfo)
+	if err := filepath.Walk(
+		commitPath,
+		func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if filePath == commitPath {
+				return nil
+			}
+			relPath, err := filepath.Rel(commitPath, filePath)
+			if err != nil {
+				return err
+			}
+			relPath = filepath.ToSlash(relPath)
+			if InMetadataDir(relPath) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			infos[relPath] = info
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// PrunedFiles returns the regular files of the commit at commitPath that
+// remove accepts, sorted.
+func PrunedFiles(commitPath string, remove func(path string) bool) ([]string, error) {
+	infos, err := WalkCommit(commitPath)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for relPath, info := range infos {
+		if info.Mode().IsRegular() && remove(relPath) {
+			paths = append(paths, relPath)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// RemoveFiles removes paths and their checksums from the commit at
+// commitPath. The commit's size is written again if it has one.
+func RemoveFiles(commitPath string, paths []string) error {
+	for _, path := range paths {
+		filePath, err := SafeJoin(commitPath, path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+		checksumPath, err := SafeJoin(filepath.Join(commitPath, MetadataDir, ChecksumsDir), path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(checksumPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	sizePath := filepath.Join(commitPath, MetadataDir, "size")
+	if _, err := os.Stat(sizePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	size, err := CommitSize(commitPath)
+	if err != nil {
+		return err
+	}
+	// the size may be linked to another commit's so it is replaced rather
+	// than written to
+	if err := os.Remove(sizePath); err != nil {
+		return err
+	}
+	return WriteMetadata(commitPath, "size", fmt.Sprint(size))
+}
+
+// CommitSize returns the total size of the regular files in the commit at commitPath.
+func CommitSize(commitPath string) (uint64, error) {
+	infos, err := WalkCommit(commitPath)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+	}
+	return size, nil
+}
+
+// WriteChecksums writes the checksum of every regular file in the commit at
+// commitPath that doesn't have one. parentPath is the commit's parent, whose
+// checksums are linked for the files that are still linked to it, or empty.
+func WriteChecksums(commitPath string, parentPath string) error {
+	infos, err := WalkCommit(commitPath)
+	if err != nil {
+		return err
+	}
+	for relPath, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		relPath = filepath.FromSlash(relPath)
+		checksumPath := filepath.Join(commitPath, MetadataDir, ChecksumsDir, relPath)
+		if _, err := os.Stat(checksumPath); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(checksumPath), 0700); err != nil {
+			return err
+		}
+		if parentPath != "" {
+			unchanged, err := Unchanged(filepath.Join(parentPath, relPath), info)
+			if err != nil {
+				return err
+			}
+			// the parent may predate checksums, in which case it's computed
+			if unchanged && os.Link(filepath.Join(parentPath, MetadataDir, ChecksumsDir, relPath), checksumPath) == nil {
+				continue
+			}
+		}
+		checksum, err := FileChecksum(filepath.Join(commitPath, relPath))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(checksumPath, []byte(hex.EncodeToString(checksum)), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unchanged returns true if parentFilePath is the same as the file described by info.
+func Unchanged(parentFilePath string, info os.FileInfo) (bool, error) {
+	parentInfo, err := os.Lstat(parentFilePath)
+	if err != nil && os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return parentInfo.IsDir(), nil
+	}
+	return os.SameFile(parentInfo, info), nil
+}
+
+// FileChecksum returns the SHA-256 of the contents of the file at filePath.
+func FileChecksum(filePath string) (_ []byte, retErr error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// FileChangeType returns how the file at relPath changed from the commit at
+// fromPath to the commit at toPath and the file's info in toPath, fromPath
+// is empty if there is no from commit.
+func FileChangeType(fromPath string, toPath string, relPath string) (pfs.ChangeType, os.FileInfo, error) {
+	toInfo, err := StatIfExists(filepath.Join(toPath, relPath))
+	if err != nil {
+		return pfs.ChangeType_CHANGE_TYPE_NONE, nil, err
+	}
+	var fromInfo os.FileInfo
+	if fromPath != "" {
+		if fromInfo, err = StatIfExists(filepath.Join(fromPath, relPath)); err != nil {
+			return pfs.ChangeType_CHANGE_TYPE_NONE, nil, err
+		}
+	}
+	switch {
+	case fromInfo == nil && toInfo == nil:
+		return pfs.ChangeType_CHANGE_TYPE_NONE, nil, nil
+	case fromInfo == nil:
+		return pfs.ChangeType_CHANGE_TYPE_ADDED, toInfo, nil
+	case toInfo == nil:
+		return pfs.ChangeType_CHANGE_TYPE_DELETED, nil, nil
+	case fromInfo.IsDir() != toInfo.IsDir():
+		return pfs.ChangeType_CHANGE_TYPE_MODIFIED, toInfo, nil
+	case toInfo.IsDir():
+		return pfs.ChangeType_CHANGE_TYPE_NONE, toInfo, nil
+	}
+	same, err := SameContents(filepath.Join(fromPath, relPath), fromInfo, filepath.Join(toPath, relPath), toInfo)
+	if err != nil {
+		return pfs.ChangeType_CHANGE_TYPE_NONE, nil, err
+	}
+	if same {
+		return pfs.ChangeType_CHANGE_TYPE_NONE, toInfo, nil
+	}
+	return pfs.ChangeType_CHANGE_TYPE_MODIFIED, toInfo, nil
+}
+
+// StatIfExists returns nil if there is no file at filePath, including when
+// one of its parents is a file.
+func StatIfExists(filePath string) (os.FileInfo, error) {
+	info, err := os.Stat(filePath)
+	if err == nil {
+		return info, nil
+	}
+	if pathErr, ok := err.(*os.PathError); os.IsNotExist(err) || (ok && pathErr.Err == syscall.ENOTDIR) {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// SameContents returns true if the files at path1 and path2 have the same contents.
+func SameContents(path1 string, info1 os.FileInfo, path2 string, info2 os.FileInfo) (_ bool, retErr error) {
+	if os.SameFile(info1, info2) {
+		return true, nil
+	}
+	if info1.Size() != info2.Size() {
+		return false, nil
+	}
+	file1, err := os.Open(path1)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := file1.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	file2, err := os.Open(path2)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := file2.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	buf1 := make([]byte, 32*1024)
+	buf2 := make([]byte, 32*1024)
+	for {
+		n1, err1 := io.ReadFull(file1, buf1)
+		n2, err2 := io.ReadFull(file2, buf2)
+		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+		if err1 == io.EOF || err1 == io.ErrUnexpectedEOF {
+			return err2 == io.EOF || err2 == io.ErrUnexpectedEOF, nil
+		}
+		if err1 != nil {
+			return false, err1
+		}
+		if err2 != nil {
+			return false, err2
+		}
+	}
+}
+
+// SafeJoin joins name onto rootPath, making sure the result stays within rootPath.
+func SafeJoin(rootPath string, name string) (string, error) {
+	relPath := filepath.Clean(string(filepath.Separator) + filepath.FromSlash(name))
+	if relPath == string(filepath.Separator) {
+		return "", fmt.Errorf("pachyderm: invalid path %s", name)
+	}
+	return filepath.Join(rootPath, relPath), nil
+}
+
+// CheckDeletable returns an error if path is the root of a commit or within its metadata.
+func CheckDeletable(path string) error {
+	relPath := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	if relPath == "" || InMetadataDir(relPath) {
+		return fmt.Errorf("pachyderm: cannot delete %s", path)
+	}
+	return nil
+}
+
+// StartOperation journals operation in dirPath until FinishOperation is
+// called with it.
+func StartOperation(dirPath string, operation *pfs.Operation) (retErr error) {
+	if err := os.MkdirAll(dirPath, 0700); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(operation)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dirPath, operation.Id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	// the operation must be on disk before any shard is changed
+	return file.Sync()
+}
+
+func FinishOperation(dirPath string, operation *pfs.Operation) error {
+	if err := os.Remove(filepath.Join(dirPath, operation.Id)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("pachyderm: operation %s not found", operation.Id)
+		}
+		return err
+	}
+	return nil
+}
+
+// ListOperations returns the operations journaled in dirPath sorted by id.
+func ListOperations(dirPath string) ([]*pfs.Operation, error) {
+	infos, err := ioutil.ReadDir(dirPath)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var operations []*pfs.Operation
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(dirPath, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		operation := &pfs.Operation{}
+		if err := proto.Unmarshal(data, operation); err != nil {
+			return nil, err
+		}
+		operations = append(operations, operation)
+	}
+	return operations, nil
+}
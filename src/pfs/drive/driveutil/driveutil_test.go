@@ -0,0 +1,118 @@
+package driveutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/src/pfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedFiles(t *testing.T) {
+	fromPath := tempDir(t)
+	defer os.RemoveAll(fromPath)
+	toPath := tempDir(t)
+	defer os.RemoveAll(toPath)
+	writeFile(t, fromPath, "same", "foo")
+	writeFile(t, fromPath, "modified", "foo")
+	writeFile(t, fromPath, "deleted", "foo")
+	writeFile(t, fromPath, MetadataDir+"/parent", "foo")
+	writeFile(t, toPath, "same", "foo")
+	writeFile(t, toPath, "modified", "bar")
+	writeFile(t, toPath, "added", "foo")
+	to := &pfs.Commit{Id: "to"}
+	changes, err := ChangedFiles(
+		fromPath,
+		toPath,
+		to,
+		func(relPath string, fromInfo os.FileInfo, toInfo os.FileInfo) (bool, error) {
+			same, err := SameContents(filepath.Join(fromPath, relPath), fromInfo, filepath.Join(toPath, relPath), toInfo)
+			return !same, err
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(changes))
+	require.Equal(t, "added", changes[0].Path.Path)
+	require.Equal(t, pfs.ChangeType_CHANGE_TYPE_ADDED, changes[0].ChangeType)
+	require.Equal(t, "deleted", changes[1].Path.Path)
+	require.Equal(t, pfs.ChangeType_CHANGE_TYPE_DELETED, changes[1].ChangeType)
+	require.Equal(t, "modified", changes[2].Path.Path)
+	require.Equal(t, pfs.ChangeType_CHANGE_TYPE_MODIFIED, changes[2].ChangeType)
+	require.Equal(t, to, changes[2].Path.Commit)
+}
+
+func TestWriteChecksumsLinksUnchanged(t *testing.T) {
+	parentPath := tempDir(t)
+	defer os.RemoveAll(parentPath)
+	commitPath := tempDir(t)
+	defer os.RemoveAll(commitPath)
+	writeFile(t, parentPath, "linked", "foo")
+	require.NoError(t, WriteChecksums(parentPath, ""))
+	require.NoError(t, os.Link(filepath.Join(parentPath, "linked"), filepath.Join(commitPath, "linked")))
+	writeFile(t, commitPath, "new", "bar")
+	require.NoError(t, WriteChecksums(commitPath, parentPath))
+	parentInfo, err := os.Stat(filepath.Join(parentPath, MetadataDir, ChecksumsDir, "linked"))
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(commitPath, MetadataDir, ChecksumsDir, "linked"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(parentInfo, info))
+	checksum, err := ioutil.ReadFile(filepath.Join(commitPath, MetadataDir, ChecksumsDir, "new"))
+	require.NoError(t, err)
+	// the SHA-256 of "bar"
+	require.Equal(t, "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9", string(checksum))
+}
+
+func TestMergePaths(t *testing.T) {
+	theirsPath := tempDir(t)
+	defer os.RemoveAll(theirsPath)
+	commitPath := tempDir(t)
+	defer os.RemoveAll(commitPath)
+	writeFile(t, theirsPath, "dir/theirs", "theirs")
+	writeFile(t, commitPath, "ours", "ours")
+	writeFile(t, commitPath, MetadataDir+"/"+ChecksumsDir+"/ours", "checksum")
+	require.NoError(t, MergePaths(theirsPath, commitPath, []string{"dir", "dir/theirs", "ours"}, false))
+	data, err := ioutil.ReadFile(filepath.Join(commitPath, "dir", "theirs"))
+	require.NoError(t, err)
+	require.Equal(t, "theirs", string(data))
+	_, err = os.Stat(filepath.Join(commitPath, "ours"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(commitPath, MetadataDir, ChecksumsDir, "ours"))
+	require.True(t, os.IsNotExist(err))
+	require.Error(t, MergePaths(theirsPath, commitPath, []string{MetadataDir}, false))
+}
+
+func TestOperations(t *testing.T) {
+	dirPath := tempDir(t)
+	defer os.RemoveAll(dirPath)
+	operations, err := ListOperations(filepath.Join(dirPath, "operations"))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(operations))
+	for _, id := range []string{"b", "a"} {
+		require.NoError(t, StartOperation(filepath.Join(dirPath, "operations"), &pfs.Operation{Id: id}))
+	}
+	require.Error(t, StartOperation(filepath.Join(dirPath, "operations"), &pfs.Operation{Id: "a"}))
+	operations, err = ListOperations(filepath.Join(dirPath, "operations"))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(operations))
+	require.Equal(t, "a", operations[0].Id)
+	require.NoError(t, FinishOperation(filepath.Join(dirPath, "operations"), &pfs.Operation{Id: "a"}))
+	require.Error(t, FinishOperation(filepath.Join(dirPath, "operations"), &pfs.Operation{Id: "a"}))
+	operations, err = ListOperations(filepath.Join(dirPath, "operations"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(operations))
+	require.Equal(t, "b", operations[0].Id)
+}
+
+func tempDir(t *testing.T) string {
+	dirPath, err := ioutil.TempDir("", "driveutil")
+	require.NoError(t, err)
+	return dirPath
+}
+
+func writeFile(t *testing.T, rootPath string, relPath string, value string) {
+	filePath := filepath.Join(rootPath, filepath.FromSlash(relPath))
+	require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0700))
+	require.NoError(t, ioutil.WriteFile(filePath, []byte(value), 0600))
+}
@@ -0,0 +1,1482 @@
+/*
+
+directory structure
+
+  .
+  |-- .pfs
+	  |-- operations
+		  |-- operationID // an operation this server is coordinating
+  |-- repositoryName
+	  |-- .pfs
+		  |-- created // when the repository was created
+		  |-- branches
+			  |-- branchName // the id of the commit the branch points at
+		  |-- retention_policy // the repository's RetentionPolicy, if it has one
+	  |-- scratch
+		  |-- shardNum // the read commit created on InitRepository, this is where to start branching
+	  |-- commitID
+		  |-- shardNum.write // a write commit
+		  |-- shardNum // a read commit, renamed from shardNum.write on Commit
+
+Branch hard links every file of the base commit into the new write commit.
+A file that is still linked to another commit is copied before it is written
+to, so the contents of a read commit never change.
+
+The .pfs directory of a commit holds its metadata: parent, merge_parent,
+created, branch, message and, once it is committed, finished, size and
+checksums, which mirrors the commit's files with the hex SHA-256 of each.
+Checksums of files that are still linked to the parent commit are linked
+from the parent's so that PullDiff only sends the checksums that changed.
+
+*/
+
+package local
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pachyderm/pachyderm/src/pfs"
+	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pfs/drive/driveutil"
+	"github.com/pachyderm/pachyderm/src/pkg/protoutil"
+	"golang.org/x/net/context"
+)
+
+const (
+	diffHeaderName = ".pfsdiff"
+	writeSuffix    = ".write"
+	receiveSuffix  = ".receive"
+)
+
+// diffHeader is the first entry of every diff produced by PullDiff.
+// The rest of the diff is a tar stream of every file and directory that
+// is not present in the parent commit.
+type diffHeader struct {
+	Shard   int      `json:"shard"`
+	Parent  string   `json:"parent,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+type driver struct {
+	rootDir   string
+	namespace string
+	// linkLock serializes breaking hard links so that concurrent writes to
+	// the same file never copy it twice.
+	linkLock *sync.Mutex
+}
+
+func newDriver(rootDir string, namespace string) (*driver, error) {
+	if err := os.MkdirAll(filepath.Join(rootDir, namespace), 0700); err != nil {
+		return nil, err
+	}
+	return &driver{rootDir, namespace, &sync.Mutex{}}, nil
+}
+
+func (d *driver) InitRepository(ctx context.Context, repository *pfs.Repository, shards map[int]bool) error {
+	repositoryPath := d.repositoryPath(repository)
+	if err := os.MkdirAll(filepath.Join(repositoryPath, driveutil.MetadataDir), 0700); err != nil {
+		return err
+	}
+	if exists(filepath.Join(repositoryPath, driveutil.MetadataDir, "created")) {
+		return nil
+	}
+	return driveutil.WriteMetadata(repositoryPath, "created", time.Now().UTC().Format(time.RFC3339Nano))
+}
+
+func (d *driver) ListRepositories(ctx context.Context) ([]*pfs.Repository, error) {
+	names, err := readDirNames(filepath.Join(d.rootDir, d.namespace))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var repositories []*pfs.Repository
+	for _, name := range names {
+		if name == driveutil.MetadataDir {
+			continue
+		}
+		repositories = append(repositories, &pfs.Repository{Name: name})
+	}
+	return repositories, nil
+}
+
+func (d *driver) InspectRepository(ctx context.Context, repository *pfs.Repository, shard int) (*pfs.RepositoryInfo, bool, error) {
+	repositoryPath := d.repositoryPath(repository)
+	if !exists(repositoryPath) {
+		return nil, false, nil
+	}
+	repositoryInfo := &pfs.RepositoryInfo{
+		Repository: repository,
+	}
+	created, err := driveutil.ReadTimestamp(filepath.Join(repositoryPath, driveutil.MetadataDir, "created"))
+	if err != nil {
+		return nil, false, err
+	}
+	repositoryInfo.Created = created
+	commitInfos, err := d.ListCommits(ctx, repository, shard)
+	if err != nil {
+		return nil, false, err
+	}
+	repositoryInfo.CommitCount = uint64(len(commitInfos))
+	// files are hard linked between commits, only count each inode once
+	seen := make(map[uint64]bool)
+	for _, commitInfo := range commitInfos {
+		commitPath, err := d.commitPath(commitInfo.Commit, shard)
+		if err != nil {
+			return nil, false, err
+		}
+		infos, err := driveutil.WalkCommit(commitPath)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, info := range infos {
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				if seen[uint64(stat.Ino)] {
+					continue
+				}
+				seen[uint64(stat.Ino)] = true
+			}
+			repositoryInfo.SizeBytes += uint64(info.Size())
+		}
+	}
+	return repositoryInfo, true, nil
+}
+
+func (d *driver) DeleteRepository(ctx context.Context, repository *pfs.Repository, shards map[int]bool) error {
+	repositoryPath := d.repositoryPath(repository)
+	if !exists(repositoryPath) {
+		return fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	commitIDs, err := readDirNames(repositoryPath)
+	if err != nil {
+		return err
+	}
+	remaining := false
+	for _, commitID := range commitIDs {
+		if commitID == driveutil.MetadataDir {
+			continue
+		}
+		commit := &pfs.Commit{
+			Repository: repository,
+			Id:         commitID,
+		}
+		if err := d.DeleteCommit(ctx, commit, shards); err != nil {
+			return err
+		}
+		if exists(d.commitPathNoShard(commit)) {
+			remaining = true
+		}
+	}
+	// the repository goes with its last shard
+	if remaining {
+		return nil
+	}
+	return os.RemoveAll(repositoryPath)
+}
+
+func (d *driver) GetFile(ctx context.Context, path *pfs.Path, shard int) (drive.ReaderAtCloser, error) {
+	filePath, err := d.filePath(path, shard)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filePath)
+}
+
+func (d *driver) GetFileInfo(ctx context.Context, path *pfs.Path, shard int) (_ *pfs.FileInfo, ok bool, _ error) {
+	fileInfo, err := d.stat(path, shard)
+	if err != nil && os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return fileInfo, true, nil
+}
+
+func (d *driver) MakeDirectory(ctx context.Context, path *pfs.Path, shards map[int]bool) (retErr error) {
+	// the directories made on earlier shards are removed if a shard fails
+	var made []string
+	defer func() {
+		if retErr != nil {
+			for _, dirPath := range made {
+				_ = os.RemoveAll(dirPath)
+			}
+		}
+	}()
+	for shard := range shards {
+		if err := d.checkWrite(path.Commit, shard); err != nil {
+			return err
+		}
+		filePath, err := d.filePath(path, shard)
+		if err != nil {
+			return err
+		}
+		missing := driveutil.MissingDir(filePath)
+		if err := os.MkdirAll(filePath, 0700); err != nil {
+			return err
+		}
+		if missing != "" {
+			made = append(made, missing)
+		}
+	}
+	return nil
+}
+
+func (d *driver) PutFile(ctx context.Context, path *pfs.Path, shard int, offset int64, reader io.Reader) (retErr error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := d.checkWrite(path.Commit, shard); err != nil {
+		return err
+	}
+	filePath, err := d.filePath(path, shard)
+	if err != nil {
+		return err
+	}
+	if err := d.breakLink(filePath, filepath.Join(d.writeCommitPath(path.Commit, shard), driveutil.MetadataDir)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	if _, err := file.Seek(offset, 0); err != nil { // 0 means relative to start
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	return err
+}
+
+func (d *driver) DeleteFile(ctx context.Context, path *pfs.Path, shards map[int]bool) error {
+	if err := driveutil.CheckDeletable(path.Path); err != nil {
+		return err
+	}
+	for shard := range shards {
+		if err := d.checkWrite(path.Commit, shard); err != nil {
+			return err
+		}
+		filePath, err := d.filePath(path, shard)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filePath); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *driver) PruneCommit(ctx context.Context, commit *pfs.Commit, shard int, remove func(path string) bool) error {
+	commitPath, err := d.commitPath(commit, shard)
+	if err != nil {
+		return err
+	}
+	paths, err := driveutil.PrunedFiles(commitPath, remove)
+	if err != nil {
+		return err
+	}
+	// the other commits the files are linked into keep them
+	return driveutil.RemoveFiles(commitPath, paths)
+}
+
+func (d *driver) ListFiles(ctx context.Context, path *pfs.Path, shard int) (_ []*pfs.FileInfo, retErr error) {
+	filePath, err := d.filePath(path, shard)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", filePath)
+	}
+	dir, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := dir.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	var fileInfos []*pfs.FileInfo
+	// TODO(pedge): constant
+	for names, err := dir.Readdirnames(100); err != io.EOF; names, err = dir.Readdirnames(100) {
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if driveutil.InMetadataDir(name) {
+				continue
+			}
+			fileInfo, err := d.stat(
+				&pfs.Path{
+					Commit: path.Commit,
+					Path:   filepath.Join(path.Path, name),
+				},
+				shard,
+			)
+			if err != nil {
+				return nil, err
+			}
+			fileInfos = append(fileInfos, fileInfo)
+		}
+	}
+	return fileInfos, nil
+}
+
+func (d *driver) ListChangedFiles(ctx context.Context, from *pfs.Commit, to *pfs.Commit, shard int) ([]*pfs.Change, error) {
+	toPath, err := d.commitPath(to, shard)
+	if err != nil {
+		return nil, err
+	}
+	if from == nil {
+		from, err = d.getParent(to, shard)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var fromPath string
+	if from != nil {
+		fromPath, err = d.commitPath(from, shard)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return driveutil.ChangedFiles(
+		fromPath,
+		toPath,
+		to,
+		func(relPath string, fromInfo os.FileInfo, toInfo os.FileInfo) (bool, error) {
+			same, err := driveutil.SameContents(filepath.Join(fromPath, relPath), fromInfo, filepath.Join(toPath, relPath), toInfo)
+			return !same, err
+		},
+	)
+}
+
+func (d *driver) stat(path *pfs.Path, shard int) (*pfs.FileInfo, error) {
+	filePath, err := d.filePath(path, shard)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	fileType := pfs.FileType_FILE_TYPE_OTHER
+	if stat.Mode().IsRegular() {
+		fileType = pfs.FileType_FILE_TYPE_REGULAR
+	}
+	if stat.Mode().IsDir() {
+		fileType = pfs.FileType_FILE_TYPE_DIR
+	}
+	fileInfo := &pfs.FileInfo{
+		Path:         path,
+		FileType:     fileType,
+		SizeBytes:    uint64(stat.Size()),
+		Perm:         uint32(stat.Mode() & os.ModePerm),
+		LastModified: protoutil.TimeToTimestamp(stat.ModTime()),
+	}
+	if fileType == pfs.FileType_FILE_TYPE_REGULAR {
+		// only committed files have checksums
+		checksum, err := d.readMetadata(path.Commit, shard, filepath.Join(driveutil.ChecksumsDir, path.Path))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if fileInfo.Checksum, err = hex.DecodeString(string(checksum)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fileInfo, nil
+}
+
+func (d *driver) ListFileHistory(ctx context.Context, path *pfs.Path, shard int) ([]*pfs.FileRevision, error) {
+	relPath := filepath.Clean("/" + path.Path)
+	var fileRevisions []*pfs.FileRevision
+	for commit := path.Commit; commit != nil; {
+		commitPath, err := d.commitPath(commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		parent, err := d.getParent(commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		var parentPath string
+		if parent != nil {
+			if parentPath, err = d.commitPath(parent, shard); err != nil {
+				return nil, err
+			}
+		}
+		changeType, info, err := driveutil.FileChangeType(parentPath, commitPath, relPath)
+		if err != nil {
+			return nil, err
+		}
+		if changeType != pfs.ChangeType_CHANGE_TYPE_NONE {
+			fileRevision := &pfs.FileRevision{
+				Commit:     commit,
+				ChangeType: changeType,
+			}
+			if info != nil && info.Mode().IsRegular() {
+				fileRevision.SizeBytes = uint64(info.Size())
+			}
+			if fileRevision.Finished, err = driveutil.ReadTimestamp(filepath.Join(commitPath, driveutil.MetadataDir, "finished")); err != nil {
+				return nil, err
+			}
+			fileRevisions = append(fileRevisions, fileRevision)
+		}
+		commit = parent
+	}
+	return fileRevisions, nil
+}
+
+func (d *driver) Branch(ctx context.Context, commit *pfs.Commit, newCommit *pfs.Commit, branch string, message string, shards map[int]bool) (_ *pfs.Commit, retErr error) {
+	if commit == nil && newCommit == nil {
+		return nil, fmt.Errorf("pachyderm: must specify either commit or newCommit")
+	}
+	if newCommit == nil {
+		newCommit = &pfs.Commit{
+			Repository: commit.Repository,
+			Id:         driveutil.NewCommitID(),
+		}
+	}
+	if err := os.MkdirAll(d.commitPathNoShard(newCommit), 0700); err != nil {
+		return nil, err
+	}
+	// the commit is removed from the shards it was made on if a shard fails,
+	// even if that is because ctx is done
+	branched := make(map[int]bool)
+	defer func() {
+		if retErr != nil {
+			_ = d.DeleteCommit(context.Background(), newCommit, branched)
+		}
+	}()
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	for shard := range shards {
+		newCommitPath := d.writeCommitPath(newCommit, shard)
+		if exists(d.readCommitPath(newCommit, shard)) {
+			return nil, fmt.Errorf("pachyderm: commit %s already exists", newCommit.Id)
+		}
+		if commit != nil {
+			if err := d.checkReadOnly(commit, shard); err != nil {
+				return nil, err
+			}
+			// the metadata of the base commit is not carried over
+			if err := snapshot(d.readCommitPath(commit, shard), newCommitPath, false); err != nil {
+				return nil, err
+			}
+			if err := os.Mkdir(filepath.Join(newCommitPath, driveutil.MetadataDir), 0700); err != nil {
+				return nil, err
+			}
+			if err := driveutil.WriteMetadata(newCommitPath, "parent", commit.Id); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := os.Mkdir(newCommitPath, 0700); err != nil {
+				return nil, err
+			}
+			if err := os.Mkdir(filepath.Join(newCommitPath, driveutil.MetadataDir), 0700); err != nil {
+				return nil, err
+			}
+		}
+		if err := driveutil.WriteMetadata(newCommitPath, "created", created); err != nil {
+			return nil, err
+		}
+		if branch != "" {
+			if err := driveutil.WriteMetadata(newCommitPath, "branch", branch); err != nil {
+				return nil, err
+			}
+		}
+		if message != "" {
+			if err := driveutil.WriteMetadata(newCommitPath, "message", message); err != nil {
+				return nil, err
+			}
+		}
+		branched[shard] = true
+	}
+	return newCommit, nil
+}
+
+func (d *driver) Merge(ctx context.Context, ours *pfs.Commit, theirs *pfs.Commit, newCommit *pfs.Commit, paths []string, branch string, message string, shards map[int]bool) (*pfs.Commit, error) {
+	if ours == nil || theirs == nil {
+		return nil, fmt.Errorf("pachyderm: must specify both ours and theirs")
+	}
+	for shard := range shards {
+		if err := d.checkReadOnly(theirs, shard); err != nil {
+			return nil, err
+		}
+	}
+	newCommit, err := d.Branch(ctx, ours, newCommit, branch, message, shards)
+	if err != nil {
+		return nil, err
+	}
+	for shard := range shards {
+		newCommitPath := d.writeCommitPath(newCommit, shard)
+		if err := driveutil.WriteMetadata(newCommitPath, "merge_parent", theirs.Id); err != nil {
+			return nil, err
+		}
+		if err := driveutil.MergePaths(d.readCommitPath(theirs, shard), newCommitPath, paths, true); err != nil {
+			return nil, err
+		}
+	}
+	return newCommit, nil
+}
+
+func (d *driver) Commit(ctx context.Context, commit *pfs.Commit, message string, shards map[int]bool) (retErr error) {
+	// the shards that were committed are uncommitted if a shard fails
+	committed := make(map[int]bool)
+	defer func() {
+		if retErr != nil {
+			_ = d.Uncommit(context.Background(), commit, committed)
+		}
+	}()
+	finished := time.Now().UTC().Format(time.RFC3339Nano)
+	for shard := range shards {
+		if err := d.checkWrite(commit, shard); err != nil {
+			return err
+		}
+		writeCommitPath := d.writeCommitPath(commit, shard)
+		if message != "" {
+			if err := driveutil.WriteMetadata(writeCommitPath, "message", message); err != nil {
+				return err
+			}
+		}
+		size, err := driveutil.CommitSize(writeCommitPath)
+		if err != nil {
+			return err
+		}
+		if err := driveutil.WriteMetadata(writeCommitPath, "size", fmt.Sprint(size)); err != nil {
+			return err
+		}
+		parent, err := d.getParent(commit, shard)
+		if err != nil {
+			return err
+		}
+		var parentPath string
+		if parent != nil {
+			parentPath = d.readCommitPath(parent, shard)
+		}
+		if err := driveutil.WriteChecksums(writeCommitPath, parentPath); err != nil {
+			return err
+		}
+		if err := driveutil.WriteMetadata(writeCommitPath, "finished", finished); err != nil {
+			return err
+		}
+		if err := os.Rename(d.writeCommitPath(commit, shard), d.readCommitPath(commit, shard)); err != nil {
+			return err
+		}
+		committed[shard] = true
+	}
+	return nil
+}
+
+func (d *driver) Uncommit(ctx context.Context, commit *pfs.Commit, shards map[int]bool) error {
+	for shard := range shards {
+		readCommitPath := d.readCommitPath(commit, shard)
+		if !exists(readCommitPath) {
+			continue
+		}
+		writeCommitPath := d.writeCommitPath(commit, shard)
+		if err := os.Rename(readCommitPath, writeCommitPath); err != nil {
+			return err
+		}
+		// checksums are only kept for read commits
+		for _, name := range []string{"finished", "size", driveutil.ChecksumsDir} {
+			if err := os.RemoveAll(filepath.Join(writeCommitPath, driveutil.MetadataDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *driver) DeleteCommit(ctx context.Context, commit *pfs.Commit, shards map[int]bool) error {
+	for shard := range shards {
+		if err := os.RemoveAll(d.readCommitPath(commit, shard)); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(d.writeCommitPath(commit, shard)); err != nil {
+			return err
+		}
+	}
+	// the commit's directory goes with its last shard
+	names, err := readDirNames(d.commitPathNoShard(commit))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && len(names) == 0 {
+		return os.Remove(d.commitPathNoShard(commit))
+	}
+	return nil
+}
+
+func (d *driver) SquashCommits(ctx context.Context, commits []*pfs.Commit, message string, shards map[int]bool) error {
+	if len(commits) == 0 {
+		return fmt.Errorf("pachyderm: must specify commits to squash")
+	}
+	// check every shard before changing anything
+	for shard := range shards {
+		for i, commit := range commits {
+			if err := d.checkReadOnly(commit, shard); err != nil {
+				return err
+			}
+			if i == 0 {
+				continue
+			}
+			parent, err := d.getParent(commit, shard)
+			if err != nil {
+				return err
+			}
+			if parent == nil || parent.Id != commits[i-1].Id {
+				return fmt.Errorf("pachyderm: commit %s is not the parent of %s", commits[i-1].Id, commit.Id)
+			}
+		}
+	}
+	last := commits[len(commits)-1]
+	squashed := make(map[string]bool)
+	for _, commit := range commits[:len(commits)-1] {
+		squashed[commit.Id] = true
+	}
+	for shard := range shards {
+		parent, err := d.getParent(commits[0], shard)
+		if err != nil {
+			return err
+		}
+		lastPath := d.readCommitPath(last, shard)
+		names := []string{"parent"}
+		if message != "" {
+			names = append(names, "message")
+		}
+		// metadata received by PushDiff can be linked to the parent's so it
+		// is replaced rather than written to
+		for _, name := range names {
+			if err := os.Remove(filepath.Join(lastPath, driveutil.MetadataDir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if parent != nil {
+			if err := driveutil.WriteMetadata(lastPath, "parent", parent.Id); err != nil {
+				return err
+			}
+		}
+		if message != "" {
+			if err := driveutil.WriteMetadata(lastPath, "message", message); err != nil {
+				return err
+			}
+		}
+		if err := d.reparent(last, squashed, shard); err != nil {
+			return err
+		}
+		for _, commit := range commits[:len(commits)-1] {
+			if err := d.DeleteCommit(ctx, commit, map[int]bool{shard: true}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *driver) PullDiff(ctx context.Context, commit *pfs.Commit, shard int, diff io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := d.checkReadOnly(commit, shard); err != nil {
+		return err
+	}
+	parent, err := d.getParent(commit, shard)
+	if err != nil {
+		return err
+	}
+	commitPath := d.readCommitPath(commit, shard)
+	header := &diffHeader{
+		Shard: shard,
+	}
+	var parentPath string
+	if parent != nil {
+		header.Parent = parent.Id
+		parentPath = d.readCommitPath(parent, shard)
+		deleted, err := deletedPaths(parentPath, commitPath)
+		if err != nil {
+			return err
+		}
+		header.Deleted = deleted
+	}
+	tarWriter := tar.NewWriter(diff)
+	if err := writeDiffHeader(tarWriter, header); err != nil {
+		return err
+	}
+	if err := filepath.Walk(
+		commitPath,
+		func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if filePath == commitPath {
+				return nil
+			}
+			relPath, err := filepath.Rel(commitPath, filePath)
+			if err != nil {
+				return err
+			}
+			if parentPath != "" {
+				unchanged, err := driveutil.Unchanged(filepath.Join(parentPath, relPath), info)
+				if err != nil {
+					return err
+				}
+				if unchanged {
+					return nil
+				}
+			}
+			return writeTarEntry(tarWriter, relPath, filePath, info)
+		},
+	); err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+func (d *driver) PushDiff(ctx context.Context, commit *pfs.Commit, diff io.Reader) (retErr error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tarReader := tar.NewReader(diff)
+	header, err := readDiffHeader(tarReader)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.commitPathNoShard(commit), 0700); err != nil {
+		return err
+	}
+	readCommitPath := d.readCommitPath(commit, header.Shard)
+	if _, err := os.Stat(readCommitPath); err == nil {
+		return fmt.Errorf("pachyderm: commit %s already exists on shard %d", commit.Id, header.Shard)
+	}
+	receivePath := readCommitPath + receiveSuffix
+	// clean up after an interrupted PushDiff
+	if err := os.RemoveAll(receivePath); err != nil {
+		return err
+	}
+	if header.Parent != "" {
+		parent := &pfs.Commit{
+			Repository: commit.Repository,
+			Id:         header.Parent,
+		}
+		if err := d.checkReadOnly(parent, header.Shard); err != nil {
+			return err
+		}
+		if err := snapshot(d.readCommitPath(parent, header.Shard), receivePath, true); err != nil {
+			return err
+		}
+	} else {
+		if err := os.Mkdir(receivePath, 0700); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if retErr != nil {
+			os.RemoveAll(receivePath)
+		}
+	}()
+	for _, deleted := range header.Deleted {
+		deletedPath, err := driveutil.SafeJoin(receivePath, deleted)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(deletedPath); err != nil {
+			return err
+		}
+	}
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := readTarEntry(tarReader, tarHeader, receivePath); err != nil {
+			return err
+		}
+	}
+	return os.Rename(receivePath, readCommitPath)
+}
+
+func (d *driver) GetCommitInfo(ctx context.Context, commit *pfs.Commit, shard int) (_ *pfs.CommitInfo, ok bool, _ error) {
+	_, readErr := os.Stat(d.readCommitPath(commit, shard))
+	_, writeErr := os.Stat(d.writeCommitPath(commit, shard))
+	if readErr != nil && os.IsNotExist(readErr) && writeErr != nil && os.IsNotExist(writeErr) {
+		return nil, false, nil
+	}
+	parent, err := d.getParent(commit, shard)
+	if err != nil {
+		return nil, false, err
+	}
+	readOnly, err := d.getReadOnly(commit, shard)
+	if err != nil {
+		return nil, false, err
+	}
+	commitType := pfs.CommitType_COMMIT_TYPE_WRITE
+	if readOnly {
+		commitType = pfs.CommitType_COMMIT_TYPE_READ
+	}
+	commitPath, err := d.commitPath(commit, shard)
+	if err != nil {
+		return nil, false, err
+	}
+	commitInfo := &pfs.CommitInfo{
+		Commit:       commit,
+		CommitType:   commitType,
+		ParentCommit: parent,
+	}
+	if commitInfo.Created, err = driveutil.ReadTimestamp(filepath.Join(commitPath, driveutil.MetadataDir, "created")); err != nil {
+		return nil, false, err
+	}
+	if commitInfo.Finished, err = driveutil.ReadTimestamp(filepath.Join(commitPath, driveutil.MetadataDir, "finished")); err != nil {
+		return nil, false, err
+	}
+	message, err := d.readMetadata(commit, shard, "message")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	commitInfo.Message = string(message)
+	branch, err := d.readMetadata(commit, shard, "branch")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	commitInfo.Branch = string(branch)
+	mergeParent, err := d.readMetadata(commit, shard, "merge_parent")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	if err == nil {
+		commitInfo.MergeParentCommit = &pfs.Commit{
+			Repository: commit.Repository,
+			Id:         string(mergeParent),
+		}
+	}
+	if !readOnly {
+		// the size of a write commit is only known once it is committed
+		if commitInfo.SizeBytes, err = driveutil.CommitSize(commitPath); err != nil {
+			return nil, false, err
+		}
+		return commitInfo, true, nil
+	}
+	size, err := d.readMetadata(commit, shard, "size")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	if err == nil {
+		if commitInfo.SizeBytes, err = strconv.ParseUint(string(size), 10, 64); err != nil {
+			return nil, false, err
+		}
+	}
+	return commitInfo, true, nil
+}
+
+func (d *driver) ListCommits(ctx context.Context, repository *pfs.Repository, shard int) ([]*pfs.CommitInfo, error) {
+	commitIDs, err := readDirNames(d.repositoryPath(repository))
+	if err != nil {
+		return nil, err
+	}
+	var commitInfos []*pfs.CommitInfo
+	var created []time.Time
+	for _, commitID := range commitIDs {
+		if commitID == driveutil.MetadataDir {
+			continue
+		}
+		commit := &pfs.Commit{
+			Repository: repository,
+			Id:         commitID,
+		}
+		commitInfo, ok, err := d.GetCommitInfo(ctx, commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// this commit does not exist on this shard
+			continue
+		}
+		commitCreated, err := d.getCreated(commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		commitInfos = append(commitInfos, commitInfo)
+		created = append(created, commitCreated)
+	}
+	sort.Sort(&newestFirst{commitInfos, created})
+	return commitInfos, nil
+}
+
+func (d *driver) CreateBranch(ctx context.Context, repository *pfs.Repository, name string, commit *pfs.Commit) (retErr error) {
+	if !exists(d.repositoryPath(repository)) {
+		return fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	branchesPath := d.branchesPath(repository)
+	if err := os.MkdirAll(branchesPath, 0700); err != nil {
+		return err
+	}
+	// write to a temporary file and rename it so that readers never see a
+	// partially written branch
+	file, err := ioutil.TempFile(branchesPath, ".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err := file.WriteString(commit.Id); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(branchesPath, name))
+}
+
+func (d *driver) ListBranches(ctx context.Context, repository *pfs.Repository) ([]*pfs.BranchInfo, error) {
+	if !exists(d.repositoryPath(repository)) {
+		return nil, fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	names, err := readDirNames(d.branchesPath(repository))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.Strings(names)
+	var branchInfos []*pfs.BranchInfo
+	for _, name := range names {
+		if strings.HasPrefix(name, ".") {
+			// an unfinished CreateBranch
+			continue
+		}
+		commitID, err := ioutil.ReadFile(filepath.Join(d.branchesPath(repository), name))
+		if err != nil {
+			return nil, err
+		}
+		branchInfos = append(
+			branchInfos,
+			&pfs.BranchInfo{
+				Name: name,
+				Commit: &pfs.Commit{
+					Repository: repository,
+					Id:         string(commitID),
+				},
+			},
+		)
+	}
+	return branchInfos, nil
+}
+
+func (d *driver) DeleteBranch(ctx context.Context, repository *pfs.Repository, name string) error {
+	if !exists(d.repositoryPath(repository)) {
+		return fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	if err := os.Remove(filepath.Join(d.branchesPath(repository), name)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("pachyderm: branch %s not found", name)
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *driver) SetRetentionPolicy(ctx context.Context, repository *pfs.Repository, retentionPolicy *pfs.RetentionPolicy) (retErr error) {
+	if !exists(d.repositoryPath(repository)) {
+		return fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	retentionPolicyPath := d.retentionPolicyPath(repository)
+	if retentionPolicy == nil {
+		if err := os.Remove(retentionPolicyPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := proto.Marshal(retentionPolicy)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(retentionPolicyPath), 0700); err != nil {
+		return err
+	}
+	// write to a temporary file and rename it so that readers never see a
+	// partially written policy
+	file, err := ioutil.TempFile(filepath.Dir(retentionPolicyPath), ".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), retentionPolicyPath)
+}
+
+func (d *driver) GetRetentionPolicy(ctx context.Context, repository *pfs.Repository) (*pfs.RetentionPolicy, error) {
+	if !exists(d.repositoryPath(repository)) {
+		return nil, fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	data, err := ioutil.ReadFile(d.retentionPolicyPath(repository))
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	retentionPolicy := &pfs.RetentionPolicy{}
+	if err := proto.Unmarshal(data, retentionPolicy); err != nil {
+		return nil, err
+	}
+	return retentionPolicy, nil
+}
+
+func (d *driver) StartOperation(ctx context.Context, operation *pfs.Operation) error {
+	return driveutil.StartOperation(d.operationsPath(), operation)
+}
+
+func (d *driver) FinishOperation(ctx context.Context, operation *pfs.Operation) error {
+	return driveutil.FinishOperation(d.operationsPath(), operation)
+}
+
+func (d *driver) ListOperations(ctx context.Context) ([]*pfs.Operation, error) {
+	return driveutil.ListOperations(d.operationsPath())
+}
+
+// reparent makes the children of the squashed commits on shard children of
+// commit.
+func (d *driver) reparent(commit *pfs.Commit, squashed map[string]bool, shard int) error {
+	commitIDs, err := readDirNames(d.repositoryPath(commit.Repository))
+	if err != nil {
+		return err
+	}
+	for _, commitID := range commitIDs {
+		if commitID == driveutil.MetadataDir || squashed[commitID] {
+			continue
+		}
+		child := &pfs.Commit{
+			Repository: commit.Repository,
+			Id:         commitID,
+		}
+		if !exists(d.readCommitPath(child, shard)) && !exists(d.writeCommitPath(child, shard)) {
+			continue
+		}
+		childPath, err := d.commitPath(child, shard)
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"parent", "merge_parent"} {
+			parent, err := d.readMetadata(child, shard, name)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err != nil || !squashed[string(parent)] {
+				continue
+			}
+			// the metadata may be linked to another commit's
+			if err := os.Remove(filepath.Join(childPath, driveutil.MetadataDir, name)); err != nil {
+				return err
+			}
+			if err := driveutil.WriteMetadata(childPath, name, commit.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *driver) getParent(commit *pfs.Commit, shard int) (*pfs.Commit, error) {
+	data, err := d.readMetadata(commit, shard, "parent")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.Commit{
+		Repository: commit.Repository,
+		Id:         string(data),
+	}, nil
+}
+
+func (d *driver) getCreated(commit *pfs.Commit, shard int) (time.Time, error) {
+	data, err := d.readMetadata(commit, shard, "created")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, string(data))
+}
+
+func (d *driver) readMetadata(commit *pfs.Commit, shard int, name string) ([]byte, error) {
+	filePath, err := d.filePath(&pfs.Path{Commit: commit, Path: filepath.Join(driveutil.MetadataDir, name)}, shard)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filePath)
+}
+
+func (d *driver) checkReadOnly(commit *pfs.Commit, shard int) error {
+	ok, err := d.getReadOnly(commit, shard)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%+v is not a read only commit", commit)
+	}
+	return nil
+}
+
+func (d *driver) checkWrite(commit *pfs.Commit, shard int) error {
+	ok, err := d.getReadOnly(commit, shard)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("%+v is not a write commit", commit)
+	}
+	return nil
+}
+
+func (d *driver) getReadOnly(commit *pfs.Commit, shard int) (bool, error) {
+	if exists(d.readCommitPath(commit, shard)) {
+		return true, nil
+	} else if exists(d.writeCommitPath(commit, shard)) {
+		return false, nil
+	} else {
+		return false, fmt.Errorf("pachyderm: commit %s doesn't exist", commit.Id)
+	}
+}
+
+// breakLink copies filePath into place if it is still hard linked to
+// another commit. tmpDir must be on the same file system as filePath.
+func (d *driver) breakLink(filePath string, tmpDir string) (retErr error) {
+	d.linkLock.Lock()
+	defer d.linkLock.Unlock()
+	info, err := os.Lstat(filePath)
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || linkCount(info) < 2 {
+		return nil
+	}
+	tmpFile, err := ioutil.TempFile(tmpDir, "copy")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	defer func() {
+		if retErr != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(tmpPath); err != nil {
+		return err
+	}
+	if err := driveutil.CopyFile(filePath, tmpPath, info); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+func (d *driver) repositoryPath(repository *pfs.Repository) string {
+	return filepath.Join(d.rootDir, d.namespace, repository.Name)
+}
+
+func (d *driver) operationsPath() string {
+	return filepath.Join(d.rootDir, d.namespace, driveutil.MetadataDir, "operations")
+}
+
+func (d *driver) branchesPath(repository *pfs.Repository) string {
+	return filepath.Join(d.repositoryPath(repository), driveutil.MetadataDir, "branches")
+}
+
+func (d *driver) retentionPolicyPath(repository *pfs.Repository) string {
+	return filepath.Join(d.repositoryPath(repository), driveutil.MetadataDir, "retention_policy")
+}
+
+func (d *driver) commitPathNoShard(commit *pfs.Commit) string {
+	return filepath.Join(d.repositoryPath(commit.Repository), commit.Id)
+}
+
+func (d *driver) readCommitPath(commit *pfs.Commit, shard int) string {
+	return filepath.Join(d.commitPathNoShard(commit), fmt.Sprint(shard))
+}
+
+func (d *driver) writeCommitPath(commit *pfs.Commit, shard int) string {
+	return d.readCommitPath(commit, shard) + writeSuffix
+}
+
+func (d *driver) commitPath(commit *pfs.Commit, shard int) (string, error) {
+	readOnly, err := d.getReadOnly(commit, shard)
+	if err != nil {
+		return "", err
+	}
+	if readOnly {
+		return d.readCommitPath(commit, shard), nil
+	}
+	return d.writeCommitPath(commit, shard), nil
+}
+
+func (d *driver) filePath(path *pfs.Path, shard int) (string, error) {
+	commitPath, err := d.commitPath(path.Commit, shard)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(commitPath, path.Path), nil
+}
+
+type newestFirst struct {
+	commitInfos []*pfs.CommitInfo
+	created     []time.Time
+}
+
+func (n *newestFirst) Len() int {
+	return len(n.commitInfos)
+}
+
+func (n *newestFirst) Less(i, j int) bool {
+	if n.created[i].Equal(n.created[j]) {
+		return n.commitInfos[i].Commit.Id < n.commitInfos[j].Commit.Id
+	}
+	return n.created[i].After(n.created[j])
+}
+
+func (n *newestFirst) Swap(i, j int) {
+	n.commitInfos[i], n.commitInfos[j] = n.commitInfos[j], n.commitInfos[i]
+	n.created[i], n.created[j] = n.created[j], n.created[i]
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readDirNames(dirPath string) (_ []string, retErr error) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := dir.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	return dir.Readdirnames(-1)
+}
+
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	// we can't tell, so assume the file is shared
+	return 2
+}
+
+// snapshot recreates the tree rooted at src at dest, which must not exist.
+// Regular files are hard linked if possible and copied otherwise.
+func snapshot(src string, dest string, withMetadata bool) error {
+	return filepath.Walk(
+		src,
+		func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(src, filePath)
+			if err != nil {
+				return err
+			}
+			if !withMetadata && driveutil.InMetadataDir(relPath) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			destPath := filepath.Join(dest, relPath)
+			switch {
+			case info.IsDir():
+				return os.Mkdir(destPath, info.Mode().Perm())
+			case info.Mode().IsRegular():
+				if err := os.Link(filePath, destPath); err == nil {
+					return nil
+				}
+				return driveutil.CopyFile(filePath, destPath, info)
+			}
+			return nil
+		},
+	)
+}
+
+// deletedPaths returns the topmost paths in parentPath that no longer exist in commitPath.
+func deletedPaths(parentPath string, commitPath string) ([]string, error) {
+	var deleted []string
+	if err := filepath.Walk(
+		parentPath,
+		func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if filePath == parentPath {
+				return nil
+			}
+			relPath, err := filepath.Rel(parentPath, filePath)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Lstat(filepath.Join(commitPath, relPath)); err != nil {
+				if !os.IsNotExist(err) {
+					return err
+				}
+				deleted = append(deleted, filepath.ToSlash(relPath))
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func writeDiffHeader(tarWriter *tar.Writer, header *diffHeader) error {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.WriteHeader(
+		&tar.Header{
+			Name:     diffHeaderName,
+			Mode:     0600,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Now(),
+		},
+	); err != nil {
+		return err
+	}
+	_, err = tarWriter.Write(data)
+	return err
+}
+
+func readDiffHeader(tarReader *tar.Reader) (*diffHeader, error) {
+	tarHeader, err := tarReader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if tarHeader.Name != diffHeaderName {
+		return nil, fmt.Errorf("pachyderm: invalid diff, expected %s, got %s", diffHeaderName, tarHeader.Name)
+	}
+	header := &diffHeader{}
+	if err := json.NewDecoder(tarReader).Decode(header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func writeTarEntry(tarWriter *tar.Writer, relPath string, filePath string, info os.FileInfo) (retErr error) {
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return nil
+	}
+	tarHeader, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	tarHeader.Name = filepath.ToSlash(relPath)
+	if err := tarWriter.WriteHeader(tarHeader); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	_, err = io.Copy(tarWriter, file)
+	return err
+}
+
+func readTarEntry(tarReader *tar.Reader, tarHeader *tar.Header, rootPath string) (retErr error) {
+	filePath, err := driveutil.SafeJoin(rootPath, tarHeader.Name)
+	if err != nil {
+		return err
+	}
+	switch tarHeader.Typeflag {
+	case tar.TypeDir:
+		if info, err := os.Lstat(filePath); err == nil && !info.IsDir() {
+			if err := os.Remove(filePath); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filePath, 0700); err != nil {
+			return err
+		}
+		return os.Chmod(filePath, os.FileMode(tarHeader.Mode).Perm())
+	case tar.TypeReg, tar.TypeRegA:
+		// never write through a hard link into the parent commit
+		if err := os.RemoveAll(filePath); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(tarHeader.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, tarReader); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(filePath, tarHeader.ModTime, tarHeader.ModTime)
+	default:
+		return fmt.Errorf("pachyderm: unsupported entry %s in diff", tarHeader.Name)
+	}
+}
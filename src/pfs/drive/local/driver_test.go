@@ -0,0 +1,25 @@
+package local
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pfs/drive/drivertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "pachyderm-local")
+	require.NoError(t, err)
+	defer os.RemoveAll(rootDir)
+	counter := 0
+	drivertest.RunDriverTests(t, func(t *testing.T) drive.Driver {
+		counter++
+		driver, err := NewDriver(rootDir, fmt.Sprintf("test-%d", counter))
+		require.NoError(t, err)
+		return driver
+	})
+}
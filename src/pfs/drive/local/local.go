@@ -0,0 +1,14 @@
+/*
+Package local provides a drive.Driver that stores commits as plain directories.
+
+It does not depend on btrfs and works on any local file system that supports
+hard links, falling back to copying files when it does not.
+*/
+package local
+
+import "github.com/pachyderm/pachyderm/src/pfs/drive"
+
+// NewDriver constructs a new Driver for a local directory.
+func NewDriver(rootDir string, namespace string) (drive.Driver, error) {
+	return newDriver(rootDir, namespace)
+}
@@ -0,0 +1,1199 @@
+package memory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pachyderm/pachyderm/src/pfs"
+	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pkg/protoutil"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+const (
+	filePerm = 0666
+	dirPerm  = 0700
+)
+
+// file is never modified once it is stored in a commit, writes replace it
+// instead, which lets commits share files with their parents.
+type file struct {
+	dir     bool
+	data    []byte
+	modTime time.Time
+	// checksum is the SHA-256 of data, it is set by Commit
+	checksum []byte
+}
+
+type shardCommit struct {
+	parent string
+	// mergeParent is the second parent of a commit made by Merge
+	mergeParent string
+	readOnly    bool
+	branch      string
+	message     string
+	created     time.Time
+	finished    time.Time
+	// seq orders commits by creation on a shard
+	seq   uint64
+	files map[string]*file
+	// shared is true while files is still shared with the parent commit
+	shared bool
+}
+
+// write returns the files of c, copying them first if they are still
+// shared with the parent commit.
+func (c *shardCommit) write() map[string]*file {
+	if c.shared {
+		files := make(map[string]*file, len(c.files))
+		for name, f := range c.files {
+			files[name] = f
+		}
+		c.files = files
+		c.shared = false
+	}
+	return c.files
+}
+
+// diff is the format produced by PullDiff and consumed by PushDiff, it is
+// gob encoded.
+type diff struct {
+	Shard       int
+	Parent      string
+	MergeParent string
+	Branch      string
+	Message     string
+	Created     time.Time
+	Finished    time.Time
+	Files       map[string]*diffFile
+	Deleted     []string
+}
+
+type diffFile struct {
+	Dir      bool
+	Data     []byte
+	ModTime  time.Time
+	Checksum []byte
+}
+
+type driver struct {
+	// repositoryName -> commitID -> shard -> commit
+	repositories map[string]map[string]map[int]*shardCommit
+	// repositoryName -> time InitRepository first created it
+	created map[string]time.Time
+	// repositoryName -> branch name -> commitID
+	branches map[string]map[string]string
+	// repositoryName -> retention policy
+	retentionPolicies map[string]*pfs.RetentionPolicy
+	// operationID -> operation
+	operations map[string]*pfs.Operation
+	seq        uint64
+	lock       *sync.RWMutex
+}
+
+func newDriver() *driver {
+	return &driver{
+		make(map[string]map[string]map[int]*shardCommit),
+		make(map[string]time.Time),
+		make(map[string]map[string]string),
+		make(map[string]*pfs.RetentionPolicy),
+		make(map[string]*pfs.Operation),
+		0,
+		&sync.RWMutex{},
+	}
+}
+
+func (d *driver) InitRepository(ctx context.Context, repository *pfs.Repository, shards map[int]bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.repositories[repository.Name]; !ok {
+		d.repositories[repository.Name] = make(map[string]map[int]*shardCommit)
+		d.created[repository.Name] = time.Now()
+		d.branches[repository.Name] = make(map[string]string)
+	}
+	return nil
+}
+
+func (d *driver) ListRepositories(ctx context.Context) ([]*pfs.Repository, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var names []string
+	for name := range d.repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var repositories []*pfs.Repository
+	for _, name := range names {
+		repositories = append(repositories, &pfs.Repository{Name: name})
+	}
+	return repositories, nil
+}
+
+func (d *driver) InspectRepository(ctx context.Context, repository *pfs.Repository, shard int) (*pfs.RepositoryInfo, bool, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	commits, ok := d.repositories[repository.Name]
+	if !ok {
+		return nil, false, nil
+	}
+	var commitCount uint64
+	// files are shared between commits, only count each of them once
+	seen := make(map[*file]bool)
+	var sizeBytes uint64
+	for _, shardToCommit := range commits {
+		c, ok := shardToCommit[shard]
+		if !ok {
+			continue
+		}
+		commitCount++
+		for _, f := range c.files {
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			sizeBytes += uint64(len(f.data))
+		}
+	}
+	return &pfs.RepositoryInfo{
+		Repository:  repository,
+		Created:     protoutil.TimeToTimestamp(d.created[repository.Name]),
+		CommitCount: commitCount,
+		SizeBytes:   sizeBytes,
+	}, true, nil
+}
+
+func (d *driver) DeleteRepository(ctx context.Context, repository *pfs.Repository, shards map[int]bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	commits, err := d.getCommits(repository)
+	if err != nil {
+		return err
+	}
+	for commitID, shardToCommit := range commits {
+		for shard := range shards {
+			delete(shardToCommit, shard)
+		}
+		if len(shardToCommit) == 0 {
+			delete(commits, commitID)
+		}
+	}
+	// the repository goes with its last shard
+	if len(commits) > 0 {
+		return nil
+	}
+	delete(d.repositories, repository.Name)
+	delete(d.created, repository.Name)
+	delete(d.branches, repository.Name)
+	delete(d.retentionPolicies, repository.Name)
+	return nil
+}
+
+func (d *driver) GetFile(ctx context.Context, path *pfs.Path, shard int) (drive.ReaderAtCloser, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	c, err := d.getCommit(path.Commit, shard)
+	if err != nil {
+		return nil, err
+	}
+	name := cleanPath(path.Path)
+	file, ok := c.files[name]
+	if !ok {
+		return nil, fmt.Errorf("pachyderm: file %s not found", path.Path)
+	}
+	if file.dir {
+		return nil, fmt.Errorf("pachyderm: %s is a directory", path.Path)
+	}
+	return &readerAtCloser{bytes.NewReader(file.data)}, nil
+}
+
+func (d *driver) GetFileInfo(ctx context.Context, path *pfs.Path, shard int) (*pfs.FileInfo, bool, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	c, err := d.getCommit(path.Commit, shard)
+	if err != nil {
+		return nil, false, err
+	}
+	name := cleanPath(path.Path)
+	if name == "" {
+		return newFileInfo(path, &file{dir: true}), true, nil
+	}
+	file, ok := c.files[name]
+	if !ok {
+		return nil, false, nil
+	}
+	return newFileInfo(path, file), true, nil
+}
+
+func (d *driver) MakeDirectory(ctx context.Context, path *pfs.Path, shards map[int]bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	name := cleanPath(path.Path)
+	// check every shard before writing anything
+	for shard := range shards {
+		c, err := d.getWriteCommit(path.Commit, shard)
+		if err != nil {
+			return err
+		}
+		for dir := name; dir != ""; dir = parentPath(dir) {
+			if f, ok := c.files[dir]; ok && !f.dir {
+				return fmt.Errorf("pachyderm: %s is not a directory", dir)
+			}
+		}
+	}
+	for shard := range shards {
+		c, err := d.getWriteCommit(path.Commit, shard)
+		if err != nil {
+			return err
+		}
+		files := c.write()
+		now := time.Now()
+		for dir := name; dir != ""; dir = parentPath(dir) {
+			if _, ok := files[dir]; ok {
+				break
+			}
+			files[dir] = &file{dir: true, modTime: now}
+		}
+	}
+	return nil
+}
+
+func (d *driver) PutFile(ctx context.Context, path *pfs.Path, shard int, offset int64, reader io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if offset < 0 {
+		return fmt.Errorf("pachyderm: negative offset %d", offset)
+	}
+	// read before taking the lock, reader may be slow
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	c, err := d.getWriteCommit(path.Commit, shard)
+	if err != nil {
+		return err
+	}
+	name := cleanPath(path.Path)
+	if name == "" {
+		return fmt.Errorf("pachyderm: %s is a directory", path.Path)
+	}
+	if dir := parentPath(name); dir != "" {
+		parent, ok := c.files[dir]
+		if !ok {
+			return fmt.Errorf("pachyderm: directory %s not found", dir)
+		}
+		if !parent.dir {
+			return fmt.Errorf("pachyderm: %s is not a directory", dir)
+		}
+	}
+	var oldData []byte
+	if f, ok := c.files[name]; ok {
+		if f.dir {
+			return fmt.Errorf("pachyderm: %s is a directory", path.Path)
+		}
+		oldData = f.data
+	}
+	end := offset + int64(len(data))
+	if end < int64(len(oldData)) {
+		end = int64(len(oldData))
+	}
+	newData := make([]byte, end)
+	copy(newData, oldData)
+	copy(newData[offset:], data)
+	c.write()[name] = &file{data: newData, modTime: time.Now()}
+	return nil
+}
+
+func (d *driver) DeleteFile(ctx context.Context, path *pfs.Path, shards map[int]bool) error {
+	name := cleanPath(path.Path)
+	if name == "" {
+		return fmt.Errorf("pachyderm: cannot delete %s", path.Path)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	// check every shard before deleting anything
+	for shard := range shards {
+		c, err := d.getWriteCommit(path.Commit, shard)
+		if err != nil {
+			return err
+		}
+		if _, ok := c.files[name]; !ok {
+			return fmt.Errorf("pachyderm: file %s not found", path.Path)
+		}
+	}
+	for shard := range shards {
+		c, err := d.getWriteCommit(path.Commit, shard)
+		if err != nil {
+			return err
+		}
+		files := c.write()
+		for fileName := range files {
+			if fileName == name || strings.HasPrefix(fileName, name+"/") {
+				delete(files, fileName)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *driver) PruneCommit(ctx context.Context, commit *pfs.Commit, shard int, remove func(path string) bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	c, err := d.getCommit(commit, shard)
+	if err != nil {
+		return err
+	}
+	// the files may be shared with other commits, which are pruned by their
+	// own calls
+	files := make(map[string]*file, len(c.files))
+	for name, f := range c.files {
+		if f.dir || !remove(name) {
+			files[name] = f
+		}
+	}
+	c.files = files
+	c.shared = false
+	return nil
+}
+
+func (d *driver) ListFiles(ctx context.Context, path *pfs.Path, shard int) ([]*pfs.FileInfo, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	c, err := d.getCommit(path.Commit, shard)
+	if err != nil {
+		return nil, err
+	}
+	dir := cleanPath(path.Path)
+	if dir != "" {
+		file, ok := c.files[dir]
+		if !ok {
+			return nil, fmt.Errorf("pachyderm: directory %s not found", path.Path)
+		}
+		if !file.dir {
+			return nil, fmt.Errorf("pachyderm: %s is not a directory", path.Path)
+		}
+	}
+	var names []string
+	for name := range c.files {
+		if parentPath(name) == dir {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var fileInfos []*pfs.FileInfo
+	for _, name := range names {
+		fileInfos = append(
+			fileInfos,
+			newFileInfo(
+				&pfs.Path{
+					Commit: path.Commit,
+					Path:   filepath.Join(path.Path, filepath.Base(name)),
+				},
+				c.files[name],
+			),
+		)
+	}
+	return fileInfos, nil
+}
+
+func (d *driver) ListChangedFiles(ctx context.Context, from *pfs.Commit, to *pfs.Commit, shard int) ([]*pfs.Change, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	c, err := d.getCommit(to, shard)
+	if err != nil {
+		return nil, err
+	}
+	fromFiles := make(map[string]*file)
+	if from == nil && c.parent != "" {
+		from = &pfs.Commit{Repository: to.Repository, Id: c.parent}
+	}
+	if from != nil {
+		fromC, err := d.getCommit(from, shard)
+		if err != nil {
+			return nil, err
+		}
+		fromFiles = fromC.files
+	}
+	var changes []*pfs.Change
+	for name, f := range c.files {
+		fromFile, ok := fromFiles[name]
+		switch {
+		case ok && fromFile.dir != f.dir:
+			changes = append(changes, newChange(to, name, fromFile, pfs.ChangeType_CHANGE_TYPE_DELETED))
+			changes = append(changes, newChange(to, name, f, pfs.ChangeType_CHANGE_TYPE_ADDED))
+		case !ok:
+			changes = append(changes, newChange(to, name, f, pfs.ChangeType_CHANGE_TYPE_ADDED))
+		case !f.dir && fromFile != f && !bytes.Equal(fromFile.data, f.data):
+			changes = append(changes, newChange(to, name, f, pfs.ChangeType_CHANGE_TYPE_MODIFIED))
+		}
+	}
+	for name, f := range fromFiles {
+		if _, ok := c.files[name]; !ok {
+			changes = append(changes, newChange(to, name, f, pfs.ChangeType_CHANGE_TYPE_DELETED))
+		}
+	}
+	sort.Sort(changesByPath(changes))
+	return changes, nil
+}
+
+func (d *driver) ListFileHistory(ctx context.Context, path *pfs.Path, shard int) ([]*pfs.FileRevision, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	name := cleanPath(path.Path)
+	var fileRevisions []*pfs.FileRevision
+	for commit := path.Commit; commit != nil; {
+		c, err := d.getCommit(commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		var parent *pfs.Commit
+		var parentFile *file
+		if c.parent != "" {
+			parent = &pfs.Commit{Repository: commit.Repository, Id: c.parent}
+			parentC, err := d.getCommit(parent, shard)
+			if err != nil {
+				return nil, err
+			}
+			parentFile = parentC.files[name]
+		}
+		f := c.files[name]
+		if changeType := fileChangeType(parentFile, f); changeType != pfs.ChangeType_CHANGE_TYPE_NONE {
+			fileRevision := &pfs.FileRevision{
+				Commit:     commit,
+				ChangeType: changeType,
+			}
+			if f != nil && !f.dir {
+				fileRevision.SizeBytes = uint64(len(f.data))
+			}
+			if c.readOnly {
+				fileRevision.Finished = protoutil.TimeToTimestamp(c.finished)
+			}
+			fileRevisions = append(fileRevisions, fileRevision)
+		}
+		commit = parent
+	}
+	return fileRevisions, nil
+}
+
+func (d *driver) Branch(ctx context.Context, commit *pfs.Commit, newCommit *pfs.Commit, branch string, message string, shards map[int]bool) (*pfs.Commit, error) {
+	if commit == nil && newCommit == nil {
+		return nil, fmt.Errorf("pachyderm: must specify either commit or newCommit")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.branch(commit, newCommit, branch, message, shards)
+}
+
+func (d *driver) Merge(ctx context.Context, ours *pfs.Commit, theirs *pfs.Commit, newCommit *pfs.Commit, paths []string, branch string, message string, shards map[int]bool) (*pfs.Commit, error) {
+	if ours == nil || theirs == nil {
+		return nil, fmt.Errorf("pachyderm: must specify both ours and theirs")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for shard := range shards {
+		if _, err := d.getReadCommit(theirs, shard); err != nil {
+			return nil, err
+		}
+	}
+	newCommit, err := d.branch(ours, newCommit, branch, message, shards)
+	if err != nil {
+		return nil, err
+	}
+	for shard := range shards {
+		theirsC, err := d.getReadCommit(theirs, shard)
+		if err != nil {
+			return nil, err
+		}
+		c, err := d.getWriteCommit(newCommit, shard)
+		if err != nil {
+			return nil, err
+		}
+		c.mergeParent = theirs.Id
+		files := c.write()
+		for _, p := range paths {
+			name := cleanPath(p)
+			theirsFile, ok := theirsC.files[name]
+			if f, exists := files[name]; exists && ok && f.dir && theirsFile.dir {
+				continue
+			}
+			for fileName := range files {
+				if fileName == name || strings.HasPrefix(fileName, name+"/") {
+					delete(files, fileName)
+				}
+			}
+			if !ok {
+				continue
+			}
+			// ours may have deleted the directories theirs added to
+			for dir := parentPath(name); dir != ""; dir = parentPath(dir) {
+				if f, ok := files[dir]; ok {
+					if !f.dir {
+						return nil, fmt.Errorf("pachyderm: %s is not a directory", dir)
+					}
+					break
+				}
+				files[dir] = &file{dir: true, modTime: time.Now()}
+			}
+			files[name] = theirsFile
+		}
+	}
+	return newCommit, nil
+}
+
+// branch is Branch without locking.
+func (d *driver) branch(commit *pfs.Commit, newCommit *pfs.Commit, branch string, message string, shards map[int]bool) (*pfs.Commit, error) {
+	if newCommit == nil {
+		newCommit = &pfs.Commit{
+			Repository: commit.Repository,
+			Id:         newCommitID(),
+		}
+	}
+	commits, err := d.getCommits(newCommit.Repository)
+	if err != nil {
+		return nil, err
+	}
+	// check every shard before writing anything
+	for shard := range shards {
+		if _, ok := commits[newCommit.Id][shard]; ok {
+			return nil, fmt.Errorf("pachyderm: commit %s already exists", newCommit.Id)
+		}
+		if commit != nil {
+			if _, err := d.getReadCommit(commit, shard); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, ok := commits[newCommit.Id]; !ok {
+		commits[newCommit.Id] = make(map[int]*shardCommit)
+	}
+	created := time.Now()
+	for shard := range shards {
+		newC := &shardCommit{
+			branch:  branch,
+			message: message,
+			created: created,
+			seq:     d.nextSeq(),
+			files:   make(map[string]*file),
+		}
+		if commit != nil {
+			c, err := d.getReadCommit(commit, shard)
+			if err != nil {
+				return nil, err
+			}
+			newC.parent = commit.Id
+			newC.files = c.files
+			newC.shared = true
+		}
+		commits[newCommit.Id][shard] = newC
+	}
+	return newCommit, nil
+}
+
+func (d *driver) Commit(ctx context.Context, commit *pfs.Commit, message string, shards map[int]bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for shard := range shards {
+		if _, err := d.getWriteCommit(commit, shard); err != nil {
+			return err
+		}
+	}
+	finished := time.Now()
+	for shard := range shards {
+		c, err := d.getCommit(commit, shard)
+		if err != nil {
+			return err
+		}
+		for name, f := range c.files {
+			if !f.dir && f.checksum == nil {
+				checksum := sha256.Sum256(f.data)
+				c.write()[name] = &file{
+					data:     f.data,
+					modTime:  f.modTime,
+					checksum: checksum[:],
+				}
+			}
+		}
+		c.readOnly = true
+		c.finished = finished
+		if message != "" {
+			c.message = message
+		}
+	}
+	return nil
+}
+
+func (d *driver) Uncommit(ctx context.Context, commit *pfs.Commit, shards map[int]bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	commits, err := d.getCommits(commit.Repository)
+	if err != nil {
+		return err
+	}
+	for shard := range shards {
+		c, ok := commits[commit.Id][shard]
+		if !ok || !c.readOnly {
+			continue
+		}
+		c.readOnly = false
+		c.finished = time.Time{}
+		// children of c may share its files
+		c.shared = true
+	}
+	return nil
+}
+
+func (d *driver) DeleteCommit(ctx context.Context, commit *pfs.Commit, shards map[int]bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	commits, err := d.getCommits(commit.Repository)
+	if err != nil {
+		return err
+	}
+	for shard := range shards {
+		delete(commits[commit.Id], shard)
+	}
+	if len(commits[commit.Id]) == 0 {
+		delete(commits, commit.Id)
+	}
+	return nil
+}
+
+func (d *driver) SquashCommits(ctx context.Context, commits []*pfs.Commit, message string, shards map[int]bool) error {
+	if len(commits) == 0 {
+		return fmt.Errorf("pachyderm: must specify commits to squash")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	// check every shard before changing anything
+	for shard := range shards {
+		for i, commit := range commits {
+			c, err := d.getReadCommit(commit, shard)
+			if err != nil {
+				return err
+			}
+			if i > 0 && c.parent != commits[i-1].Id {
+				return fmt.Errorf("pachyderm: commit %s is not the parent of %s", commits[i-1].Id, commit.Id)
+			}
+		}
+	}
+	last := commits[len(commits)-1]
+	for shard := range shards {
+		first, err := d.getCommit(commits[0], shard)
+		if err != nil {
+			return err
+		}
+		c, err := d.getCommit(last, shard)
+		if err != nil {
+			return err
+		}
+		c.parent = first.parent
+		if message != "" {
+			c.message = message
+		}
+	}
+	allCommits, err := d.getCommits(last.Repository)
+	if err != nil {
+		return err
+	}
+	squashed := make(map[string]bool)
+	for _, commit := range commits[:len(commits)-1] {
+		squashed[commit.Id] = true
+	}
+	// the other children of the squashed commits become children of last
+	for _, shardCommits := range allCommits {
+		for shard, c := range shardCommits {
+			if !shards[shard] {
+				continue
+			}
+			if squashed[c.parent] {
+				c.parent = last.Id
+			}
+			if squashed[c.mergeParent] {
+				c.mergeParent = last.Id
+			}
+		}
+	}
+	for _, commit := range commits[:len(commits)-1] {
+		for shard := range shards {
+			delete(allCommits[commit.Id], shard)
+		}
+		if len(allCommits[commit.Id]) == 0 {
+			delete(allCommits, commit.Id)
+		}
+	}
+	return nil
+}
+
+func (d *driver) PullDiff(ctx context.Context, commit *pfs.Commit, shard int, writer io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	c, err := d.getReadCommit(commit, shard)
+	if err != nil {
+		return err
+	}
+	commitDiff := &diff{
+		Shard:       shard,
+		Parent:      c.parent,
+		MergeParent: c.mergeParent,
+		Branch:      c.branch,
+		Message:     c.message,
+		Created:     c.created,
+		Finished:    c.finished,
+		Files:       make(map[string]*diffFile),
+	}
+	parentFiles := make(map[string]*file)
+	if c.parent != "" {
+		parent, err := d.getCommit(&pfs.Commit{Repository: commit.Repository, Id: c.parent}, shard)
+		if err != nil {
+			return err
+		}
+		parentFiles = parent.files
+	}
+	for name, f := range c.files {
+		if parentFiles[name] == f {
+			continue
+		}
+		commitDiff.Files[name] = &diffFile{
+			Dir:      f.dir,
+			Data:     f.data,
+			ModTime:  f.modTime,
+			Checksum: f.checksum,
+		}
+	}
+	for name := range parentFiles {
+		if _, ok := c.files[name]; !ok {
+			commitDiff.Deleted = append(commitDiff.Deleted, name)
+		}
+	}
+	sort.Strings(commitDiff.Deleted)
+	return gob.NewEncoder(writer).Encode(commitDiff)
+}
+
+func (d *driver) PushDiff(ctx context.Context, commit *pfs.Commit, reader io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	commitDiff := &diff{}
+	if err := gob.NewDecoder(reader).Decode(commitDiff); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	commits, err := d.getCommits(commit.Repository)
+	if err != nil {
+		return err
+	}
+	if _, ok := commits[commit.Id][commitDiff.Shard]; ok {
+		return fmt.Errorf("pachyderm: commit %s already exists on shard %d", commit.Id, commitDiff.Shard)
+	}
+	files := make(map[string]*file)
+	if commitDiff.Parent != "" {
+		parent, err := d.getReadCommit(&pfs.Commit{Repository: commit.Repository, Id: commitDiff.Parent}, commitDiff.Shard)
+		if err != nil {
+			return err
+		}
+		for name, f := range parent.files {
+			files[name] = f
+		}
+	}
+	for _, name := range commitDiff.Deleted {
+		delete(files, name)
+	}
+	for name, df := range commitDiff.Files {
+		files[cleanPath(name)] = &file{
+			dir:      df.Dir,
+			data:     df.Data,
+			modTime:  df.ModTime,
+			checksum: df.Checksum,
+		}
+	}
+	if _, ok := commits[commit.Id]; !ok {
+		commits[commit.Id] = make(map[int]*shardCommit)
+	}
+	commits[commit.Id][commitDiff.Shard] = &shardCommit{
+		parent:      commitDiff.Parent,
+		mergeParent: commitDiff.MergeParent,
+		readOnly:    true,
+		branch:      commitDiff.Branch,
+		message:     commitDiff.Message,
+		created:     commitDiff.Created,
+		finished:    commitDiff.Finished,
+		seq:         d.nextSeq(),
+		files:       files,
+	}
+	return nil
+}
+
+func (d *driver) GetCommitInfo(ctx context.Context, commit *pfs.Commit, shard int) (*pfs.CommitInfo, bool, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	c, ok := d.repositories[commit.Repository.Name][commit.Id][shard]
+	if !ok {
+		return nil, false, nil
+	}
+	return newCommitInfo(commit, c), true, nil
+}
+
+func (d *driver) ListCommits(ctx context.Context, repository *pfs.Repository, shard int) ([]*pfs.CommitInfo, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	commits, err := d.getCommits(repository)
+	if err != nil {
+		return nil, err
+	}
+	var sorted commitsBySeq
+	for commitID, shardToCommit := range commits {
+		c, ok := shardToCommit[shard]
+		if !ok {
+			continue
+		}
+		sorted.commitIDs = append(sorted.commitIDs, commitID)
+		sorted.commits = append(sorted.commits, c)
+	}
+	sort.Sort(sort.Reverse(&sorted))
+	var commitInfos []*pfs.CommitInfo
+	for i, commitID := range sorted.commitIDs {
+		commitInfos = append(
+			commitInfos,
+			newCommitInfo(
+				&pfs.Commit{
+					Repository: repository,
+					Id:         commitID,
+				},
+				sorted.commits[i],
+			),
+		)
+	}
+	return commitInfos, nil
+}
+
+func (d *driver) CreateBranch(ctx context.Context, repository *pfs.Repository, name string, commit *pfs.Commit) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	branches, err := d.getBranches(repository)
+	if err != nil {
+		return err
+	}
+	branches[name] = commit.Id
+	return nil
+}
+
+func (d *driver) ListBranches(ctx context.Context, repository *pfs.Repository) ([]*pfs.BranchInfo, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	branches, err := d.getBranches(repository)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var branchInfos []*pfs.BranchInfo
+	for _, name := range names {
+		branchInfos = append(
+			branchInfos,
+			&pfs.BranchInfo{
+				Name: name,
+				Commit: &pfs.Commit{
+					Repository: repository,
+					Id:         branches[name],
+				},
+			},
+		)
+	}
+	return branchInfos, nil
+}
+
+func (d *driver) DeleteBranch(ctx context.Context, repository *pfs.Repository, name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	branches, err := d.getBranches(repository)
+	if err != nil {
+		return err
+	}
+	if _, ok := branches[name]; !ok {
+		return fmt.Errorf("pachyderm: branch %s not found", name)
+	}
+	delete(branches, name)
+	return nil
+}
+
+func (d *driver) SetRetentionPolicy(ctx context.Context, repository *pfs.Repository, retentionPolicy *pfs.RetentionPolicy) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, err := d.getCommits(repository); err != nil {
+		return err
+	}
+	if retentionPolicy == nil {
+		delete(d.retentionPolicies, repository.Name)
+		return nil
+	}
+	d.retentionPolicies[repository.Name] = proto.Clone(retentionPolicy).(*pfs.RetentionPolicy)
+	return nil
+}
+
+func (d *driver) GetRetentionPolicy(ctx context.Context, repository *pfs.Repository) (*pfs.RetentionPolicy, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if _, err := d.getCommits(repository); err != nil {
+		return nil, err
+	}
+	retentionPolicy, ok := d.retentionPolicies[repository.Name]
+	if !ok {
+		return nil, nil
+	}
+	return proto.Clone(retentionPolicy).(*pfs.RetentionPolicy), nil
+}
+
+func (d *driver) StartOperation(ctx context.Context, operation *pfs.Operation) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.operations[operation.Id]; ok {
+		return fmt.Errorf("pachyderm: operation %s already exists", operation.Id)
+	}
+	d.operations[operation.Id] = operation
+	return nil
+}
+
+func (d *driver) FinishOperation(ctx context.Context, operation *pfs.Operation) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.operations[operation.Id]; !ok {
+		return fmt.Errorf("pachyderm: operation %s not found", operation.Id)
+	}
+	delete(d.operations, operation.Id)
+	return nil
+}
+
+func (d *driver) ListOperations(ctx context.Context) ([]*pfs.Operation, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var ids []string
+	for id := range d.operations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var operations []*pfs.Operation
+	for _, id := range ids {
+		operations = append(operations, d.operations[id])
+	}
+	return operations, nil
+}
+
+func (d *driver) getBranches(repository *pfs.Repository) (map[string]string, error) {
+	branches, ok := d.branches[repository.Name]
+	if !ok {
+		return nil, fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	return branches, nil
+}
+
+func (d *driver) getCommits(repository *pfs.Repository) (map[string]map[int]*shardCommit, error) {
+	commits, ok := d.repositories[repository.Name]
+	if !ok {
+		return nil, fmt.Errorf("pachyderm: repository %s not found", repository.Name)
+	}
+	return commits, nil
+}
+
+func (d *driver) getCommit(commit *pfs.Commit, shard int) (*shardCommit, error) {
+	commits, err := d.getCommits(commit.Repository)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := commits[commit.Id][shard]
+	if !ok {
+		return nil, fmt.Errorf("pachyderm: commit %s doesn't exist", commit.Id)
+	}
+	return c, nil
+}
+
+func (d *driver) getReadCommit(commit *pfs.Commit, shard int) (*shardCommit, error) {
+	c, err := d.getCommit(commit, shard)
+	if err != nil {
+		return nil, err
+	}
+	if !c.readOnly {
+		return nil, fmt.Errorf("%+v is not a read only commit", commit)
+	}
+	return c, nil
+}
+
+func (d *driver) getWriteCommit(commit *pfs.Commit, shard int) (*shardCommit, error) {
+	c, err := d.getCommit(commit, shard)
+	if err != nil {
+		return nil, err
+	}
+	if c.readOnly {
+		return nil, fmt.Errorf("%+v is not a write commit", commit)
+	}
+	return c, nil
+}
+
+func (d *driver) nextSeq() uint64 {
+	d.seq++
+	return d.seq
+}
+
+type readerAtCloser struct {
+	*bytes.Reader
+}
+
+func (r *readerAtCloser) Close() error {
+	return nil
+}
+
+type commitsBySeq struct {
+	commitIDs []string
+	commits   []*shardCommit
+}
+
+func (c *commitsBySeq) Len() int {
+	return len(c.commits)
+}
+
+func (c *commitsBySeq) Less(i, j int) bool {
+	return c.commits[i].seq < c.commits[j].seq
+}
+
+func (c *commitsBySeq) Swap(i, j int) {
+	c.commitIDs[i], c.commitIDs[j] = c.commitIDs[j], c.commitIDs[i]
+	c.commits[i], c.commits[j] = c.commits[j], c.commits[i]
+}
+
+type changesByPath []*pfs.Change
+
+func (c changesByPath) Len() int {
+	return len(c)
+}
+
+func (c changesByPath) Less(i, j int) bool {
+	if c[i].Path.Path != c[j].Path.Path {
+		return c[i].Path.Path < c[j].Path.Path
+	}
+	return c[i].ChangeType > c[j].ChangeType
+}
+
+func (c changesByPath) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+
+// fileChangeType returns how a file changed from from to to, either is nil
+// if the file doesn't exist.
+func fileChangeType(from *file, to *file) pfs.ChangeType {
+	switch {
+	case from == nil && to == nil:
+		return pfs.ChangeType_CHANGE_TYPE_NONE
+	case from == nil:
+		return pfs.ChangeType_CHANGE_TYPE_ADDED
+	case to == nil:
+		return pfs.ChangeType_CHANGE_TYPE_DELETED
+	case from.dir != to.dir:
+		return pfs.ChangeType_CHANGE_TYPE_MODIFIED
+	case !to.dir && from != to && !bytes.Equal(from.data, to.data):
+		return pfs.ChangeType_CHANGE_TYPE_MODIFIED
+	}
+	return pfs.ChangeType_CHANGE_TYPE_NONE
+}
+
+func newChange(commit *pfs.Commit, name string, file *file, changeType pfs.ChangeType) *pfs.Change {
+	fileType := pfs.FileType_FILE_TYPE_REGULAR
+	if file.dir {
+		fileType = pfs.FileType_FILE_TYPE_DIR
+	}
+	return &pfs.Change{
+		Path: &pfs.Path{
+			Commit: commit,
+			Path:   name,
+		},
+		ChangeType: changeType,
+		FileType:   fileType,
+	}
+}
+
+func newFileInfo(path *pfs.Path, file *file) *pfs.FileInfo {
+	fileType := pfs.FileType_FILE_TYPE_REGULAR
+	perm := uint32(filePerm)
+	if file.dir {
+		fileType = pfs.FileType_FILE_TYPE_DIR
+		perm = dirPerm
+	}
+	return &pfs.FileInfo{
+		Path:         path,
+		FileType:     fileType,
+		SizeBytes:    uint64(len(file.data)),
+		Perm:         perm,
+		LastModified: protoutil.TimeToTimestamp(file.modTime),
+		Checksum:     file.checksum,
+	}
+}
+
+func newCommitInfo(commit *pfs.Commit, c *shardCommit) *pfs.CommitInfo {
+	commitType := pfs.CommitType_COMMIT_TYPE_WRITE
+	if c.readOnly {
+		commitType = pfs.CommitType_COMMIT_TYPE_READ
+	}
+	var parent *pfs.Commit
+	if c.parent != "" {
+		parent = &pfs.Commit{
+			Repository: commit.Repository,
+			Id:         c.parent,
+		}
+	}
+	commitInfo := &pfs.CommitInfo{
+		Commit:       commit,
+		CommitType:   commitType,
+		ParentCommit: parent,
+		Created:      protoutil.TimeToTimestamp(c.created),
+		Message:      c.message,
+		Branch:       c.branch,
+	}
+	if c.mergeParent != "" {
+		commitInfo.MergeParentCommit = &pfs.Commit{
+			Repository: commit.Repository,
+			Id:         c.mergeParent,
+		}
+	}
+	if c.readOnly {
+		commitInfo.Finished = protoutil.TimeToTimestamp(c.finished)
+	}
+	for _, f := range c.files {
+		commitInfo.SizeBytes += uint64(len(f.data))
+	}
+	return commitInfo
+}
+
+func newCommitID() string {
+	return strings.Replace(uuid.NewV4().String(), "-", "", -1)
+}
+
+// cleanPath returns the key for p in a commit's files, the root is "".
+func cleanPath(p string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+p), "/")
+}
+
+func parentPath(name string) string {
+	dir := filepath.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
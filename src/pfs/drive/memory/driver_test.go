@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pfs/drive/drivertest"
+)
+
+func TestDriver(t *testing.T) {
+	drivertest.RunDriverTests(t, func(t *testing.T) drive.Driver {
+		return NewDriver()
+	})
+}
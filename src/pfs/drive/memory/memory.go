@@ -0,0 +1,14 @@
+/*
+Package memory provides a drive.Driver that keeps everything in memory.
+
+It is meant for tests and for embedding pfs in other processes, nothing
+written to it survives the process.
+*/
+package memory
+
+import "github.com/pachyderm/pachyderm/src/pfs/drive"
+
+// NewDriver constructs a new in-memory Driver.
+func NewDriver() drive.Driver {
+	return newDriver()
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"sync/atomic"
 
 	"bazil.org/fuse"
@@ -18,6 +19,8 @@ import (
 const (
 	namePrefix = "pfs://"
 	subtype    = "pfs"
+	// maxBufferedWrites is how many bytes a file buffers before writing them.
+	maxBufferedWrites = 8 * pfsutil.PutFileChunkSize
 )
 
 type mounter struct {
@@ -103,8 +106,12 @@ func (d *directory) nodeFromFileInfo(fileInfo *pfs.FileInfo) (fs.Node, error) {
 	case pfs.FileType_FILE_TYPE_OTHER:
 		return nil, fuse.ENOENT
 	case pfs.FileType_FILE_TYPE_REGULAR:
-		return &file{d.fs, d.commitID, path.Join(d.path, fileInfo.Path.Path), 0,
-			int64(fileInfo.SizeBytes)}, nil
+		return &file{
+			fs:       d.fs,
+			commitID: d.commitID,
+			path:     path.Join(d.path, fileInfo.Path.Path),
+			size:     int64(fileInfo.SizeBytes),
+		}, nil
 	case pfs.FileType_FILE_TYPE_DIR:
 		return &directory{d.fs, d.commitID, d.write, fileInfo.Path.Path}, nil
 	default:
@@ -150,10 +157,19 @@ func (d *directory) readCommits(ctx context.Context) ([]fuse.Dirent, error) {
 	if err != nil {
 		return nil, err
 	}
-	result := make([]fuse.Dirent, 0, len(response.CommitInfo))
+	listBranchesResponse, err := pfsutil.ListBranches(d.fs.apiClient, d.fs.repositoryName)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]fuse.Dirent, 0, len(response.CommitInfo)+len(listBranchesResponse.BranchInfo))
 	for _, commitInfo := range response.CommitInfo {
 		result = append(result, fuse.Dirent{Name: commitInfo.Commit.Id, Type: fuse.DT_Dir})
 	}
+	// branches are resolved by the server every time they are used, so a
+	// branch directory always shows the commit the branch currently points at
+	for _, branchInfo := range listBranchesResponse.BranchInfo {
+		result = append(result, fuse.Dirent{Name: branchInfo.Name, Type: fuse.DT_Dir})
+	}
 	return result, nil
 }
 
@@ -188,7 +204,11 @@ func (d *directory) Create(ctx context.Context, request *fuse.CreateRequest, res
 	if d.commitID == "" {
 		return nil, 0, fuse.EPERM
 	}
-	result := &file{d.fs, d.commitID, path.Join(d.path, request.Name), 0, 0}
+	result := &file{
+		fs:       d.fs,
+		commitID: d.commitID,
+		path:     path.Join(d.path, request.Name),
+	}
 	handle, err := result.Open(ctx, nil, nil)
 	if err != nil {
 		return nil, nil, err
@@ -208,15 +228,30 @@ func (d *directory) Mkdir(ctx context.Context, request *fuse.MkdirRequest) (fs.N
 	}, nil
 }
 
+func (d *directory) Remove(ctx context.Context, request *fuse.RemoveRequest) error {
+	if d.commitID == "" || !d.write {
+		return fuse.EPERM
+	}
+	return pfsutil.DeleteFile(d.fs.apiClient, d.fs.repositoryName, d.commitID, path.Join(d.path, request.Name))
+}
+
 type file struct {
 	fs       *filesystem
 	commitID string
 	path     string
 	handles  int32
 	size     int64
+	// writes buffers contiguous writes starting at writeOffset until they're
+	// flushed in a single PutFile.
+	lock        sync.Mutex
+	writes      bytes.Buffer
+	writeOffset int64
 }
 
 func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
+	if err := f.flush(); err != nil {
+		return err
+	}
 	response, err := pfsutil.GetFileInfo(
 		f.fs.apiClient,
 		f.fs.repositoryName,
@@ -234,6 +269,9 @@ func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
 }
 
 func (f *file) Read(ctx context.Context, request *fuse.ReadRequest, response *fuse.ReadResponse) error {
+	if err := f.flush(); err != nil {
+		return err
+	}
 	buffer := bytes.NewBuffer(make([]byte, 0, request.Size))
 	if err := pfsutil.GetFile(f.fs.apiClient, f.fs.repositoryName, f.commitID, f.path, request.Offset, int64(request.Size), buffer); err != nil {
 		return err
@@ -248,13 +286,53 @@ func (f *file) Open(ctx context.Context, request *fuse.OpenRequest, response *fu
 }
 
 func (f *file) Write(ctx context.Context, request *fuse.WriteRequest, response *fuse.WriteResponse) error {
-	written, err := pfsutil.PutFile(f.fs.apiClient, f.fs.repositoryName, f.commitID, f.path, request.Offset, bytes.NewReader(request.Data))
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.writes.Len() > 0 && f.writeOffset+int64(f.writes.Len()) != request.Offset {
+		if err := f.flushLocked(); err != nil {
+			return err
+		}
+	}
+	if f.writes.Len() == 0 {
+		f.writeOffset = request.Offset
+	}
+	written, err := f.writes.Write(request.Data)
 	if err != nil {
 		return err
 	}
-	response.Size = int(written)
-	if f.size < request.Offset+written {
-		f.size = request.Offset + written
+	response.Size = written
+	if f.size < request.Offset+int64(written) {
+		f.size = request.Offset + int64(written)
+	}
+	if f.writes.Len() >= maxBufferedWrites {
+		return f.flushLocked()
+	}
+	return nil
+}
+
+func (f *file) Flush(ctx context.Context, request *fuse.FlushRequest) error {
+	return f.flush()
+}
+
+func (f *file) Release(ctx context.Context, request *fuse.ReleaseRequest) error {
+	return f.flush()
+}
+
+func (f *file) flush() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.flushLocked()
+}
+
+// flushLocked sends the buffered writes, f.lock must be held. The writes stay
+// buffered if sending them fails so the next flush retries them.
+func (f *file) flushLocked() error {
+	if f.writes.Len() == 0 {
+		return nil
+	}
+	if _, err := pfsutil.PutFile(f.fs.apiClient, f.fs.repositoryName, f.commitID, f.path, f.writeOffset, bytes.NewReader(f.writes.Bytes())); err != nil {
+		return err
 	}
+	f.writes.Reset()
 	return nil
 }
@@ -0,0 +1,28 @@
+/*
+Package mirror copies repositories between PFS clusters.
+
+Commits are copied through the API rather than as driver diffs, so the
+clusters can have different numbers of shards. A commit keeps its id, parent,
+merge parent and message, and only the files it changed are sent.
+*/
+package mirror
+
+import (
+	"github.com/pachyderm/pachyderm/src/pfs"
+)
+
+type Mirrorer interface {
+	// Mirror copies the read commits of repositoryName that to doesn't have
+	// from from, parents first, and then points to's branches at the commits
+	// from's branches point at. A branch on to is only moved to a descendant
+	// of the commit it points at, Mirror returns an error naming the
+	// branches that have diverged. The repository is created on to if needed.
+	// It returns the commits that were copied.
+	// Commits that were squashed on from after they were copied are not
+	// changed on to.
+	Mirror(repositoryName string) ([]*pfs.Commit, error)
+}
+
+func NewMirrorer(from pfs.ApiClient, to pfs.ApiClient) Mirrorer {
+	return newMirrorer(from, to)
+}
@@ -0,0 +1,258 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pachyderm/pachyderm/src/pfs"
+	"github.com/pachyderm/pachyderm/src/pfs/pfsutil"
+	"golang.org/x/net/context"
+)
+
+type mirrorer struct {
+	from pfs.ApiClient
+	to   pfs.ApiClient
+}
+
+func newMirrorer(from pfs.ApiClient, to pfs.ApiClient) *mirrorer {
+	return &mirrorer{from, to}
+}
+
+func (m *mirrorer) Mirror(repositoryName string) ([]*pfs.Commit, error) {
+	ok, err := m.hasRepository(repositoryName)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := pfsutil.InitRepository(m.to, repositoryName); err != nil {
+			return nil, err
+		}
+	}
+	fromListCommitsResponse, err := pfsutil.ListCommits(m.from, repositoryName)
+	if err != nil {
+		return nil, err
+	}
+	toListCommitsResponse, err := pfsutil.ListCommits(m.to, repositoryName)
+	if err != nil {
+		return nil, err
+	}
+	toCommitInfos := make(map[string]*pfs.CommitInfo)
+	for _, commitInfo := range toListCommitsResponse.CommitInfo {
+		toCommitInfos[commitInfo.Commit.Id] = commitInfo
+	}
+	var commits []*pfs.Commit
+	// commits are listed newest first and a commit is always newer than its parents
+	fromCommitInfos := fromListCommitsResponse.CommitInfo
+	for i := len(fromCommitInfos) - 1; i >= 0; i-- {
+		commitInfo := fromCommitInfos[i]
+		if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+			continue
+		}
+		if toCommitInfo, ok := toCommitInfos[commitInfo.Commit.Id]; ok {
+			if toCommitInfo.CommitType == pfs.CommitType_COMMIT_TYPE_READ {
+				continue
+			}
+			// left behind by a copy that failed part way through
+			if err := pfsutil.DeleteCommit(m.to, repositoryName, commitInfo.Commit.Id); err != nil {
+				return commits, err
+			}
+		}
+		if err := m.copyCommit(commitInfo); err != nil {
+			return commits, err
+		}
+		commits = append(commits, commitInfo.Commit)
+	}
+	listBranchesResponse, err := pfsutil.ListBranches(m.from, repositoryName)
+	if err != nil {
+		return commits, err
+	}
+	toListBranchesResponse, err := pfsutil.ListBranches(m.to, repositoryName)
+	if err != nil {
+		return commits, err
+	}
+	toBranches := make(map[string]*pfs.Commit)
+	for _, branchInfo := range toListBranchesResponse.BranchInfo {
+		toBranches[branchInfo.Name] = branchInfo.Commit
+	}
+	parents := make(map[string][]*pfs.Commit)
+	for _, commitInfo := range fromCommitInfos {
+		parents[commitInfo.Commit.Id] = []*pfs.Commit{commitInfo.ParentCommit, commitInfo.MergeParentCommit}
+	}
+	var diverged []string
+	for _, branchInfo := range listBranchesResponse.BranchInfo {
+		toCommit := toBranches[branchInfo.Name]
+		if toCommit != nil && toCommit.Id == branchInfo.Commit.Id {
+			continue
+		}
+		// a branch that was moved on to is only moved forward
+		if toCommit != nil && !isAncestor(parents, toCommit.Id, branchInfo.Commit.Id) {
+			diverged = append(diverged, branchInfo.Name)
+			continue
+		}
+		if _, err := m.to.CreateBranch(
+			context.Background(),
+			&pfs.CreateBranchRequest{
+				Commit:      branchInfo.Commit,
+				Name:        branchInfo.Name,
+				CheckAndSet: true,
+				PrevCommit:  toCommit,
+			},
+		); err != nil {
+			return commits, err
+		}
+	}
+	if len(diverged) != 0 {
+		return commits, fmt.Errorf("pachyderm: branches %s of %s have diverged and were not updated", strings.Join(diverged, ", "), repositoryName)
+	}
+	return commits, nil
+}
+
+// copyCommit makes commitInfo's commit on to from its parent, which to must
+// already have.
+func (m *mirrorer) copyCommit(commitInfo *pfs.CommitInfo) error {
+	commit := commitInfo.Commit
+	if commitInfo.ParentCommit == nil {
+		return fmt.Errorf("pachyderm: commit %s has no parent", commit.Id)
+	}
+	// the paths that differ between the new commit and commit on from
+	paths := make(map[string]bool)
+	if commitInfo.MergeParentCommit != nil {
+		if _, err := m.to.Merge(
+			context.Background(),
+			&pfs.MergeRequest{
+				Ours:           commitInfo.ParentCommit,
+				Theirs:         commitInfo.MergeParentCommit,
+				NewCommit:      commit,
+				ConflictPolicy: pfs.ConflictPolicy_CONFLICT_POLICY_OURS,
+				Message:        commitInfo.Message,
+			},
+		); err != nil {
+			return err
+		}
+		// conflicts may have been resolved differently on from, the paths
+		// the merge changed are compared along with the ones from changed
+		listChangedFilesResponse, err := pfsutil.ListChangedFiles(m.to, commit.Repository.Name, "", commit.Id, 0, 1)
+		if err != nil {
+			return err
+		}
+		for _, change := range listChangedFilesResponse.Change {
+			paths[change.Path.Path] = true
+		}
+	} else {
+		if _, err := m.to.Branch(
+			context.Background(),
+			&pfs.BranchRequest{
+				Commit:    commitInfo.ParentCommit,
+				NewCommit: commit,
+				Message:   commitInfo.Message,
+			},
+		); err != nil {
+			return err
+		}
+	}
+	listChangedFilesResponse, err := pfsutil.ListChangedFiles(m.from, commit.Repository.Name, "", commit.Id, 0, 1)
+	if err != nil {
+		return err
+	}
+	for _, change := range listChangedFilesResponse.Change {
+		paths[change.Path.Path] = true
+	}
+	var sorted []string
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	// directories sort before their contents
+	sort.Strings(sorted)
+	for _, path := range sorted {
+		if err := m.copyPath(commit, path); err != nil {
+			return err
+		}
+	}
+	return pfsutil.Commit(m.to, commit.Repository.Name, commit.Id, commitInfo.Message)
+}
+
+// copyPath makes path in commit on to the same as it is on from.
+func (m *mirrorer) copyPath(commit *pfs.Commit, path string) error {
+	fromFileInfo, err := getFileInfo(m.from, commit, path)
+	if err != nil {
+		return err
+	}
+	toFileInfo, err := getFileInfo(m.to, commit, path)
+	if err != nil {
+		return err
+	}
+	// a directory that is still a directory is kept, everything else is
+	// rewritten from scratch
+	if toFileInfo != nil && (fromFileInfo == nil ||
+		fromFileInfo.FileType != pfs.FileType_FILE_TYPE_DIR ||
+		toFileInfo.FileType != pfs.FileType_FILE_TYPE_DIR) {
+		if err := pfsutil.DeleteFile(m.to, commit.Repository.Name, commit.Id, path); err != nil {
+			return err
+		}
+		toFileInfo = nil
+	}
+	switch {
+	case fromFileInfo == nil:
+		return nil
+	case fromFileInfo.FileType == pfs.FileType_FILE_TYPE_DIR:
+		if toFileInfo != nil {
+			return nil
+		}
+		return pfsutil.MakeDirectory(m.to, commit.Repository.Name, commit.Id, path)
+	default:
+		return m.copyFile(commit, path)
+	}
+}
+
+func (m *mirrorer) copyFile(commit *pfs.Commit, path string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(pfsutil.GetFile(m.from, commit.Repository.Name, commit.Id, path, 0, pfsutil.GetAll, writer))
+	}()
+	_, err := pfsutil.PutFile(m.to, commit.Repository.Name, commit.Id, path, 0, reader)
+	// unblocks GetFile if PutFile failed first
+	_ = reader.Close()
+	return err
+}
+
+func (m *mirrorer) hasRepository(repositoryName string) (bool, error) {
+	listRepositoriesResponse, err := pfsutil.ListRepositories(m.to)
+	if err != nil {
+		return false, err
+	}
+	for _, repository := range listRepositoriesResponse.Repository {
+		if repository.Name == repositoryName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isAncestor returns true if ancestorID is commitID or one of its ancestors,
+// parents maps a commit id to its parent and merge parent.
+func isAncestor(parents map[string][]*pfs.Commit, ancestorID string, commitID string) bool {
+	visited := make(map[string]bool)
+	for queue := []string{commitID}; len(queue) != 0; queue = queue[1:] {
+		if queue[0] == ancestorID {
+			return true
+		}
+		for _, parent := range parents[queue[0]] {
+			if parent != nil && !visited[parent.Id] {
+				visited[parent.Id] = true
+				queue = append(queue, parent.Id)
+			}
+		}
+	}
+	return false
+}
+
+// getFileInfo returns nil if there is no file at path.
+func getFileInfo(apiClient pfs.ApiClient, commit *pfs.Commit, path string) (*pfs.FileInfo, error) {
+	getFileInfoResponse, err := pfsutil.GetFileInfo(apiClient, commit.Repository.Name, commit.Id, path)
+	if err != nil {
+		return nil, err
+	}
+	return getFileInfoResponse.FileInfo, nil
+}
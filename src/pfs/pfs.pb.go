@@ -15,23 +15,61 @@ It has these top-level messages:
 	FileInfo
 	Shard
 	CommitInfo
+	BranchInfo
+	RetentionPolicy
+	RepositoryInfo
+	Change
+	FileRevision
 	InitRepositoryRequest
+	ListRepositoriesRequest
+	ListRepositoriesResponse
+	InspectRepositoryRequest
+	InspectRepositoryResponse
+	DeleteRepositoryRequest
 	GetFileRequest
 	GetFileInfoRequest
 	GetFileInfoResponse
 	MakeDirectoryRequest
 	PutFileRequest
+	PutFileStreamResponse
+	DeleteFileRequest
 	ListFilesRequest
 	ListFilesResponse
+	ListChangedFilesRequest
+	ListChangedFilesResponse
+	ListFileHistoryRequest
+	ListFileHistoryResponse
+	ExportCommitRequest
+	ImportTarRequest
+	ImportTarResponse
 	BranchRequest
 	BranchResponse
+	MergeRequest
+	MergeResponse
 	CommitRequest
+	SquashCommitsRequest
+	SquashCommitsResponse
+	DeleteCommitRequest
 	GetCommitInfoRequest
 	GetCommitInfoResponse
 	ListCommitsRequest
 	ListCommitsResponse
+	CreateBranchRequest
+	ListBranchesRequest
+	ListBranchesResponse
+	DeleteBranchRequest
+	SetRetentionPolicyRequest
+	GetRetentionPolicyRequest
+	GetRetentionPolicyResponse
+	GarbageCollectRequest
+	GarbageCollectResponse
+	ReshardRequest
+	ReshardResponse
 	PullDiffRequest
 	PushDiffRequest
+	Operation
+	RollbackRequest
+	ReshardPhaseRequest
 */
 package pfs
 
@@ -39,6 +77,7 @@ import proto "github.com/golang/protobuf/proto"
 import google_protobuf "github.com/peter-edge/go-google-protobuf"
 import google_protobuf1 "github.com/peter-edge/go-google-protobuf"
 import google_protobuf2 "github.com/peter-edge/go-google-protobuf"
+import google_protobuf3 "github.com/peter-edge/go-google-protobuf"
 
 import (
 	context "golang.org/x/net/context"
@@ -103,6 +142,151 @@ func (x FileType) String() string {
 	return proto.EnumName(FileType_name, int32(x))
 }
 
+// ChangeType represents the type of change from ListChangedFiles.
+type ChangeType int32
+
+const (
+	ChangeType_CHANGE_TYPE_NONE     ChangeType = 0
+	ChangeType_CHANGE_TYPE_ADDED    ChangeType = 1
+	ChangeType_CHANGE_TYPE_MODIFIED ChangeType = 2
+	ChangeType_CHANGE_TYPE_DELETED  ChangeType = 3
+)
+
+var ChangeType_name = map[int32]string{
+	0: "CHANGE_TYPE_NONE",
+	1: "CHANGE_TYPE_ADDED",
+	2: "CHANGE_TYPE_MODIFIED",
+	3: "CHANGE_TYPE_DELETED",
+}
+var ChangeType_value = map[string]int32{
+	"CHANGE_TYPE_NONE":     0,
+	"CHANGE_TYPE_ADDED":    1,
+	"CHANGE_TYPE_MODIFIED": 2,
+	"CHANGE_TYPE_DELETED":  3,
+}
+
+func (x ChangeType) String() string {
+	return proto.EnumName(ChangeType_name, int32(x))
+}
+
+// ConflictPolicy decides how Merge handles a path that both sides changed.
+type ConflictPolicy int32
+
+const (
+	ConflictPolicy_CONFLICT_POLICY_NONE   ConflictPolicy = 0
+	ConflictPolicy_CONFLICT_POLICY_FAIL   ConflictPolicy = 1
+	ConflictPolicy_CONFLICT_POLICY_OURS   ConflictPolicy = 2
+	ConflictPolicy_CONFLICT_POLICY_THEIRS ConflictPolicy = 3
+)
+
+var ConflictPolicy_name = map[int32]string{
+	0: "CONFLICT_POLICY_NONE",
+	1: "CONFLICT_POLICY_FAIL",
+	2: "CONFLICT_POLICY_OURS",
+	3: "CONFLICT_POLICY_THEIRS",
+}
+var ConflictPolicy_value = map[string]int32{
+	"CONFLICT_POLICY_NONE":   0,
+	"CONFLICT_POLICY_FAIL":   1,
+	"CONFLICT_POLICY_OURS":   2,
+	"CONFLICT_POLICY_THEIRS": 3,
+}
+
+func (x ConflictPolicy) String() string {
+	return proto.EnumName(ConflictPolicy_name, int32(x))
+}
+
+// OperationType is the type of an operation that changes every shard.
+type OperationType int32
+
+const (
+	OperationType_OPERATION_TYPE_NONE            OperationType = 0
+	OperationType_OPERATION_TYPE_INIT_REPOSITORY OperationType = 1
+	OperationType_OPERATION_TYPE_MAKE_DIRECTORY  OperationType = 2
+	OperationType_OPERATION_TYPE_BRANCH          OperationType = 3
+	OperationType_OPERATION_TYPE_COMMIT          OperationType = 4
+	OperationType_OPERATION_TYPE_DELETE_COMMITS  OperationType = 5
+)
+
+var OperationType_name = map[int32]string{
+	0: "OPERATION_TYPE_NONE",
+	1: "OPERATION_TYPE_INIT_REPOSITORY",
+	2: "OPERATION_TYPE_MAKE_DIRECTORY",
+	3: "OPERATION_TYPE_BRANCH",
+	4: "OPERATION_TYPE_COMMIT",
+	5: "OPERATION_TYPE_DELETE_COMMITS",
+}
+var OperationType_value = map[string]int32{
+	"OPERATION_TYPE_NONE":            0,
+	"OPERATION_TYPE_INIT_REPOSITORY": 1,
+	"OPERATION_TYPE_MAKE_DIRECTORY":  2,
+	"OPERATION_TYPE_BRANCH":          3,
+	"OPERATION_TYPE_COMMIT":          4,
+	"OPERATION_TYPE_DELETE_COMMITS":  5,
+}
+
+func (x OperationType) String() string {
+	return proto.EnumName(OperationType_name, int32(x))
+}
+
+// ReshardPhase is a phase of Reshard, every server finishes a phase before
+// any server starts the next.
+type ReshardPhase int32
+
+const (
+	ReshardPhase_RESHARD_PHASE_NONE    ReshardPhase = 0
+	ReshardPhase_RESHARD_PHASE_START   ReshardPhase = 1
+	ReshardPhase_RESHARD_PHASE_MIGRATE ReshardPhase = 2
+	ReshardPhase_RESHARD_PHASE_FINISH  ReshardPhase = 3
+	ReshardPhase_RESHARD_PHASE_ABORT   ReshardPhase = 4
+	ReshardPhase_RESHARD_PHASE_CLEAN   ReshardPhase = 5
+)
+
+var ReshardPhase_name = map[int32]string{
+	0: "RESHARD_PHASE_NONE",
+	1: "RESHARD_PHASE_START",
+	2: "RESHARD_PHASE_MIGRATE",
+	3: "RESHARD_PHASE_FINISH",
+	4: "RESHARD_PHASE_ABORT",
+	5: "RESHARD_PHASE_CLEAN",
+}
+var ReshardPhase_value = map[string]int32{
+	"RESHARD_PHASE_NONE":    0,
+	"RESHARD_PHASE_START":   1,
+	"RESHARD_PHASE_MIGRATE": 2,
+	"RESHARD_PHASE_FINISH":  3,
+	"RESHARD_PHASE_ABORT":   4,
+	"RESHARD_PHASE_CLEAN":   5,
+}
+
+func (x ReshardPhase) String() string {
+	return proto.EnumName(ReshardPhase_name, int32(x))
+}
+
+// ReadConsistency decides which servers a read of a file can go to.
+type ReadConsistency int32
+
+const (
+	ReadConsistency_READ_CONSISTENCY_NONE   ReadConsistency = 0
+	ReadConsistency_READ_CONSISTENCY_ANY    ReadConsistency = 1
+	ReadConsistency_READ_CONSISTENCY_MASTER ReadConsistency = 2
+)
+
+var ReadConsistency_name = map[int32]string{
+	0: "READ_CONSISTENCY_NONE",
+	1: "READ_CONSISTENCY_ANY",
+	2: "READ_CONSISTENCY_MASTER",
+}
+var ReadConsistency_value = map[string]int32{
+	"READ_CONSISTENCY_NONE":   0,
+	"READ_CONSISTENCY_ANY":    1,
+	"READ_CONSISTENCY_MASTER": 2,
+}
+
+func (x ReadConsistency) String() string {
+	return proto.EnumName(ReadConsistency_name, int32(x))
+}
+
 // Repository represents a repository.
 type Repository struct {
 	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
@@ -153,6 +337,7 @@ type FileInfo struct {
 	SizeBytes    uint64                      `protobuf:"varint,3,opt,name=size_bytes" json:"size_bytes,omitempty"`
 	Perm         uint32                      `protobuf:"varint,4,opt,name=perm" json:"perm,omitempty"`
 	LastModified *google_protobuf1.Timestamp `protobuf:"bytes,5,opt,name=last_modified" json:"last_modified,omitempty"`
+	Checksum     []byte                      `protobuf:"bytes,6,opt,name=checksum,proto3" json:"checksum,omitempty"`
 }
 
 func (m *FileInfo) Reset()         { *m = FileInfo{} }
@@ -186,9 +371,15 @@ func (*Shard) ProtoMessage()    {}
 
 // CommitInfo represents information about a commit.
 type CommitInfo struct {
-	Commit       *Commit    `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
-	CommitType   CommitType `protobuf:"varint,2,opt,name=commit_type,enum=pfs.CommitType" json:"commit_type,omitempty"`
-	ParentCommit *Commit    `protobuf:"bytes,3,opt,name=parent_commit" json:"parent_commit,omitempty"`
+	Commit            *Commit                     `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	CommitType        CommitType                  `protobuf:"varint,2,opt,name=commit_type,enum=pfs.CommitType" json:"commit_type,omitempty"`
+	ParentCommit      *Commit                     `protobuf:"bytes,3,opt,name=parent_commit" json:"parent_commit,omitempty"`
+	Created           *google_protobuf1.Timestamp `protobuf:"bytes,4,opt,name=created" json:"created,omitempty"`
+	Finished          *google_protobuf1.Timestamp `protobuf:"bytes,5,opt,name=finished" json:"finished,omitempty"`
+	Message           string                      `protobuf:"bytes,6,opt,name=message" json:"message,omitempty"`
+	SizeBytes         uint64                      `protobuf:"varint,7,opt,name=size_bytes" json:"size_bytes,omitempty"`
+	Branch            string                      `protobuf:"bytes,8,opt,name=branch" json:"branch,omitempty"`
+	MergeParentCommit *Commit                     `protobuf:"bytes,9,opt,name=merge_parent_commit" json:"merge_parent_commit,omitempty"`
 }
 
 func (m *CommitInfo) Reset()         { *m = CommitInfo{} }
@@ -209,6 +400,142 @@ func (m *CommitInfo) GetParentCommit() *Commit {
 	return nil
 }
 
+func (m *CommitInfo) GetCreated() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Created
+	}
+	return nil
+}
+
+func (m *CommitInfo) GetFinished() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Finished
+	}
+	return nil
+}
+
+func (m *CommitInfo) GetMergeParentCommit() *Commit {
+	if m != nil {
+		return m.MergeParentCommit
+	}
+	return nil
+}
+
+// BranchInfo represents a named branch that points at a read commit.
+type BranchInfo struct {
+	Name   string  `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Commit *Commit `protobuf:"bytes,2,opt,name=commit" json:"commit,omitempty"`
+}
+
+func (m *BranchInfo) Reset()         { *m = BranchInfo{} }
+func (m *BranchInfo) String() string { return proto.CompactTextString(m) }
+func (*BranchInfo) ProtoMessage()    {}
+
+func (m *BranchInfo) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+// RetentionPolicy decides which commits of a repository garbage collection
+// keeps. A read commit is kept if any rule keeps it, no read commit is
+// collected if neither keep_last nor keep_newer_than is set.
+// The initial commit and the commits branches point at are always kept.
+type RetentionPolicy struct {
+	KeepLast           uint64                     `protobuf:"varint,1,opt,name=keep_last" json:"keep_last,omitempty"`
+	KeepNewerThan      *google_protobuf3.Duration `protobuf:"bytes,2,opt,name=keep_newer_than" json:"keep_newer_than,omitempty"`
+	WriteCommitTimeout *google_protobuf3.Duration `protobuf:"bytes,3,opt,name=write_commit_timeout" json:"write_commit_timeout,omitempty"`
+}
+
+func (m *RetentionPolicy) Reset()         { *m = RetentionPolicy{} }
+func (m *RetentionPolicy) String() string { return proto.CompactTextString(m) }
+func (*RetentionPolicy) ProtoMessage()    {}
+
+func (m *RetentionPolicy) GetKeepNewerThan() *google_protobuf3.Duration {
+	if m != nil {
+		return m.KeepNewerThan
+	}
+	return nil
+}
+
+func (m *RetentionPolicy) GetWriteCommitTimeout() *google_protobuf3.Duration {
+	if m != nil {
+		return m.WriteCommitTimeout
+	}
+	return nil
+}
+
+// RepositoryInfo represents information about a repository.
+type RepositoryInfo struct {
+	Repository  *Repository                 `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+	Created     *google_protobuf1.Timestamp `protobuf:"bytes,2,opt,name=created" json:"created,omitempty"`
+	CommitCount uint64                      `protobuf:"varint,3,opt,name=commit_count" json:"commit_count,omitempty"`
+	SizeBytes   uint64                      `protobuf:"varint,4,opt,name=size_bytes" json:"size_bytes,omitempty"`
+}
+
+func (m *RepositoryInfo) Reset()         { *m = RepositoryInfo{} }
+func (m *RepositoryInfo) String() string { return proto.CompactTextString(m) }
+func (*RepositoryInfo) ProtoMessage()    {}
+
+func (m *RepositoryInfo) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+func (m *RepositoryInfo) GetCreated() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Created
+	}
+	return nil
+}
+
+// Change represents a file or directory that differs between two commits.
+type Change struct {
+	Path       *Path      `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	ChangeType ChangeType `protobuf:"varint,2,opt,name=change_type,enum=pfs.ChangeType" json:"change_type,omitempty"`
+	FileType   FileType   `protobuf:"varint,3,opt,name=file_type,enum=pfs.FileType" json:"file_type,omitempty"`
+}
+
+func (m *Change) Reset()         { *m = Change{} }
+func (m *Change) String() string { return proto.CompactTextString(m) }
+func (*Change) ProtoMessage()    {}
+
+func (m *Change) GetPath() *Path {
+	if m != nil {
+		return m.Path
+	}
+	return nil
+}
+
+// FileRevision represents a commit that changed the content of a file.
+type FileRevision struct {
+	Commit     *Commit                     `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	ChangeType ChangeType                  `protobuf:"varint,2,opt,name=change_type,enum=pfs.ChangeType" json:"change_type,omitempty"`
+	SizeBytes  uint64                      `protobuf:"varint,3,opt,name=size_bytes" json:"size_bytes,omitempty"`
+	Finished   *google_protobuf1.Timestamp `protobuf:"bytes,4,opt,name=finished" json:"finished,omitempty"`
+}
+
+func (m *FileRevision) Reset()         { *m = FileRevision{} }
+func (m *FileRevision) String() string { return proto.CompactTextString(m) }
+func (*FileRevision) ProtoMessage()    {}
+
+func (m *FileRevision) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+func (m *FileRevision) GetFinished() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Finished
+	}
+	return nil
+}
+
 type InitRepositoryRequest struct {
 	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
 	Redirect   bool        `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
@@ -225,10 +552,81 @@ func (m *InitRepositoryRequest) GetRepository() *Repository {
 	return nil
 }
 
+type ListRepositoriesRequest struct {
+}
+
+func (m *ListRepositoriesRequest) Reset()         { *m = ListRepositoriesRequest{} }
+func (m *ListRepositoriesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListRepositoriesRequest) ProtoMessage()    {}
+
+type ListRepositoriesResponse struct {
+	Repository []*Repository `protobuf:"bytes,1,rep,name=repository" json:"repository,omitempty"`
+}
+
+func (m *ListRepositoriesResponse) Reset()         { *m = ListRepositoriesResponse{} }
+func (m *ListRepositoriesResponse) String() string { return proto.CompactTextString(m) }
+func (*ListRepositoriesResponse) ProtoMessage()    {}
+
+func (m *ListRepositoriesResponse) GetRepository() []*Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+type InspectRepositoryRequest struct {
+	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+	Redirect   bool        `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+}
+
+func (m *InspectRepositoryRequest) Reset()         { *m = InspectRepositoryRequest{} }
+func (m *InspectRepositoryRequest) String() string { return proto.CompactTextString(m) }
+func (*InspectRepositoryRequest) ProtoMessage()    {}
+
+func (m *InspectRepositoryRequest) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+type InspectRepositoryResponse struct {
+	RepositoryInfo *RepositoryInfo `protobuf:"bytes,1,opt,name=repository_info" json:"repository_info,omitempty"`
+}
+
+func (m *InspectRepositoryResponse) Reset()         { *m = InspectRepositoryResponse{} }
+func (m *InspectRepositoryResponse) String() string { return proto.CompactTextString(m) }
+func (*InspectRepositoryResponse) ProtoMessage()    {}
+
+func (m *InspectRepositoryResponse) GetRepositoryInfo() *RepositoryInfo {
+	if m != nil {
+		return m.RepositoryInfo
+	}
+	return nil
+}
+
+type DeleteRepositoryRequest struct {
+	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+	Redirect   bool        `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+}
+
+func (m *DeleteRepositoryRequest) Reset()         { *m = DeleteRepositoryRequest{} }
+func (m *DeleteRepositoryRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteRepositoryRequest) ProtoMessage()    {}
+
+func (m *DeleteRepositoryRequest) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
 type GetFileRequest struct {
-	Path        *Path `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
-	OffsetBytes int64 `protobuf:"varint,2,opt,name=offset_bytes" json:"offset_bytes,omitempty"`
-	SizeBytes   int64 `protobuf:"varint,3,opt,name=size_bytes" json:"size_bytes,omitempty"`
+	Path            *Path           `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	OffsetBytes     int64           `protobuf:"varint,2,opt,name=offset_bytes" json:"offset_bytes,omitempty"`
+	SizeBytes       int64           `protobuf:"varint,3,opt,name=size_bytes" json:"size_bytes,omitempty"`
+	Redirect        bool            `protobuf:"varint,4,opt,name=redirect" json:"redirect,omitempty"`
+	ReadConsistency ReadConsistency `protobuf:"varint,5,opt,name=read_consistency,enum=pfs.ReadConsistency" json:"read_consistency,omitempty"`
 }
 
 func (m *GetFileRequest) Reset()         { *m = GetFileRequest{} }
@@ -243,7 +641,9 @@ func (m *GetFileRequest) GetPath() *Path {
 }
 
 type GetFileInfoRequest struct {
-	Path *Path `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Path            *Path           `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Redirect        bool            `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+	ReadConsistency ReadConsistency `protobuf:"varint,3,opt,name=read_consistency,enum=pfs.ReadConsistency" json:"read_consistency,omitempty"`
 }
 
 func (m *GetFileInfoRequest) Reset()         { *m = GetFileInfoRequest{} }
@@ -305,10 +705,36 @@ func (m *PutFileRequest) GetPath() *Path {
 	return nil
 }
 
+type PutFileStreamResponse struct {
+	SizeBytes uint64 `protobuf:"varint,1,opt,name=size_bytes" json:"size_bytes,omitempty"`
+}
+
+func (m *PutFileStreamResponse) Reset()         { *m = PutFileStreamResponse{} }
+func (m *PutFileStreamResponse) String() string { return proto.CompactTextString(m) }
+func (*PutFileStreamResponse) ProtoMessage()    {}
+
+type DeleteFileRequest struct {
+	Path     *Path `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Redirect bool  `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+}
+
+func (m *DeleteFileRequest) Reset()         { *m = DeleteFileRequest{} }
+func (m *DeleteFileRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteFileRequest) ProtoMessage()    {}
+
+func (m *DeleteFileRequest) GetPath() *Path {
+	if m != nil {
+		return m.Path
+	}
+	return nil
+}
+
 type ListFilesRequest struct {
-	Path     *Path  `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
-	Shard    *Shard `protobuf:"bytes,2,opt,name=shard" json:"shard,omitempty"`
-	Redirect bool   `protobuf:"varint,3,opt,name=redirect" json:"redirect,omitempty"`
+	Path      *Path  `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Shard     *Shard `protobuf:"bytes,2,opt,name=shard" json:"shard,omitempty"`
+	Redirect  bool   `protobuf:"varint,3,opt,name=redirect" json:"redirect,omitempty"`
+	Recursive bool   `protobuf:"varint,4,opt,name=recursive" json:"recursive,omitempty"`
+	Pattern   string `protobuf:"bytes,5,opt,name=pattern" json:"pattern,omitempty"`
 }
 
 func (m *ListFilesRequest) Reset()         { *m = ListFilesRequest{} }
@@ -344,469 +770,1716 @@ func (m *ListFilesResponse) GetFileInfo() []*FileInfo {
 	return nil
 }
 
-type BranchRequest struct {
-	Commit    *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
-	NewCommit *Commit `protobuf:"bytes,2,opt,name=new_commit" json:"new_commit,omitempty"`
-	Redirect  bool    `protobuf:"varint,3,opt,name=redirect" json:"redirect,omitempty"`
+type ListChangedFilesRequest struct {
+	FromCommit *Commit `protobuf:"bytes,1,opt,name=from_commit" json:"from_commit,omitempty"`
+	ToCommit   *Commit `protobuf:"bytes,2,opt,name=to_commit" json:"to_commit,omitempty"`
+	Shard      *Shard  `protobuf:"bytes,3,opt,name=shard" json:"shard,omitempty"`
+	Redirect   bool    `protobuf:"varint,4,opt,name=redirect" json:"redirect,omitempty"`
 }
 
-func (m *BranchRequest) Reset()         { *m = BranchRequest{} }
-func (m *BranchRequest) String() string { return proto.CompactTextString(m) }
-func (*BranchRequest) ProtoMessage()    {}
+func (m *ListChangedFilesRequest) Reset()         { *m = ListChangedFilesRequest{} }
+func (m *ListChangedFilesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListChangedFilesRequest) ProtoMessage()    {}
 
-func (m *BranchRequest) GetCommit() *Commit {
+func (m *ListChangedFilesRequest) GetFromCommit() *Commit {
 	if m != nil {
-		return m.Commit
+		return m.FromCommit
 	}
 	return nil
 }
 
-func (m *BranchRequest) GetNewCommit() *Commit {
+func (m *ListChangedFilesRequest) GetToCommit() *Commit {
 	if m != nil {
-		return m.NewCommit
+		return m.ToCommit
 	}
 	return nil
 }
 
-type BranchResponse struct {
-	Commit *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
-}
-
-func (m *BranchResponse) Reset()         { *m = BranchResponse{} }
-func (m *BranchResponse) String() string { return proto.CompactTextString(m) }
-func (*BranchResponse) ProtoMessage()    {}
-
-func (m *BranchResponse) GetCommit() *Commit {
+func (m *ListChangedFilesRequest) GetShard() *Shard {
 	if m != nil {
-		return m.Commit
+		return m.Shard
 	}
 	return nil
 }
 
-type CommitRequest struct {
-	Commit   *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
-	Redirect bool    `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+type ListChangedFilesResponse struct {
+	Change []*Change `protobuf:"bytes,1,rep,name=change" json:"change,omitempty"`
 }
 
-func (m *CommitRequest) Reset()         { *m = CommitRequest{} }
-func (m *CommitRequest) String() string { return proto.CompactTextString(m) }
-func (*CommitRequest) ProtoMessage()    {}
+func (m *ListChangedFilesResponse) Reset()         { *m = ListChangedFilesResponse{} }
+func (m *ListChangedFilesResponse) String() string { return proto.CompactTextString(m) }
+func (*ListChangedFilesResponse) ProtoMessage()    {}
 
-func (m *CommitRequest) GetCommit() *Commit {
+func (m *ListChangedFilesResponse) GetChange() []*Change {
 	if m != nil {
-		return m.Commit
+		return m.Change
 	}
 	return nil
 }
 
-type GetCommitInfoRequest struct {
-	Commit *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+type ListFileHistoryRequest struct {
+	Path *Path `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
 }
 
-func (m *GetCommitInfoRequest) Reset()         { *m = GetCommitInfoRequest{} }
-func (m *GetCommitInfoRequest) String() string { return proto.CompactTextString(m) }
-func (*GetCommitInfoRequest) ProtoMessage()    {}
+func (m *ListFileHistoryRequest) Reset()         { *m = ListFileHistoryRequest{} }
+func (m *ListFileHistoryRequest) String() string { return proto.CompactTextString(m) }
+func (*ListFileHistoryRequest) ProtoMessage()    {}
 
-func (m *GetCommitInfoRequest) GetCommit() *Commit {
+func (m *ListFileHistoryRequest) GetPath() *Path {
 	if m != nil {
-		return m.Commit
+		return m.Path
 	}
 	return nil
 }
 
-type GetCommitInfoResponse struct {
-	CommitInfo *CommitInfo `protobuf:"bytes,1,opt,name=commit_info" json:"commit_info,omitempty"`
+type ListFileHistoryResponse struct {
+	FileRevision []*FileRevision `protobuf:"bytes,1,rep,name=file_revision" json:"file_revision,omitempty"`
 }
 
-func (m *GetCommitInfoResponse) Reset()         { *m = GetCommitInfoResponse{} }
-func (m *GetCommitInfoResponse) String() string { return proto.CompactTextString(m) }
-func (*GetCommitInfoResponse) ProtoMessage()    {}
+func (m *ListFileHistoryResponse) Reset()         { *m = ListFileHistoryResponse{} }
+func (m *ListFileHistoryResponse) String() string { return proto.CompactTextString(m) }
+func (*ListFileHistoryResponse) ProtoMessage()    {}
 
-func (m *GetCommitInfoResponse) GetCommitInfo() *CommitInfo {
+func (m *ListFileHistoryResponse) GetFileRevision() []*FileRevision {
 	if m != nil {
-		return m.CommitInfo
+		return m.FileRevision
 	}
 	return nil
 }
 
-type ListCommitsRequest struct {
-	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+type ExportCommitRequest struct {
+	Commit *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	Path   string  `protobuf:"bytes,2,opt,name=path" json:"path,omitempty"`
 }
 
-func (m *ListCommitsRequest) Reset()         { *m = ListCommitsRequest{} }
-func (m *ListCommitsRequest) String() string { return proto.CompactTextString(m) }
-func (*ListCommitsRequest) ProtoMessage()    {}
+func (m *ExportCommitRequest) Reset()         { *m = ExportCommitRequest{} }
+func (m *ExportCommitRequest) String() string { return proto.CompactTextString(m) }
+func (*ExportCommitRequest) ProtoMessage()    {}
 
-func (m *ListCommitsRequest) GetRepository() *Repository {
+func (m *ExportCommitRequest) GetCommit() *Commit {
 	if m != nil {
-		return m.Repository
+		return m.Commit
 	}
 	return nil
 }
 
-type ListCommitsResponse struct {
-	CommitInfo []*CommitInfo `protobuf:"bytes,1,rep,name=commit_info" json:"commit_info,omitempty"`
+type ImportTarRequest struct {
+	Commit *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	Value  []byte  `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
 }
 
-func (m *ListCommitsResponse) Reset()         { *m = ListCommitsResponse{} }
-func (m *ListCommitsResponse) String() string { return proto.CompactTextString(m) }
-func (*ListCommitsResponse) ProtoMessage()    {}
+func (m *ImportTarRequest) Reset()         { *m = ImportTarRequest{} }
+func (m *ImportTarRequest) String() string { return proto.CompactTextString(m) }
+func (*ImportTarRequest) ProtoMessage()    {}
 
-func (m *ListCommitsResponse) GetCommitInfo() []*CommitInfo {
+func (m *ImportTarRequest) GetCommit() *Commit {
 	if m != nil {
-		return m.CommitInfo
+		return m.Commit
 	}
 	return nil
 }
 
-type PullDiffRequest struct {
-	Commit *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
-	Shard  uint64  `protobuf:"varint,2,opt,name=shard" json:"shard,omitempty"`
+type ImportTarResponse struct {
+	NumFiles  uint64 `protobuf:"varint,1,opt,name=num_files" json:"num_files,omitempty"`
+	SizeBytes uint64 `protobuf:"varint,2,opt,name=size_bytes" json:"size_bytes,omitempty"`
 }
 
-func (m *PullDiffRequest) Reset()         { *m = PullDiffRequest{} }
-func (m *PullDiffRequest) String() string { return proto.CompactTextString(m) }
-func (*PullDiffRequest) ProtoMessage()    {}
+func (m *ImportTarResponse) Reset()         { *m = ImportTarResponse{} }
+func (m *ImportTarResponse) String() string { return proto.CompactTextString(m) }
+func (*ImportTarResponse) ProtoMessage()    {}
 
-func (m *PullDiffRequest) GetCommit() *Commit {
-	if m != nil {
-		return m.Commit
-	}
-	return nil
+type BranchRequest struct {
+	Commit    *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	NewCommit *Commit `protobuf:"bytes,2,opt,name=new_commit" json:"new_commit,omitempty"`
+	Redirect  bool    `protobuf:"varint,3,opt,name=redirect" json:"redirect,omitempty"`
+	Message   string  `protobuf:"bytes,4,opt,name=message" json:"message,omitempty"`
+	Branch    string  `protobuf:"bytes,5,opt,name=branch" json:"branch,omitempty"`
 }
 
-type PushDiffRequest struct {
+func (m *BranchRequest) Reset()         { *m = BranchRequest{} }
+func (m *BranchRequest) String() string { return proto.CompactTextString(m) }
+func (*BranchRequest) ProtoMessage()    {}
+
+func (m *BranchRequest) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+func (m *BranchRequest) GetNewCommit() *Commit {
+	if m != nil {
+		return m.NewCommit
+	}
+	return nil
+}
+
+type BranchResponse struct {
+	Commit *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+}
+
+func (m *BranchResponse) Reset()         { *m = BranchResponse{} }
+func (m *BranchResponse) String() string { return proto.CompactTextString(m) }
+func (*BranchResponse) ProtoMessage()    {}
+
+func (m *BranchResponse) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+type MergeRequest struct {
+	Ours           *Commit        `protobuf:"bytes,1,opt,name=ours" json:"ours,omitempty"`
+	Theirs         *Commit        `protobuf:"bytes,2,opt,name=theirs" json:"theirs,omitempty"`
+	NewCommit      *Commit        `protobuf:"bytes,3,opt,name=new_commit" json:"new_commit,omitempty"`
+	ConflictPolicy ConflictPolicy `protobuf:"varint,4,opt,name=conflict_policy,enum=pfs.ConflictPolicy" json:"conflict_policy,omitempty"`
+	Message        string         `protobuf:"bytes,5,opt,name=message" json:"message,omitempty"`
+	Redirect       bool           `protobuf:"varint,6,opt,name=redirect" json:"redirect,omitempty"`
+	Branch         string         `protobuf:"bytes,7,opt,name=branch" json:"branch,omitempty"`
+	Paths          []string       `protobuf:"bytes,8,rep,name=paths" json:"paths,omitempty"`
+}
+
+func (m *MergeRequest) Reset()         { *m = MergeRequest{} }
+func (m *MergeRequest) String() string { return proto.CompactTextString(m) }
+func (*MergeRequest) ProtoMessage()    {}
+
+func (m *MergeRequest) GetOurs() *Commit {
+	if m != nil {
+		return m.Ours
+	}
+	return nil
+}
+
+func (m *MergeRequest) GetTheirs() *Commit {
+	if m != nil {
+		return m.Theirs
+	}
+	return nil
+}
+
+func (m *MergeRequest) GetNewCommit() *Commit {
+	if m != nil {
+		return m.NewCommit
+	}
+	return nil
+}
+
+type MergeResponse struct {
+	Commit *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+}
+
+func (m *MergeResponse) Reset()         { *m = MergeResponse{} }
+func (m *MergeResponse) String() string { return proto.CompactTextString(m) }
+func (*MergeResponse) ProtoMessage()    {}
+
+func (m *MergeResponse) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+type CommitRequest struct {
+	Commit   *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	Redirect bool    `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+	Message  string  `protobuf:"bytes,3,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *CommitRequest) Reset()         { *m = CommitRequest{} }
+func (m *CommitRequest) String() string { return proto.CompactTextString(m) }
+func (*CommitRequest) ProtoMessage()    {}
+
+func (m *CommitRequest) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+type SquashCommitsRequest struct {
+	FromCommit *Commit   `protobuf:"bytes,1,opt,name=from_commit" json:"from_commit,omitempty"`
+	ToCommit   *Commit   `protobuf:"bytes,2,opt,name=to_commit" json:"to_commit,omitempty"`
+	Message    string    `protobuf:"bytes,3,opt,name=message" json:"message,omitempty"`
+	Redirect   bool      `protobuf:"varint,4,opt,name=redirect" json:"redirect,omitempty"`
+	Commits    []*Commit `protobuf:"bytes,5,rep,name=commits" json:"commits,omitempty"`
+}
+
+func (m *SquashCommitsRequest) Reset()         { *m = SquashCommitsRequest{} }
+func (m *SquashCommitsRequest) String() string { return proto.CompactTextString(m) }
+func (*SquashCommitsRequest) ProtoMessage()    {}
+
+func (m *SquashCommitsRequest) GetFromCommit() *Commit {
+	if m != nil {
+		return m.FromCommit
+	}
+	return nil
+}
+
+func (m *SquashCommitsRequest) GetToCommit() *Commit {
+	if m != nil {
+		return m.ToCommit
+	}
+	return nil
+}
+
+func (m *SquashCommitsRequest) GetCommits() []*Commit {
+	if m != nil {
+		return m.Commits
+	}
+	return nil
+}
+
+type SquashCommitsResponse struct {
 	Commit *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
-	Shard  uint64  `protobuf:"varint,2,opt,name=shard" json:"shard,omitempty"`
-	Value  []byte  `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
 }
 
-func (m *PushDiffRequest) Reset()         { *m = PushDiffRequest{} }
-func (m *PushDiffRequest) String() string { return proto.CompactTextString(m) }
-func (*PushDiffRequest) ProtoMessage()    {}
+func (m *SquashCommitsResponse) Reset()         { *m = SquashCommitsResponse{} }
+func (m *SquashCommitsResponse) String() string { return proto.CompactTextString(m) }
+func (*SquashCommitsResponse) ProtoMessage()    {}
+
+func (m *SquashCommitsResponse) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+type DeleteCommitRequest struct {
+	Commit   *Commit   `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	Redirect bool      `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+	Commits  []*Commit `protobuf:"bytes,3,rep,name=commits" json:"commits,omitempty"`
+}
+
+func (m *DeleteCommitRequest) Reset()         { *m = DeleteCommitRequest{} }
+func (m *DeleteCommitRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteCommitRequest) ProtoMessage()    {}
+
+func (m *DeleteCommitRequest) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+func (m *DeleteCommitRequest) GetCommits() []*Commit {
+	if m != nil {
+		return m.Commits
+	}
+	return nil
+}
+
+type GetCommitInfoRequest struct {
+	Commit   *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	Redirect bool    `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+}
+
+func (m *GetCommitInfoRequest) Reset()         { *m = GetCommitInfoRequest{} }
+func (m *GetCommitInfoRequest) String() string { return proto.CompactTextString(m) }
+func (*GetCommitInfoRequest) ProtoMessage()    {}
+
+func (m *GetCommitInfoRequest) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+type GetCommitInfoResponse struct {
+	CommitInfo *CommitInfo `protobuf:"bytes,1,opt,name=commit_info" json:"commit_info,omitempty"`
+}
+
+func (m *GetCommitInfoResponse) Reset()         { *m = GetCommitInfoResponse{} }
+func (m *GetCommitInfoResponse) String() string { return proto.CompactTextString(m) }
+func (*GetCommitInfoResponse) ProtoMessage()    {}
+
+func (m *GetCommitInfoResponse) GetCommitInfo() *CommitInfo {
+	if m != nil {
+		return m.CommitInfo
+	}
+	return nil
+}
+
+type ListCommitsRequest struct {
+	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+	Redirect   bool        `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+}
+
+func (m *ListCommitsRequest) Reset()         { *m = ListCommitsRequest{} }
+func (m *ListCommitsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListCommitsRequest) ProtoMessage()    {}
+
+func (m *ListCommitsRequest) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+type ListCommitsResponse struct {
+	CommitInfo []*CommitInfo `protobuf:"bytes,1,rep,name=commit_info" json:"commit_info,omitempty"`
+}
+
+func (m *ListCommitsResponse) Reset()         { *m = ListCommitsResponse{} }
+func (m *ListCommitsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListCommitsResponse) ProtoMessage()    {}
+
+func (m *ListCommitsResponse) GetCommitInfo() []*CommitInfo {
+	if m != nil {
+		return m.CommitInfo
+	}
+	return nil
+}
+
+type CreateBranchRequest struct {
+	Commit      *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	Name        string  `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Redirect    bool    `protobuf:"varint,3,opt,name=redirect" json:"redirect,omitempty"`
+	CheckAndSet bool    `protobuf:"varint,4,opt,name=check_and_set" json:"check_and_set,omitempty"`
+	PrevCommit  *Commit `protobuf:"bytes,5,opt,name=prev_commit" json:"prev_commit,omitempty"`
+}
+
+func (m *CreateBranchRequest) Reset()         { *m = CreateBranchRequest{} }
+func (m *CreateBranchRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateBranchRequest) ProtoMessage()    {}
+
+func (m *CreateBranchRequest) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+func (m *CreateBranchRequest) GetPrevCommit() *Commit {
+	if m != nil {
+		return m.PrevCommit
+	}
+	return nil
+}
+
+type ListBranchesRequest struct {
+	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+}
+
+func (m *ListBranchesRequest) Reset()         { *m = ListBranchesRequest{} }
+func (m *ListBranchesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListBranchesRequest) ProtoMessage()    {}
+
+func (m *ListBranchesRequest) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+type ListBranchesResponse struct {
+	BranchInfo []*BranchInfo `protobuf:"bytes,1,rep,name=branch_info" json:"branch_info,omitempty"`
+}
+
+func (m *ListBranchesResponse) Reset()         { *m = ListBranchesResponse{} }
+func (m *ListBranchesResponse) String() string { return proto.CompactTextString(m) }
+func (*ListBranchesResponse) ProtoMessage()    {}
+
+func (m *ListBranchesResponse) GetBranchInfo() []*BranchInfo {
+	if m != nil {
+		return m.BranchInfo
+	}
+	return nil
+}
+
+type DeleteBranchRequest struct {
+	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+	Name       string      `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Redirect   bool        `protobuf:"varint,3,opt,name=redirect" json:"redirect,omitempty"`
+}
+
+func (m *DeleteBranchRequest) Reset()         { *m = DeleteBranchRequest{} }
+func (m *DeleteBranchRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteBranchRequest) ProtoMessage()    {}
+
+func (m *DeleteBranchRequest) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+type SetRetentionPolicyRequest struct {
+	Repository      *Repository      `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+	RetentionPolicy *RetentionPolicy `protobuf:"bytes,2,opt,name=retention_policy" json:"retention_policy,omitempty"`
+	Redirect        bool             `protobuf:"varint,3,opt,name=redirect" json:"redirect,omitempty"`
+}
+
+func (m *SetRetentionPolicyRequest) Reset()         { *m = SetRetentionPolicyRequest{} }
+func (m *SetRetentionPolicyRequest) String() string { return proto.CompactTextString(m) }
+func (*SetRetentionPolicyRequest) ProtoMessage()    {}
+
+func (m *SetRetentionPolicyRequest) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+func (m *SetRetentionPolicyRequest) GetRetentionPolicy() *RetentionPolicy {
+	if m != nil {
+		return m.RetentionPolicy
+	}
+	return nil
+}
+
+type GetRetentionPolicyRequest struct {
+	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+}
+
+func (m *GetRetentionPolicyRequest) Reset()         { *m = GetRetentionPolicyRequest{} }
+func (m *GetRetentionPolicyRequest) String() string { return proto.CompactTextString(m) }
+func (*GetRetentionPolicyRequest) ProtoMessage()    {}
+
+func (m *GetRetentionPolicyRequest) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+type GetRetentionPolicyResponse struct {
+	RetentionPolicy *RetentionPolicy `protobuf:"bytes,1,opt,name=retention_policy" json:"retention_policy,omitempty"`
+}
+
+func (m *GetRetentionPolicyResponse) Reset()         { *m = GetRetentionPolicyResponse{} }
+func (m *GetRetentionPolicyResponse) String() string { return proto.CompactTextString(m) }
+func (*GetRetentionPolicyResponse) ProtoMessage()    {}
+
+func (m *GetRetentionPolicyResponse) GetRetentionPolicy() *RetentionPolicy {
+	if m != nil {
+		return m.RetentionPolicy
+	}
+	return nil
+}
+
+type GarbageCollectRequest struct {
+	Repository *Repository `protobuf:"bytes,1,opt,name=repository" json:"repository,omitempty"`
+	DryRun     bool        `protobuf:"varint,2,opt,name=dry_run" json:"dry_run,omitempty"`
+}
+
+func (m *GarbageCollectRequest) Reset()         { *m = GarbageCollectRequest{} }
+func (m *GarbageCollectRequest) String() string { return proto.CompactTextString(m) }
+func (*GarbageCollectRequest) ProtoMessage()    {}
+
+func (m *GarbageCollectRequest) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+type GarbageCollectResponse struct {
+	Deleted  []*Commit `protobuf:"bytes,1,rep,name=deleted" json:"deleted,omitempty"`
+	Squashed []*Commit `protobuf:"bytes,2,rep,name=squashed" json:"squashed,omitempty"`
+}
+
+func (m *GarbageCollectResponse) Reset()         { *m = GarbageCollectResponse{} }
+func (m *GarbageCollectResponse) String() string { return proto.CompactTextString(m) }
+func (*GarbageCollectResponse) ProtoMessage()    {}
+
+func (m *GarbageCollectResponse) GetDeleted() []*Commit {
+	if m != nil {
+		return m.Deleted
+	}
+	return nil
+}
+
+func (m *GarbageCollectResponse) GetSquashed() []*Commit {
+	if m != nil {
+		return m.Squashed
+	}
+	return nil
+}
+
+type ReshardRequest struct {
+	NumShards uint64 `protobuf:"varint,1,opt,name=num_shards" json:"num_shards,omitempty"`
+}
+
+func (m *ReshardRequest) Reset()         { *m = ReshardRequest{} }
+func (m *ReshardRequest) String() string { return proto.CompactTextString(m) }
+func (*ReshardRequest) ProtoMessage()    {}
+
+type ReshardResponse struct {
+	NumFiles uint64 `protobuf:"varint,1,opt,name=num_files" json:"num_files,omitempty"`
+}
+
+func (m *ReshardResponse) Reset()         { *m = ReshardResponse{} }
+func (m *ReshardResponse) String() string { return proto.CompactTextString(m) }
+func (*ReshardResponse) ProtoMessage()    {}
+
+type PullDiffRequest struct {
+	Commit    *Commit `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	Shard     uint64  `protobuf:"varint,2,opt,name=shard" json:"shard,omitempty"`
+	NumShards uint64  `protobuf:"varint,3,opt,name=num_shards" json:"num_shards,omitempty"`
+	ToShard   uint64  `protobuf:"varint,4,opt,name=to_shard" json:"to_shard,omitempty"`
+}
+
+func (m *PullDiffRequest) Reset()         { *m = PullDiffRequest{} }
+func (m *PullDiffRequest) String() string { return proto.CompactTextString(m) }
+func (*PullDiffRequest) ProtoMessage()    {}
+
+func (m *PullDiffRequest) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+type PushDiffRequest struct {
+	Commit           *Commit     `protobuf:"bytes,1,opt,name=commit" json:"commit,omitempty"`
+	Shard            uint64      `protobuf:"varint,2,opt,name=shard" json:"shard,omitempty"`
+	Value            []byte      `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Replaces         []*Commit   `protobuf:"bytes,4,rep,name=replaces" json:"replaces,omitempty"`
+	Version          uint64      `protobuf:"varint,5,opt,name=version" json:"version,omitempty"`
+	Parent           *Commit     `protobuf:"bytes,6,opt,name=parent" json:"parent,omitempty"`
+	DeleteRepository *Repository `protobuf:"bytes,7,opt,name=delete_repository" json:"delete_repository,omitempty"`
+}
+
+func (m *PushDiffRequest) Reset()         { *m = PushDiffRequest{} }
+func (m *PushDiffRequest) String() string { return proto.CompactTextString(m) }
+func (*PushDiffRequest) ProtoMessage()    {}
+
+func (m *PushDiffRequest) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+func (m *PushDiffRequest) GetReplaces() []*Commit {
+	if m != nil {
+		return m.Replaces
+	}
+	return nil
+}
+
+func (m *PushDiffRequest) GetParent() *Commit {
+	if m != nil {
+		return m.Parent
+	}
+	return nil
+}
+
+func (m *PushDiffRequest) GetDeleteRepository() *Repository {
+	if m != nil {
+		return m.DeleteRepository
+	}
+	return nil
+}
+
+// Operation represents a change that every shard applies or none does.
+// The server that coordinates an operation journals it until it has been
+// applied or rolled back.
+type Operation struct {
+	Id            string                      `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	OperationType OperationType               `protobuf:"varint,2,opt,name=operation_type,enum=pfs.OperationType" json:"operation_type,omitempty"`
+	Started       *google_protobuf1.Timestamp `protobuf:"bytes,3,opt,name=started" json:"started,omitempty"`
+	Repository    *Repository                 `protobuf:"bytes,4,opt,name=repository" json:"repository,omitempty"`
+	Commit        *Commit                     `protobuf:"bytes,5,opt,name=commit" json:"commit,omitempty"`
+	Path          *Path                       `protobuf:"bytes,6,opt,name=path" json:"path,omitempty"`
+	Branch        string                      `protobuf:"bytes,7,opt,name=branch" json:"branch,omitempty"`
+	BranchCommit  *Commit                     `protobuf:"bytes,8,opt,name=branch_commit" json:"branch_commit,omitempty"`
+	Commits       []*Commit                   `protobuf:"bytes,9,rep,name=commits" json:"commits,omitempty"`
+}
+
+func (m *Operation) Reset()         { *m = Operation{} }
+func (m *Operation) String() string { return proto.CompactTextString(m) }
+func (*Operation) ProtoMessage()    {}
+
+func (m *Operation) GetStarted() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Started
+	}
+	return nil
+}
+
+func (m *Operation) GetRepository() *Repository {
+	if m != nil {
+		return m.Repository
+	}
+	return nil
+}
+
+func (m *Operation) GetCommit() *Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+func (m *Operation) GetPath() *Path {
+	if m != nil {
+		return m.Path
+	}
+	return nil
+}
+
+func (m *Operation) GetBranchCommit() *Commit {
+	if m != nil {
+		return m.BranchCommit
+	}
+	return nil
+}
+
+func (m *Operation) GetCommits() []*Commit {
+	if m != nil {
+		return m.Commits
+	}
+	return nil
+}
+
+type RollbackRequest struct {
+	Operation *Operation `protobuf:"bytes,1,opt,name=operation" json:"operation,omitempty"`
+	Redirect  bool       `protobuf:"varint,2,opt,name=redirect" json:"redirect,omitempty"`
+}
+
+func (m *RollbackRequest) Reset()         { *m = RollbackRequest{} }
+func (m *RollbackRequest) String() string { return proto.CompactTextString(m) }
+func (*RollbackRequest) ProtoMessage()    {}
+
+func (m *RollbackRequest) GetOperation() *Operation {
+	if m != nil {
+		return m.Operation
+	}
+	return nil
+}
+
+type ReshardPhaseRequest struct {
+	ReshardPhase ReshardPhase `protobuf:"varint,1,opt,name=reshard_phase,enum=pfs.ReshardPhase" json:"reshard_phase,omitempty"`
+	NumShards    uint64       `protobuf:"varint,2,opt,name=num_shards" json:"num_shards,omitempty"`
+	ReshardId    string       `protobuf:"bytes,3,opt,name=reshard_id" json:"reshard_id,omitempty"`
+}
+
+func (m *ReshardPhaseRequest) Reset()         { *m = ReshardPhaseRequest{} }
+func (m *ReshardPhaseRequest) String() string { return proto.CompactTextString(m) }
+func (*ReshardPhaseRequest) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterEnum("pfs.CommitType", CommitType_name, CommitType_value)
+	proto.RegisterEnum("pfs.FileType", FileType_name, FileType_value)
+	proto.RegisterEnum("pfs.ChangeType", ChangeType_name, ChangeType_value)
+	proto.RegisterEnum("pfs.ConflictPolicy", ConflictPolicy_name, ConflictPolicy_value)
+	proto.RegisterEnum("pfs.OperationType", OperationType_name, OperationType_value)
+	proto.RegisterEnum("pfs.ReshardPhase", ReshardPhase_name, ReshardPhase_value)
+	proto.RegisterEnum("pfs.ReadConsistency", ReadConsistency_name, ReadConsistency_value)
+}
+
+// Client API for Api service
+
+type ApiClient interface {
+	// InitRepository creates a new repository.
+	// An error is returned if the specified repository already exists.
+	InitRepository(ctx context.Context, in *InitRepositoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// ListRepositories lists the repositories.
+	ListRepositories(ctx context.Context, in *ListRepositoriesRequest, opts ...grpc.CallOption) (*ListRepositoriesResponse, error)
+	// InspectRepository returns a RepositoryInfo for a repository.
+	InspectRepository(ctx context.Context, in *InspectRepositoryRequest, opts ...grpc.CallOption) (*InspectRepositoryResponse, error)
+	// DeleteRepository deletes a repository and all of its commits.
+	// An error is returned if the specified repository does not exist.
+	DeleteRepository(ctx context.Context, in *DeleteRepositoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// GetFile returns a byte stream of the specified file.
+	// An error is returned if the specified commit is a write commit.
+	GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (Api_GetFileClient, error)
+	// GetFileInfo returns a FileInfo for a file.
+	GetFileInfo(ctx context.Context, in *GetFileInfoRequest, opts ...grpc.CallOption) (*GetFileInfoResponse, error)
+	// MakeDirectory makes a directory on the file system.
+	MakeDirectory(ctx context.Context, in *MakeDirectoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// PutFile writes the specified file to PFS.
+	// An error is returned if the specified commit is not a write commit.
+	PutFile(ctx context.Context, in *PutFileRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// PutFileStream writes the specified file to PFS from a stream of requests.
+	// path and offset_bytes are taken from the first request, the values of
+	// every request are written one after another.
+	// An error is returned if the specified commit is not a write commit.
+	PutFileStream(ctx context.Context, opts ...grpc.CallOption) (Api_PutFileStreamClient, error)
+	// DeleteFile deletes a file or directory, directories are deleted recursively.
+	// An error is returned if the specified commit is not a write commit.
+	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// ListFiles lists the files within a directory sorted by path.
+	// An error is returned if the specified path is not a directory.
+	ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error)
+	// ListFilesStream is ListFiles for listings too big for a single response.
+	ListFilesStream(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (Api_ListFilesStreamClient, error)
+	// ListChangedFiles lists the files and directories that were added, modified
+	// or deleted between from_commit and to_commit.
+	// If from_commit is not set the parent of to_commit is used.
+	ListChangedFiles(ctx context.Context, in *ListChangedFilesRequest, opts ...grpc.CallOption) (*ListChangedFilesResponse, error)
+	// ListFileHistory lists the commits that changed the content of a file,
+	// starting at the path's commit and following its parents, newest first.
+	ListFileHistory(ctx context.Context, in *ListFileHistoryRequest, opts ...grpc.CallOption) (*ListFileHistoryResponse, error)
+	// ExportCommit returns a byte stream of a tar archive of a read commit.
+	// Entries are named by their path in the commit.
+	ExportCommit(ctx context.Context, in *ExportCommitRequest, opts ...grpc.CallOption) (Api_ExportCommitClient, error)
+	// ImportTar unpacks a tar archive sent as a stream of requests into a write
+	// commit, missing parent directories are created.
+	ImportTar(ctx context.Context, opts ...grpc.CallOption) (Api_ImportTarClient, error)
+	// Branch creates a new write commit from a base commit.
+	// An error is returned if the base commit is not a read commit.
+	// If the base commit is a branch the new commit is made on that branch.
+	Branch(ctx context.Context, in *BranchRequest, opts ...grpc.CallOption) (*BranchResponse, error)
+	// Merge creates a new write commit from ours with the changes theirs made
+	// since the closest commit they have in common.
+	// A path changed differently by both is handled according to conflict_policy.
+	// An error is returned if ours or theirs is not a read commit.
+	Merge(ctx context.Context, in *MergeRequest, opts ...grpc.CallOption) (*MergeResponse, error)
+	// Commit turns the specified write commit into a read commit and advances
+	// the branch it was made on to it.
+	// An error is returned if the specified commit is not a write commit.
+	Commit(ctx context.Context, in *CommitRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// SquashCommits collapses a linear range of read commits into to_commit,
+	// which keeps its id and contents and takes the parent of from_commit.
+	// The other commits in the range are deleted.
+	// An error is returned if a commit outside the range or a branch refers to
+	// a commit in the range other than to_commit.
+	SquashCommits(ctx context.Context, in *SquashCommitsRequest, opts ...grpc.CallOption) (*SquashCommitsResponse, error)
+	// DeleteCommit deletes a commit and its contents.
+	// An error is returned if a branch or another commit refers to it.
+	DeleteCommit(ctx context.Context, in *DeleteCommitRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// GetCommitInfo returns the CommitInfo for a commit.
+	GetCommitInfo(ctx context.Context, in *GetCommitInfoRequest, opts ...grpc.CallOption) (*GetCommitInfoResponse, error)
+	// ListCommitInfo lists the commits on a repo
+	ListCommits(ctx context.Context, in *ListCommitsRequest, opts ...grpc.CallOption) (*ListCommitsResponse, error)
+	// CreateBranch points a branch at a read commit, creating the branch if it
+	// does not exist.
+	// A branch name can be used anywhere a commit id can.
+	CreateBranch(ctx context.Context, in *CreateBranchRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// ListBranches lists the branches of a repository.
+	ListBranches(ctx context.Context, in *ListBranchesRequest, opts ...grpc.CallOption) (*ListBranchesResponse, error)
+	// DeleteBranch deletes a branch, the commits it points at are not deleted.
+	// An error is returned if the branch does not exist.
+	DeleteBranch(ctx context.Context, in *DeleteBranchRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// SetRetentionPolicy sets the retention policy of a repository.
+	SetRetentionPolicy(ctx context.Context, in *SetRetentionPolicyRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// GetRetentionPolicy returns the retention policy of a repository, which
+	// is not set if the repository has none.
+	GetRetentionPolicy(ctx context.Context, in *GetRetentionPolicyRequest, opts ...grpc.CallOption) (*GetRetentionPolicyResponse, error)
+	// GarbageCollect deletes the commits the retention policy of a repository
+	// doesn't keep. Read commits with children are squashed into their child
+	// rather than deleted, so a child keeps its contents.
+	GarbageCollect(ctx context.Context, in *GarbageCollectRequest, opts ...grpc.CallOption) (*GarbageCollectResponse, error)
+	// Reshard grows the cluster to num_shards shards, which must already have
+	// masters. Only the files that map to the new shards are copied, in every
+	// commit, open write commits included, and then removed from their old
+	// shards. The cluster is read only until it is done.
+	Reshard(ctx context.Context, in *ReshardRequest, opts ...grpc.CallOption) (*ReshardResponse, error)
+}
+
+type apiClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewApiClient(cc *grpc.ClientConn) ApiClient {
+	return &apiClient{cc}
+}
+
+func (c *apiClient) InitRepository(ctx context.Context, in *InitRepositoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/InitRepository", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) ListRepositories(ctx context.Context, in *ListRepositoriesRequest, opts ...grpc.CallOption) (*ListRepositoriesResponse, error) {
+	out := new(ListRepositoriesResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/ListRepositories", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) InspectRepository(ctx context.Context, in *InspectRepositoryRequest, opts ...grpc.CallOption) (*InspectRepositoryResponse, error) {
+	out := new(InspectRepositoryResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/InspectRepository", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) DeleteRepository(ctx context.Context, in *DeleteRepositoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/DeleteRepository", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (Api_GetFileClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[0], c.cc, "/pfs.Api/GetFile", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiGetFileClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_GetFileClient interface {
+	Recv() (*google_protobuf2.BytesValue, error)
+	grpc.ClientStream
+}
+
+type apiGetFileClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiGetFileClient) Recv() (*google_protobuf2.BytesValue, error) {
+	m := new(google_protobuf2.BytesValue)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) GetFileInfo(ctx context.Context, in *GetFileInfoRequest, opts ...grpc.CallOption) (*GetFileInfoResponse, error) {
+	out := new(GetFileInfoResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/GetFileInfo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) MakeDirectory(ctx context.Context, in *MakeDirectoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/MakeDirectory", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) PutFile(ctx context.Context, in *PutFileRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/PutFile", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) PutFileStream(ctx context.Context, opts ...grpc.CallOption) (Api_PutFileStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[1], c.cc, "/pfs.Api/PutFileStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiPutFileStreamClient{stream}
+	return x, nil
+}
+
+type Api_PutFileStreamClient interface {
+	Send(*PutFileRequest) error
+	CloseAndRecv() (*PutFileStreamResponse, error)
+	grpc.ClientStream
+}
+
+type apiPutFileStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiPutFileStreamClient) Send(m *PutFileRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *apiPutFileStreamClient) CloseAndRecv() (*PutFileStreamResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PutFileStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/DeleteFile", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error) {
+	out := new(ListFilesResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/ListFiles", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) ListFilesStream(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (Api_ListFilesStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[2], c.cc, "/pfs.Api/ListFilesStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiListFilesStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_ListFilesStreamClient interface {
+	Recv() (*FileInfo, error)
+	grpc.ClientStream
+}
+
+type apiListFilesStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiListFilesStreamClient) Recv() (*FileInfo, error) {
+	m := new(FileInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) ListChangedFiles(ctx context.Context, in *ListChangedFilesRequest, opts ...grpc.CallOption) (*ListChangedFilesResponse, error) {
+	out := new(ListChangedFilesResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/ListChangedFiles", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) ListFileHistory(ctx context.Context, in *ListFileHistoryRequest, opts ...grpc.CallOption) (*ListFileHistoryResponse, error) {
+	out := new(ListFileHistoryResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/ListFileHistory", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) ExportCommit(ctx context.Context, in *ExportCommitRequest, opts ...grpc.CallOption) (Api_ExportCommitClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[3], c.cc, "/pfs.Api/ExportCommit", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiExportCommitClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Api_ExportCommitClient interface {
+	Recv() (*google_protobuf2.BytesValue, error)
+	grpc.ClientStream
+}
+
+type apiExportCommitClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiExportCommitClient) Recv() (*google_protobuf2.BytesValue, error) {
+	m := new(google_protobuf2.BytesValue)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) ImportTar(ctx context.Context, opts ...grpc.CallOption) (Api_ImportTarClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[4], c.cc, "/pfs.Api/ImportTar", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &apiImportTarClient{stream}
+	return x, nil
+}
+
+type Api_ImportTarClient interface {
+	Send(*ImportTarRequest) error
+	CloseAndRecv() (*ImportTarResponse, error)
+	grpc.ClientStream
+}
+
+type apiImportTarClient struct {
+	grpc.ClientStream
+}
+
+func (x *apiImportTarClient) Send(m *ImportTarRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *apiImportTarClient) CloseAndRecv() (*ImportTarResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportTarResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *apiClient) Branch(ctx context.Context, in *BranchRequest, opts ...grpc.CallOption) (*BranchResponse, error) {
+	out := new(BranchResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/Branch", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) Merge(ctx context.Context, in *MergeRequest, opts ...grpc.CallOption) (*MergeResponse, error) {
+	out := new(MergeResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/Merge", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) Commit(ctx context.Context, in *CommitRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/Commit", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) SquashCommits(ctx context.Context, in *SquashCommitsRequest, opts ...grpc.CallOption) (*SquashCommitsResponse, error) {
+	out := new(SquashCommitsResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/SquashCommits", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) DeleteCommit(ctx context.Context, in *DeleteCommitRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/DeleteCommit", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-func (m *PushDiffRequest) GetCommit() *Commit {
-	if m != nil {
-		return m.Commit
+func (c *apiClient) GetCommitInfo(ctx context.Context, in *GetCommitInfoRequest, opts ...grpc.CallOption) (*GetCommitInfoResponse, error) {
+	out := new(GetCommitInfoResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/GetCommitInfo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return out, nil
 }
 
-func init() {
-	proto.RegisterEnum("pfs.CommitType", CommitType_name, CommitType_value)
-	proto.RegisterEnum("pfs.FileType", FileType_name, FileType_value)
+func (c *apiClient) ListCommits(ctx context.Context, in *ListCommitsRequest, opts ...grpc.CallOption) (*ListCommitsResponse, error) {
+	out := new(ListCommitsResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/ListCommits", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-// Client API for Api service
+func (c *apiClient) CreateBranch(ctx context.Context, in *CreateBranchRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/CreateBranch", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-type ApiClient interface {
+func (c *apiClient) ListBranches(ctx context.Context, in *ListBranchesRequest, opts ...grpc.CallOption) (*ListBranchesResponse, error) {
+	out := new(ListBranchesResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/ListBranches", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) DeleteBranch(ctx context.Context, in *DeleteBranchRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/DeleteBranch", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) SetRetentionPolicy(ctx context.Context, in *SetRetentionPolicyRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.Api/SetRetentionPolicy", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) GetRetentionPolicy(ctx context.Context, in *GetRetentionPolicyRequest, opts ...grpc.CallOption) (*GetRetentionPolicyResponse, error) {
+	out := new(GetRetentionPolicyResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/GetRetentionPolicy", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) GarbageCollect(ctx context.Context, in *GarbageCollectRequest, opts ...grpc.CallOption) (*GarbageCollectResponse, error) {
+	out := new(GarbageCollectResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/GarbageCollect", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiClient) Reshard(ctx context.Context, in *ReshardRequest, opts ...grpc.CallOption) (*ReshardResponse, error) {
+	out := new(ReshardResponse)
+	err := grpc.Invoke(ctx, "/pfs.Api/Reshard", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Api service
+
+type ApiServer interface {
 	// InitRepository creates a new repository.
 	// An error is returned if the specified repository already exists.
-	InitRepository(ctx context.Context, in *InitRepositoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	InitRepository(context.Context, *InitRepositoryRequest) (*google_protobuf.Empty, error)
+	// ListRepositories lists the repositories.
+	ListRepositories(context.Context, *ListRepositoriesRequest) (*ListRepositoriesResponse, error)
+	// InspectRepository returns a RepositoryInfo for a repository.
+	InspectRepository(context.Context, *InspectRepositoryRequest) (*InspectRepositoryResponse, error)
+	// DeleteRepository deletes a repository and all of its commits.
+	// An error is returned if the specified repository does not exist.
+	DeleteRepository(context.Context, *DeleteRepositoryRequest) (*google_protobuf.Empty, error)
 	// GetFile returns a byte stream of the specified file.
 	// An error is returned if the specified commit is a write commit.
-	GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (Api_GetFileClient, error)
+	GetFile(*GetFileRequest, Api_GetFileServer) error
 	// GetFileInfo returns a FileInfo for a file.
-	GetFileInfo(ctx context.Context, in *GetFileInfoRequest, opts ...grpc.CallOption) (*GetFileInfoResponse, error)
+	GetFileInfo(context.Context, *GetFileInfoRequest) (*GetFileInfoResponse, error)
 	// MakeDirectory makes a directory on the file system.
-	MakeDirectory(ctx context.Context, in *MakeDirectoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	MakeDirectory(context.Context, *MakeDirectoryRequest) (*google_protobuf.Empty, error)
 	// PutFile writes the specified file to PFS.
 	// An error is returned if the specified commit is not a write commit.
-	PutFile(ctx context.Context, in *PutFileRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
-	// ListFiles lists the files within a directory.
+	PutFile(context.Context, *PutFileRequest) (*google_protobuf.Empty, error)
+	// PutFileStream writes the specified file to PFS from a stream of requests.
+	// path and offset_bytes are taken from the first request, the values of
+	// every request are written one after another.
+	// An error is returned if the specified commit is not a write commit.
+	PutFileStream(Api_PutFileStreamServer) error
+	// DeleteFile deletes a file or directory, directories are deleted recursively.
+	// An error is returned if the specified commit is not a write commit.
+	DeleteFile(context.Context, *DeleteFileRequest) (*google_protobuf.Empty, error)
+	// ListFiles lists the files within a directory sorted by path.
 	// An error is returned if the specified path is not a directory.
-	ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error)
+	ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error)
+	// ListFilesStream is ListFiles for listings too big for a single response.
+	ListFilesStream(*ListFilesRequest, Api_ListFilesStreamServer) error
+	// ListChangedFiles lists the files and directories that were added, modified
+	// or deleted between from_commit and to_commit.
+	// If from_commit is not set the parent of to_commit is used.
+	ListChangedFiles(context.Context, *ListChangedFilesRequest) (*ListChangedFilesResponse, error)
+	// ListFileHistory lists the commits that changed the content of a file,
+	// starting at the path's commit and following its parents, newest first.
+	ListFileHistory(context.Context, *ListFileHistoryRequest) (*ListFileHistoryResponse, error)
+	// ExportCommit returns a byte stream of a tar archive of a read commit.
+	// Entries are named by their path in the commit.
+	ExportCommit(*ExportCommitRequest, Api_ExportCommitServer) error
+	// ImportTar unpacks a tar archive sent as a stream of requests into a write
+	// commit, missing parent directories are created.
+	ImportTar(Api_ImportTarServer) error
 	// Branch creates a new write commit from a base commit.
 	// An error is returned if the base commit is not a read commit.
-	Branch(ctx context.Context, in *BranchRequest, opts ...grpc.CallOption) (*BranchResponse, error)
-	// Commit turns the specified write commit into a read commit.
+	// If the base commit is a branch the new commit is made on that branch.
+	Branch(context.Context, *BranchRequest) (*BranchResponse, error)
+	// Merge creates a new write commit from ours with the changes theirs made
+	// since the closest commit they have in common.
+	// A path changed differently by both is handled according to conflict_policy.
+	// An error is returned if ours or theirs is not a read commit.
+	Merge(context.Context, *MergeRequest) (*MergeResponse, error)
+	// Commit turns the specified write commit into a read commit and advances
+	// the branch it was made on to it.
 	// An error is returned if the specified commit is not a write commit.
-	Commit(ctx context.Context, in *CommitRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	Commit(context.Context, *CommitRequest) (*google_protobuf.Empty, error)
+	// SquashCommits collapses a linear range of read commits into to_commit,
+	// which keeps its id and contents and takes the parent of from_commit.
+	// The other commits in the range are deleted.
+	// An error is returned if a commit outside the range or a branch refers to
+	// a commit in the range other than to_commit.
+	SquashCommits(context.Context, *SquashCommitsRequest) (*SquashCommitsResponse, error)
+	// DeleteCommit deletes a commit and its contents.
+	// An error is returned if a branch or another commit refers to it.
+	DeleteCommit(context.Context, *DeleteCommitRequest) (*google_protobuf.Empty, error)
 	// GetCommitInfo returns the CommitInfo for a commit.
-	GetCommitInfo(ctx context.Context, in *GetCommitInfoRequest, opts ...grpc.CallOption) (*GetCommitInfoResponse, error)
+	GetCommitInfo(context.Context, *GetCommitInfoRequest) (*GetCommitInfoResponse, error)
 	// ListCommitInfo lists the commits on a repo
-	ListCommits(ctx context.Context, in *ListCommitsRequest, opts ...grpc.CallOption) (*ListCommitsResponse, error)
+	ListCommits(context.Context, *ListCommitsRequest) (*ListCommitsResponse, error)
+	// CreateBranch points a branch at a read commit, creating the branch if it
+	// does not exist.
+	// A branch name can be used anywhere a commit id can.
+	CreateBranch(context.Context, *CreateBranchRequest) (*google_protobuf.Empty, error)
+	// ListBranches lists the branches of a repository.
+	ListBranches(context.Context, *ListBranchesRequest) (*ListBranchesResponse, error)
+	// DeleteBranch deletes a branch, the commits it points at are not deleted.
+	// An error is returned if the branch does not exist.
+	DeleteBranch(context.Context, *DeleteBranchRequest) (*google_protobuf.Empty, error)
+	// SetRetentionPolicy sets the retention policy of a repository.
+	SetRetentionPolicy(context.Context, *SetRetentionPolicyRequest) (*google_protobuf.Empty, error)
+	// GetRetentionPolicy returns the retention policy of a repository, which
+	// is not set if the repository has none.
+	GetRetentionPolicy(context.Context, *GetRetentionPolicyRequest) (*GetRetentionPolicyResponse, error)
+	// GarbageCollect deletes the commits the retention policy of a repository
+	// doesn't keep. Read commits with children are squashed into their child
+	// rather than deleted, so a child keeps its contents.
+	GarbageCollect(context.Context, *GarbageCollectRequest) (*GarbageCollectResponse, error)
+	// Reshard grows the cluster to num_shards shards, which must already have
+	// masters. Only the files that map to the new shards are copied, in every
+	// commit, open write commits included, and then removed from their old
+	// shards. The cluster is read only until it is done.
+	Reshard(context.Context, *ReshardRequest) (*ReshardResponse, error)
 }
 
-type apiClient struct {
-	cc *grpc.ClientConn
+func RegisterApiServer(s *grpc.Server, srv ApiServer) {
+	s.RegisterService(&_Api_serviceDesc, srv)
 }
 
-func NewApiClient(cc *grpc.ClientConn) ApiClient {
-	return &apiClient{cc}
+func _Api_InitRepository_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(InitRepositoryRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).InitRepository(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (c *apiClient) InitRepository(ctx context.Context, in *InitRepositoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
-	out := new(google_protobuf.Empty)
-	err := grpc.Invoke(ctx, "/pfs.Api/InitRepository", in, out, c.cc, opts...)
+func _Api_ListRepositories_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ListRepositoriesRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).ListRepositories(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Api_InspectRepository_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(InspectRepositoryRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).InspectRepository(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Api_DeleteRepository_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(DeleteRepositoryRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).DeleteRepository(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Api_GetFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetFileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ApiServer).GetFile(m, &apiGetFileServer{stream})
+}
+
+type Api_GetFileServer interface {
+	Send(*google_protobuf2.BytesValue) error
+	grpc.ServerStream
+}
+
+type apiGetFileServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiGetFileServer) Send(m *google_protobuf2.BytesValue) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Api_GetFileInfo_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(GetFileInfoRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).GetFileInfo(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Api_MakeDirectory_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(MakeDirectoryRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).MakeDirectory(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Api_PutFile_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(PutFileRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).PutFile(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Api_PutFileStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ApiServer).PutFileStream(&apiPutFileStreamServer{stream})
+}
+
+type Api_PutFileStreamServer interface {
+	SendAndClose(*PutFileStreamResponse) error
+	Recv() (*PutFileRequest, error)
+	grpc.ServerStream
+}
+
+type apiPutFileStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiPutFileStreamServer) SendAndClose(m *PutFileStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *apiPutFileStreamServer) Recv() (*PutFileRequest, error) {
+	m := new(PutFileRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Api_DeleteFile_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(DeleteFileRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).DeleteFile(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Api_ListFiles_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ListFilesRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).ListFiles(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Api_ListFilesStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListFilesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ApiServer).ListFilesStream(m, &apiListFilesStreamServer{stream})
+}
+
+type Api_ListFilesStreamServer interface {
+	Send(*FileInfo) error
+	grpc.ServerStream
+}
+
+type apiListFilesStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiListFilesStreamServer) Send(m *FileInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Api_ListChangedFiles_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ListChangedFilesRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).ListChangedFiles(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *apiClient) GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (Api_GetFileClient, error) {
-	stream, err := grpc.NewClientStream(ctx, &_Api_serviceDesc.Streams[0], c.cc, "/pfs.Api/GetFile", opts...)
-	if err != nil {
+func _Api_ListFileHistory_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ListFileHistoryRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	x := &apiGetFileClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
+	out, err := srv.(ApiServer).ListFileHistory(ctx, in)
+	if err != nil {
 		return nil, err
 	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
+	return out, nil
+}
+
+func _Api_ExportCommit_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportCommitRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
 	}
-	return x, nil
+	return srv.(ApiServer).ExportCommit(m, &apiExportCommitServer{stream})
 }
 
-type Api_GetFileClient interface {
-	Recv() (*google_protobuf2.BytesValue, error)
-	grpc.ClientStream
+type Api_ExportCommitServer interface {
+	Send(*google_protobuf2.BytesValue) error
+	grpc.ServerStream
 }
 
-type apiGetFileClient struct {
-	grpc.ClientStream
+type apiExportCommitServer struct {
+	grpc.ServerStream
 }
 
-func (x *apiGetFileClient) Recv() (*google_protobuf2.BytesValue, error) {
-	m := new(google_protobuf2.BytesValue)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
+func (x *apiExportCommitServer) Send(m *google_protobuf2.BytesValue) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Api_ImportTar_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ApiServer).ImportTar(&apiImportTarServer{stream})
+}
+
+type Api_ImportTarServer interface {
+	SendAndClose(*ImportTarResponse) error
+	Recv() (*ImportTarRequest, error)
+	grpc.ServerStream
+}
+
+type apiImportTarServer struct {
+	grpc.ServerStream
+}
+
+func (x *apiImportTarServer) SendAndClose(m *ImportTarResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *apiImportTarServer) Recv() (*ImportTarRequest, error) {
+	m := new(ImportTarRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
-func (c *apiClient) GetFileInfo(ctx context.Context, in *GetFileInfoRequest, opts ...grpc.CallOption) (*GetFileInfoResponse, error) {
-	out := new(GetFileInfoResponse)
-	err := grpc.Invoke(ctx, "/pfs.Api/GetFileInfo", in, out, c.cc, opts...)
-	if err != nil {
+func _Api_Branch_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(BranchRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	return out, nil
-}
-
-func (c *apiClient) MakeDirectory(ctx context.Context, in *MakeDirectoryRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
-	out := new(google_protobuf.Empty)
-	err := grpc.Invoke(ctx, "/pfs.Api/MakeDirectory", in, out, c.cc, opts...)
+	out, err := srv.(ApiServer).Branch(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *apiClient) PutFile(ctx context.Context, in *PutFileRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
-	out := new(google_protobuf.Empty)
-	err := grpc.Invoke(ctx, "/pfs.Api/PutFile", in, out, c.cc, opts...)
-	if err != nil {
+func _Api_Merge_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(MergeRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	return out, nil
-}
-
-func (c *apiClient) ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error) {
-	out := new(ListFilesResponse)
-	err := grpc.Invoke(ctx, "/pfs.Api/ListFiles", in, out, c.cc, opts...)
+	out, err := srv.(ApiServer).Merge(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *apiClient) Branch(ctx context.Context, in *BranchRequest, opts ...grpc.CallOption) (*BranchResponse, error) {
-	out := new(BranchResponse)
-	err := grpc.Invoke(ctx, "/pfs.Api/Branch", in, out, c.cc, opts...)
-	if err != nil {
+func _Api_Commit_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(CommitRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	return out, nil
-}
-
-func (c *apiClient) Commit(ctx context.Context, in *CommitRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
-	out := new(google_protobuf.Empty)
-	err := grpc.Invoke(ctx, "/pfs.Api/Commit", in, out, c.cc, opts...)
+	out, err := srv.(ApiServer).Commit(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *apiClient) GetCommitInfo(ctx context.Context, in *GetCommitInfoRequest, opts ...grpc.CallOption) (*GetCommitInfoResponse, error) {
-	out := new(GetCommitInfoResponse)
-	err := grpc.Invoke(ctx, "/pfs.Api/GetCommitInfo", in, out, c.cc, opts...)
+func _Api_SquashCommits_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(SquashCommitsRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).SquashCommits(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *apiClient) ListCommits(ctx context.Context, in *ListCommitsRequest, opts ...grpc.CallOption) (*ListCommitsResponse, error) {
-	out := new(ListCommitsResponse)
-	err := grpc.Invoke(ctx, "/pfs.Api/ListCommits", in, out, c.cc, opts...)
+func _Api_DeleteCommit_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(DeleteCommitRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ApiServer).DeleteCommit(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-// Server API for Api service
-
-type ApiServer interface {
-	// InitRepository creates a new repository.
-	// An error is returned if the specified repository already exists.
-	InitRepository(context.Context, *InitRepositoryRequest) (*google_protobuf.Empty, error)
-	// GetFile returns a byte stream of the specified file.
-	// An error is returned if the specified commit is a write commit.
-	GetFile(*GetFileRequest, Api_GetFileServer) error
-	// GetFileInfo returns a FileInfo for a file.
-	GetFileInfo(context.Context, *GetFileInfoRequest) (*GetFileInfoResponse, error)
-	// MakeDirectory makes a directory on the file system.
-	MakeDirectory(context.Context, *MakeDirectoryRequest) (*google_protobuf.Empty, error)
-	// PutFile writes the specified file to PFS.
-	// An error is returned if the specified commit is not a write commit.
-	PutFile(context.Context, *PutFileRequest) (*google_protobuf.Empty, error)
-	// ListFiles lists the files within a directory.
-	// An error is returned if the specified path is not a directory.
-	ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error)
-	// Branch creates a new write commit from a base commit.
-	// An error is returned if the base commit is not a read commit.
-	Branch(context.Context, *BranchRequest) (*BranchResponse, error)
-	// Commit turns the specified write commit into a read commit.
-	// An error is returned if the specified commit is not a write commit.
-	Commit(context.Context, *CommitRequest) (*google_protobuf.Empty, error)
-	// GetCommitInfo returns the CommitInfo for a commit.
-	GetCommitInfo(context.Context, *GetCommitInfoRequest) (*GetCommitInfoResponse, error)
-	// ListCommitInfo lists the commits on a repo
-	ListCommits(context.Context, *ListCommitsRequest) (*ListCommitsResponse, error)
-}
-
-func RegisterApiServer(s *grpc.Server, srv ApiServer) {
-	s.RegisterService(&_Api_serviceDesc, srv)
-}
-
-func _Api_InitRepository_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(InitRepositoryRequest)
+func _Api_GetCommitInfo_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(GetCommitInfoRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).InitRepository(ctx, in)
+	out, err := srv.(ApiServer).GetCommitInfo(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _Api_GetFile_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(GetFileRequest)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
-	}
-	return srv.(ApiServer).GetFile(m, &apiGetFileServer{stream})
-}
-
-type Api_GetFileServer interface {
-	Send(*google_protobuf2.BytesValue) error
-	grpc.ServerStream
-}
-
-type apiGetFileServer struct {
-	grpc.ServerStream
-}
-
-func (x *apiGetFileServer) Send(m *google_protobuf2.BytesValue) error {
-	return x.ServerStream.SendMsg(m)
-}
-
-func _Api_GetFileInfo_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(GetFileInfoRequest)
+func _Api_ListCommits_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ListCommitsRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).GetFileInfo(ctx, in)
+	out, err := srv.(ApiServer).ListCommits(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _Api_MakeDirectory_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(MakeDirectoryRequest)
+func _Api_CreateBranch_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(CreateBranchRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).MakeDirectory(ctx, in)
+	out, err := srv.(ApiServer).CreateBranch(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _Api_PutFile_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(PutFileRequest)
+func _Api_ListBranches_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ListBranchesRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).PutFile(ctx, in)
+	out, err := srv.(ApiServer).ListBranches(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _Api_ListFiles_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(ListFilesRequest)
+func _Api_DeleteBranch_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(DeleteBranchRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).ListFiles(ctx, in)
+	out, err := srv.(ApiServer).DeleteBranch(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _Api_Branch_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(BranchRequest)
+func _Api_SetRetentionPolicy_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(SetRetentionPolicyRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).Branch(ctx, in)
+	out, err := srv.(ApiServer).SetRetentionPolicy(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _Api_Commit_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(CommitRequest)
+func _Api_GetRetentionPolicy_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(GetRetentionPolicyRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).Commit(ctx, in)
+	out, err := srv.(ApiServer).GetRetentionPolicy(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _Api_GetCommitInfo_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(GetCommitInfoRequest)
+func _Api_GarbageCollect_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(GarbageCollectRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).GetCommitInfo(ctx, in)
+	out, err := srv.(ApiServer).GarbageCollect(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _Api_ListCommits_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
-	in := new(ListCommitsRequest)
+func _Api_Reshard_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ReshardRequest)
 	if err := codec.Unmarshal(buf, in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ApiServer).ListCommits(ctx, in)
+	out, err := srv.(ApiServer).Reshard(ctx, in)
 	if err != nil {
 		return nil, err
 	}
@@ -821,6 +2494,18 @@ var _Api_serviceDesc = grpc.ServiceDesc{
 			MethodName: "InitRepository",
 			Handler:    _Api_InitRepository_Handler,
 		},
+		{
+			MethodName: "ListRepositories",
+			Handler:    _Api_ListRepositories_Handler,
+		},
+		{
+			MethodName: "InspectRepository",
+			Handler:    _Api_InspectRepository_Handler,
+		},
+		{
+			MethodName: "DeleteRepository",
+			Handler:    _Api_DeleteRepository_Handler,
+		},
 		{
 			MethodName: "GetFileInfo",
 			Handler:    _Api_GetFileInfo_Handler,
@@ -833,18 +2518,42 @@ var _Api_serviceDesc = grpc.ServiceDesc{
 			MethodName: "PutFile",
 			Handler:    _Api_PutFile_Handler,
 		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _Api_DeleteFile_Handler,
+		},
 		{
 			MethodName: "ListFiles",
 			Handler:    _Api_ListFiles_Handler,
 		},
+		{
+			MethodName: "ListChangedFiles",
+			Handler:    _Api_ListChangedFiles_Handler,
+		},
+		{
+			MethodName: "ListFileHistory",
+			Handler:    _Api_ListFileHistory_Handler,
+		},
 		{
 			MethodName: "Branch",
 			Handler:    _Api_Branch_Handler,
 		},
+		{
+			MethodName: "Merge",
+			Handler:    _Api_Merge_Handler,
+		},
 		{
 			MethodName: "Commit",
 			Handler:    _Api_Commit_Handler,
 		},
+		{
+			MethodName: "SquashCommits",
+			Handler:    _Api_SquashCommits_Handler,
+		},
+		{
+			MethodName: "DeleteCommit",
+			Handler:    _Api_DeleteCommit_Handler,
+		},
 		{
 			MethodName: "GetCommitInfo",
 			Handler:    _Api_GetCommitInfo_Handler,
@@ -853,6 +2562,34 @@ var _Api_serviceDesc = grpc.ServiceDesc{
 			MethodName: "ListCommits",
 			Handler:    _Api_ListCommits_Handler,
 		},
+		{
+			MethodName: "CreateBranch",
+			Handler:    _Api_CreateBranch_Handler,
+		},
+		{
+			MethodName: "ListBranches",
+			Handler:    _Api_ListBranches_Handler,
+		},
+		{
+			MethodName: "DeleteBranch",
+			Handler:    _Api_DeleteBranch_Handler,
+		},
+		{
+			MethodName: "SetRetentionPolicy",
+			Handler:    _Api_SetRetentionPolicy_Handler,
+		},
+		{
+			MethodName: "GetRetentionPolicy",
+			Handler:    _Api_GetRetentionPolicy_Handler,
+		},
+		{
+			MethodName: "GarbageCollect",
+			Handler:    _Api_GarbageCollect_Handler,
+		},
+		{
+			MethodName: "Reshard",
+			Handler:    _Api_Reshard_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -860,6 +2597,26 @@ var _Api_serviceDesc = grpc.ServiceDesc{
 			Handler:       _Api_GetFile_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "PutFileStream",
+			Handler:       _Api_PutFileStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ListFilesStream",
+			Handler:       _Api_ListFilesStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportCommit",
+			Handler:       _Api_ExportCommit_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportTar",
+			Handler:       _Api_ImportTar_Handler,
+			ClientStreams: true,
+		},
 	},
 }
 
@@ -871,6 +2628,10 @@ type InternalApiClient interface {
 	PullDiff(ctx context.Context, in *PullDiffRequest, opts ...grpc.CallOption) (InternalApi_PullDiffClient, error)
 	// Push diff pushes a diff from the specified commit.
 	PushDiff(ctx context.Context, in *PushDiffRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// Rollback undoes an operation on every shard it was applied to.
+	Rollback(ctx context.Context, in *RollbackRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	// RunReshardPhase runs a phase of a Reshard on the local shards.
+	RunReshardPhase(ctx context.Context, in *ReshardPhaseRequest, opts ...grpc.CallOption) (*ReshardResponse, error)
 }
 
 type internalApiClient struct {
@@ -922,6 +2683,24 @@ func (c *internalApiClient) PushDiff(ctx context.Context, in *PushDiffRequest, o
 	return out, nil
 }
 
+func (c *internalApiClient) Rollback(ctx context.Context, in *RollbackRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/pfs.InternalApi/Rollback", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *internalApiClient) RunReshardPhase(ctx context.Context, in *ReshardPhaseRequest, opts ...grpc.CallOption) (*ReshardResponse, error) {
+	out := new(ReshardResponse)
+	err := grpc.Invoke(ctx, "/pfs.InternalApi/RunReshardPhase", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for InternalApi service
 
 type InternalApiServer interface {
@@ -930,6 +2709,10 @@ type InternalApiServer interface {
 	PullDiff(*PullDiffRequest, InternalApi_PullDiffServer) error
 	// Push diff pushes a diff from the specified commit.
 	PushDiff(context.Context, *PushDiffRequest) (*google_protobuf.Empty, error)
+	// Rollback undoes an operation on every shard it was applied to.
+	Rollback(context.Context, *RollbackRequest) (*google_protobuf.Empty, error)
+	// RunReshardPhase runs a phase of a Reshard on the local shards.
+	RunReshardPhase(context.Context, *ReshardPhaseRequest) (*ReshardResponse, error)
 }
 
 func RegisterInternalApiServer(s *grpc.Server, srv InternalApiServer) {
@@ -969,6 +2752,30 @@ func _InternalApi_PushDiff_Handler(srv interface{}, ctx context.Context, codec g
 	return out, nil
 }
 
+func _InternalApi_Rollback_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(RollbackRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(InternalApiServer).Rollback(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _InternalApi_RunReshardPhase_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(ReshardPhaseRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(InternalApiServer).RunReshardPhase(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _InternalApi_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pfs.InternalApi",
 	HandlerType: (*InternalApiServer)(nil),
@@ -977,6 +2784,14 @@ var _InternalApi_serviceDesc = grpc.ServiceDesc{
 			MethodName: "PushDiff",
 			Handler:    _InternalApi_PushDiff_Handler,
 		},
+		{
+			MethodName: "Rollback",
+			Handler:    _InternalApi_Rollback_Handler,
+		},
+		{
+			MethodName: "RunReshardPhase",
+			Handler:    _InternalApi_RunReshardPhase_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -15,6 +15,8 @@ import (
 
 const (
 	GetAll int64 = 1<<63 - 1
+	// PutFileChunkSize is the size of the values PutFile sends.
+	PutFileChunkSize = 1 << 20
 )
 
 func InitRepository(apiClient pfs.ApiClient, repositoryName string) error {
@@ -29,7 +31,37 @@ func InitRepository(apiClient pfs.ApiClient, repositoryName string) error {
 	return err
 }
 
-func Branch(apiClient pfs.ApiClient, repositoryName string, commitID string) (*pfs.BranchResponse, error) {
+func ListRepositories(apiClient pfs.ApiClient) (*pfs.ListRepositoriesResponse, error) {
+	return apiClient.ListRepositories(
+		context.Background(),
+		&pfs.ListRepositoriesRequest{},
+	)
+}
+
+func InspectRepository(apiClient pfs.ApiClient, repositoryName string) (*pfs.InspectRepositoryResponse, error) {
+	return apiClient.InspectRepository(
+		context.Background(),
+		&pfs.InspectRepositoryRequest{
+			Repository: &pfs.Repository{
+				Name: repositoryName,
+			},
+		},
+	)
+}
+
+func DeleteRepository(apiClient pfs.ApiClient, repositoryName string) error {
+	_, err := apiClient.DeleteRepository(
+		context.Background(),
+		&pfs.DeleteRepositoryRequest{
+			Repository: &pfs.Repository{
+				Name: repositoryName,
+			},
+		},
+	)
+	return err
+}
+
+func Branch(apiClient pfs.ApiClient, repositoryName string, commitID string, message string) (*pfs.BranchResponse, error) {
 	return apiClient.Branch(
 		context.Background(),
 		&pfs.BranchRequest{
@@ -39,6 +71,29 @@ func Branch(apiClient pfs.ApiClient, repositoryName string, commitID string) (*p
 				},
 				Id: commitID,
 			},
+			Message: message,
+		},
+	)
+}
+
+func Merge(apiClient pfs.ApiClient, repositoryName string, oursID string, theirsID string, conflictPolicy pfs.ConflictPolicy, message string) (*pfs.MergeResponse, error) {
+	return apiClient.Merge(
+		context.Background(),
+		&pfs.MergeRequest{
+			Ours: &pfs.Commit{
+				Repository: &pfs.Repository{
+					Name: repositoryName,
+				},
+				Id: oursID,
+			},
+			Theirs: &pfs.Commit{
+				Repository: &pfs.Repository{
+					Name: repositoryName,
+				},
+				Id: theirsID,
+			},
+			ConflictPolicy: conflictPolicy,
+			Message:        message,
 		},
 	)
 }
@@ -61,14 +116,59 @@ func MakeDirectory(apiClient pfs.ApiClient, repositoryName string, commitID stri
 	return err
 }
 
+// PutFile streams reader to path in chunks of PutFileChunkSize bytes, it
+// returns the number of bytes written.
 func PutFile(apiClient pfs.ApiClient, repositoryName string, commitID string, path string, offset int64, reader io.Reader) (int64, error) {
-	value, err := ioutil.ReadAll(reader)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	putFileStreamClient, err := apiClient.PutFileStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+	putFileRequest := &pfs.PutFileRequest{
+		Path: &pfs.Path{
+			Commit: &pfs.Commit{
+				Repository: &pfs.Repository{
+					Name: repositoryName,
+				},
+				Id: commitID,
+			},
+			Path: path,
+		},
+		OffsetBytes: offset,
+	}
+	buffer := make([]byte, PutFileChunkSize)
+	for {
+		n, err := io.ReadFull(reader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			// returning cancels the stream so the server sees an error rather than the end of the file
+			return 0, err
+		}
+		if n > 0 || putFileRequest.Path != nil {
+			putFileRequest.Value = buffer[:n]
+			if sendErr := putFileStreamClient.Send(putFileRequest); sendErr == io.EOF {
+				// the server has failed, CloseAndRecv returns its error
+				break
+			} else if sendErr != nil {
+				return 0, sendErr
+			}
+			putFileRequest = &pfs.PutFileRequest{}
+		}
+		if err != nil {
+			break
+		}
+	}
+	putFileStreamResponse, err := putFileStreamClient.CloseAndRecv()
 	if err != nil {
 		return 0, err
 	}
-	_, err = apiClient.PutFile(
+	return int64(putFileStreamResponse.SizeBytes), nil
+}
+
+func DeleteFile(apiClient pfs.ApiClient, repositoryName string, commitID string, path string) error {
+	_, err := apiClient.DeleteFile(
 		context.Background(),
-		&pfs.PutFileRequest{
+		&pfs.DeleteFileRequest{
 			Path: &pfs.Path{
 				Commit: &pfs.Commit{
 					Repository: &pfs.Repository{
@@ -78,14 +178,18 @@ func PutFile(apiClient pfs.ApiClient, repositoryName string, commitID string, pa
 				},
 				Path: path,
 			},
-			OffsetBytes: offset,
-			Value:       value,
 		},
 	)
-	return int64(len(value)), err
+	return err
 }
 
 func GetFile(apiClient pfs.ApiClient, repositoryName string, commitID string, path string, offset int64, size int64, writer io.Writer) error {
+	return GetFileWithReadConsistency(apiClient, repositoryName, commitID, path, offset, size, pfs.ReadConsistency_READ_CONSISTENCY_NONE, writer)
+}
+
+// GetFileWithReadConsistency is GetFile with the servers the read can go to
+// set by readConsistency.
+func GetFileWithReadConsistency(apiClient pfs.ApiClient, repositoryName string, commitID string, path string, offset int64, size int64, readConsistency pfs.ReadConsistency, writer io.Writer) error {
 	apiGetFileClient, err := apiClient.GetFile(
 		context.Background(),
 		&pfs.GetFileRequest{
@@ -98,8 +202,9 @@ func GetFile(apiClient pfs.ApiClient, repositoryName string, commitID string, pa
 				},
 				Path: path,
 			},
-			OffsetBytes: offset,
-			SizeBytes:   size,
+			OffsetBytes:     offset,
+			SizeBytes:       size,
+			ReadConsistency: readConsistency,
 		},
 	)
 	if err != nil {
@@ -112,6 +217,12 @@ func GetFile(apiClient pfs.ApiClient, repositoryName string, commitID string, pa
 }
 
 func GetFileInfo(apiClient pfs.ApiClient, repositoryName string, commitID string, path string) (*pfs.GetFileInfoResponse, error) {
+	return GetFileInfoWithReadConsistency(apiClient, repositoryName, commitID, path, pfs.ReadConsistency_READ_CONSISTENCY_NONE)
+}
+
+// GetFileInfoWithReadConsistency is GetFileInfo with the servers the read can
+// go to set by readConsistency.
+func GetFileInfoWithReadConsistency(apiClient pfs.ApiClient, repositoryName string, commitID string, path string, readConsistency pfs.ReadConsistency) (*pfs.GetFileInfoResponse, error) {
 	return apiClient.GetFileInfo(
 		context.Background(),
 		&pfs.GetFileInfoRequest{
@@ -124,6 +235,7 @@ func GetFileInfo(apiClient pfs.ApiClient, repositoryName string, commitID string
 				},
 				Path: path,
 			},
+			ReadConsistency: readConsistency,
 		},
 	)
 }
@@ -149,7 +261,151 @@ func ListFiles(apiClient pfs.ApiClient, repositoryName string, commitID string,
 	)
 }
 
-func Commit(apiClient pfs.ApiClient, repositoryName string, commitID string) error {
+// ListFilesStream calls handleFunc with each file below path that matches
+// pattern, in path order.
+func ListFilesStream(apiClient pfs.ApiClient, repositoryName string, commitID string, path string, recursive bool, pattern string, shard uint64, modulus uint64, handleFunc func(*pfs.FileInfo) error) error {
+	apiListFilesStreamClient, err := apiClient.ListFilesStream(
+		context.Background(),
+		&pfs.ListFilesRequest{
+			Path: &pfs.Path{
+				Commit: &pfs.Commit{
+					Repository: &pfs.Repository{
+						Name: repositoryName,
+					},
+					Id: commitID,
+				},
+				Path: path,
+			},
+			Shard: &pfs.Shard{
+				Number: shard,
+				Modulo: modulus,
+			},
+			Recursive: recursive,
+			Pattern:   pattern,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	for fileInfo, err := apiListFilesStreamClient.Recv(); err != io.EOF; fileInfo, err = apiListFilesStreamClient.Recv() {
+		if err != nil {
+			return err
+		}
+		if err := handleFunc(fileInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ListChangedFiles(apiClient pfs.ApiClient, repositoryName string, fromCommitID string, toCommitID string, shard uint64, modulus uint64) (*pfs.ListChangedFilesResponse, error) {
+	var fromCommit *pfs.Commit
+	if fromCommitID != "" {
+		fromCommit = &pfs.Commit{
+			Repository: &pfs.Repository{
+				Name: repositoryName,
+			},
+			Id: fromCommitID,
+		}
+	}
+	return apiClient.ListChangedFiles(
+		context.Background(),
+		&pfs.ListChangedFilesRequest{
+			FromCommit: fromCommit,
+			ToCommit: &pfs.Commit{
+				Repository: &pfs.Repository{
+					Name: repositoryName,
+				},
+				Id: toCommitID,
+			},
+			Shard: &pfs.Shard{
+				Number: shard,
+				Modulo: modulus,
+			},
+		},
+	)
+}
+
+func ListFileHistory(apiClient pfs.ApiClient, repositoryName string, commitID string, path string) (*pfs.ListFileHistoryResponse, error) {
+	return apiClient.ListFileHistory(
+		context.Background(),
+		&pfs.ListFileHistoryRequest{
+			Path: &pfs.Path{
+				Commit: &pfs.Commit{
+					Repository: &pfs.Repository{
+						Name: repositoryName,
+					},
+					Id: commitID,
+				},
+				Path: path,
+			},
+		},
+	)
+}
+
+// ExportCommit writes a tar archive of path in commitID to writer, the whole
+// commit is exported if path is empty.
+func ExportCommit(apiClient pfs.ApiClient, repositoryName string, commitID string, path string, writer io.Writer) error {
+	apiExportCommitClient, err := apiClient.ExportCommit(
+		context.Background(),
+		&pfs.ExportCommitRequest{
+			Commit: &pfs.Commit{
+				Repository: &pfs.Repository{
+					Name: repositoryName,
+				},
+				Id: commitID,
+			},
+			Path: path,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return protoutil.WriteFromStreamingBytesClient(apiExportCommitClient, writer)
+}
+
+// ImportTar streams the tar archive in reader to commitID in chunks of
+// PutFileChunkSize bytes.
+func ImportTar(apiClient pfs.ApiClient, repositoryName string, commitID string, reader io.Reader) (*pfs.ImportTarResponse, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	importTarClient, err := apiClient.ImportTar(ctx)
+	if err != nil {
+		return nil, err
+	}
+	importTarRequest := &pfs.ImportTarRequest{
+		Commit: &pfs.Commit{
+			Repository: &pfs.Repository{
+				Name: repositoryName,
+			},
+			Id: commitID,
+		},
+	}
+	buffer := make([]byte, PutFileChunkSize)
+	for {
+		n, err := io.ReadFull(reader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			// returning cancels the stream so the server sees an error rather than the end of the archive
+			return nil, err
+		}
+		if n > 0 || importTarRequest.Commit != nil {
+			importTarRequest.Value = buffer[:n]
+			if sendErr := importTarClient.Send(importTarRequest); sendErr == io.EOF {
+				// the server has failed, CloseAndRecv returns its error
+				break
+			} else if sendErr != nil {
+				return nil, sendErr
+			}
+			importTarRequest = &pfs.ImportTarRequest{}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return importTarClient.CloseAndRecv()
+}
+
+func Commit(apiClient pfs.ApiClient, repositoryName string, commitID string, message string) error {
 	_, err := apiClient.Commit(
 		context.Background(),
 		&pfs.CommitRequest{
@@ -159,6 +415,42 @@ func Commit(apiClient pfs.ApiClient, repositoryName string, commitID string) err
 				},
 				Id: commitID,
 			},
+			Message: message,
+		},
+	)
+	return err
+}
+
+func SquashCommits(apiClient pfs.ApiClient, repositoryName string, fromCommitID string, toCommitID string, message string) (*pfs.SquashCommitsResponse, error) {
+	repository := &pfs.Repository{
+		Name: repositoryName,
+	}
+	return apiClient.SquashCommits(
+		context.Background(),
+		&pfs.SquashCommitsRequest{
+			FromCommit: &pfs.Commit{
+				Repository: repository,
+				Id:         fromCommitID,
+			},
+			ToCommit: &pfs.Commit{
+				Repository: repository,
+				Id:         toCommitID,
+			},
+			Message: message,
+		},
+	)
+}
+
+func DeleteCommit(apiClient pfs.ApiClient, repositoryName string, commitID string) error {
+	_, err := apiClient.DeleteCommit(
+		context.Background(),
+		&pfs.DeleteCommitRequest{
+			Commit: &pfs.Commit{
+				Repository: &pfs.Repository{
+					Name: repositoryName,
+				},
+				Id: commitID,
+			},
 		},
 	)
 	return err
@@ -189,6 +481,96 @@ func ListCommits(apiClient pfs.ApiClient, repositoryName string) (*pfs.ListCommi
 	)
 }
 
+func CreateBranch(apiClient pfs.ApiClient, repositoryName string, name string, commitID string) error {
+	_, err := apiClient.CreateBranch(
+		context.Background(),
+		&pfs.CreateBranchRequest{
+			Commit: &pfs.Commit{
+				Repository: &pfs.Repository{
+					Name: repositoryName,
+				},
+				Id: commitID,
+			},
+			Name: name,
+		},
+	)
+	return err
+}
+
+func ListBranches(apiClient pfs.ApiClient, repositoryName string) (*pfs.ListBranchesResponse, error) {
+	return apiClient.ListBranches(
+		context.Background(),
+		&pfs.ListBranchesRequest{
+			Repository: &pfs.Repository{
+				Name: repositoryName,
+			},
+		},
+	)
+}
+
+func DeleteBranch(apiClient pfs.ApiClient, repositoryName string, name string) error {
+	_, err := apiClient.DeleteBranch(
+		context.Background(),
+		&pfs.DeleteBranchRequest{
+			Repository: &pfs.Repository{
+				Name: repositoryName,
+			},
+			Name: name,
+		},
+	)
+	return err
+}
+
+func SetRetentionPolicy(apiClient pfs.ApiClient, repositoryName string, retentionPolicy *pfs.RetentionPolicy) error {
+	_, err := apiClient.SetRetentionPolicy(
+		context.Background(),
+		&pfs.SetRetentionPolicyRequest{
+			Repository: &pfs.Repository{
+				Name: repositoryName,
+			},
+			RetentionPolicy: retentionPolicy,
+		},
+	)
+	return err
+}
+
+func GetRetentionPolicy(apiClient pfs.ApiClient, repositoryName string) (*pfs.GetRetentionPolicyResponse, error) {
+	return apiClient.GetRetentionPolicy(
+		context.Background(),
+		&pfs.GetRetentionPolicyRequest{
+			Repository: &pfs.Repository{
+				Name: repositoryName,
+			},
+		},
+	)
+}
+
+// GarbageCollect collects every repository if repositoryName is empty.
+func GarbageCollect(apiClient pfs.ApiClient, repositoryName string, dryRun bool) (*pfs.GarbageCollectResponse, error) {
+	var repository *pfs.Repository
+	if repositoryName != "" {
+		repository = &pfs.Repository{
+			Name: repositoryName,
+		}
+	}
+	return apiClient.GarbageCollect(
+		context.Background(),
+		&pfs.GarbageCollectRequest{
+			Repository: repository,
+			DryRun:     dryRun,
+		},
+	)
+}
+
+func Reshard(apiClient pfs.ApiClient, numShards uint64) (*pfs.ReshardResponse, error) {
+	return apiClient.Reshard(
+		context.Background(),
+		&pfs.ReshardRequest{
+			NumShards: numShards,
+		},
+	)
+}
+
 func PullDiff(internalAPIClient pfs.InternalApiClient, repositoryName string, commitID string, shard uint64, writer io.Writer) error {
 	apiPullDiffClient, err := internalAPIClient.PullDiff(
 		context.Background(),
@@ -15,12 +15,16 @@ type Server interface {
 	// After this returns the Server is expected to service Master requests for shard.
 	Master(shard int) error
 	// Replica tells the server that it is now a replica for shard.
-	// After this returns the Server is expected to service Replica requests for shard.
+	// After this returns the Server is expected to service Replica requests for shard,
+	// so it must already have every commit the master of shard has.
 	Replica(shard int) error
 	// Clear tells the server that it is no longer filling any role for shard.
 	Clear(shard int) error
 }
 
-func NewRoler(addresser route.Addresser, sharder route.Sharder, server Server, localAddress string) Roler {
-	return newRoler(addresser, sharder, server, localAddress)
+// NewRoler returns a Roler that claims master roles, and replica roles until
+// every shard has numReplicas replicas. With no replicas a shard that loses its
+// master goes to another server without its data.
+func NewRoler(addresser route.Addresser, sharder route.Sharder, server Server, localAddress string, numReplicas int) Roler {
+	return newRoler(addresser, sharder, server, localAddress, numReplicas)
 }
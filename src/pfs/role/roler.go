@@ -3,8 +3,11 @@ package role
 import (
 	"log"
 	"math"
+	"math/rand"
+	"sync"
 
 	"github.com/pachyderm/pachyderm/src/pfs/route"
+	"github.com/pachyderm/pachyderm/src/pkg/discovery"
 )
 
 type roler struct {
@@ -12,80 +15,300 @@ type roler struct {
 	sharder      route.Sharder
 	server       Server
 	localAddress string
+	numReplicas  int
 	cancel       chan bool
+	// lock guards replicaCancels, which stop the holds of the replica
+	// roles so that a replica can give its role up when it's promoted.
+	lock           *sync.Mutex
+	replicaCancels map[int]chan bool
 }
 
-func newRoler(addresser route.Addresser, sharder route.Sharder, server Server, localAddress string) *roler {
-	return &roler{addresser, sharder, server, localAddress, make(chan bool)}
+func newRoler(addresser route.Addresser, sharder route.Sharder, server Server, localAddress string, numReplicas int) *roler {
+	return &roler{
+		addresser,
+		sharder,
+		server,
+		localAddress,
+		numReplicas,
+		make(chan bool),
+		&sync.Mutex{},
+		make(map[int]chan bool),
+	}
 }
 
 func (r *roler) Run() error {
-	return r.addresser.WatchShardToMasterAddress(
+	return r.addresser.WatchShardToAddresses(
 		r.cancel,
-		func(shardToMasterAddress map[int]string) error {
-			log.Printf("Find shard: r.localAddress: %s, shardToMasterAddress: %+v", r.localAddress, shardToMasterAddress)
-			counts := r.masterCounts(shardToMasterAddress)
-			minAddress, min := r.minCount(counts)
-			if counts[r.localAddress] > min {
-				// someone else has fewer roles than us let them claim them
-				log.Printf("%s has few roles (%d)", minAddress, min)
-				return nil
-			}
-			shard, ok := r.openShard(shardToMasterAddress)
-			if ok {
-				if err := r.server.Master(shard); err != nil {
-					return err
-				}
-				go func() {
-					r.addresser.HoldMasterAddress(shard, r.localAddress, "", r.cancel)
-					r.server.Clear(shard)
-				}()
-				log.Printf("open: %d -> %s", shard, r.localAddress)
-				return nil
-			}
-
-			maxAddress, max := r.maxCount(counts)
-			if maxAddress == r.localAddress || counts[r.localAddress]+1 > max-1 {
-				// either we're the maxAddress or stealing a role from
-				// maxAddress would make us the new maxAddress that'd cause
-				// flappying which is bad
-				log.Printf("maxAddress: %s, max: %d, r.localAddress: %s, counts[r.localAddress]: %d", maxAddress, max, r.localAddress, counts[r.localAddress])
-				return nil
-			}
-			shard, ok = r.randomShard(maxAddress, shardToMasterAddress)
-			if ok {
-				log.Printf("Stealing shard %d from %s", shard, maxAddress)
-				if err := r.server.Master(shard); err != nil {
-					return err
-				}
-				go func() {
-					r.addresser.HoldMasterAddress(shard, r.localAddress, maxAddress, r.cancel)
-					r.server.Clear(shard)
-				}()
-			} else {
-				log.Print("wtf")
+		func(shardToMasterAddress map[int]string, shardToReplicaAddresses map[int]map[string]bool) error {
+			// one role per change, claiming a role changes the addresses
+			// again and we'll be called back with them
+			ok, err := r.claimMaster(shardToMasterAddress)
+			if err != nil || ok {
+				return err
 			}
-			return nil
+			return r.claimReplica(shardToMasterAddress, shardToReplicaAddresses)
 		},
 	)
 }
 
+func (r *roler) claimMaster(shardToMasterAddress map[int]string) (bool, error) {
+	log.Printf("Find shard: r.localAddress: %s, shardToMasterAddress: %+v", r.localAddress, shardToMasterAddress)
+	// a shard that lost its master can only go to a server that has all of
+	// its data, however many roles that server has, anyone else would
+	// serve stale data
+	shard, ok, err := r.failoverShard(shardToMasterAddress)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return r.promote(shard)
+	}
+	if r.numReplicas == 0 {
+		// without replicas nobody has the data of a shard that lost its
+		// master, it would never get one again if we waited for a caught
+		// up server
+		shard, ok, err := r.lostShard(shardToMasterAddress)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			log.Printf("shard %d has no replicas, its data is lost", shard)
+			return r.promote(shard)
+		}
+	}
+	counts := r.masterCounts(shardToMasterAddress)
+	minAddress, min := r.minCount(counts)
+	if counts[r.localAddress] > min {
+		// someone else has fewer roles than us let them claim them
+		log.Printf("%s has few roles (%d)", minAddress, min)
+		return false, nil
+	}
+	shard, ok, err = r.openShard(shardToMasterAddress)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		if err := r.master(shard, ""); err != nil {
+			return false, err
+		}
+		log.Printf("open: %d -> %s", shard, r.localAddress)
+		return true, nil
+	}
+
+	maxAddress, max := r.maxCount(counts)
+	if maxAddress == r.localAddress || counts[r.localAddress]+1 > max-1 {
+		// either we're the maxAddress or stealing a role from
+		// maxAddress would make us the new maxAddress that'd cause
+		// flappying which is bad
+		log.Printf("maxAddress: %s, max: %d, r.localAddress: %s, counts[r.localAddress]: %d", maxAddress, max, r.localAddress, counts[r.localAddress])
+		return false, nil
+	}
+	shard, ok, err = r.randomShard(maxAddress, shardToMasterAddress)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		log.Printf("Stealing shard %d from %s", shard, maxAddress)
+		if err := r.master(shard, maxAddress); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	// none of the shards of maxAddress are ones we're caught up with
+	return false, nil
+}
+
+// promote makes us the master of shard, which has no master. The caught up
+// replicas race for it, so the role is taken before the server is told, with
+// the ttl of a hold so that it expires if we die before holding it.
+func (r *roler) promote(shard int) (bool, error) {
+	if err := r.addresser.CheckAndSetMasterAddress(shard, r.localAddress, "", discovery.HoldTTL); err != nil {
+		log.Printf("another server was promoted to master of %d: %s", shard, err.Error())
+		return false, nil
+	}
+	if err := r.master(shard, r.localAddress); err != nil {
+		return false, err
+	}
+	log.Printf("promote: %d -> %s", shard, r.localAddress)
+	return true, nil
+}
+
+// master tells the server it's the master of shard and holds the role, which
+// prevAddress has. A replica of shard gives that role up.
+func (r *roler) master(shard int, prevAddress string) error {
+	if err := r.releaseReplica(shard); err != nil {
+		return err
+	}
+	if err := r.server.Master(shard); err != nil {
+		return err
+	}
+	go func() {
+		r.addresser.HoldMasterAddress(shard, r.localAddress, prevAddress, r.cancel)
+		r.server.Clear(shard)
+	}()
+	return nil
+}
+
+// releaseReplica gives up the replica role of shard, if we have it.
+func (r *roler) releaseReplica(shard int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cancel, ok := r.replicaCancels[shard]
+	if !ok {
+		return nil
+	}
+	close(cancel)
+	delete(r.replicaCancels, shard)
+	return r.addresser.DeleteReplicaAddress(shard, r.localAddress)
+}
+
+// claimReplica becomes a replica of a shard that has a master and fewer than
+// numReplicas replicas. The server backfills the shard before the replica is
+// advertised, so the replicas in the addresser are always ready.
+func (r *roler) claimReplica(shardToMasterAddress map[int]string, shardToReplicaAddresses map[int]map[string]bool) error {
+	counts := r.replicaCounts(shardToReplicaAddresses)
+	minAddress, min := r.minCount(counts)
+	if counts[r.localAddress] > min {
+		// someone else has fewer replicas than us let them claim them
+		log.Printf("%s has few replicas (%d)", minAddress, min)
+		return nil
+	}
+	shard, ok := r.openReplicaShard(shardToMasterAddress, shardToReplicaAddresses)
+	if !ok {
+		return nil
+	}
+	log.Printf("replica: %d -> %s", shard, r.localAddress)
+	if err := r.server.Replica(shard); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	select {
+	case <-r.cancel:
+		return nil
+	default:
+	}
+	cancel := make(chan bool)
+	r.replicaCancels[shard] = cancel
+	go func() {
+		r.addresser.HoldReplicaAddress(shard, r.localAddress, cancel)
+		select {
+		case <-cancel:
+			// we gave the role up
+		default:
+			r.server.Clear(shard)
+		}
+	}()
+	return nil
+}
+
 func (r *roler) Cancel() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	close(r.cancel)
+	for shard, cancel := range r.replicaCancels {
+		close(cancel)
+		delete(r.replicaCancels, shard)
+	}
 }
 
 type counts map[string]int
 
-func (r *roler) openShard(shardToMasterAddress map[int]string) (int, bool) {
+// openShard returns a shard that has no master and no data.
+func (r *roler) openShard(shardToMasterAddress map[int]string) (int, bool, error) {
 	for i := 0; i < r.sharder.NumShards(); i++ {
 		if _, ok := shardToMasterAddress[i]; !ok {
-			return i, true
+			version, _, err := r.getVersions(i)
+			if err != nil {
+				return 0, false, err
+			}
+			if version == 0 {
+				return i, true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// failoverShard returns a shard that has no master but has data, all of which
+// we have.
+func (r *roler) failoverShard(shardToMasterAddress map[int]string) (int, bool, error) {
+	for i := 0; i < r.sharder.NumShards(); i++ {
+		if _, ok := shardToMasterAddress[i]; !ok {
+			version, localVersion, err := r.getVersions(i)
+			if err != nil {
+				return 0, false, err
+			}
+			if version > 0 && localVersion == version {
+				return i, true, nil
+			}
 		}
 	}
-	return 0, false
+	return 0, false, nil
 }
 
-func (r *roler) randomShard(address string, shardToMasterAddress map[int]string) (int, bool) {
+// lostShard returns a shard that has no master but has data.
+func (r *roler) lostShard(shardToMasterAddress map[int]string) (int, bool, error) {
+	for i := 0; i < r.sharder.NumShards(); i++ {
+		if _, ok := shardToMasterAddress[i]; !ok {
+			version, _, err := r.getVersions(i)
+			if err != nil {
+				return 0, false, err
+			}
+			if version > 0 {
+				return i, true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// getVersions returns the highest version any server has of shard, 0 if no
+// diffs were ever pushed to it, and the version we have.
+func (r *roler) getVersions(shard int) (uint64, uint64, error) {
+	versions, err := r.addresser.GetShardVersions(shard)
+	if err != nil {
+		return 0, 0, err
+	}
+	var result uint64
+	for _, version := range versions {
+		if version > result {
+			result = version
+		}
+	}
+	return result, versions[r.localAddress], nil
+}
+
+// openReplicaShard returns one of the shards with the fewest replicas, if
+// that is fewer than numReplicas, that has a master other than us and that
+// we aren't a replica of.
+func (r *roler) openReplicaShard(shardToMasterAddress map[int]string, shardToReplicaAddresses map[int]map[string]bool) (int, bool) {
+	var shards []int
+	min := r.numReplicas
+	for shard, address := range shardToMasterAddress {
+		if shard >= r.sharder.NumShards() || address == r.localAddress || shardToReplicaAddresses[shard][r.localAddress] {
+			continue
+		}
+		numReplicas := len(shardToReplicaAddresses[shard])
+		if numReplicas >= r.numReplicas {
+			continue
+		}
+		if numReplicas < min {
+			shards = nil
+			min = numReplicas
+		}
+		if numReplicas == min {
+			shards = append(shards, shard)
+		}
+	}
+	if len(shards) == 0 {
+		return 0, false
+	}
+	// a random shard, so that servers claiming at once spread out
+	return shards[rand.Intn(len(shards))], true
+}
+
+func (r *roler) randomShard(address string, shardToMasterAddress map[int]string) (int, bool, error) {
 	// we want this function to return a random shard which belongs to address
 	// so that not everyone tries to steal the same shard since Go 1 the
 	// runtime randomizes iteration of maps to prevent people from depending on
@@ -95,10 +318,17 @@ func (r *roler) randomShard(address string, shardToMasterAddress map[int]string)
 	// is all still correct if the order isn't random.
 	for shard, iAddress := range shardToMasterAddress {
 		if address == iAddress {
-			return shard, true
+			// only a shard we have all the data of can be taken over
+			version, localVersion, err := r.getVersions(shard)
+			if err != nil {
+				return 0, false, err
+			}
+			if localVersion == version {
+				return shard, true, nil
+			}
 		}
 	}
-	return 0, false
+	return 0, false, nil
 }
 
 func (r *roler) masterCounts(shardToMasterAddress map[int]string) counts {
@@ -109,6 +339,16 @@ func (r *roler) masterCounts(shardToMasterAddress map[int]string) counts {
 	return result
 }
 
+func (r *roler) replicaCounts(shardToReplicaAddresses map[int]map[string]bool) counts {
+	result := make(map[string]int)
+	for _, addresses := range shardToReplicaAddresses {
+		for address := range addresses {
+			result[address]++
+		}
+	}
+	return result
+}
+
 func (r *roler) minCount(counts counts) (string, int) {
 	address := ""
 	result := math.MaxInt64
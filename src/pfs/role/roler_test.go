@@ -16,8 +16,9 @@ import (
 )
 
 const (
-	testNumShards  = 4
-	testNumServers = 2
+	testNumShards   = 4
+	testNumServers  = 2
+	testNumReplicas = 1
 )
 
 func TestRoler(t *testing.T) {
@@ -26,6 +27,60 @@ func TestRoler(t *testing.T) {
 	runTest(t, client)
 }
 
+func TestFailover(t *testing.T) {
+	addresser := route.NewDiscoveryAddresser(discovery.NewMockClient(), "TestFailover")
+	sharder := route.NewSharder(1)
+	// shard 0 lost its master, which had version 2
+	require.NoError(t, addresser.SetShardVersion(0, "master", 2))
+	require.NoError(t, addresser.SetShardVersion(0, "behind", 1))
+	behindServer := newServer()
+	behind := newRoler(addresser, sharder, behindServer, "behind", testNumReplicas)
+	defer behind.Cancel()
+	_, ok, err := behind.failoverShard(map[int]string{})
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = behind.claimMaster(map[int]string{})
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, 0, len(behindServer.roles))
+
+	// the caught up replicas race for it and only the first one wins
+	for i, address := range []string{"caught-up-0", "caught-up-1"} {
+		require.NoError(t, addresser.SetShardVersion(0, address, 2))
+		server := newServer()
+		roler := newRoler(addresser, sharder, server, address, testNumReplicas)
+		defer roler.Cancel()
+		shard, ok, err := roler.failoverShard(map[int]string{})
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, 0, shard)
+		ok, err = roler.promote(shard)
+		require.NoError(t, err)
+		require.Equal(t, i == 0, ok)
+		require.Equal(t, i == 0, server.roles[0] == "master")
+	}
+	shardToMasterAddress, err := addresser.GetShardToMasterAddress()
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{0: "caught-up-0"}, shardToMasterAddress)
+}
+
+func TestFailoverWithoutReplicas(t *testing.T) {
+	addresser := route.NewDiscoveryAddresser(discovery.NewMockClient(), "TestFailoverWithoutReplicas")
+	sharder := route.NewSharder(1)
+	// shard 0 lost its master and nobody else has its data
+	require.NoError(t, addresser.SetShardVersion(0, "master", 2))
+	server := newServer()
+	roler := newRoler(addresser, sharder, server, "other", 0)
+	defer roler.Cancel()
+	ok, err := roler.claimMaster(map[int]string{})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "master", server.roles[0])
+	shardToMasterAddress, err := addresser.GetShardToMasterAddress()
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{0: "other"}, shardToMasterAddress)
+}
+
 type server struct {
 	roles map[int]string
 }
@@ -60,7 +115,7 @@ func NewServerGroup(addresser route.Addresser, numServers int, offset int) *serv
 	serverGroup := serverGroup{}
 	for i := 0; i < numServers; i++ {
 		serverGroup.servers = append(serverGroup.servers, newServer())
-		serverGroup.rolers = append(serverGroup.rolers, NewRoler(addresser, sharder, serverGroup.servers[i], fmt.Sprintf("server-%d", i+offset)))
+		serverGroup.rolers = append(serverGroup.rolers, NewRoler(addresser, sharder, serverGroup.servers[i], fmt.Sprintf("server-%d", i+offset), testNumReplicas))
 	}
 	return &serverGroup
 }
@@ -83,10 +138,14 @@ func (s *serverGroup) cancel() {
 	}
 }
 
-func (s *serverGroup) satisfied(rolesLen int) bool {
+func (s *serverGroup) satisfied(masters int, replicas int) bool {
 	for _, server := range s.servers {
-		if len(server.roles) != rolesLen {
-			log.Printf("len(server.roles): %d, rolesLen: %d", len(server.roles), rolesLen)
+		counts := make(map[string]int)
+		for _, role := range server.roles {
+			counts[role]++
+		}
+		if counts["master"] != masters || counts["replica"] != replicas {
+			log.Printf("counts: %+v, masters: %d, replicas: %d", counts, masters, replicas)
 			return false
 		}
 	}
@@ -99,7 +158,8 @@ func runTest(t *testing.T, client discovery.Client) {
 	log.Print("===Starting group 1===")
 	go serverGroup1.run(t)
 	start := time.Now()
-	for !serverGroup1.satisfied(testNumShards / (testNumServers / 2)) {
+	// a single server can't replicate its own shards
+	for !serverGroup1.satisfied(testNumShards/(testNumServers/2), 0) {
 		time.Sleep(3 * time.Second)
 		if time.Since(start) > time.Second*time.Duration(10) {
 			t.Fatal("test timed out")
@@ -110,7 +170,7 @@ func runTest(t *testing.T, client discovery.Client) {
 	serverGroup2 := NewServerGroup(addresser, testNumServers/2, testNumServers/2)
 	go serverGroup2.run(t)
 	start = time.Now()
-	for !serverGroup1.satisfied(testNumShards/testNumServers) || !serverGroup2.satisfied(testNumShards/testNumServers) {
+	for !serverGroup1.satisfied(testNumShards/testNumServers, testNumShards/testNumServers) || !serverGroup2.satisfied(testNumShards/testNumServers, testNumShards/testNumServers) {
 		time.Sleep(3 * time.Second)
 		if time.Since(start) > time.Second*time.Duration(10) {
 			t.Fatal("test timed out")
@@ -119,7 +179,9 @@ func runTest(t *testing.T, client discovery.Client) {
 
 	log.Print("===Stoping group 1===")
 	serverGroup1.cancel()
-	for !serverGroup2.satisfied(testNumShards) {
+	// group 2 takes every master, including those of the shards it was a
+	// replica of
+	for !serverGroup2.satisfied(testNumShards, 0) {
 		time.Sleep(3 * time.Second)
 		if time.Since(start) > time.Second*time.Duration(30) {
 			t.Fatal("test timed out")
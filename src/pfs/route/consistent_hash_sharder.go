@@ -0,0 +1,90 @@
+package route
+
+import (
+	"fmt"
+	"hash/crc32"
+	"path"
+	"sort"
+
+	"github.com/pachyderm/pachyderm/src/pfs"
+)
+
+// consistentHashSharder places numVirtualNodes points per shard on a ring of
+// hashes, a path maps to the shard of the first point at or after its hash.
+// Adding shards only adds points, so the only paths that move are the ones
+// that move to the new shards.
+type consistentHashSharder struct {
+	numShards       int
+	numVirtualNodes int
+	points          []uint32
+	pointToShard    map[uint32]int
+}
+
+func newConsistentHashSharder(numShards int, numVirtualNodes int) *consistentHashSharder {
+	if numVirtualNodes < 1 {
+		numVirtualNodes = 1
+	}
+	pointToShard := make(map[uint32]int)
+	for shard := 0; shard < numShards; shard++ {
+		for node := 0; node < numVirtualNodes; node++ {
+			point := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%d-%d", shard, node)))
+			// on a collision the lower shard keeps the point, so adding
+			// shards never moves it
+			if _, ok := pointToShard[point]; !ok {
+				pointToShard[point] = shard
+			}
+		}
+	}
+	points := make([]uint32, 0, len(pointToShard))
+	for point := range pointToShard {
+		points = append(points, point)
+	}
+	sort.Sort(uint32s(points))
+	return &consistentHashSharder{
+		numShards,
+		numVirtualNodes,
+		points,
+		pointToShard,
+	}
+}
+
+func (s *consistentHashSharder) NumShards() int {
+	return s.numShards
+}
+
+func (s *consistentHashSharder) GetShard(pfsPath *pfs.Path) (int, error) {
+	if len(s.points) == 0 {
+		return 0, fmt.Errorf("pachyderm: no shards")
+	}
+	hash := crc32.ChecksumIEEE([]byte(path.Clean(pfsPath.Path)))
+	i := sort.Search(len(s.points), func(i int) bool { return s.points[i] >= hash })
+	if i == len(s.points) {
+		i = 0
+	}
+	return s.pointToShard[s.points[i]], nil
+}
+
+func (s *consistentHashSharder) GetDirectoryShard(pfsPath *pfs.Path, recursive bool) (int, bool, error) {
+	return 0, false, nil
+}
+
+func (s *consistentHashSharder) WithNumShards(numShards int) (Sharder, error) {
+	if numShards < s.numShards {
+		return nil, fmt.Errorf("pachyderm: can't reduce the number of shards from %d to %d", s.numShards, numShards)
+	}
+	return newConsistentHashSharder(numShards, s.numVirtualNodes), nil
+}
+
+type uint32s []uint32
+
+func (u uint32s) Len() int {
+	return len(u)
+}
+
+func (u uint32s) Less(i, j int) bool {
+	return u[i] < u[j]
+}
+
+func (u uint32s) Swap(i, j int) {
+	u[i], u[j] = u[j], u[i]
+}
@@ -0,0 +1,79 @@
+package route
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pachyderm/pachyderm/src/pfs"
+)
+
+// directorySharder maps a file to the shard sharder maps its parent directory
+// to, cut to depth elements if depth is more than 0.
+type directorySharder struct {
+	sharder Sharder
+	depth   int
+}
+
+func newDirectorySharder(sharder Sharder, depth int) *directorySharder {
+	return &directorySharder{sharder, depth}
+}
+
+func (s *directorySharder) NumShards() int {
+	return s.sharder.NumShards()
+}
+
+func (s *directorySharder) GetShard(pfsPath *pfs.Path) (int, error) {
+	return s.getKeyShard(pfsPath, cleanDir(path.Dir(cleanDir(pfsPath.Path))))
+}
+
+func (s *directorySharder) GetDirectoryShard(pfsPath *pfs.Path, recursive bool) (int, bool, error) {
+	dir := cleanDir(pfsPath.Path)
+	elements := splitDir(dir)
+	// below depth every directory maps to the same key, above it only the
+	// files directly in the directory do
+	if recursive && (s.depth == 0 || len(elements) < s.depth) {
+		return 0, false, nil
+	}
+	shard, err := s.getKeyShard(pfsPath, dir)
+	if err != nil {
+		return 0, false, err
+	}
+	return shard, true, nil
+}
+
+func (s *directorySharder) WithNumShards(numShards int) (Sharder, error) {
+	sharder, err := s.sharder.WithNumShards(numShards)
+	if err != nil {
+		return nil, err
+	}
+	return newDirectorySharder(sharder, s.depth), nil
+}
+
+// getKeyShard returns the shard of the files directly in dir, which must be
+// cleaned with cleanDir so that every spelling of a directory gets one shard.
+func (s *directorySharder) getKeyShard(pfsPath *pfs.Path, dir string) (int, error) {
+	elements := splitDir(dir)
+	if s.depth > 0 && len(elements) > s.depth {
+		elements = elements[:s.depth]
+	}
+	return s.sharder.GetShard(
+		&pfs.Path{
+			Commit: pfsPath.Commit,
+			Path:   strings.Join(elements, "/"),
+		},
+	)
+}
+
+// cleanDir cleans p and removes its leading and trailing slashes, the root is
+// the empty string.
+func cleanDir(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+func splitDir(dir string) []string {
+	dir = cleanDir(dir)
+	if dir == "" {
+		return nil
+	}
+	return strings.Split(dir, "/")
+}
@@ -2,6 +2,7 @@ package route
 
 import (
 	"fmt"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
@@ -58,41 +59,59 @@ func (a *discoveryAddresser) WatchShardToMasterAddress(cancel chan bool, callBac
 }
 
 func (a *discoveryAddresser) GetShardToReplicaAddresses() (map[int]map[string]bool, error) {
-	base := a.replicaDir()
-	addresses, err := a.discoveryClient.GetAll(base)
+	addresses, err := a.discoveryClient.GetAll(a.replicaDir())
 	if err != nil {
 		return nil, err
 	}
-	m := make(map[int]map[string]bool, 0)
-	for shardString, address := range addresses {
-		shardString = strings.TrimPrefix(shardString, fmt.Sprintf("%s/", base))
-		shardString = strings.Split(shardString, "/")[0]
-		shard, err := strconv.ParseInt(shardString, 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		if _, ok := m[int(shard)]; !ok {
-			m[int(shard)] = make(map[string]bool, 0)
-		}
-		m[int(shard)][address] = true
-	}
-	return m, nil
+	return a.makeReplicaMap(addresses)
+}
+
+func (a *discoveryAddresser) WatchShardToAddresses(cancel chan bool, callBack func(map[int]string, map[int]map[string]bool) error) error {
+	return a.discoveryClient.WatchAll(
+		a.shardDir(),
+		cancel,
+		func(addresses map[string]string) error {
+			masterAddresses := make(map[string]string)
+			replicaAddresses := make(map[string]string)
+			for key, address := range addresses {
+				switch {
+				case strings.HasPrefix(key, a.masterDir()+"/"):
+					masterAddresses[key] = address
+				case strings.HasPrefix(key, a.replicaDir()+"/"):
+					replicaAddresses[key] = address
+				}
+			}
+			shardToMasterAddress, err := a.makeMasterMap(masterAddresses)
+			if err != nil {
+				return err
+			}
+			shardToReplicaAddresses, err := a.makeReplicaMap(replicaAddresses)
+			if err != nil {
+				return err
+			}
+			return callBack(shardToMasterAddress, shardToReplicaAddresses)
+		},
+	)
 }
 
 func (a *discoveryAddresser) SetMasterAddress(shard int, address string, ttl uint64) error {
 	return a.discoveryClient.Set(a.masterKey(shard), address, ttl)
 }
 
+func (a *discoveryAddresser) CheckAndSetMasterAddress(shard int, address string, prevAddress string, ttl uint64) error {
+	return a.discoveryClient.CheckAndSet(a.masterKey(shard), address, ttl, prevAddress)
+}
+
 func (a *discoveryAddresser) HoldMasterAddress(shard int, address string, prevAddress string, cancel chan bool) error {
 	return a.discoveryClient.Hold(a.masterKey(shard), address, prevAddress, cancel)
 }
 
 func (a *discoveryAddresser) SetReplicaAddress(shard int, address string, ttl uint64) error {
-	return a.discoveryClient.CreateInDir(a.replicaKey(shard), address, ttl)
+	return a.discoveryClient.Set(a.replicaAddressKey(shard, address), address, ttl)
 }
 
-func (a *discoveryAddresser) HoldReplicaAddress(shard int, address string, prevAddress string, cancel chan bool) error {
-	return a.discoveryClient.Hold(a.replicaKey(shard), address, prevAddress, cancel)
+func (a *discoveryAddresser) HoldReplicaAddress(shard int, address string, cancel chan bool) error {
+	return a.discoveryClient.Hold(a.replicaAddressKey(shard, address), address, "", cancel)
 }
 
 func (a *discoveryAddresser) DeleteMasterAddress(shard int) error {
@@ -100,11 +119,73 @@ func (a *discoveryAddresser) DeleteMasterAddress(shard int) error {
 }
 
 func (a *discoveryAddresser) DeleteReplicaAddress(shard int, address string) error {
-	return a.discoveryClient.Delete(a.replicaKey(shard))
+	return a.discoveryClient.Delete(a.replicaAddressKey(shard, address))
+}
+
+func (a *discoveryAddresser) GetShardVersions(shard int) (map[string]uint64, error) {
+	versions, err := a.discoveryClient.GetAll(a.versionKey(shard))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]uint64, 0)
+	for key, versionString := range versions {
+		address, err := url.QueryUnescape(path.Base(key))
+		if err != nil {
+			return nil, err
+		}
+		version, err := strconv.ParseUint(versionString, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		result[address] = version
+	}
+	return result, nil
+}
+
+func (a *discoveryAddresser) SetShardVersion(shard int, address string, version uint64) error {
+	return a.discoveryClient.Set(path.Join(a.versionKey(shard), url.QueryEscape(address)), fmt.Sprint(version), 0)
+}
+
+func (a *discoveryAddresser) GetNumShards() (int, bool, error) {
+	numShardsString, ok, err := a.discoveryClient.Get(a.numShardsKey())
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	numShards, err := strconv.Atoi(numShardsString)
+	if err != nil {
+		return 0, false, err
+	}
+	return numShards, true, nil
+}
+
+func (a *discoveryAddresser) SetNumShards(numShards int) error {
+	return a.discoveryClient.Set(a.numShardsKey(), fmt.Sprint(numShards), 0)
+}
+
+func (a *discoveryAddresser) WatchNumShards(cancel chan bool, callBack func(int) error) error {
+	return a.discoveryClient.Watch(
+		a.numShardsKey(),
+		cancel,
+		func(numShardsString string) error {
+			// the key doesn't exist
+			if numShardsString == "" {
+				return nil
+			}
+			numShards, err := strconv.Atoi(numShardsString)
+			if err != nil {
+				return err
+			}
+			return callBack(numShards)
+		},
+	)
+}
+
+func (a *discoveryAddresser) shardDir() string {
+	return fmt.Sprintf("%s/pfs/shard", a.namespace)
 }
 
 func (a *discoveryAddresser) masterDir() string {
-	return fmt.Sprintf("%s/pfs/shard/master", a.namespace)
+	return path.Join(a.shardDir(), "master")
 }
 
 func (a *discoveryAddresser) masterKey(shard int) string {
@@ -112,13 +193,29 @@ func (a *discoveryAddresser) masterKey(shard int) string {
 }
 
 func (a *discoveryAddresser) replicaDir() string {
-	return fmt.Sprintf("%s/pfs/shard/replica", a.namespace)
+	return path.Join(a.shardDir(), "replica")
 }
 
 func (a *discoveryAddresser) replicaKey(shard int) string {
 	return path.Join(a.replicaDir(), fmt.Sprint(shard))
 }
 
+// versionKey is outside of shardDir so that new versions don't wake up the
+// watchers of the addresses.
+func (a *discoveryAddresser) versionKey(shard int) string {
+	return path.Join(fmt.Sprintf("%s/pfs/version", a.namespace), fmt.Sprint(shard))
+}
+
+func (a *discoveryAddresser) numShardsKey() string {
+	return fmt.Sprintf("%s/pfs/num_shards", a.namespace)
+}
+
+// replicaAddressKey is the key of address in the replicas of shard, addresses
+// are escaped so that they are always one element of the key.
+func (a *discoveryAddresser) replicaAddressKey(shard int, address string) string {
+	return path.Join(a.replicaKey(shard), url.QueryEscape(address))
+}
+
 func (a *discoveryAddresser) makeMasterMap(addresses map[string]string) (map[int]string, error) {
 	result := make(map[int]string, 0)
 	for shardString, address := range addresses {
@@ -130,3 +227,20 @@ func (a *discoveryAddresser) makeMasterMap(addresses map[string]string) (map[int
 	}
 	return result, nil
 }
+
+func (a *discoveryAddresser) makeReplicaMap(addresses map[string]string) (map[int]map[string]bool, error) {
+	result := make(map[int]map[string]bool, 0)
+	for shardString, address := range addresses {
+		shardString = strings.TrimPrefix(shardString, fmt.Sprintf("%s/", a.replicaDir()))
+		shardString = strings.Split(shardString, "/")[0]
+		shard, err := strconv.ParseInt(shardString, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := result[int(shard)]; !ok {
+			result[int(shard)] = make(map[string]bool, 0)
+		}
+		result[int(shard)][address] = true
+	}
+	return result, nil
+}
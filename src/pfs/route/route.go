@@ -10,14 +10,37 @@ import (
 type Sharder interface {
 	NumShards() int
 	GetShard(path *pfs.Path) (int, error)
+	// GetDirectoryShard returns the shard of every file directly in the
+	// directory path, and every file below it if recursive, it returns false
+	// if they can be on more than one shard.
+	GetDirectoryShard(path *pfs.Path, recursive bool) (int, bool, error)
+	// WithNumShards returns a Sharder with numShards shards that maps paths
+	// the way this one does wherever it can.
+	WithNumShards(numShards int) (Sharder, error)
 }
 
 func NewSharder(numShards int) Sharder {
 	return newSharder(numShards)
 }
 
+// NewDirectorySharder returns a Sharder that maps a file to the shard sharder
+// maps its parent directory to, so the files of a directory share a shard.
+// If depth is more than 0 the directory is cut to its first depth elements,
+// so all the files below a directory that deep share a shard.
+func NewDirectorySharder(sharder Sharder, depth int) Sharder {
+	return newDirectorySharder(sharder, depth)
+}
+
+// NewConsistentHashSharder returns a Sharder that hashes paths onto a ring
+// with numVirtualNodes points per shard. Growing it with WithNumShards only
+// moves paths onto the new shards.
+func NewConsistentHashSharder(numShards int, numVirtualNodes int) Sharder {
+	return newConsistentHashSharder(numShards, numVirtualNodes)
+}
+
 // namespace/pfs/shard/num/master -> address
 // namespace/pfs/shard/num/replica/address -> true
+// namespace/pfs/num_shards -> the number of shards
 
 type Addresser interface {
 	// TODO consider splitting Addresser's interface into read an write methods.
@@ -27,12 +50,31 @@ type Addresser interface {
 	GetShardToMasterAddress() (map[int]string, error)
 	WatchShardToMasterAddress(chan bool, func(map[int]string) error) error
 	GetShardToReplicaAddresses() (map[int]map[string]bool, error)
+	// WatchShardToAddresses calls callBack with the masters and the
+	// replicas of every shard whenever either changes.
+	WatchShardToAddresses(chan bool, func(map[int]string, map[int]map[string]bool) error) error
 	SetMasterAddress(shard int, address string, ttl uint64) error
+	// CheckAndSetMasterAddress makes address the master of shard only if
+	// prevAddress still is, an empty prevAddress means shard has no master.
+	CheckAndSetMasterAddress(shard int, address string, prevAddress string, ttl uint64) error
 	HoldMasterAddress(shard int, address string, prevAddress string, cancel chan bool) error
 	SetReplicaAddress(shard int, address string, ttl uint64) error
-	HoldReplicaAddress(shard int, address string, prevAddress string, cancel chan bool) error
+	// HoldReplicaAddress is like HoldMasterAddress, but every replica of a
+	// shard holds its own key so it never takes the role from another.
+	HoldReplicaAddress(shard int, address string, cancel chan bool) error
 	DeleteMasterAddress(shard int) error
 	DeleteReplicaAddress(shard int, address string) error
+	// GetShardVersions returns the version of shard that each address has,
+	// the number of diffs of shard it has applied.
+	GetShardVersions(shard int) (map[string]uint64, error)
+	SetShardVersion(shard int, address string, version uint64) error
+	// GetNumShards returns the number of shards the cluster uses, the bool
+	// is false if it was never set.
+	GetNumShards() (int, bool, error)
+	SetNumShards(numShards int) error
+	// WatchNumShards calls callBack with the number of shards whenever it
+	// is set.
+	WatchNumShards(chan bool, func(int) error) error
 }
 
 func NewDiscoveryAddresser(discoveryClient discovery.Client, namespace string) Addresser {
@@ -46,6 +88,13 @@ type Router interface {
 	GetMasterOrReplicaClientConn(shard int) (*grpc.ClientConn, error)
 	GetReplicaClientConns(shard int) ([]*grpc.ClientConn, error)
 	GetAllClientConns() ([]*grpc.ClientConn, error)
+	// GetVersion returns the highest version any server has of shard.
+	GetVersion(shard int) (uint64, error)
+	// SetLocalVersion records the version of shard the local server has.
+	SetLocalVersion(shard int, version uint64) error
+	GetNumShards() (int, bool, error)
+	SetNumShards(numShards int) error
+	WatchNumShards(chan bool, func(int) error) error
 }
 
 func NewRouter(
@@ -2,6 +2,8 @@ package route
 
 import (
 	"fmt"
+	"math/rand"
+	"sort"
 
 	"github.com/pachyderm/pachyderm/src/pkg/grpcutil"
 	"google.golang.org/grpc"
@@ -64,13 +66,20 @@ func (r *router) GetMasterClientConn(shard int) (*grpc.ClientConn, error) {
 	return r.dialer.Dial(address)
 }
 
+// GetMasterOrReplicaClientConn picks one of the replicas of shard at random,
+// so reads spread across them, and the master if shard has no replicas.
 func (r *router) GetMasterOrReplicaClientConn(shard int) (*grpc.ClientConn, error) {
 	addresses, err := r.addresser.GetReplicaAddresses(shard)
 	if err != nil {
 		return nil, err
 	}
-	for address := range addresses {
-		return r.dialer.Dial(address)
+	if len(addresses) > 0 {
+		sortedAddresses := make([]string, 0, len(addresses))
+		for address := range addresses {
+			sortedAddresses = append(sortedAddresses, address)
+		}
+		sort.Strings(sortedAddresses)
+		return r.dialer.Dial(sortedAddresses[rand.Intn(len(sortedAddresses))])
 	}
 	address, ok, err := r.addresser.GetMasterAddress(shard)
 	if err != nil {
@@ -82,14 +91,23 @@ func (r *router) GetMasterOrReplicaClientConn(shard int) (*grpc.ClientConn, erro
 	return r.dialer.Dial(address)
 }
 
+// GetReplicaClientConns returns the replicas of shard other than the local
+// server in random order, so reads that walk them spread across them.
 func (r *router) GetReplicaClientConns(shard int) ([]*grpc.ClientConn, error) {
 	addresses, err := r.addresser.GetReplicaAddresses(shard)
 	if err != nil {
 		return nil, err
 	}
-	var result []*grpc.ClientConn
+	sortedAddresses := make([]string, 0, len(addresses))
 	for address := range addresses {
-		conn, err := r.dialer.Dial(address)
+		if address != r.localAddress {
+			sortedAddresses = append(sortedAddresses, address)
+		}
+	}
+	sort.Strings(sortedAddresses)
+	var result []*grpc.ClientConn
+	for _, i := range rand.Perm(len(sortedAddresses)) {
+		conn, err := r.dialer.Dial(sortedAddresses[i])
 		if err != nil {
 			return nil, err
 		}
@@ -117,6 +135,36 @@ func (r *router) GetAllClientConns() ([]*grpc.ClientConn, error) {
 	return clientConns, nil
 }
 
+func (r *router) GetVersion(shard int) (uint64, error) {
+	versions, err := r.addresser.GetShardVersions(shard)
+	if err != nil {
+		return 0, err
+	}
+	var result uint64
+	for _, version := range versions {
+		if version > result {
+			result = version
+		}
+	}
+	return result, nil
+}
+
+func (r *router) SetLocalVersion(shard int, version uint64) error {
+	return r.addresser.SetShardVersion(shard, r.localAddress, version)
+}
+
+func (r *router) GetNumShards() (int, bool, error) {
+	return r.addresser.GetNumShards()
+}
+
+func (r *router) SetNumShards(numShards int) error {
+	return r.addresser.SetNumShards(numShards)
+}
+
+func (r *router) WatchNumShards(cancel chan bool, callBack func(int) error) error {
+	return r.addresser.WatchNumShards(cancel, callBack)
+}
+
 func (r *router) getAllAddresses() (map[string]bool, error) {
 	m := make(map[string]bool, 0)
 	shardToMasterAddress, err := r.addresser.GetShardToMasterAddress()
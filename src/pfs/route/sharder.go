@@ -1,6 +1,7 @@
 package route
 
 import (
+	"fmt"
 	"hash/adler32"
 	"path"
 
@@ -22,3 +23,15 @@ func (s *sharder) NumShards() int {
 func (s *sharder) GetShard(pfsPath *pfs.Path) (int, error) {
 	return int(adler32.Checksum([]byte(path.Clean(pfsPath.Path)))) % s.numShards, nil
 }
+
+func (s *sharder) GetDirectoryShard(pfsPath *pfs.Path, recursive bool) (int, bool, error) {
+	return 0, false, nil
+}
+
+func (s *sharder) WithNumShards(numShards int) (Sharder, error) {
+	if numShards != s.numShards {
+		// almost every path would move
+		return nil, fmt.Errorf("pachyderm: can't change the number of shards of a modulo sharder, start the cluster with virtual nodes to reshard")
+	}
+	return s, nil
+}
@@ -0,0 +1,133 @@
+package route
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/src/pfs"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testNumShards = 16
+	testNumPaths  = 1000
+)
+
+func TestDirectorySharderDepth0(t *testing.T) {
+	sharder := NewDirectorySharder(NewSharder(testNumShards), 0)
+	// every directory has its own shard, so no directory is on one shard
+	// with its subdirectories
+	for _, dir := range []string{"", "a", "a/b", "a/b/c"} {
+		_, ok, err := sharder.GetDirectoryShard(newPath(dir), true)
+		require.NoError(t, err)
+		require.False(t, ok, dir)
+		requireDirectoryShard(t, sharder, dir)
+	}
+	requireSameShard(t, sharder, "a/b/file1", "a/b/file2", "/a/b/file3", "a/b/../b/file4")
+}
+
+func TestDirectorySharderDepth1(t *testing.T) {
+	sharder := NewDirectorySharder(NewSharder(testNumShards), 1)
+	_, ok, err := sharder.GetDirectoryShard(newPath(""), true)
+	require.NoError(t, err)
+	require.False(t, ok)
+	requireDirectoryShard(t, sharder, "")
+	for _, dir := range []string{"a", "a/b", "a/b/c"} {
+		shard, ok, err := sharder.GetDirectoryShard(newPath(dir), true)
+		require.NoError(t, err)
+		require.True(t, ok, dir)
+		requireShard(t, sharder, shard, "a/file")
+		requireDirectoryShard(t, sharder, dir)
+	}
+	requireSameShard(t, sharder, "a/file", "a/b/file", "a/b/c/file", "/a/b/")
+}
+
+func TestDirectorySharderDepth2(t *testing.T) {
+	sharder := NewDirectorySharder(NewSharder(testNumShards), 2)
+	for _, dir := range []string{"", "a"} {
+		_, ok, err := sharder.GetDirectoryShard(newPath(dir), true)
+		require.NoError(t, err)
+		require.False(t, ok, dir)
+		requireDirectoryShard(t, sharder, dir)
+	}
+	for _, dir := range []string{"a/b", "a/b/c", "a/b/c/d"} {
+		shard, ok, err := sharder.GetDirectoryShard(newPath(dir), true)
+		require.NoError(t, err)
+		require.True(t, ok, dir)
+		requireShard(t, sharder, shard, "a/b/file")
+		requireDirectoryShard(t, sharder, dir)
+	}
+	requireSameShard(t, sharder, "a/b/file", "a/b/c/file", "a/b/c/d/file", "/a/b/c/")
+}
+
+func TestDirectorySharderCleansPaths(t *testing.T) {
+	sharder := NewDirectorySharder(NewSharder(testNumShards), 0)
+	shard, ok, err := sharder.GetDirectoryShard(newPath("a/b"), false)
+	require.NoError(t, err)
+	require.True(t, ok)
+	for _, dir := range []string{"/a/b", "a/b/", "/a/b/", "a//b", "a/./b", "a/c/../b"} {
+		dirShard, ok, err := sharder.GetDirectoryShard(newPath(dir), false)
+		require.NoError(t, err)
+		require.True(t, ok, dir)
+		require.Equal(t, shard, dirShard, dir)
+	}
+}
+
+func TestConsistentHashSharderWithNumShards(t *testing.T) {
+	sharder := NewConsistentHashSharder(testNumShards, 64)
+	_, err := sharder.WithNumShards(testNumShards - 1)
+	require.Error(t, err)
+	newSharder, err := sharder.WithNumShards(2 * testNumShards)
+	require.NoError(t, err)
+	require.Equal(t, 2*testNumShards, newSharder.NumShards())
+	moved := 0
+	for i := 0; i < testNumPaths; i++ {
+		pfsPath := newPath(fmt.Sprintf("dir/file%d", i))
+		shard, err := sharder.GetShard(pfsPath)
+		require.NoError(t, err)
+		newShard, err := newSharder.GetShard(pfsPath)
+		require.NoError(t, err)
+		if newShard != shard {
+			// paths only move onto the new shards
+			require.True(t, newShard >= testNumShards, pfsPath.Path)
+			moved++
+		}
+	}
+	require.True(t, moved > 0)
+	require.True(t, moved < testNumPaths)
+}
+
+// requireDirectoryShard requires that the files directly in dir are on the
+// shard GetDirectoryShard returns for it when it isn't recursive.
+func requireDirectoryShard(t *testing.T, sharder Sharder, dir string) {
+	shard, ok, err := sharder.GetDirectoryShard(newPath(dir), false)
+	require.NoError(t, err)
+	require.True(t, ok, dir)
+	requireShard(t, sharder, shard, dir+"/file")
+}
+
+func requireSameShard(t *testing.T, sharder Sharder, paths ...string) {
+	shard, err := sharder.GetShard(newPath(paths[0]))
+	require.NoError(t, err)
+	requireShard(t, sharder, shard, paths[1:]...)
+}
+
+func requireShard(t *testing.T, sharder Sharder, shard int, paths ...string) {
+	for _, p := range paths {
+		pathShard, err := sharder.GetShard(newPath(p))
+		require.NoError(t, err)
+		require.Equal(t, shard, pathShard, p)
+	}
+}
+
+func newPath(p string) *pfs.Path {
+	return &pfs.Path{
+		Commit: &pfs.Commit{
+			Repository: &pfs.Repository{
+				Name: "repository",
+			},
+			Id: "commit",
+		},
+		Path: p,
+	}
+}
@@ -1,11 +1,19 @@
 package server
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
+	"container/heap"
+	"encoding/json"
 	"fmt"
 	"io"
-	"math/rand"
+	"log"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 
@@ -13,9 +21,23 @@ import (
 
 	"github.com/pachyderm/pachyderm/src/pfs"
 	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pfs/pfsutil"
 	"github.com/pachyderm/pachyderm/src/pfs/route"
+	"github.com/pachyderm/pachyderm/src/pkg/concurrent"
 	"github.com/pachyderm/pachyderm/src/pkg/protoutil"
 	"github.com/peter-edge/go-google-protobuf"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	recoverMinBackoff = time.Second
+	recoverMaxBackoff = 30 * time.Second
+	// recoverMaxAttempts bounds the rollbacks of an operation so that one
+	// that can never be rolled back doesn't block the ones after it.
+	recoverMaxAttempts = 10
+	// replicaTimeout bounds the backfill of a shard a server becomes a
+	// replica of.
+	replicaTimeout = 10 * time.Minute
 )
 
 var (
@@ -23,56 +45,120 @@ var (
 )
 
 type combinedAPIServer struct {
-	sharder route.Sharder
-	router  route.Router
-	driver  drive.Driver
+	sharder           route.Sharder
+	router            route.Router
+	driver            drive.Driver
+	hopTimeout        time.Duration
+	fanOutParallelism int
+	// lock guards sharder, reshardID, numWrites, nextVersions,
+	// localVersions, readCommits and replicateLocks, writesDone is signalled
+	// when numWrites drops.
+	lock       *sync.Mutex
+	writesDone *sync.Cond
+	// reshardID is the id of the Reshard in progress, if there is one.
+	reshardID     string
+	numWrites     int
+	nextVersions  map[int]uint64
+	localVersions map[int]uint64
+	// readCommits caches the local read commits by readCommitKey, a read
+	// commit stays one until it's deleted or rolled back.
+	readCommits map[string]bool
+	// replicateLocks serialize the pushes of each local master shard, so
+	// that its replicas get its versions in order.
+	replicateLocks map[int]*sync.Mutex
+	// branchLock makes checking where a branch points and moving it atomic.
+	branchLock *sync.Mutex
 }
 
 func newCombinedAPIServer(
 	sharder route.Sharder,
 	router route.Router,
 	driver drive.Driver,
+	hopTimeout time.Duration,
+	fanOutParallelism int,
 ) *combinedAPIServer {
+	lock := &sync.Mutex{}
 	return &combinedAPIServer{
 		sharder,
 		router,
 		driver,
+		hopTimeout,
+		fanOutParallelism,
+		lock,
+		sync.NewCond(lock),
+		"",
+		0,
+		make(map[int]uint64),
+		make(map[int]uint64),
+		make(map[string]bool),
+		make(map[int]*sync.Mutex),
+		&sync.Mutex{},
 	}
 }
 
 func (a *combinedAPIServer) InitRepository(ctx context.Context, initRepositoryRequest *pfs.InitRepositoryRequest) (*google_protobuf.Empty, error) {
-	masterShards, err := a.router.GetMasterShards()
+	finishWrite, err := a.startWrite()
 	if err != nil {
 		return nil, err
 	}
-	if err := a.driver.InitRepository(initRepositoryRequest.Repository, masterShards); err != nil {
-		return nil, err
+	defer finishWrite()
+	if initRepositoryRequest.Redirect {
+		return emptyInstance, a.initRepository(ctx, initRepositoryRequest)
 	}
-	replicaShards, err := a.router.GetReplicaShards()
+	// rolling back deletes the repository so it must not already exist
+	ok, err := a.hasRepository(ctx, initRepositoryRequest.Repository)
 	if err != nil {
 		return nil, err
 	}
-	if err := a.driver.InitRepository(initRepositoryRequest.Repository, replicaShards); err != nil {
-		return nil, err
+	if ok {
+		return nil, fmt.Errorf("pachyderm: repository %s already exists", initRepositoryRequest.Repository.Name)
+	}
+	return emptyInstance, a.runOperation(
+		ctx,
+		&pfs.Operation{
+			OperationType: pfs.OperationType_OPERATION_TYPE_INIT_REPOSITORY,
+			Repository:    initRepositoryRequest.Repository,
+		},
+		func() error {
+			return a.initRepository(ctx, initRepositoryRequest)
+		},
+	)
+}
+
+func (a *combinedAPIServer) initRepository(ctx context.Context, initRepositoryRequest *pfs.InitRepositoryRequest) error {
+	masterShards, err := a.getMasterShards()
+	if err != nil {
+		return err
+	}
+	if err := a.driver.InitRepository(ctx, initRepositoryRequest.Repository, masterShards); err != nil {
+		return err
+	}
+	replicaShards, err := a.getReplicaShards()
+	if err != nil {
+		return err
+	}
+	if err := a.driver.InitRepository(ctx, initRepositoryRequest.Repository, replicaShards); err != nil {
+		return err
 	}
 	if !initRepositoryRequest.Redirect {
 		clientConns, err := a.router.GetAllClientConns()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for _, clientConn := range clientConns {
-			if _, err := pfs.NewApiClient(clientConn).InitRepository(
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			_, err := pfs.NewApiClient(clientConn).InitRepository(
 				ctx,
 				&pfs.InitRepositoryRequest{
 					Repository: initRepositoryRequest.Repository,
 					Redirect:   true,
 				},
-			); err != nil {
-				return nil, err
-			}
+			)
+			return err
+		}); err != nil {
+			return err
 		}
 		// Create the initial commit
-		if _, err = a.Branch(ctx, &pfs.BranchRequest{
+		if _, err = a.handleBranch(ctx, &pfs.BranchRequest{
 			Commit: nil,
 			NewCommit: &pfs.Commit{
 				Repository: initRepositoryRequest.Repository,
@@ -80,423 +166,4190 @@ func (a *combinedAPIServer) InitRepository(ctx context.Context, initRepositoryRe
 			},
 			Redirect: false,
 		}); err != nil {
-			return nil, err
+			return err
 		}
-		if _, err = a.Commit(ctx, &pfs.CommitRequest{
+		if _, err = a.handleCommit(ctx, &pfs.CommitRequest{
 			Commit: &pfs.Commit{
 				Repository: initRepositoryRequest.Repository,
 				Id:         InitialCommitID,
 			},
 			Redirect: false,
 		}); err != nil {
-			return nil, err
+			return err
 		}
-	}
-	return emptyInstance, nil
-}
-
-func (a *combinedAPIServer) GetFile(getFileRequest *pfs.GetFileRequest, apiGetFileServer pfs.Api_GetFileServer) (retErr error) {
-	shard, clientConn, err := a.getShardAndClientConnIfNecessary(getFileRequest.Path, false)
-	if err != nil {
-		return err
-	}
-	if clientConn != nil {
-		apiGetFileClient, err := pfs.NewApiClient(clientConn).GetFile(context.Background(), getFileRequest)
-		if err != nil {
+		if _, err = a.handleCreateBranch(ctx, &pfs.CreateBranchRequest{
+			Commit: &pfs.Commit{
+				Repository: initRepositoryRequest.Repository,
+				Id:         InitialCommitID,
+			},
+			Name:     InitialBranch,
+			Redirect: false,
+		}); err != nil {
 			return err
 		}
-		return protoutil.RelayFromStreamingBytesClient(apiGetFileClient, apiGetFileServer)
-	}
-	file, err := a.driver.GetFile(getFileRequest.Path, shard)
-	if err != nil {
-		return err
 	}
-	defer func() {
-		if err := file.Close(); err != nil && retErr == nil {
-			retErr = err
-		}
-	}()
-	return protoutil.WriteToStreamingBytesServer(
-		io.NewSectionReader(file, getFileRequest.OffsetBytes, getFileRequest.SizeBytes),
-		apiGetFileServer,
-	)
+	return nil
 }
 
-func (a *combinedAPIServer) GetFileInfo(ctx context.Context, getFileInfoRequest *pfs.GetFileInfoRequest) (*pfs.GetFileInfoResponse, error) {
-	shard, clientConn, err := a.getShardAndClientConnIfNecessary(getFileInfoRequest.Path, false)
-	if err != nil {
-		return nil, err
-	}
-	if clientConn != nil {
-		return pfs.NewApiClient(clientConn).GetFileInfo(context.Background(), getFileInfoRequest)
-	}
-	fileInfo, ok, err := a.driver.GetFileInfo(getFileInfoRequest.Path, shard)
+func (a *combinedAPIServer) ListRepositories(ctx context.Context, listRepositoriesRequest *pfs.ListRepositoriesRequest) (*pfs.ListRepositoriesResponse, error) {
+	// every server has every repository
+	repositories, err := a.driver.ListRepositories(ctx)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		return &pfs.GetFileInfoResponse{}, nil
-	}
-	return &pfs.GetFileInfoResponse{
-		FileInfo: fileInfo,
+	return &pfs.ListRepositoriesResponse{
+		Repository: repositories,
 	}, nil
 }
 
-func (a *combinedAPIServer) MakeDirectory(ctx context.Context, makeDirectoryRequest *pfs.MakeDirectoryRequest) (*google_protobuf.Empty, error) {
-	shards, err := a.getAllShards(false)
-	if err != nil {
-		return nil, err
-	}
-	if err := a.driver.MakeDirectory(makeDirectoryRequest.Path, shards); err != nil {
-		return nil, err
-	}
-	if !makeDirectoryRequest.Redirect {
-		clientConns, err := a.router.GetAllClientConns()
-		if err != nil {
-			return nil, err
-		}
-		for _, clientConn := range clientConns {
-			if _, err := pfs.NewApiClient(clientConn).MakeDirectory(
-				ctx,
-				&pfs.MakeDirectoryRequest{
-					Path:     makeDirectoryRequest.Path,
-					Redirect: true,
-				},
-			); err != nil {
-				return nil, err
-			}
-		}
-	}
-	return emptyInstance, nil
-}
-
-func (a *combinedAPIServer) PutFile(ctx context.Context, putFileRequest *pfs.PutFileRequest) (*google_protobuf.Empty, error) {
-	if strings.HasPrefix(putFileRequest.Path.Path, "/") {
-		// This is a subtle error case, the paths foo and /foo will hash to
-		// different shards but will produce the same change once they get to
-		// those shards due to how path.Join. This can go wrong in a number of
-		// ways so we forbid leading slashes.
-		return nil, fmt.Errorf("pachyderm: leading slash in path: %s", putFileRequest.Path.Path)
-	}
-	shard, clientConn, err := a.getShardAndClientConnIfNecessary(putFileRequest.Path, false)
-	if err != nil {
-		return nil, err
-	}
-	if clientConn != nil {
-		return pfs.NewApiClient(clientConn).PutFile(ctx, putFileRequest)
-	}
-	if err := a.driver.PutFile(putFileRequest.Path, shard, putFileRequest.OffsetBytes, bytes.NewReader(putFileRequest.Value)); err != nil {
-		return nil, err
-	}
-	return emptyInstance, nil
-}
-
-func (a *combinedAPIServer) ListFiles(ctx context.Context, listFilesRequest *pfs.ListFilesRequest) (*pfs.ListFilesResponse, error) {
+func (a *combinedAPIServer) InspectRepository(ctx context.Context, inspectRepositoryRequest *pfs.InspectRepositoryRequest) (*pfs.InspectRepositoryResponse, error) {
 	shards, err := a.getAllShards(false)
 	if err != nil {
 		return nil, err
 	}
-	dynamicShard := listFilesRequest.Shard
-	if dynamicShard == nil {
-		dynamicShard = &pfs.Shard{Number: 0, Modulo: 1}
-	}
-	filteredShards := make(map[int]bool)
+	var repositoryInfo *pfs.RepositoryInfo
 	for shard := range shards {
-		if uint64(shard)%dynamicShard.Modulo == dynamicShard.Number {
-			filteredShards[shard] = true
-		}
-	}
-	var fileInfos []*pfs.FileInfo
-	seenDirectories := make(map[string]bool)
-	for shard := range filteredShards {
-		subFileInfos, err := a.driver.ListFiles(listFilesRequest.Path, shard)
+		shardRepositoryInfo, ok, err := a.driver.InspectRepository(ctx, inspectRepositoryRequest.Repository, shard)
 		if err != nil {
 			return nil, err
 		}
-		for _, fileInfo := range subFileInfos {
-			if fileInfo.FileType == pfs.FileType_FILE_TYPE_DIR {
-				if seenDirectories[fileInfo.Path.Path] {
-					continue
-				}
-				seenDirectories[fileInfo.Path.Path] = true
-			}
-			fileInfos = append(fileInfos, fileInfo)
+		if ok {
+			repositoryInfo = mergeRepositoryInfos(repositoryInfo, shardRepositoryInfo)
 		}
 	}
-	if !listFilesRequest.Redirect {
+	if !inspectRepositoryRequest.Redirect {
 		clientConns, err := a.router.GetAllClientConns()
 		if err != nil {
 			return nil, err
 		}
-		for _, clientConn := range clientConns {
-			listFilesResponse, err := pfs.NewApiClient(clientConn).ListFiles(
+		var lock sync.Mutex
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			inspectRepositoryResponse, err := pfs.NewApiClient(clientConn).InspectRepository(
 				ctx,
-				&pfs.ListFilesRequest{
-					Path:     listFilesRequest.Path,
-					Shard:    listFilesRequest.Shard,
-					Redirect: true,
+				&pfs.InspectRepositoryRequest{
+					Repository: inspectRepositoryRequest.Repository,
+					Redirect:   true,
 				},
 			)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			for _, fileInfo := range listFilesResponse.FileInfo {
-				if fileInfo.FileType == pfs.FileType_FILE_TYPE_DIR {
-					if seenDirectories[fileInfo.Path.Path] {
-						continue
-					}
-					seenDirectories[fileInfo.Path.Path] = true
-				}
-				fileInfos = append(fileInfos, fileInfo)
+			lock.Lock()
+			defer lock.Unlock()
+			if inspectRepositoryResponse.RepositoryInfo != nil {
+				repositoryInfo = mergeRepositoryInfos(repositoryInfo, inspectRepositoryResponse.RepositoryInfo)
 			}
+			return nil
+		}); err != nil {
+			return nil, err
 		}
 	}
-	return &pfs.ListFilesResponse{
-		FileInfo: fileInfos,
+	return &pfs.InspectRepositoryResponse{
+		RepositoryInfo: repositoryInfo,
 	}, nil
 }
 
-func (a *combinedAPIServer) Branch(ctx context.Context, branchRequest *pfs.BranchRequest) (*pfs.BranchResponse, error) {
-	if branchRequest.Redirect && branchRequest.NewCommit == nil {
-		return nil, fmt.Errorf("must set a new commit for redirect %+v", branchRequest)
-	}
-	shards, err := a.getAllShards(false)
+func (a *combinedAPIServer) DeleteRepository(ctx context.Context, deleteRepositoryRequest *pfs.DeleteRepositoryRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
 	if err != nil {
 		return nil, err
 	}
-	newCommit, err := a.driver.Branch(branchRequest.Commit, branchRequest.NewCommit, shards)
+	defer finishWrite()
+	shards, err := a.getDriverShards()
 	if err != nil {
 		return nil, err
 	}
-	if !branchRequest.Redirect {
-		clientConns, err := a.router.GetAllClientConns()
-		if err != nil {
-			return nil, err
-		}
-		for _, clientConn := range clientConns {
-			if _, err := pfs.NewApiClient(clientConn).Branch(
-				ctx,
-				&pfs.BranchRequest{
-					Commit:    branchRequest.Commit,
-					Redirect:  true,
-					NewCommit: newCommit,
-				},
-			); err != nil {
+	// the DeleteRepository of a server that's the master of a local replica
+	// shard can get here first
+	if deleteRepositoryRequest.Redirect {
+		for shard := range shards {
+			if err := a.deleteLocalRepository(ctx, deleteRepositoryRequest.Repository, shard); err != nil {
 				return nil, err
 			}
 		}
-	}
-	return &pfs.BranchResponse{
-		Commit: newCommit,
-	}, nil
-}
-
-func (a *combinedAPIServer) Commit(ctx context.Context, commitRequest *pfs.CommitRequest) (*google_protobuf.Empty, error) {
-	shards, err := a.router.GetMasterShards()
-	if err != nil {
+	} else if err := a.driver.DeleteRepository(ctx, deleteRepositoryRequest.Repository, shards); err != nil {
 		return nil, err
 	}
-	if err := a.driver.Commit(commitRequest.Commit, shards); err != nil {
+	a.forgetReadCommits()
+	// the deletion gets a version so that failover can tell who has it
+	masterShards, err := a.getMasterShards()
+	if err != nil {
 		return nil, err
 	}
-	if err := a.commitToReplicas(ctx, commitRequest.Commit); err != nil {
-		return nil, err
+	for shard := range masterShards {
+		if err := a.replicate(
+			ctx,
+			&pfs.PushDiffRequest{
+				Shard:            uint64(shard),
+				DeleteRepository: deleteRepositoryRequest.Repository,
+			},
+		); err != nil {
+			return nil, err
+		}
 	}
-	if !commitRequest.Redirect {
+	if !deleteRepositoryRequest.Redirect {
 		clientConns, err := a.router.GetAllClientConns()
 		if err != nil {
 			return nil, err
 		}
-		for _, clientConn := range clientConns {
-			if _, err := pfs.NewApiClient(clientConn).Commit(
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			_, err := pfs.NewApiClient(clientConn).DeleteRepository(
 				ctx,
-				&pfs.CommitRequest{
-					Commit:   commitRequest.Commit,
-					Redirect: true,
+				&pfs.DeleteRepositoryRequest{
+					Repository: deleteRepositoryRequest.Repository,
+					Redirect:   true,
 				},
-			); err != nil {
-				return nil, err
-			}
+			)
+			return err
+		}); err != nil {
+			return nil, err
 		}
 	}
 	return emptyInstance, nil
 }
 
-func (a *combinedAPIServer) PullDiff(pullDiffRequest *pfs.PullDiffRequest, apiPullDiffServer pfs.InternalApi_PullDiffServer) error {
-	clientConn, err := a.getClientConnIfNecessary(int(pullDiffRequest.Shard), false)
+func (a *combinedAPIServer) GetFile(getFileRequest *pfs.GetFileRequest, apiGetFileServer pfs.Api_GetFileServer) error {
+	ctx := apiGetFileServer.Context()
+	path, err := a.resolvePath(ctx, getFileRequest.Path)
 	if err != nil {
 		return err
 	}
-	if clientConn != nil {
-		apiPullDiffClient, err := pfs.NewInternalApiClient(clientConn).PullDiff(context.Background(), pullDiffRequest)
-		if err != nil {
+	shard, err := a.getSharder().GetShard(path)
+	if err != nil {
+		return err
+	}
+	if getFileRequest.Redirect {
+		_, err := a.getFile(ctx, getFileRequest, path, shard, nil, apiGetFileServer)
+		return err
+	}
+	clientConns, err := a.getReadClientConns(ctx, path.Commit, shard, getFileRequest.ReadConsistency)
+	if err != nil {
+		return err
+	}
+	for _, clientConn := range clientConns {
+		var started bool
+		started, err = a.getFile(ctx, getFileRequest, path, shard, clientConn, apiGetFileServer)
+		if err == nil || started {
 			return err
 		}
-		return protoutil.RelayFromStreamingBytesClient(apiPullDiffClient, apiPullDiffServer)
 	}
-	var buffer bytes.Buffer
-	a.driver.PullDiff(pullDiffRequest.Commit, int(pullDiffRequest.Shard), &buffer)
-	return protoutil.WriteToStreamingBytesServer(
-		&buffer,
-		apiPullDiffServer,
-	)
+	return err
 }
 
-func (a *combinedAPIServer) PushDiff(ctx context.Context, pushDiffRequest *pfs.PushDiffRequest) (*google_protobuf.Empty, error) {
-	ok, err := a.isLocalReplicaShard(int(pushDiffRequest.Shard))
+func (a *combinedAPIServer) GetFileInfo(ctx context.Context, getFileInfoRequest *pfs.GetFileInfoRequest) (*pfs.GetFileInfoResponse, error) {
+	path, err := a.resolvePath(ctx, getFileInfoRequest.Path)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		return nil, fmt.Errorf("pachyderm: illegal PushDiffRequest for unknown shard %d", pushDiffRequest.Shard)
-	}
-	return emptyInstance, a.driver.PushDiff(pushDiffRequest.Commit, bytes.NewReader(pushDiffRequest.Value))
-}
-
-// TODO(pedge): race on Branch
-func (a *combinedAPIServer) GetCommitInfo(ctx context.Context, getCommitInfoRequest *pfs.GetCommitInfoRequest) (*pfs.GetCommitInfoResponse, error) {
-	shard, clientConn, err := a.getMasterShardOrMasterClientConnIfNecessary()
+	shard, err := a.getSharder().GetShard(path)
 	if err != nil {
 		return nil, err
 	}
-	if clientConn != nil {
-		return pfs.NewApiClient(clientConn).GetCommitInfo(ctx, getCommitInfoRequest)
+	if getFileInfoRequest.Redirect {
+		return a.getFileInfo(ctx, path, shard, nil)
 	}
-	commitInfo, ok, err := a.driver.GetCommitInfo(getCommitInfoRequest.Commit, shard)
+	clientConns, err := a.getReadClientConns(ctx, path.Commit, shard, getFileInfoRequest.ReadConsistency)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		return &pfs.GetCommitInfoResponse{}, nil
+	var getFileInfoResponse *pfs.GetFileInfoResponse
+	for _, clientConn := range clientConns {
+		getFileInfoResponse, err = a.getFileInfo(ctx, path, shard, clientConn)
+		if err == nil {
+			return getFileInfoResponse, nil
+		}
 	}
-	return &pfs.GetCommitInfoResponse{
-		CommitInfo: commitInfo,
-	}, nil
+	return nil, err
 }
 
-func (a *combinedAPIServer) ListCommits(ctx context.Context, listCommitsRequest *pfs.ListCommitsRequest) (*pfs.ListCommitsResponse, error) {
-	shard, clientConn, err := a.getMasterShardOrMasterClientConnIfNecessary()
+func (a *combinedAPIServer) MakeDirectory(ctx context.Context, makeDirectoryRequest *pfs.MakeDirectoryRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
 	if err != nil {
 		return nil, err
 	}
-	if clientConn != nil {
-		return pfs.NewApiClient(clientConn).ListCommits(ctx, listCommitsRequest)
+	defer finishWrite()
+	return a.handleMakeDirectory(ctx, makeDirectoryRequest)
+}
+
+func (a *combinedAPIServer) handleMakeDirectory(ctx context.Context, makeDirectoryRequest *pfs.MakeDirectoryRequest) (*google_protobuf.Empty, error) {
+	path, err := a.resolvePath(ctx, makeDirectoryRequest.Path)
+	if err != nil {
+		return nil, err
+	}
+	if makeDirectoryRequest.Redirect {
+		return emptyInstance, a.makeDirectory(ctx, path)
 	}
-	commitInfos, err := a.driver.ListCommits(listCommitsRequest.Repository, shard)
+	// only the directories this makes are removed by a rollback
+	missingPath, err := a.getMissingPath(ctx, path)
 	if err != nil {
 		return nil, err
 	}
-	return &pfs.ListCommitsResponse{
-		CommitInfo: commitInfos,
-	}, nil
+	return emptyInstance, a.runOperation(
+		ctx,
+		&pfs.Operation{
+			OperationType: pfs.OperationType_OPERATION_TYPE_MAKE_DIRECTORY,
+			Repository:    path.Commit.Repository,
+			Commit:        path.Commit,
+			Path:          missingPath,
+		},
+		func() error {
+			if err := a.makeDirectory(ctx, path); err != nil {
+				return err
+			}
+			clientConns, err := a.router.GetAllClientConns()
+			if err != nil {
+				return err
+			}
+			return a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+				_, err := pfs.NewApiClient(clientConn).MakeDirectory(
+					ctx,
+					&pfs.MakeDirectoryRequest{
+						Path:     path,
+						Redirect: true,
+					},
+				)
+				return err
+			})
+		},
+	)
 }
 
-func (a *combinedAPIServer) getShardAndClientConnIfNecessary(path *pfs.Path, replicaOk bool) (int, *grpc.ClientConn, error) {
-	shard, err := a.sharder.GetShard(path)
+func (a *combinedAPIServer) makeDirectory(ctx context.Context, path *pfs.Path) error {
+	shards, err := a.getAllShards(false)
 	if err != nil {
-		return shard, nil, err
+		return err
 	}
-	clientConn, err := a.getClientConnIfNecessary(shard, replicaOk)
-	return shard, clientConn, err
+	return a.driver.MakeDirectory(ctx, path, shards)
 }
 
-func (a *combinedAPIServer) getClientConnIfNecessary(shard int, replicaOk bool) (*grpc.ClientConn, error) {
-	ok, err := a.isLocalMasterShard(shard)
+func (a *combinedAPIServer) PutFile(ctx context.Context, putFileRequest *pfs.PutFileRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		if !replicaOk {
-			clientConn, err := a.router.GetMasterClientConn(shard)
-			return clientConn, err
-		}
-		ok, err = a.isLocalReplicaShard(shard)
-		if err != nil {
-			return nil, err
-		}
-		if !ok {
-			clientConn, err := a.router.GetMasterOrReplicaClientConn(shard)
-			return clientConn, err
-		}
+	defer finishWrite()
+	if strings.HasPrefix(putFileRequest.Path.Path, "/") {
+		// This is a subtle error case, the paths foo and /foo will hash to
+		// different shards but will produce the same change once they get to
+		// those shards due to how path.Join. This can go wrong in a number of
+		// ways so we forbid leading slashes.
+		return nil, fmt.Errorf("pachyderm: leading slash in path: %s", putFileRequest.Path.Path)
 	}
-	return nil, nil
-}
-
-func (a *combinedAPIServer) getMasterShardOrMasterClientConnIfNecessary() (int, *grpc.ClientConn, error) {
-	shards, err := a.router.GetMasterShards()
+	path, err := a.resolvePath(ctx, putFileRequest.Path)
 	if err != nil {
-		return -1, nil, err
+		return nil, err
 	}
-	if len(shards) > 0 {
-		for shard := range shards {
-			return shard, nil, nil
+	shard, clientConn, err := a.getShardAndClientConnIfNecessary(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if clientConn != nil {
+		hopCtx, cancel := a.hopContext(ctx)
+		defer cancel()
+		return pfs.NewApiClient(clientConn).PutFile(
+			hopCtx,
+			&pfs.PutFileRequest{
+				Path:        path,
+				OffsetBytes: putFileRequest.OffsetBytes,
+				Value:       putFileRequest.Value,
+			},
+		)
+	}
+	if err := a.driver.PutFile(ctx, path, shard, putFileRequest.OffsetBytes, bytes.NewReader(putFileRequest.Value)); err != nil {
+		return nil, err
+	}
+	return emptyInstance, nil
+}
+
+func (a *combinedAPIServer) PutFileStream(apiPutFileStreamServer pfs.Api_PutFileStreamServer) error {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return err
+	}
+	defer finishWrite()
+	ctx := apiPutFileStreamServer.Context()
+	putFileRequest, err := apiPutFileStreamServer.Recv()
+	if err == io.EOF {
+		return fmt.Errorf("pachyderm: no path sent to PutFileStream")
+	}
+	if err != nil {
+		return err
+	}
+	if putFileRequest.Path == nil {
+		return fmt.Errorf("pachyderm: no path sent to PutFileStream")
+	}
+	if strings.HasPrefix(putFileRequest.Path.Path, "/") {
+		// See PutFile for why leading slashes are forbidden.
+		return fmt.Errorf("pachyderm: leading slash in path: %s", putFileRequest.Path.Path)
+	}
+	path, err := a.resolvePath(ctx, putFileRequest.Path)
+	if err != nil {
+		return err
+	}
+	shard, clientConn, err := a.getShardAndClientConnIfNecessary(path, false)
+	if err != nil {
+		return err
+	}
+	if clientConn != nil {
+		apiPutFileStreamClient, err := pfs.NewApiClient(clientConn).PutFileStream(ctx)
+		if err != nil {
+			return err
+		}
+		if err := apiPutFileStreamClient.Send(
+			&pfs.PutFileRequest{
+				Path:        path,
+				OffsetBytes: putFileRequest.OffsetBytes,
+				Value:       putFileRequest.Value,
+			},
+		); err != nil {
+			return err
+		}
+		for putFileRequest, err = apiPutFileStreamServer.Recv(); err != io.EOF; putFileRequest, err = apiPutFileStreamServer.Recv() {
+			if err != nil {
+				return err
+			}
+			if err := apiPutFileStreamClient.Send(&pfs.PutFileRequest{Value: putFileRequest.Value}); err != nil {
+				return err
+			}
+		}
+		putFileStreamResponse, err := apiPutFileStreamClient.CloseAndRecv()
+		if err != nil {
+			return err
 		}
+		return apiPutFileStreamServer.SendAndClose(putFileStreamResponse)
+	}
+	reader := &putFileReader{
+		apiPutFileStreamServer: apiPutFileStreamServer,
+		value:                  putFileRequest.Value,
+	}
+	if err := a.driver.PutFile(ctx, path, shard, putFileRequest.OffsetBytes, reader); err != nil {
+		return err
 	}
-	clientConn, err := a.router.GetMasterClientConn(int(rand.Uint32()) % a.sharder.NumShards())
-	return -1, clientConn, err
+	return apiPutFileStreamServer.SendAndClose(
+		&pfs.PutFileStreamResponse{
+			SizeBytes: uint64(reader.size),
+		},
+	)
 }
 
-func (a *combinedAPIServer) getAllShards(replicaToo bool) (map[int]bool, error) {
-	shards, err := a.router.GetMasterShards()
+func (a *combinedAPIServer) DeleteFile(ctx context.Context, deleteFileRequest *pfs.DeleteFileRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
 	if err != nil {
 		return nil, err
 	}
-	if replicaToo {
-		replicaShards, err := a.router.GetReplicaShards()
+	defer finishWrite()
+	return a.handleDeleteFile(ctx, deleteFileRequest)
+}
+
+func (a *combinedAPIServer) handleDeleteFile(ctx context.Context, deleteFileRequest *pfs.DeleteFileRequest) (*google_protobuf.Empty, error) {
+	if strings.HasPrefix(deleteFileRequest.Path.Path, "/") {
+		// See PutFile for why leading slashes are forbidden.
+		return nil, fmt.Errorf("pachyderm: leading slash in path: %s", deleteFileRequest.Path.Path)
+	}
+	path, err := a.resolvePath(ctx, deleteFileRequest.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !deleteFileRequest.Redirect {
+		getFileInfoResponse, err := a.GetFileInfo(ctx, &pfs.GetFileInfoRequest{Path: path})
 		if err != nil {
 			return nil, err
 		}
-		for replicaShard := range replicaShards {
-			shards[replicaShard] = true
+		if getFileInfoResponse.FileInfo == nil {
+			return nil, fmt.Errorf("pachyderm: file %s not found", deleteFileRequest.Path.Path)
+		}
+		if getFileInfoResponse.FileInfo.FileType != pfs.FileType_FILE_TYPE_DIR {
+			// files only live on the shard their path maps to
+			shard, clientConn, err := a.getShardAndClientConnIfNecessary(path, false)
+			if err != nil {
+				return nil, err
+			}
+			if clientConn != nil {
+				hopCtx, cancel := a.hopContext(ctx)
+				defer cancel()
+				return pfs.NewApiClient(clientConn).DeleteFile(hopCtx, &pfs.DeleteFileRequest{Path: path})
+			}
+			if err := a.driver.DeleteFile(ctx, path, map[int]bool{shard: true}); err != nil {
+				return nil, err
+			}
+			return emptyInstance, nil
 		}
 	}
-	return shards, nil
+	// directories live on every shard
+	shards, err := a.getAllShards(false)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.driver.DeleteFile(ctx, path, shards); err != nil {
+		return nil, err
+	}
+	if !deleteFileRequest.Redirect {
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return nil, err
+		}
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			_, err := pfs.NewApiClient(clientConn).DeleteFile(
+				ctx,
+				&pfs.DeleteFileRequest{
+					Path:     path,
+					Redirect: true,
+				},
+			)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return emptyInstance, nil
 }
 
-func (a *combinedAPIServer) isLocalMasterShard(shard int) (bool, error) {
-	shards, err := a.router.GetMasterShards()
+func (a *combinedAPIServer) ListFiles(ctx context.Context, listFilesRequest *pfs.ListFilesRequest) (*pfs.ListFilesResponse, error) {
+	var fileInfos []*pfs.FileInfo
+	if err := a.listFiles(
+		ctx,
+		listFilesRequest,
+		func(fileInfo *pfs.FileInfo) error {
+			fileInfos = append(fileInfos, fileInfo)
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	return &pfs.ListFilesResponse{
+		FileInfo: fileInfos,
+	}, nil
+}
+
+func (a *combinedAPIServer) ListFilesStream(listFilesRequest *pfs.ListFilesRequest, apiListFilesStreamServer pfs.Api_ListFilesStreamServer) error {
+	return a.listFiles(apiListFilesStreamServer.Context(), listFilesRequest, apiListFilesStreamServer.Send)
+}
+
+func (a *combinedAPIServer) ListChangedFiles(ctx context.Context, listChangedFilesRequest *pfs.ListChangedFilesRequest) (*pfs.ListChangedFilesResponse, error) {
+	fromCommit, err := a.resolveCommit(ctx, listChangedFilesRequest.FromCommit)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	_, ok := shards[shard]
-	return ok, nil
+	toCommit, err := a.resolveCommit(ctx, listChangedFilesRequest.ToCommit)
+	if err != nil {
+		return nil, err
+	}
+	filteredShards, err := a.getFilteredShards(listChangedFilesRequest.Shard)
+	if err != nil {
+		return nil, err
+	}
+	var changes []*pfs.Change
+	// directories live on every shard so their changes are seen once per shard
+	seenDirectories := make(map[string]bool)
+	addChanges := func(subChanges []*pfs.Change) {
+		for _, change := range subChanges {
+			if change.FileType == pfs.FileType_FILE_TYPE_DIR {
+				key := change.ChangeType.String() + " " + change.Path.Path
+				if seenDirectories[key] {
+					continue
+				}
+				seenDirectories[key] = true
+			}
+			changes = append(changes, change)
+		}
+	}
+	for shard := range filteredShards {
+		subChanges, err := a.driver.ListChangedFiles(ctx, fromCommit, toCommit, shard)
+		if err != nil {
+			return nil, err
+		}
+		var shardChanges []*pfs.Change
+		for _, change := range subChanges {
+			moved, err := a.isMoved(change.Path, change.FileType, shard)
+			if err != nil {
+				return nil, err
+			}
+			if !moved {
+				shardChanges = append(shardChanges, change)
+			}
+		}
+		addChanges(shardChanges)
+	}
+	if !listChangedFilesRequest.Redirect {
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return nil, err
+		}
+		var lock sync.Mutex
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			listChangedFilesResponse, err := pfs.NewApiClient(clientConn).ListChangedFiles(
+				ctx,
+				&pfs.ListChangedFilesRequest{
+					FromCommit: fromCommit,
+					ToCommit:   toCommit,
+					Shard:      listChangedFilesRequest.Shard,
+					Redirect:   true,
+				},
+			)
+			if err != nil {
+				return err
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			addChanges(listChangedFilesResponse.Change)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return &pfs.ListChangedFilesResponse{
+		Change: changes,
+	}, nil
 }
 
-func (a *combinedAPIServer) isLocalReplicaShard(shard int) (bool, error) {
-	shards, err := a.router.GetReplicaShards()
+func (a *combinedAPIServer) ListFileHistory(ctx context.Context, listFileHistoryRequest *pfs.ListFileHistoryRequest) (*pfs.ListFileHistoryResponse, error) {
+	path, err := a.resolvePath(ctx, listFileHistoryRequest.Path)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	_, ok := shards[shard]
-	return ok, nil
+	shard, clientConn, err := a.getShardAndClientConnIfNecessary(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if clientConn != nil {
+		hopCtx, cancel := a.hopContext(ctx)
+		defer cancel()
+		return pfs.NewApiClient(clientConn).ListFileHistory(hopCtx, &pfs.ListFileHistoryRequest{Path: path})
+	}
+	fileRevisions, err := a.driver.ListFileHistory(ctx, path, shard)
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.ListFileHistoryResponse{
+		FileRevision: fileRevisions,
+	}, nil
 }
 
-func (a *combinedAPIServer) commitToReplicas(ctx context.Context, commit *pfs.Commit) error {
-	shards, err := a.router.GetMasterShards()
+func (a *combinedAPIServer) ExportCommit(exportCommitRequest *pfs.ExportCommitRequest, apiExportCommitServer pfs.Api_ExportCommitServer) error {
+	ctx := apiExportCommitServer.Context()
+	commit, err := a.resolveCommit(ctx, exportCommitRequest.Commit)
 	if err != nil {
 		return err
 	}
-	for shard := range shards {
-		clientConns, err := a.router.GetReplicaClientConns(shard)
+	getCommitInfoResponse, err := a.GetCommitInfo(ctx, &pfs.GetCommitInfoRequest{Commit: commit})
+	if err != nil {
+		return err
+	}
+	if getCommitInfoResponse.CommitInfo == nil {
+		return fmt.Errorf("pachyderm: commit %s not found", commit.Id)
+	}
+	if getCommitInfoResponse.CommitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+		return fmt.Errorf("pachyderm: commit %s is not a read commit", commit.Id)
+	}
+	path := &pfs.Path{
+		Commit: commit,
+		Path:   cleanPath(exportCommitRequest.Path),
+	}
+	// tar writes in small blocks, buffer them into fewer messages
+	writer := bufio.NewWriterSize(protoutil.NewStreamingBytesWriter(apiExportCommitServer), pfsutil.PutFileChunkSize)
+	tarWriter := tar.NewWriter(writer)
+	isDir := true
+	if path.Path != "" {
+		getFileInfoResponse, err := a.GetFileInfo(ctx, &pfs.GetFileInfoRequest{Path: path})
 		if err != nil {
 			return err
 		}
-		var diff bytes.Buffer
-		if err = a.driver.PullDiff(commit, shard, &diff); err != nil {
+		if getFileInfoResponse.FileInfo == nil {
+			return fmt.Errorf("pachyderm: file %s not found", path.Path)
+		}
+		if err := a.exportFile(ctx, tarWriter, getFileInfoResponse.FileInfo); err != nil {
 			return err
 		}
-		for _, clientConn := range clientConns {
-			if _, err = pfs.NewInternalApiClient(clientConn).PushDiff(
-				ctx,
-				&pfs.PushDiffRequest{
-					Commit: commit,
-					Shard:  uint64(shard),
-					Value:  diff.Bytes(),
-				},
-			); err != nil {
+		isDir = getFileInfoResponse.FileInfo.FileType == pfs.FileType_FILE_TYPE_DIR
+	}
+	if isDir {
+		if err := a.listFiles(
+			ctx,
+			&pfs.ListFilesRequest{
+				Path:      path,
+				Recursive: true,
+			},
+			func(fileInfo *pfs.FileInfo) error {
+				return a.exportFile(ctx, tarWriter, fileInfo)
+			},
+		); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func (a *combinedAPIServer) ImportTar(apiImportTarServer pfs.Api_ImportTarServer) error {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return err
+	}
+	defer finishWrite()
+	ctx := apiImportTarServer.Context()
+	importTarRequest, err := apiImportTarServer.Recv()
+	if err == io.EOF {
+		return fmt.Errorf("pachyderm: no commit sent to ImportTar")
+	}
+	if err != nil {
+		return err
+	}
+	if importTarRequest.Commit == nil {
+		return fmt.Errorf("pachyderm: no commit sent to ImportTar")
+	}
+	commit, err := a.resolveCommit(ctx, importTarRequest.Commit)
+	if err != nil {
+		return err
+	}
+	// check before anything is unpacked rather than failing part way through
+	getCommitInfoResponse, err := a.GetCommitInfo(ctx, &pfs.GetCommitInfoRequest{Commit: commit})
+	if err != nil {
+		return err
+	}
+	if getCommitInfoResponse.CommitInfo == nil {
+		return fmt.Errorf("pachyderm: commit %s not found", commit.Id)
+	}
+	if getCommitInfoResponse.CommitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_WRITE {
+		return fmt.Errorf("pachyderm: commit %s is not a write commit", commit.Id)
+	}
+	tarReader := tar.NewReader(
+		&importTarReader{
+			apiImportTarServer: apiImportTarServer,
+			value:              importTarRequest.Value,
+		},
+	)
+	// the directories that are known to exist
+	dirs := map[string]bool{"": true}
+	importTarResponse := &pfs.ImportTarResponse{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path := &pfs.Path{
+			Commit: commit,
+			Path:   cleanPath(header.Name),
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := a.importDirectory(ctx, path, dirs); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := a.importDirectory(ctx, &pfs.Path{Commit: commit, Path: cleanPath(filepath.Dir(path.Path))}, dirs); err != nil {
+				return err
+			}
+			if err := a.importFile(ctx, path, tarReader); err != nil {
 				return err
 			}
+			importTarResponse.NumFiles++
+			importTarResponse.SizeBytes += uint64(header.Size)
+		default:
+			return fmt.Errorf("pachyderm: tar entry %s has unsupported type %c", header.Name, header.Typeflag)
 		}
 	}
-	return nil
+	return apiImportTarServer.SendAndClose(importTarResponse)
+}
+
+func (a *combinedAPIServer) Branch(ctx context.Context, branchRequest *pfs.BranchRequest) (*pfs.BranchResponse, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	return a.handleBranch(ctx, branchRequest)
+}
+
+func (a *combinedAPIServer) handleBranch(ctx context.Context, branchRequest *pfs.BranchRequest) (*pfs.BranchResponse, error) {
+	if branchRequest.Redirect && branchRequest.NewCommit == nil {
+		return nil, fmt.Errorf("must set a new commit for redirect %+v", branchRequest)
+	}
+	commit := branchRequest.Commit
+	branch := branchRequest.Branch
+	if branch != "" {
+		if err := checkBranchName(branch); err != nil {
+			return nil, err
+		}
+	}
+	if commit != nil {
+		branchCommit, ok, err := a.getBranch(ctx, commit.Repository, commit.Id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if branch == "" {
+				branch = commit.Id
+			}
+			commit = branchCommit
+		}
+	}
+	shards, err := a.getAllShards(false)
+	if err != nil {
+		return nil, err
+	}
+	if branchRequest.Redirect {
+		newCommit, err := a.driver.Branch(ctx, commit, branchRequest.NewCommit, branch, branchRequest.Message, shards)
+		if err != nil {
+			return nil, err
+		}
+		return &pfs.BranchResponse{
+			Commit: newCommit,
+		}, nil
+	}
+	repository := branchRequest.NewCommit.GetRepository()
+	if commit != nil {
+		repository = commit.Repository
+	}
+	newCommit, err := a.getNewCommit(ctx, repository, branchRequest.NewCommit)
+	if err != nil {
+		return nil, err
+	}
+	if branchRequest.Branch != "" {
+		if err := a.startBranch(ctx, commit, branchRequest.Branch); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.runOperation(
+		ctx,
+		&pfs.Operation{
+			OperationType: pfs.OperationType_OPERATION_TYPE_BRANCH,
+			Repository:    newCommit.Repository,
+			Commit:        newCommit,
+		},
+		func() error {
+			if _, err := a.driver.Branch(ctx, commit, newCommit, branch, branchRequest.Message, shards); err != nil {
+				return err
+			}
+			clientConns, err := a.router.GetAllClientConns()
+			if err != nil {
+				return err
+			}
+			return a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+				_, err := pfs.NewApiClient(clientConn).Branch(
+					ctx,
+					&pfs.BranchRequest{
+						Commit:    commit,
+						Redirect:  true,
+						NewCommit: newCommit,
+						Message:   branchRequest.Message,
+						Branch:    branch,
+					},
+				)
+				return err
+			})
+		},
+	); err != nil {
+		return nil, err
+	}
+	return &pfs.BranchResponse{
+		Commit: newCommit,
+	}, nil
+}
+
+func (a *combinedAPIServer) Merge(ctx context.Context, mergeRequest *pfs.MergeRequest) (*pfs.MergeResponse, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	if mergeRequest.Ours == nil || mergeRequest.Theirs == nil {
+		return nil, fmt.Errorf("pachyderm: must specify both ours and theirs")
+	}
+	if mergeRequest.Redirect && mergeRequest.NewCommit == nil {
+		return nil, fmt.Errorf("must set a new commit for redirect %+v", mergeRequest)
+	}
+	ours := mergeRequest.Ours
+	theirs := mergeRequest.Theirs
+	branch := mergeRequest.Branch
+	paths := mergeRequest.Paths
+	if !mergeRequest.Redirect {
+		if branch != "" {
+			if err := checkBranchName(branch); err != nil {
+				return nil, err
+			}
+		}
+		branchCommit, ok, err := a.getBranch(ctx, ours.Repository, ours.Id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if branch == "" {
+				branch = ours.Id
+			}
+			ours = branchCommit
+		}
+		if theirs, err = a.resolveCommit(ctx, theirs); err != nil {
+			return nil, err
+		}
+		// paths are decided before anything is written so a conflict leaves no commit behind
+		if paths, err = a.getMergePaths(ctx, ours, theirs, mergeRequest.ConflictPolicy); err != nil {
+			return nil, err
+		}
+	}
+	shards, err := a.getAllShards(false)
+	if err != nil {
+		return nil, err
+	}
+	if mergeRequest.Redirect {
+		newCommit, err := a.driver.Merge(ctx, ours, theirs, mergeRequest.NewCommit, paths, branch, mergeRequest.Message, shards)
+		if err != nil {
+			return nil, err
+		}
+		return &pfs.MergeResponse{
+			Commit: newCommit,
+		}, nil
+	}
+	newCommit, err := a.getNewCommit(ctx, ours.Repository, mergeRequest.NewCommit)
+	if err != nil {
+		return nil, err
+	}
+	if mergeRequest.Branch != "" {
+		if err := a.startBranch(ctx, ours, mergeRequest.Branch); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.runOperation(
+		ctx,
+		&pfs.Operation{
+			OperationType: pfs.OperationType_OPERATION_TYPE_BRANCH,
+			Repository:    newCommit.Repository,
+			Commit:        newCommit,
+		},
+		func() error {
+			if _, err := a.driver.Merge(ctx, ours, theirs, newCommit, paths, branch, mergeRequest.Message, shards); err != nil {
+				return err
+			}
+			clientConns, err := a.router.GetAllClientConns()
+			if err != nil {
+				return err
+			}
+			return a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+				_, err := pfs.NewApiClient(clientConn).Merge(
+					ctx,
+					&pfs.MergeRequest{
+						Ours:      ours,
+						Theirs:    theirs,
+						NewCommit: newCommit,
+						Message:   mergeRequest.Message,
+						Redirect:  true,
+						Branch:    branch,
+						Paths:     paths,
+					},
+				)
+				return err
+			})
+		},
+	); err != nil {
+		return nil, err
+	}
+	return &pfs.MergeResponse{
+		Commit: newCommit,
+	}, nil
+}
+
+func (a *combinedAPIServer) Commit(ctx context.Context, commitRequest *pfs.CommitRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	return a.handleCommit(ctx, commitRequest)
+}
+
+func (a *combinedAPIServer) handleCommit(ctx context.Context, commitRequest *pfs.CommitRequest) (*google_protobuf.Empty, error) {
+	if commitRequest.Redirect {
+		return emptyInstance, a.commit(ctx, commitRequest.Commit, commitRequest.Message)
+	}
+	// rolling back uncommits the commit so it must not already be a read commit
+	getCommitInfoResponse, err := a.GetCommitInfo(ctx, &pfs.GetCommitInfoRequest{Commit: commitRequest.Commit})
+	if err != nil {
+		return nil, err
+	}
+	commitInfo := getCommitInfoResponse.CommitInfo
+	if commitInfo == nil {
+		return nil, fmt.Errorf("pachyderm: commit %s not found", commitRequest.Commit.Id)
+	}
+	if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_WRITE {
+		return nil, fmt.Errorf("pachyderm: commit %s is not a write commit", commitRequest.Commit.Id)
+	}
+	operation := &pfs.Operation{
+		OperationType: pfs.OperationType_OPERATION_TYPE_COMMIT,
+		Repository:    commitRequest.Commit.Repository,
+		Commit:        commitRequest.Commit,
+		Branch:        commitInfo.Branch,
+	}
+	if commitInfo.Branch != "" {
+		// the branch only moves if no other commit moved it since this one
+		// was branched from it
+		branchCommit, ok, err := a.getBranch(ctx, commitRequest.Commit.Repository, commitInfo.Branch)
+		if err != nil {
+			return nil, err
+		}
+		if ok && (commitInfo.ParentCommit == nil || branchCommit.Id != commitInfo.ParentCommit.Id) {
+			return nil, fmt.Errorf("pachyderm: branch %s moved to %s after commit %s was branched from it", commitInfo.Branch, branchCommit.Id, commitRequest.Commit.Id)
+		}
+		if !ok && commitInfo.ParentCommit != nil {
+			// a branch is started at the parent of its first commit, so it
+			// was deleted while the commit was open and stays deleted
+			operation.Branch = ""
+		}
+		operation.BranchCommit = branchCommit
+	}
+	return emptyInstance, a.runOperation(
+		ctx,
+		operation,
+		func() error {
+			if err := a.commit(ctx, commitRequest.Commit, commitRequest.Message); err != nil {
+				return err
+			}
+			clientConns, err := a.router.GetAllClientConns()
+			if err != nil {
+				return err
+			}
+			if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+				_, err := pfs.NewApiClient(clientConn).Commit(
+					ctx,
+					&pfs.CommitRequest{
+						Commit:   commitRequest.Commit,
+						Redirect: true,
+						Message:  commitRequest.Message,
+					},
+				)
+				return err
+			}); err != nil {
+				return err
+			}
+			// advance the branch the commit was made on
+			if operation.Branch != "" {
+				if _, err := a.handleCreateBranch(
+					ctx,
+					&pfs.CreateBranchRequest{
+						Commit:      commitRequest.Commit,
+						Name:        operation.Branch,
+						CheckAndSet: true,
+						PrevCommit:  operation.BranchCommit,
+					},
+				); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+}
+
+func (a *combinedAPIServer) commit(ctx context.Context, commit *pfs.Commit, message string) error {
+	shards, err := a.getMasterShards()
+	if err != nil {
+		return err
+	}
+	if err := a.driver.Commit(ctx, commit, message, shards); err != nil {
+		return err
+	}
+	return a.commitToReplicas(ctx, commit, nil)
+}
+
+func (a *combinedAPIServer) SquashCommits(ctx context.Context, squashCommitsRequest *pfs.SquashCommitsRequest) (*pfs.SquashCommitsResponse, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	commits := squashCommitsRequest.Commits
+	if !squashCommitsRequest.Redirect {
+		if squashCommitsRequest.FromCommit == nil || squashCommitsRequest.ToCommit == nil {
+			return nil, fmt.Errorf("pachyderm: must specify both from_commit and to_commit")
+		}
+		var err error
+		// the range is decided once so that every shard squashes the same commits
+		if commits, err = a.getSquashCommits(ctx, squashCommitsRequest.FromCommit, squashCommitsRequest.ToCommit); err != nil {
+			return nil, err
+		}
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("pachyderm: must set commits for redirect %+v", squashCommitsRequest)
+	}
+	if err := a.squashCommits(ctx, commits, squashCommitsRequest.Message, squashCommitsRequest.Redirect); err != nil {
+		return nil, err
+	}
+	return &pfs.SquashCommitsResponse{
+		Commit: commits[len(commits)-1],
+	}, nil
+}
+
+func (a *combinedAPIServer) DeleteCommit(ctx context.Context, deleteCommitRequest *pfs.DeleteCommitRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	if deleteCommitRequest.Redirect {
+		return emptyInstance, a.deleteLocalCommits(ctx, deleteCommitRequest.Commits)
+	}
+	commit, err := a.getDeleteCommit(ctx, deleteCommitRequest.Commit)
+	if err != nil {
+		return nil, err
+	}
+	return emptyInstance, a.deleteCommits(ctx, commit.Repository, []*pfs.Commit{commit})
+}
+
+func (a *combinedAPIServer) PullDiff(pullDiffRequest *pfs.PullDiffRequest, apiPullDiffServer pfs.InternalApi_PullDiffServer) error {
+	ctx := apiPullDiffServer.Context()
+	clientConn, err := a.getClientConnIfNecessary(int(pullDiffRequest.Shard), false)
+	if err != nil {
+		return err
+	}
+	if clientConn != nil {
+		apiPullDiffClient, err := pfs.NewInternalApiClient(clientConn).PullDiff(ctx, pullDiffRequest)
+		if err != nil {
+			return err
+		}
+		return protoutil.RelayFromStreamingBytesClient(apiPullDiffClient, apiPullDiffServer)
+	}
+	var buffer bytes.Buffer
+	if pullDiffRequest.NumShards != 0 {
+		if err := a.pullShardDiff(ctx, pullDiffRequest, &buffer); err != nil {
+			return err
+		}
+	} else if err := a.driver.PullDiff(ctx, pullDiffRequest.Commit, int(pullDiffRequest.Shard), &buffer); err != nil {
+		return err
+	}
+	return protoutil.WriteToStreamingBytesServer(
+		&buffer,
+		apiPullDiffServer,
+	)
+}
+
+// PushDiff applies a change the master of a shard made to the local replica
+// of the shard. The master pushes the changes of a shard one at a time, in
+// the order of their versions, so a version that isn't the next one means the
+// replica missed some and it backfills the shard from the master first.
+func (a *combinedAPIServer) PushDiff(ctx context.Context, pushDiffRequest *pfs.PushDiffRequest) (*google_protobuf.Empty, error) {
+	shard := int(pushDiffRequest.Shard)
+	ok, err := a.isLocalReplicaShard(shard)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("pachyderm: illegal PushDiffRequest for unknown shard %d", pushDiffRequest.Shard)
+	}
+	localVersion := a.getLocalVersion(shard)
+	if pushDiffRequest.Version <= localVersion {
+		// already applied
+		return emptyInstance, nil
+	}
+	if pushDiffRequest.Version > localVersion+1 {
+		// a replica misses the changes made between its backfill and the
+		// master finding it
+		if err := a.backfillShard(ctx, shard); err != nil {
+			return nil, fmt.Errorf("pachyderm: backfilling shard %d from version %d to %d: %v", shard, localVersion, pushDiffRequest.Version, err)
+		}
+	}
+	if pushDiffRequest.DeleteRepository != nil {
+		if err := a.deleteLocalRepository(ctx, pushDiffRequest.DeleteRepository, shard); err != nil {
+			return nil, err
+		}
+		a.forgetReadCommits()
+	}
+	for _, commit := range pushDiffRequest.Replaces {
+		if err := a.driver.DeleteCommit(ctx, commit, map[int]bool{shard: true}); err != nil {
+			return nil, err
+		}
+	}
+	if len(pushDiffRequest.Replaces) > 0 {
+		a.forgetReadCommits()
+	}
+	if pushDiffRequest.Commit != nil {
+		if err := a.pushLocalDiff(ctx, pushDiffRequest); err != nil {
+			return nil, err
+		}
+	}
+	return emptyInstance, a.setLocalVersion(shard, pushDiffRequest.Version)
+}
+
+// pushLocalDiff applies the diff of pushDiffRequest to the local replica,
+// unless a backfill already brought its commit.
+func (a *combinedAPIServer) pushLocalDiff(ctx context.Context, pushDiffRequest *pfs.PushDiffRequest) error {
+	shard := int(pushDiffRequest.Shard)
+	if pushDiffRequest.Parent != nil {
+		_, ok, err := a.driver.GetCommitInfo(ctx, pushDiffRequest.Parent, shard)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			clientConn, err := a.router.GetMasterClientConn(shard)
+			if err != nil {
+				return err
+			}
+			if err := a.backfill(ctx, pushDiffRequest.Commit.Repository, shard, clientConn); err != nil {
+				return fmt.Errorf("pachyderm: backfilling parent %s of %s on shard %d: %v", pushDiffRequest.Parent.Id, pushDiffRequest.Commit.Id, shard, err)
+			}
+		}
+	}
+	// the backfill brings the commit too once the master has it
+	_, ok, err := a.driver.GetCommitInfo(ctx, pushDiffRequest.Commit, shard)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	return a.driver.PushDiff(ctx, pushDiffRequest.Commit, bytes.NewReader(pushDiffRequest.Value))
+}
+
+func (a *combinedAPIServer) Rollback(ctx context.Context, rollbackRequest *pfs.RollbackRequest) (*google_protobuf.Empty, error) {
+	if err := a.rollback(ctx, rollbackRequest.Operation); err != nil {
+		return nil, err
+	}
+	if !rollbackRequest.Redirect {
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return nil, err
+		}
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			_, err := pfs.NewInternalApiClient(clientConn).Rollback(
+				ctx,
+				&pfs.RollbackRequest{
+					Operation: rollbackRequest.Operation,
+					Redirect:  true,
+				},
+			)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return emptyInstance, nil
+}
+
+func (a *combinedAPIServer) Recover() error {
+	ctx := context.Background()
+	operations, err := a.driver.ListOperations(ctx)
+	if err != nil {
+		return err
+	}
+	// later operations can depend on earlier ones so they are undone first
+	sort.Sort(newestOperationFirst(operations))
+	var failed []string
+	for _, operation := range operations {
+		// the peers may be restarting too, so a rollback that fails is retried
+		// until they are reachable
+		backoff := recoverMinBackoff
+		for attempt := 1; ; attempt++ {
+			_, err = a.Rollback(ctx, &pfs.RollbackRequest{Operation: operation})
+			if err == nil || attempt == recoverMaxAttempts {
+				break
+			}
+			log.Printf("pachyderm: rolling back operation %s failed, retrying in %v: %v", operation.Id, backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > recoverMaxBackoff {
+				backoff = recoverMaxBackoff
+			}
+		}
+		if err != nil {
+			// the operation stays in the journal so the next Recover tries again
+			log.Printf("pachyderm: giving up on rolling back operation %s: %v", operation.Id, err)
+			failed = append(failed, operation.Id)
+			continue
+		}
+		if err := a.driver.FinishOperation(ctx, operation); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("pachyderm: could not roll back operations %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// TODO(pedge): race on Branch
+func (a *combinedAPIServer) GetCommitInfo(ctx context.Context, getCommitInfoRequest *pfs.GetCommitInfoRequest) (*pfs.GetCommitInfoResponse, error) {
+	commit, err := a.resolveCommit(ctx, getCommitInfoRequest.Commit)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := a.getAllShards(false)
+	if err != nil {
+		return nil, err
+	}
+	var commitInfo *pfs.CommitInfo
+	for shard := range shards {
+		shardCommitInfo, ok, err := a.driver.GetCommitInfo(ctx, commit, shard)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			commitInfo = mergeCommitInfos(commitInfo, shardCommitInfo)
+		}
+	}
+	if !getCommitInfoRequest.Redirect {
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return nil, err
+		}
+		var lock sync.Mutex
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			getCommitInfoResponse, err := pfs.NewApiClient(clientConn).GetCommitInfo(
+				ctx,
+				&pfs.GetCommitInfoRequest{
+					Commit:   commit,
+					Redirect: true,
+				},
+			)
+			if err != nil {
+				return err
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if getCommitInfoResponse.CommitInfo != nil {
+				commitInfo = mergeCommitInfos(commitInfo, getCommitInfoResponse.CommitInfo)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return &pfs.GetCommitInfoResponse{
+		CommitInfo: commitInfo,
+	}, nil
+}
+
+func (a *combinedAPIServer) ListCommits(ctx context.Context, listCommitsRequest *pfs.ListCommitsRequest) (*pfs.ListCommitsResponse, error) {
+	shards, err := a.getAllShards(false)
+	if err != nil {
+		return nil, err
+	}
+	commitInfos := make(map[string]*pfs.CommitInfo)
+	for shard := range shards {
+		shardCommitInfos, err := a.driver.ListCommits(ctx, listCommitsRequest.Repository, shard)
+		if err != nil {
+			return nil, err
+		}
+		for _, commitInfo := range shardCommitInfos {
+			commitInfos[commitInfo.Commit.Id] = mergeCommitInfos(commitInfos[commitInfo.Commit.Id], commitInfo)
+		}
+	}
+	if !listCommitsRequest.Redirect {
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return nil, err
+		}
+		var lock sync.Mutex
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			listCommitsResponse, err := pfs.NewApiClient(clientConn).ListCommits(
+				ctx,
+				&pfs.ListCommitsRequest{
+					Repository: listCommitsRequest.Repository,
+					Redirect:   true,
+				},
+			)
+			if err != nil {
+				return err
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			for _, commitInfo := range listCommitsResponse.CommitInfo {
+				commitInfos[commitInfo.Commit.Id] = mergeCommitInfos(commitInfos[commitInfo.Commit.Id], commitInfo)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	var sorted []*pfs.CommitInfo
+	for _, commitInfo := range commitInfos {
+		sorted = append(sorted, commitInfo)
+	}
+	sort.Sort(newestFirst(sorted))
+	return &pfs.ListCommitsResponse{
+		CommitInfo: sorted,
+	}, nil
+}
+
+func (a *combinedAPIServer) CreateBranch(ctx context.Context, createBranchRequest *pfs.CreateBranchRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	return a.handleCreateBranch(ctx, createBranchRequest)
+}
+
+func (a *combinedAPIServer) handleCreateBranch(ctx context.Context, createBranchRequest *pfs.CreateBranchRequest) (*google_protobuf.Empty, error) {
+	commit := createBranchRequest.Commit
+	if !createBranchRequest.Redirect {
+		if err := checkBranchName(createBranchRequest.Name); err != nil {
+			return nil, err
+		}
+		// a branch would hide a commit with the same id
+		getCommitInfoResponse, err := a.GetCommitInfo(
+			ctx,
+			&pfs.GetCommitInfoRequest{
+				Commit: &pfs.Commit{
+					Repository: commit.Repository,
+					Id:         createBranchRequest.Name,
+				},
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if getCommitInfoResponse.CommitInfo != nil && getCommitInfoResponse.CommitInfo.Commit.Id == createBranchRequest.Name {
+			return nil, fmt.Errorf("pachyderm: %s is already a commit id", createBranchRequest.Name)
+		}
+		getCommitInfoResponse, err = a.GetCommitInfo(ctx, &pfs.GetCommitInfoRequest{Commit: commit})
+		if err != nil {
+			return nil, err
+		}
+		if getCommitInfoResponse.CommitInfo == nil {
+			return nil, fmt.Errorf("pachyderm: commit %s not found", commit.Id)
+		}
+		if getCommitInfoResponse.CommitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+			return nil, fmt.Errorf("pachyderm: commit %s is not a read commit", commit.Id)
+		}
+		commit = getCommitInfoResponse.CommitInfo.Commit
+		clientConn, err := a.getBranchClientConn()
+		if err != nil {
+			return nil, err
+		}
+		if clientConn != nil {
+			return pfs.NewApiClient(clientConn).CreateBranch(
+				ctx,
+				&pfs.CreateBranchRequest{
+					Commit:      commit,
+					Name:        createBranchRequest.Name,
+					CheckAndSet: createBranchRequest.CheckAndSet,
+					PrevCommit:  createBranchRequest.PrevCommit,
+				},
+			)
+		}
+	}
+	if err := a.setBranch(ctx, commit.Repository, createBranchRequest.Name, commit, func(branchCommit *pfs.Commit, ok bool) error {
+		// the server that decides branch changes already checked a
+		// redirected request
+		if !createBranchRequest.CheckAndSet || createBranchRequest.Redirect {
+			return nil
+		}
+		prevCommit := createBranchRequest.PrevCommit
+		if ok != (prevCommit != nil) || (ok && branchCommit.Id != prevCommit.Id) {
+			return fmt.Errorf("pachyderm: branch %s was moved by another commit", createBranchRequest.Name)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if !createBranchRequest.Redirect {
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return nil, err
+		}
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			_, err := pfs.NewApiClient(clientConn).CreateBranch(
+				ctx,
+				&pfs.CreateBranchRequest{
+					Commit:   commit,
+					Name:     createBranchRequest.Name,
+					Redirect: true,
+				},
+			)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return emptyInstance, nil
+}
+
+func (a *combinedAPIServer) ListBranches(ctx context.Context, listBranchesRequest *pfs.ListBranchesRequest) (*pfs.ListBranchesResponse, error) {
+	// every server has every branch
+	branchInfos, err := a.driver.ListBranches(ctx, listBranchesRequest.Repository)
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.ListBranchesResponse{
+		BranchInfo: branchInfos,
+	}, nil
+}
+
+func (a *combinedAPIServer) DeleteBranch(ctx context.Context, deleteBranchRequest *pfs.DeleteBranchRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	if !deleteBranchRequest.Redirect {
+		clientConn, err := a.getBranchClientConn()
+		if err != nil {
+			return nil, err
+		}
+		if clientConn != nil {
+			return pfs.NewApiClient(clientConn).DeleteBranch(ctx, deleteBranchRequest)
+		}
+	}
+	if err := a.setBranch(ctx, deleteBranchRequest.Repository, deleteBranchRequest.Name, nil, func(*pfs.Commit, bool) error { return nil }); err != nil {
+		return nil, err
+	}
+	if !deleteBranchRequest.Redirect {
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return nil, err
+		}
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			_, err := pfs.NewApiClient(clientConn).DeleteBranch(
+				ctx,
+				&pfs.DeleteBranchRequest{
+					Repository: deleteBranchRequest.Repository,
+					Name:       deleteBranchRequest.Name,
+					Redirect:   true,
+				},
+			)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return emptyInstance, nil
+}
+
+func (a *combinedAPIServer) SetRetentionPolicy(ctx context.Context, setRetentionPolicyRequest *pfs.SetRetentionPolicyRequest) (*google_protobuf.Empty, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	if !setRetentionPolicyRequest.Redirect {
+		if err := checkRetentionPolicy(setRetentionPolicyRequest.RetentionPolicy); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.driver.SetRetentionPolicy(ctx, setRetentionPolicyRequest.Repository, setRetentionPolicyRequest.RetentionPolicy); err != nil {
+		return nil, err
+	}
+	if !setRetentionPolicyRequest.Redirect {
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return nil, err
+		}
+		if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+			_, err := pfs.NewApiClient(clientConn).SetRetentionPolicy(
+				ctx,
+				&pfs.SetRetentionPolicyRequest{
+					Repository:      setRetentionPolicyRequest.Repository,
+					RetentionPolicy: setRetentionPolicyRequest.RetentionPolicy,
+					Redirect:        true,
+				},
+			)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return emptyInstance, nil
+}
+
+func (a *combinedAPIServer) GetRetentionPolicy(ctx context.Context, getRetentionPolicyRequest *pfs.GetRetentionPolicyRequest) (*pfs.GetRetentionPolicyResponse, error) {
+	// every server has every retention policy
+	retentionPolicy, err := a.driver.GetRetentionPolicy(ctx, getRetentionPolicyRequest.Repository)
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.GetRetentionPolicyResponse{
+		RetentionPolicy: retentionPolicy,
+	}, nil
+}
+
+func (a *combinedAPIServer) GarbageCollect(ctx context.Context, garbageCollectRequest *pfs.GarbageCollectRequest) (*pfs.GarbageCollectResponse, error) {
+	finishWrite, err := a.startWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer finishWrite()
+	repositories := []*pfs.Repository{garbageCollectRequest.Repository}
+	if garbageCollectRequest.Repository == nil {
+		listRepositoriesResponse, err := a.ListRepositories(ctx, &pfs.ListRepositoriesRequest{})
+		if err != nil {
+			return nil, err
+		}
+		repositories = listRepositoriesResponse.Repository
+	}
+	garbageCollectResponse := &pfs.GarbageCollectResponse{}
+	for _, repository := range repositories {
+		if err := a.garbageCollect(ctx, repository, garbageCollectRequest.DryRun, garbageCollectResponse); err != nil {
+			return nil, err
+		}
+	}
+	return garbageCollectResponse, nil
+}
+
+func (a *combinedAPIServer) CollectGarbage() error {
+	// only the master of the lowest shard that has a master collects so that
+	// servers don't race each other and a shard without a master doesn't stop
+	// garbage collection
+	for shard := 0; shard < a.getSharder().NumShards(); shard++ {
+		ok, err := a.isLocalMasterShard(shard)
+		if err != nil {
+			return err
+		}
+		if ok {
+			_, err := a.GarbageCollect(context.Background(), &pfs.GarbageCollectRequest{})
+			return err
+		}
+		if _, err := a.router.GetMasterClientConn(shard); err == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Master does nothing, a master already has its shards.
+func (a *combinedAPIServer) Master(shard int) error {
+	return nil
+}
+
+// Replica backfills shard from its master. Write commits are pushed to the
+// replicas when they are committed. The local server then has the version of
+// shard from before the backfill, a change made during it is only counted
+// once it's pushed.
+func (a *combinedAPIServer) Replica(shard int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaTimeout)
+	defer cancel()
+	version, err := a.router.GetVersion(shard)
+	if err != nil {
+		return err
+	}
+	if err := a.backfillShard(ctx, shard); err != nil {
+		return err
+	}
+	return a.setLocalVersion(shard, version)
+}
+
+// Clear does nothing, the commits of shard stay on the driver.
+func (a *combinedAPIServer) Clear(shard int) error {
+	return nil
+}
+
+// Reshard runs each phase on every server before the next. Only one Reshard
+// may run at a time.
+func (a *combinedAPIServer) Reshard(ctx context.Context, reshardRequest *pfs.ReshardRequest) (*pfs.ReshardResponse, error) {
+	sharder, err := a.getSharder().WithNumShards(int(reshardRequest.NumShards))
+	if err != nil {
+		return nil, err
+	}
+	if sharder.NumShards() == a.getSharder().NumShards() {
+		return &pfs.ReshardResponse{}, nil
+	}
+	reshardID := newID()
+	// starting here first fails, without aborting anything, if a Reshard
+	// through this server is running
+	if _, err := a.RunReshardPhase(
+		ctx,
+		&pfs.ReshardPhaseRequest{
+			ReshardPhase: pfs.ReshardPhase_RESHARD_PHASE_START,
+			NumShards:    reshardRequest.NumShards,
+			ReshardId:    reshardID,
+		},
+	); err != nil {
+		return nil, err
+	}
+	reshardResponse, err := a.reshard(ctx, reshardRequest.NumShards, reshardID)
+	if err != nil {
+		// servers that already finished keep the new shards, and servers
+		// running another Reshard ignore the abort
+		if _, abortErr := a.runReshardPhase(context.Background(), pfs.ReshardPhase_RESHARD_PHASE_ABORT, reshardRequest.NumShards, reshardID, true); abortErr != nil {
+			return nil, fmt.Errorf("pachyderm: %v, aborting the reshard failed: %v", err, abortErr)
+		}
+		return nil, err
+	}
+	return reshardResponse, nil
+}
+
+func (a *combinedAPIServer) RunReshardPhase(ctx context.Context, reshardPhaseRequest *pfs.ReshardPhaseRequest) (*pfs.ReshardResponse, error) {
+	numShards := int(reshardPhaseRequest.NumShards)
+	switch reshardPhaseRequest.ReshardPhase {
+	case pfs.ReshardPhase_RESHARD_PHASE_START:
+		return &pfs.ReshardResponse{}, a.startReshard(ctx, numShards, reshardPhaseRequest.ReshardId)
+	case pfs.ReshardPhase_RESHARD_PHASE_MIGRATE:
+		numFiles, err := a.migrate(ctx, numShards)
+		if err != nil {
+			return nil, err
+		}
+		return &pfs.ReshardResponse{NumFiles: numFiles}, nil
+	case pfs.ReshardPhase_RESHARD_PHASE_FINISH:
+		return &pfs.ReshardResponse{}, a.finishReshard(numShards, reshardPhaseRequest.ReshardId)
+	case pfs.ReshardPhase_RESHARD_PHASE_CLEAN:
+		return &pfs.ReshardResponse{}, a.cleanReshard(ctx, reshardPhaseRequest.ReshardId)
+	case pfs.ReshardPhase_RESHARD_PHASE_ABORT:
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		if a.reshardID == reshardPhaseRequest.ReshardId {
+			a.reshardID = ""
+		}
+		return &pfs.ReshardResponse{}, nil
+	default:
+		return nil, fmt.Errorf("pachyderm: unknown reshard phase %v", reshardPhaseRequest.ReshardPhase)
+	}
+}
+
+// hopContext returns the context for a unary call to another server, it is
+// done when ctx is or, if a hop timeout is set, when the timeout passes.
+// Streams relay an unbounded amount of data and only use ctx.
+func (a *combinedAPIServer) hopContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.hopTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, a.hopTimeout)
+}
+
+// fanOut calls f with each of clientConns, at most fanOutParallelism at once,
+// and a context from hopContext.
+func (a *combinedAPIServer) fanOut(ctx context.Context, clientConns []*grpc.ClientConn, f func(ctx context.Context, clientConn *grpc.ClientConn) error) error {
+	funcs := make([]func() error, len(clientConns))
+	for i, clientConn := range clientConns {
+		clientConn := clientConn
+		funcs[i] = func() error {
+			hopCtx, cancel := a.hopContext(ctx)
+			defer cancel()
+			return f(hopCtx, clientConn)
+		}
+	}
+	return concurrent.Run(a.fanOutParallelism, funcs...)
+}
+
+// getFile sends the bytes of path from clientConn, or from shard if
+// clientConn is nil. It returns false if it failed before sending anything,
+// in which case the read can go to another server.
+func (a *combinedAPIServer) getFile(ctx context.Context, getFileRequest *pfs.GetFileRequest, path *pfs.Path, shard int, clientConn *grpc.ClientConn, apiGetFileServer pfs.Api_GetFileServer) (_ bool, retErr error) {
+	if clientConn != nil {
+		apiGetFileClient, err := pfs.NewApiClient(clientConn).GetFile(
+			ctx,
+			&pfs.GetFileRequest{
+				Path:        path,
+				OffsetBytes: getFileRequest.OffsetBytes,
+				SizeBytes:   getFileRequest.SizeBytes,
+				Redirect:    true,
+			},
+		)
+		if err != nil {
+			return false, err
+		}
+		value, err := apiGetFileClient.Recv()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := apiGetFileServer.Send(value); err != nil {
+			return true, err
+		}
+		return true, protoutil.RelayFromStreamingBytesClient(apiGetFileClient, apiGetFileServer)
+	}
+	if err := a.checkLocalRead(ctx, path.Commit, shard); err != nil {
+		return false, err
+	}
+	file, err := a.driver.GetFile(ctx, path, shard)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	return true, protoutil.WriteToStreamingBytesServer(
+		io.NewSectionReader(file, getFileRequest.OffsetBytes, getFileRequest.SizeBytes),
+		apiGetFileServer,
+	)
+}
+
+// getFileInfo returns the info of path from clientConn, or from shard if
+// clientConn is nil.
+func (a *combinedAPIServer) getFileInfo(ctx context.Context, path *pfs.Path, shard int, clientConn *grpc.ClientConn) (*pfs.GetFileInfoResponse, error) {
+	if clientConn != nil {
+		hopCtx, cancel := a.hopContext(ctx)
+		defer cancel()
+		return pfs.NewApiClient(clientConn).GetFileInfo(
+			hopCtx,
+			&pfs.GetFileInfoRequest{
+				Path:     path,
+				Redirect: true,
+			},
+		)
+	}
+	if err := a.checkLocalRead(ctx, path.Commit, shard); err != nil {
+		return nil, err
+	}
+	fileInfo, ok, err := a.driver.GetFileInfo(ctx, path, shard)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &pfs.GetFileInfoResponse{}, nil
+	}
+	return &pfs.GetFileInfoResponse{
+		FileInfo: fileInfo,
+	}, nil
+}
+
+// getReadClientConns returns the servers to read shard from in the order to
+// try them, nil is this server. Reads of read commits go to the replicas, the
+// local one first and the others in random order, unless readConsistency asks
+// for the master, and fall back to the master.
+func (a *combinedAPIServer) getReadClientConns(ctx context.Context, commit *pfs.Commit, shard int, readConsistency pfs.ReadConsistency) ([]*grpc.ClientConn, error) {
+	ok, err := a.isLocalMasterShard(shard)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return []*grpc.ClientConn{nil}, nil
+	}
+	masterClientConn, masterErr := a.router.GetMasterClientConn(shard)
+	if readConsistency != pfs.ReadConsistency_READ_CONSISTENCY_MASTER {
+		ok, err := a.isLocalReadCommit(ctx, commit)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ok, err := a.isLocalReplicaShard(shard)
+			if err != nil {
+				return nil, err
+			}
+			var clientConns []*grpc.ClientConn
+			if ok {
+				clientConns = append(clientConns, nil)
+			}
+			replicaClientConns, err := a.router.GetReplicaClientConns(shard)
+			if err != nil {
+				return nil, err
+			}
+			for _, clientConn := range replicaClientConns {
+				if clientConn != masterClientConn {
+					clientConns = append(clientConns, clientConn)
+				}
+			}
+			if len(clientConns) > 0 {
+				// without a master the replicas still have the read commits
+				if masterErr == nil {
+					clientConns = append(clientConns, masterClientConn)
+				}
+				return clientConns, nil
+			}
+		}
+	}
+	if masterErr != nil {
+		return nil, masterErr
+	}
+	return []*grpc.ClientConn{masterClientConn}, nil
+}
+
+// isLocalReadCommit returns true if commit is a read commit on the local
+// shards, Commit makes it one on every shard.
+func (a *combinedAPIServer) isLocalReadCommit(ctx context.Context, commit *pfs.Commit) (bool, error) {
+	shards, err := a.getAllShards(true)
+	if err != nil {
+		return false, err
+	}
+	for shard := range shards {
+		if a.isCachedReadCommit(commit, shard) {
+			return true, nil
+		}
+	}
+	for shard := range shards {
+		ok, err := a.isReadCommit(ctx, commit, shard)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkLocalRead returns an error if commit can't be read from shard here, a
+// replica only has the read commits the master pushed to it.
+func (a *combinedAPIServer) checkLocalRead(ctx context.Context, commit *pfs.Commit, shard int) error {
+	ok, err := a.isLocalMasterShard(shard)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	ok, err = a.isLocalReplicaShard(shard)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("pachyderm: shard %d is not on this server", shard)
+	}
+	ok, err = a.isReadCommit(ctx, commit, shard)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("pachyderm: replica of shard %d doesn't have read commit %s", shard, commit.Id)
+	}
+	return nil
+}
+
+// isReadCommit returns true if commit is a read commit on the local shard,
+// the answer is cached when it is.
+func (a *combinedAPIServer) isReadCommit(ctx context.Context, commit *pfs.Commit, shard int) (bool, error) {
+	if a.isCachedReadCommit(commit, shard) {
+		return true, nil
+	}
+	commitInfo, ok, err := a.driver.GetCommitInfo(ctx, commit, shard)
+	if err != nil {
+		return false, err
+	}
+	if !ok || commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+		return false, nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.readCommits[readCommitKey(commit, shard)] = true
+	return true, nil
+}
+
+func (a *combinedAPIServer) isCachedReadCommit(commit *pfs.Commit, shard int) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.readCommits[readCommitKey(commit, shard)]
+}
+
+// forgetReadCommits empties the read commit cache, it's called after commits
+// are deleted or rolled back, which is rare enough not to track which.
+func (a *combinedAPIServer) forgetReadCommits() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.readCommits = make(map[string]bool)
+}
+
+func readCommitKey(commit *pfs.Commit, shard int) string {
+	return fmt.Sprintf("%s/%s/%d", commit.Repository.Name, commit.Id, shard)
+}
+
+func (a *combinedAPIServer) getShardAndClientConnIfNecessary(path *pfs.Path, replicaOk bool) (int, *grpc.ClientConn, error) {
+	shard, err := a.getSharder().GetShard(path)
+	if err != nil {
+		return shard, nil, err
+	}
+	clientConn, err := a.getClientConnIfNecessary(shard, replicaOk)
+	return shard, clientConn, err
+}
+
+func (a *combinedAPIServer) getClientConnIfNecessary(shard int, replicaOk bool) (*grpc.ClientConn, error) {
+	ok, err := a.isLocalMasterShard(shard)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if !replicaOk {
+			clientConn, err := a.router.GetMasterClientConn(shard)
+			return clientConn, err
+		}
+		ok, err = a.isLocalReplicaShard(shard)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			clientConn, err := a.router.GetMasterOrReplicaClientConn(shard)
+			return clientConn, err
+		}
+	}
+	return nil, nil
+}
+
+func (a *combinedAPIServer) getSharder() route.Sharder {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.sharder
+}
+
+// getMasterShards returns the local master shards, leaving out the shards
+// that only a Reshard in progress uses.
+func (a *combinedAPIServer) getMasterShards() (map[int]bool, error) {
+	shards, err := a.router.GetMasterShards()
+	if err != nil {
+		return nil, err
+	}
+	return a.filterNewShards(shards), nil
+}
+
+// getReplicaShards is like getMasterShards for the local replica shards.
+func (a *combinedAPIServer) getReplicaShards() (map[int]bool, error) {
+	shards, err := a.router.GetReplicaShards()
+	if err != nil {
+		return nil, err
+	}
+	return a.filterNewShards(shards), nil
+}
+
+func (a *combinedAPIServer) filterNewShards(shards map[int]bool) map[int]bool {
+	numShards := a.getSharder().NumShards()
+	for shard := range shards {
+		if shard >= numShards {
+			delete(shards, shard)
+		}
+	}
+	return shards
+}
+
+// startWrite fails while the cluster is being resharded, otherwise a Reshard
+// waits to start until the returned func is called. It is only called at the
+// start of an RPC, writes made on behalf of one go through the handle funcs so
+// that a Reshard can't fail the RPC half way through.
+func (a *combinedAPIServer) startWrite() (func(), error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.reshardID != "" {
+		return nil, fmt.Errorf("pachyderm: the cluster is being resharded")
+	}
+	a.numWrites++
+	return func() {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		a.numWrites--
+		a.writesDone.Broadcast()
+	}, nil
+}
+
+func (a *combinedAPIServer) getAllShards(replicaToo bool) (map[int]bool, error) {
+	shards, err := a.getMasterShards()
+	if err != nil {
+		return nil, err
+	}
+	if replicaToo {
+		replicaShards, err := a.getReplicaShards()
+		if err != nil {
+			return nil, err
+		}
+		for replicaShard := range replicaShards {
+			shards[replicaShard] = true
+		}
+	}
+	return shards, nil
+}
+
+// getDriverShards returns the shards the local driver can have data of, the
+// local shards and every shard of the sharder, since a server keeps the data
+// of the roles it gave up.
+func (a *combinedAPIServer) getDriverShards() (map[int]bool, error) {
+	shards, err := a.getAllShards(true)
+	if err != nil {
+		return nil, err
+	}
+	for shard := 0; shard < a.getSharder().NumShards(); shard++ {
+		shards[shard] = true
+	}
+	return shards, nil
+}
+
+// getFilteredShards returns the local master shards that fall within dynamicShard.
+func (a *combinedAPIServer) getFilteredShards(dynamicShard *pfs.Shard) (map[int]bool, error) {
+	shards, err := a.getAllShards(false)
+	if err != nil {
+		return nil, err
+	}
+	if dynamicShard == nil {
+		dynamicShard = &pfs.Shard{Number: 0, Modulo: 1}
+	}
+	filteredShards := make(map[int]bool)
+	for shard := range shards {
+		if uint64(shard)%dynamicShard.Modulo == dynamicShard.Number {
+			filteredShards[shard] = true
+		}
+	}
+	return filteredShards, nil
+}
+
+func (a *combinedAPIServer) isLocalMasterShard(shard int) (bool, error) {
+	shards, err := a.router.GetMasterShards()
+	if err != nil {
+		return false, err
+	}
+	_, ok := shards[shard]
+	return ok, nil
+}
+
+func (a *combinedAPIServer) isLocalReplicaShard(shard int) (bool, error) {
+	shards, err := a.router.GetReplicaShards()
+	if err != nil {
+		return false, err
+	}
+	_, ok := shards[shard]
+	return ok, nil
+}
+
+// isMoved returns true if path is a file that a Reshard moved off shard, it
+// stays on shard in the commits from before the Reshard.
+func (a *combinedAPIServer) isMoved(path *pfs.Path, fileType pfs.FileType, shard int) (bool, error) {
+	if fileType == pfs.FileType_FILE_TYPE_DIR {
+		// directories live on every shard
+		return false, nil
+	}
+	pathShard, err := a.getSharder().GetShard(path)
+	if err != nil {
+		return false, err
+	}
+	return pathShard != shard, nil
+}
+
+// getBranchClientConn returns the server that decides branch changes, nil if
+// it is this one. It is the master of the lowest shard that has a master, so
+// that the check and sets of a branch happen in one place, under its
+// branchLock, before they are fanned out.
+func (a *combinedAPIServer) getBranchClientConn() (*grpc.ClientConn, error) {
+	for shard := 0; shard < a.getSharder().NumShards(); shard++ {
+		ok, err := a.isLocalMasterShard(shard)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, nil
+		}
+		if clientConn, err := a.router.GetMasterClientConn(shard); err == nil {
+			return clientConn, nil
+		}
+	}
+	return nil, fmt.Errorf("pachyderm: no shard has a master")
+}
+
+// startBranch points branch at commit if repository has no such branch, so that
+// a commit made on it can tell the branch was deleted while it was open.
+func (a *combinedAPIServer) startBranch(ctx context.Context, commit *pfs.Commit, branch string) error {
+	if commit == nil {
+		return nil
+	}
+	_, ok, err := a.getBranch(ctx, commit.Repository, branch)
+	if err != nil || ok {
+		return err
+	}
+	_, err = a.handleCreateBranch(
+		ctx,
+		&pfs.CreateBranchRequest{
+			Commit:      commit,
+			Name:        branch,
+			CheckAndSet: true,
+		},
+	)
+	return err
+}
+
+// setBranch points the branch name at commit, or deletes it if commit is nil,
+// if check, which is passed where the branch points, returns nil.
+func (a *combinedAPIServer) setBranch(ctx context.Context, repository *pfs.Repository, name string, commit *pfs.Commit, check func(branchCommit *pfs.Commit, ok bool) error) error {
+	a.branchLock.Lock()
+	defer a.branchLock.Unlock()
+	branchCommit, ok, err := a.getBranch(ctx, repository, name)
+	if err != nil {
+		return err
+	}
+	if err := check(branchCommit, ok); err != nil {
+		return err
+	}
+	if commit == nil {
+		return a.driver.DeleteBranch(ctx, repository, name)
+	}
+	return a.driver.CreateBranch(ctx, repository, name, commit)
+}
+
+// getBranch returns the commit the branch name points at, it returns false if
+// repository has no such branch.
+func (a *combinedAPIServer) getBranch(ctx context.Context, repository *pfs.Repository, name string) (*pfs.Commit, bool, error) {
+	branchInfos, err := a.driver.ListBranches(ctx, repository)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, branchInfo := range branchInfos {
+		if branchInfo.Name == name {
+			return branchInfo.Commit, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// resolveCommit returns the commit a branch points at if commit's id is the
+// name of a branch, otherwise commit is returned.
+// Requests are resolved once by the server that receives them so that every
+// shard sees the same commit even if the branch moves.
+func (a *combinedAPIServer) resolveCommit(ctx context.Context, commit *pfs.Commit) (*pfs.Commit, error) {
+	if commit == nil {
+		return nil, nil
+	}
+	branchCommit, ok, err := a.getBranch(ctx, commit.Repository, commit.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return commit, nil
+	}
+	return branchCommit, nil
+}
+
+func (a *combinedAPIServer) resolvePath(ctx context.Context, path *pfs.Path) (*pfs.Path, error) {
+	commit, err := a.resolveCommit(ctx, path.Commit)
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.Path{
+		Commit: commit,
+		Path:   path.Path,
+	}, nil
+}
+
+// getMergeBase returns the closest ancestor of ours that is also an ancestor of theirs.
+func (a *combinedAPIServer) getMergeBase(ctx context.Context, ours *pfs.Commit, theirs *pfs.Commit) (*pfs.Commit, error) {
+	listCommitsResponse, err := a.ListCommits(ctx, &pfs.ListCommitsRequest{Repository: ours.Repository})
+	if err != nil {
+		return nil, err
+	}
+	commitInfos := make(map[string]*pfs.CommitInfo)
+	for _, commitInfo := range listCommitsResponse.CommitInfo {
+		commitInfos[commitInfo.Commit.Id] = commitInfo
+	}
+	for _, commit := range []*pfs.Commit{ours, theirs} {
+		commitInfo, ok := commitInfos[commit.Id]
+		if !ok {
+			return nil, fmt.Errorf("pachyderm: commit %s not found", commit.Id)
+		}
+		if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+			return nil, fmt.Errorf("pachyderm: commit %s is not a read commit", commit.Id)
+		}
+	}
+	// walk visits id and its ancestors breadth first until visit returns true
+	walk := func(id string, visit func(id string) bool) {
+		seen := make(map[string]bool)
+		queue := []string{id}
+		for len(queue) > 0 {
+			id, queue = queue[0], queue[1:]
+			commitInfo, ok := commitInfos[id]
+			if !ok || seen[id] {
+				continue
+			}
+			seen[id] = true
+			if visit(id) {
+				return
+			}
+			if commitInfo.ParentCommit != nil {
+				queue = append(queue, commitInfo.ParentCommit.Id)
+			}
+			if commitInfo.MergeParentCommit != nil {
+				queue = append(queue, commitInfo.MergeParentCommit.Id)
+			}
+		}
+	}
+	theirsAncestors := make(map[string]bool)
+	walk(theirs.Id, func(id string) bool {
+		theirsAncestors[id] = true
+		return false
+	})
+	var base *pfs.Commit
+	walk(ours.Id, func(id string) bool {
+		if theirsAncestors[id] {
+			base = &pfs.Commit{
+				Repository: ours.Repository,
+				Id:         id,
+			}
+			return true
+		}
+		return false
+	})
+	if base == nil {
+		return nil, fmt.Errorf("pachyderm: commits %s and %s have no common ancestor", ours.Id, theirs.Id)
+	}
+	return base, nil
+}
+
+// getMergePaths returns the sorted paths Merge takes from theirs, a path is
+// taken if theirs changed it since the merge base and it differs from ours.
+func (a *combinedAPIServer) getMergePaths(ctx context.Context, ours *pfs.Commit, theirs *pfs.Commit, conflictPolicy pfs.ConflictPolicy) ([]string, error) {
+	base, err := a.getMergeBase(ctx, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	oursChanged, err := a.getChangedPaths(ctx, base, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsChanged, err := a.getChangedPaths(ctx, base, theirs)
+	if err != nil {
+		return nil, err
+	}
+	different, err := a.getChangedPaths(ctx, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for path := range theirsChanged {
+		if !different[path] {
+			continue
+		}
+		if conflicts(path, oursChanged) {
+			switch conflictPolicy {
+			case pfs.ConflictPolicy_CONFLICT_POLICY_OURS:
+				continue
+			case pfs.ConflictPolicy_CONFLICT_POLICY_THEIRS:
+			default:
+				return nil, fmt.Errorf("pachyderm: merge conflict on %s", path)
+			}
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (a *combinedAPIServer) getChangedPaths(ctx context.Context, fromCommit *pfs.Commit, toCommit *pfs.Commit) (map[string]bool, error) {
+	listChangedFilesResponse, err := a.ListChangedFiles(
+		ctx,
+		&pfs.ListChangedFilesRequest{
+			FromCommit: fromCommit,
+			ToCommit:   toCommit,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool)
+	for _, change := range listChangedFilesResponse.Change {
+		paths[change.Path.Path] = true
+	}
+	return paths, nil
+}
+
+// listFiles calls send with the files listFilesRequest asks for in path order.
+// Every shard's files are walked in path order, on this server and on the
+// others, and then merged so the whole listing never has to be held at once.
+func (a *combinedAPIServer) listFiles(ctx context.Context, listFilesRequest *pfs.ListFilesRequest, send func(*pfs.FileInfo) error) error {
+	if err := checkPattern(listFilesRequest.Pattern); err != nil {
+		return err
+	}
+	path, err := a.resolvePath(ctx, listFilesRequest.Path)
+	if err != nil {
+		return err
+	}
+	// a listing of the files of one directory only needs its shard, unless
+	// the caller already asked for a subset of the shards
+	if !listFilesRequest.Redirect && (listFilesRequest.Shard == nil || listFilesRequest.Shard.Modulo <= 1) {
+		shard, ok, err := a.getSharder().GetDirectoryShard(path, listFilesRequest.Recursive)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return a.listShardFiles(ctx, listFilesRequest, path, shard, send)
+		}
+	}
+	filteredShards, err := a.getFilteredShards(listFilesRequest.Shard)
+	if err != nil {
+		return err
+	}
+	var nexts []func() (*pfs.FileInfo, error)
+	// the other servers' streams are opened first so that they walk their
+	// shards while we walk ours
+	if !listFilesRequest.Redirect {
+		// stop the other servers' streams if we return early
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		clientConns, err := a.router.GetAllClientConns()
+		if err != nil {
+			return err
+		}
+		for _, clientConn := range clientConns {
+			apiListFilesStreamClient, err := pfs.NewApiClient(clientConn).ListFilesStream(
+				ctx,
+				&pfs.ListFilesRequest{
+					Path:      path,
+					Shard:     listFilesRequest.Shard,
+					Redirect:  true,
+					Recursive: listFilesRequest.Recursive,
+					Pattern:   listFilesRequest.Pattern,
+				},
+			)
+			if err != nil {
+				return err
+			}
+			nexts = append(nexts, func() (*pfs.FileInfo, error) {
+				fileInfo, err := apiListFilesStreamClient.Recv()
+				if err == io.EOF {
+					return nil, nil
+				}
+				return fileInfo, err
+			})
+		}
+	}
+	for shard := range filteredShards {
+		nexts = append(nexts, a.walkFiles(ctx, path, shard, listFilesRequest.Recursive, listFilesRequest.Pattern))
+	}
+	return mergeFileInfos(nexts, send)
+}
+
+// listShardFiles is listFiles for a path whose files are all on shard,
+// directories are on every shard so shard has all of them too.
+func (a *combinedAPIServer) listShardFiles(ctx context.Context, listFilesRequest *pfs.ListFilesRequest, path *pfs.Path, shard int, send func(*pfs.FileInfo) error) error {
+	clientConn, err := a.getClientConnIfNecessary(shard, false)
+	if err != nil {
+		return err
+	}
+	if clientConn != nil {
+		apiListFilesStreamClient, err := pfs.NewApiClient(clientConn).ListFilesStream(
+			ctx,
+			&pfs.ListFilesRequest{
+				Path: path,
+				// no other shard falls within this one
+				Shard: &pfs.Shard{
+					Number: uint64(shard),
+					Modulo: uint64(a.getSharder().NumShards()),
+				},
+				Redirect:  true,
+				Recursive: listFilesRequest.Recursive,
+				Pattern:   listFilesRequest.Pattern,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		for {
+			fileInfo, err := apiListFilesStreamClient.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := send(fileInfo); err != nil {
+				return err
+			}
+		}
+	}
+	next := a.walkFiles(ctx, path, shard, listFilesRequest.Recursive, listFilesRequest.Pattern)
+	for {
+		fileInfo, err := next()
+		if err != nil {
+			return err
+		}
+		if fileInfo == nil {
+			return nil
+		}
+		if err := send(fileInfo); err != nil {
+			return err
+		}
+	}
+}
+
+// walkFiles returns a function that returns the files in path on shard, and
+// everything below them if recursive, that match pattern in path order and
+// then nil. Only the directories that were reached are listed, a directory's
+// files come after it so it's listed when it's the first file left.
+func (a *combinedAPIServer) walkFiles(ctx context.Context, path *pfs.Path, shard int, recursive bool, pattern string) func() (*pfs.FileInfo, error) {
+	var pending byPath
+	list := func(dir *pfs.Path) error {
+		fileInfos, err := a.driver.ListFiles(ctx, dir, shard)
+		if err != nil {
+			return err
+		}
+		for _, fileInfo := range fileInfos {
+			moved, err := a.isMoved(fileInfo.Path, fileInfo.FileType, shard)
+			if err != nil {
+				return err
+			}
+			if !moved {
+				heap.Push(&pending, fileInfo)
+			}
+		}
+		return nil
+	}
+	listed := false
+	return func() (*pfs.FileInfo, error) {
+		if !listed {
+			listed = true
+			if err := list(path); err != nil {
+				return nil, err
+			}
+		}
+		for pending.Len() > 0 {
+			fileInfo := heap.Pop(&pending).(*pfs.FileInfo)
+			name := cleanPath(fileInfo.Path.Path)
+			// nothing below a directory that can't lead to a match can match
+			if recursive && fileInfo.FileType == pfs.FileType_FILE_TYPE_DIR && (pattern == "" || matchPattern(pattern, name, true)) {
+				if err := list(&pfs.Path{Commit: path.Commit, Path: fileInfo.Path.Path}); err != nil {
+					return nil, err
+				}
+			}
+			if pattern == "" || matchPattern(pattern, name, false) {
+				return fileInfo, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// commitToReplicas pushes commit from the local master shards to their
+// replicas, replaces are deleted from the replicas first.
+func (a *combinedAPIServer) commitToReplicas(ctx context.Context, commit *pfs.Commit, replaces []*pfs.Commit) error {
+	shards, err := a.getMasterShards()
+	if err != nil {
+		return err
+	}
+	for shard := range shards {
+		if err := a.commitShardToReplicas(ctx, commit, shard, replaces); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitShardToReplicas pushes commit from the local master shard to its
+// replicas, replaces are deleted from the replicas first.
+func (a *combinedAPIServer) commitShardToReplicas(ctx context.Context, commit *pfs.Commit, shard int, replaces []*pfs.Commit) error {
+	commitInfo, ok, err := a.driver.GetCommitInfo(ctx, commit, shard)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("pachyderm: commit %s not found on shard %d", commit.Id, shard)
+	}
+	var diff bytes.Buffer
+	if err = a.driver.PullDiff(ctx, commit, shard, &diff); err != nil {
+		return err
+	}
+	return a.replicate(
+		ctx,
+		&pfs.PushDiffRequest{
+			Commit:   commit,
+			Shard:    uint64(shard),
+			Value:    diff.Bytes(),
+			Replaces: replaces,
+			Parent:   commitInfo.ParentCommit,
+		},
+	)
+}
+
+// replicate pushes pushDiffRequest to the replicas of its shard with the next
+// version of the shard. The pushes of a shard are made one at a time so that
+// the replicas get its versions in order, and the master only has a version
+// once every replica does so a replica that got it is never behind the
+// master.
+func (a *combinedAPIServer) replicate(ctx context.Context, pushDiffRequest *pfs.PushDiffRequest) error {
+	shard := int(pushDiffRequest.Shard)
+	lock := a.getReplicateLock(shard)
+	lock.Lock()
+	defer lock.Unlock()
+	clientConns, err := a.router.GetReplicaClientConns(shard)
+	if err != nil {
+		return err
+	}
+	if pushDiffRequest.Version, err = a.nextVersion(shard); err != nil {
+		return err
+	}
+	if err := a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+		_, err := pfs.NewInternalApiClient(clientConn).PushDiff(ctx, pushDiffRequest)
+		return err
+	}); err != nil {
+		return err
+	}
+	return a.setLocalVersion(shard, pushDiffRequest.Version)
+}
+
+func (a *combinedAPIServer) getReplicateLock(shard int) *sync.Mutex {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	lock, ok := a.replicateLocks[shard]
+	if !ok {
+		lock = &sync.Mutex{}
+		a.replicateLocks[shard] = lock
+	}
+	return lock
+}
+
+// nextVersion returns a version of shard that's higher than any server has
+// and than any nextVersion returned before.
+func (a *combinedAPIServer) nextVersion(shard int) (uint64, error) {
+	version, err := a.router.GetVersion(shard)
+	if err != nil {
+		return 0, err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.nextVersions[shard] > version {
+		version = a.nextVersions[shard]
+	}
+	version++
+	a.nextVersions[shard] = version
+	return version, nil
+}
+
+func (a *combinedAPIServer) getLocalVersion(shard int) uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.localVersions[shard]
+}
+
+// setLocalVersion records that the local server has version of shard, unless
+// it already has a later one.
+func (a *combinedAPIServer) setLocalVersion(shard int, version uint64) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if version <= a.localVersions[shard] {
+		return nil
+	}
+	a.localVersions[shard] = version
+	return a.router.SetLocalVersion(shard, version)
+}
+
+// backfillShard makes the local replica of shard match its master, every read
+// commit of every repository that the local server doesn't have is pulled,
+// parents first, and the repositories and commits the master deleted are
+// deleted.
+func (a *combinedAPIServer) backfillShard(ctx context.Context, shard int) error {
+	clientConn, err := a.router.GetMasterClientConn(shard)
+	if err != nil {
+		return err
+	}
+	listRepositoriesResponse, err := pfs.NewApiClient(clientConn).ListRepositories(ctx, &pfs.ListRepositoriesRequest{})
+	if err != nil {
+		return err
+	}
+	repositories := make(map[string]bool)
+	for _, repository := range listRepositoriesResponse.Repository {
+		repositories[repository.Name] = true
+		if err := a.backfill(ctx, repository, shard, clientConn); err != nil {
+			return err
+		}
+	}
+	localRepositories, err := a.driver.ListRepositories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, repository := range localRepositories {
+		if repositories[repository.Name] {
+			continue
+		}
+		if err := a.deleteLocalRepository(ctx, repository, shard); err != nil {
+			return err
+		}
+	}
+	a.forgetReadCommits()
+	return nil
+}
+
+// deleteLocalRepository deletes repository from shard, if it's there.
+func (a *combinedAPIServer) deleteLocalRepository(ctx context.Context, repository *pfs.Repository, shard int) error {
+	_, ok, err := a.driver.InspectRepository(ctx, repository, shard)
+	if err != nil || !ok {
+		return err
+	}
+	if err := a.driver.DeleteRepository(ctx, repository, map[int]bool{shard: true}); err != nil {
+		// the DeleteRepository that deleted it from the other local
+		// shards can get here first
+		if _, ok, inspectErr := a.driver.InspectRepository(ctx, repository, shard); inspectErr == nil && !ok {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// backfill pulls the read commits of repository that shard doesn't have from
+// clientConn, the master of shard, parents first, and deletes the ones
+// clientConn doesn't have.
+func (a *combinedAPIServer) backfill(ctx context.Context, repository *pfs.Repository, shard int, clientConn *grpc.ClientConn) error {
+	_, ok, err := a.driver.InspectRepository(ctx, repository, shard)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := a.driver.InitRepository(ctx, repository, map[int]bool{shard: true}); err != nil {
+			return err
+		}
+	}
+	// only the master's own shards, which it commits before it pushes them
+	listCommitsResponse, err := pfs.NewApiClient(clientConn).ListCommits(
+		ctx,
+		&pfs.ListCommitsRequest{
+			Repository: repository,
+			Redirect:   true,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	commitInfos := make(map[string]*pfs.CommitInfo)
+	for _, commitInfo := range listCommitsResponse.CommitInfo {
+		commitInfos[commitInfo.Commit.Id] = commitInfo
+	}
+	backfilled := make(map[string]bool)
+	var backfillCommit func(commit *pfs.Commit) error
+	backfillCommit = func(commit *pfs.Commit) error {
+		if commit == nil || backfilled[commit.Id] {
+			return nil
+		}
+		backfilled[commit.Id] = true
+		commitInfo, ok := commitInfos[commit.Id]
+		if !ok || commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+			return nil
+		}
+		_, ok, err := a.driver.GetCommitInfo(ctx, commit, shard)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if err := backfillCommit(commitInfo.ParentCommit); err != nil {
+			return err
+		}
+		if err := backfillCommit(commitInfo.MergeParentCommit); err != nil {
+			return err
+		}
+		apiPullDiffClient, err := pfs.NewInternalApiClient(clientConn).PullDiff(
+			ctx,
+			&pfs.PullDiffRequest{
+				Commit: commit,
+				Shard:  uint64(shard),
+			},
+		)
+		if err != nil {
+			return err
+		}
+		var diff bytes.Buffer
+		if err := protoutil.WriteFromStreamingBytesClient(apiPullDiffClient, &diff); err != nil {
+			return err
+		}
+		return a.driver.PushDiff(ctx, commit, &diff)
+	}
+	for _, commitInfo := range listCommitsResponse.CommitInfo {
+		if err := backfillCommit(commitInfo.Commit); err != nil {
+			return err
+		}
+	}
+	localCommitInfos, err := a.driver.ListCommits(ctx, repository, shard)
+	if err != nil {
+		return err
+	}
+	sort.Sort(newestFirst(localCommitInfos))
+	for _, commitInfo := range localCommitInfos {
+		if _, ok := commitInfos[commitInfo.Commit.Id]; ok {
+			continue
+		}
+		if err := a.driver.DeleteCommit(ctx, commitInfo.Commit, map[int]bool{shard: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reshard runs the phases of a Reshard after the local server has started.
+// Open write commits are migrated as well, nothing writes to them until the
+// Reshard is done. The number of shards is stored in discovery before the
+// servers finish, once it is the Reshard has happened and a server that misses
+// the finish phase gets the new shards from WatchNumShards.
+func (a *combinedAPIServer) reshard(ctx context.Context, numShards uint64, reshardID string) (*pfs.ReshardResponse, error) {
+	if _, err := a.runReshardPhase(ctx, pfs.ReshardPhase_RESHARD_PHASE_START, numShards, reshardID, false); err != nil {
+		return nil, err
+	}
+	reshardResponse, err := a.runReshardPhase(ctx, pfs.ReshardPhase_RESHARD_PHASE_MIGRATE, numShards, reshardID, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.router.SetNumShards(int(numShards)); err != nil {
+		return nil, err
+	}
+	if _, err := a.runReshardPhase(ctx, pfs.ReshardPhase_RESHARD_PHASE_FINISH, numShards, reshardID, true); err != nil {
+		return nil, err
+	}
+	// the old shards keep the files that moved until every server reads
+	// them from the new shards
+	if _, err := a.runReshardPhase(ctx, pfs.ReshardPhase_RESHARD_PHASE_CLEAN, numShards, reshardID, true); err != nil {
+		return nil, err
+	}
+	return reshardResponse, nil
+}
+
+// runReshardPhase runs reshardPhase on the other servers, and on this one if
+// localToo, and adds up the files they copied. Phases can copy any amount of
+// data so they only use ctx.
+func (a *combinedAPIServer) runReshardPhase(ctx context.Context, reshardPhase pfs.ReshardPhase, numShards uint64, reshardID string, localToo bool) (*pfs.ReshardResponse, error) {
+	reshardPhaseRequest := &pfs.ReshardPhaseRequest{
+		ReshardPhase: reshardPhase,
+		NumShards:    numShards,
+		ReshardId:    reshardID,
+	}
+	clientConns, err := a.router.GetAllClientConns()
+	if err != nil {
+		return nil, err
+	}
+	reshardResponse := &pfs.ReshardResponse{}
+	var lock sync.Mutex
+	add := func(other *pfs.ReshardResponse) {
+		lock.Lock()
+		defer lock.Unlock()
+		reshardResponse.NumFiles += other.NumFiles
+	}
+	var funcs []func() error
+	if localToo {
+		funcs = append(funcs, func() error {
+			localReshardResponse, err := a.RunReshardPhase(ctx, reshardPhaseRequest)
+			if err != nil {
+				return err
+			}
+			add(localReshardResponse)
+			return nil
+		})
+	}
+	for _, clientConn := range clientConns {
+		clientConn := clientConn
+		funcs = append(funcs, func() error {
+			otherReshardResponse, err := pfs.NewInternalApiClient(clientConn).RunReshardPhase(ctx, reshardPhaseRequest)
+			if err != nil {
+				return err
+			}
+			add(otherReshardResponse)
+			return nil
+		})
+	}
+	if err := concurrent.Run(a.fanOutParallelism, funcs...); err != nil {
+		return nil, err
+	}
+	return reshardResponse, nil
+}
+
+// startReshard stops writes, waiting for the ones that have started, and
+// clears the local shards below numShards that the sharder doesn't use yet of
+// anything a failed Reshard left.
+func (a *combinedAPIServer) startReshard(ctx context.Context, numShards int, reshardID string) error {
+	if _, err := a.getSharder().WithNumShards(numShards); err != nil {
+		return err
+	}
+	a.lock.Lock()
+	if a.reshardID != "" {
+		a.lock.Unlock()
+		return fmt.Errorf("pachyderm: the cluster is already being resharded")
+	}
+	a.reshardID = reshardID
+	for a.numWrites > 0 {
+		a.writesDone.Wait()
+	}
+	a.lock.Unlock()
+	shards, err := a.getNewShards(numShards, true)
+	if err != nil {
+		return err
+	}
+	if len(shards) == 0 {
+		return nil
+	}
+	repositories, err := a.driver.ListRepositories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, repository := range repositories {
+		if err := a.driver.InitRepository(ctx, repository, shards); err != nil {
+			return err
+		}
+		for shard := range shards {
+			commitInfos, err := a.driver.ListCommits(ctx, repository, shard)
+			if err != nil {
+				return err
+			}
+			sort.Sort(newestFirst(commitInfos))
+			for _, commitInfo := range commitInfos {
+				if err := a.driver.DeleteCommit(ctx, commitInfo.Commit, map[int]bool{shard: true}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	a.forgetReadCommits()
+	return nil
+}
+
+// finishReshard switches to numShards shards, writes stay stopped until
+// cleanReshard.
+func (a *combinedAPIServer) finishReshard(numShards int, reshardID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.reshardID != reshardID {
+		return fmt.Errorf("pachyderm: reshard %s is not in progress", reshardID)
+	}
+	sharder, err := a.sharder.WithNumShards(numShards)
+	if err != nil {
+		return err
+	}
+	a.sharder = sharder
+	return nil
+}
+
+// cleanReshard prunes the files the sharder maps elsewhere from every commit
+// of the local shards, replicas too, and allows writes.
+func (a *combinedAPIServer) cleanReshard(ctx context.Context, reshardID string) error {
+	a.lock.Lock()
+	if a.reshardID != reshardID {
+		a.lock.Unlock()
+		return fmt.Errorf("pachyderm: reshard %s is not in progress", reshardID)
+	}
+	a.lock.Unlock()
+	shards, err := a.getAllShards(true)
+	if err != nil {
+		return err
+	}
+	sharder := a.getSharder()
+	repositories, err := a.driver.ListRepositories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, repository := range repositories {
+		for shard := range shards {
+			commitInfos, err := a.driver.ListCommits(ctx, repository, shard)
+			if err != nil {
+				return err
+			}
+			for _, commitInfo := range commitInfos {
+				commit := commitInfo.Commit
+				var shardErr error
+				if err := a.driver.PruneCommit(ctx, commit, shard, func(path string) bool {
+					pathShard, err := sharder.GetShard(&pfs.Path{Commit: commit, Path: path})
+					if err != nil {
+						shardErr = err
+						return false
+					}
+					return pathShard != shard
+				}); err != nil {
+					return err
+				}
+				if shardErr != nil {
+					return shardErr
+				}
+			}
+		}
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.reshardID == reshardID {
+		a.reshardID = ""
+	}
+	return nil
+}
+
+func (a *combinedAPIServer) WatchNumShards(cancel chan bool) error {
+	return a.router.WatchNumShards(
+		cancel,
+		func(numShards int) error {
+			a.lock.Lock()
+			defer a.lock.Unlock()
+			if numShards == a.sharder.NumShards() {
+				return nil
+			}
+			sharder, err := a.sharder.WithNumShards(numShards)
+			if err != nil {
+				return err
+			}
+			a.sharder = sharder
+			return nil
+		},
+	)
+}
+
+// getNewShards returns the local master shards, and replica shards if
+// replicaToo, that numShards shards have and the sharder doesn't.
+func (a *combinedAPIServer) getNewShards(numShards int, replicaToo bool) (map[int]bool, error) {
+	shards, err := a.router.GetMasterShards()
+	if err != nil {
+		return nil, err
+	}
+	if replicaToo {
+		replicaShards, err := a.router.GetReplicaShards()
+		if err != nil {
+			return nil, err
+		}
+		for replicaShard := range replicaShards {
+			shards[replicaShard] = true
+		}
+	}
+	oldNumShards := a.getSharder().NumShards()
+	for shard := range shards {
+		if shard < oldNumShards || shard >= numShards {
+			delete(shards, shard)
+		}
+	}
+	return shards, nil
+}
+
+// migrate makes every commit on the local master shards that numShards shards
+// have and the sharder doesn't, parents first, and returns the number of
+// files it copied.
+func (a *combinedAPIServer) migrate(ctx context.Context, numShards int) (uint64, error) {
+	sharder, err := a.getSharder().WithNumShards(numShards)
+	if err != nil {
+		return 0, err
+	}
+	shards, err := a.getNewShards(numShards, false)
+	if err != nil {
+		return 0, err
+	}
+	if len(shards) == 0 {
+		return 0, nil
+	}
+	repositories, err := a.driver.ListRepositories(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var numFiles uint64
+	for _, repository := range repositories {
+		listCommitsResponse, err := a.ListCommits(ctx, &pfs.ListCommitsRequest{Repository: repository})
+		if err != nil {
+			return 0, err
+		}
+		commitInfos := make(map[string]*pfs.CommitInfo)
+		for _, commitInfo := range listCommitsResponse.CommitInfo {
+			commitInfos[commitInfo.Commit.Id] = commitInfo
+		}
+		migrated := make(map[string]bool)
+		var migrateCommit func(commit *pfs.Commit) error
+		migrateCommit = func(commit *pfs.Commit) error {
+			if commit == nil || migrated[commit.Id] {
+				return nil
+			}
+			commitInfo, ok := commitInfos[commit.Id]
+			if !ok {
+				return fmt.Errorf("pachyderm: commit %s not found", commit.Id)
+			}
+			if err := migrateCommit(commitInfo.ParentCommit); err != nil {
+				return err
+			}
+			if err := migrateCommit(commitInfo.MergeParentCommit); err != nil {
+				return err
+			}
+			commitNumFiles, err := a.migrateCommit(ctx, commitInfo, sharder, shards)
+			if err != nil {
+				return err
+			}
+			numFiles += commitNumFiles
+			migrated[commit.Id] = true
+			return nil
+		}
+		for _, commitInfo := range listCommitsResponse.CommitInfo {
+			if err := migrateCommit(commitInfo.Commit); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return numFiles, nil
+}
+
+// migrateCommit makes commit on shards, pulling the diff of the files that
+// sharder maps to each of them from every old shard, and pushes it to the
+// replicas of shards once it is a read commit.
+func (a *combinedAPIServer) migrateCommit(ctx context.Context, commitInfo *pfs.CommitInfo, sharder route.Sharder, shards map[int]bool) (uint64, error) {
+	commit := commitInfo.Commit
+	if commitInfo.MergeParentCommit != nil {
+		if _, err := a.driver.Merge(ctx, commitInfo.ParentCommit, commitInfo.MergeParentCommit, commit, nil, commitInfo.Branch, commitInfo.Message, shards); err != nil {
+			return 0, err
+		}
+	} else {
+		if _, err := a.driver.Branch(ctx, commitInfo.ParentCommit, commit, commitInfo.Branch, commitInfo.Message, shards); err != nil {
+			return 0, err
+		}
+	}
+	var numFiles uint64
+	for shard := range shards {
+		for fromShard := 0; fromShard < a.getSharder().NumShards(); fromShard++ {
+			pullDiffRequest := &pfs.PullDiffRequest{
+				Commit:    commit,
+				Shard:     uint64(fromShard),
+				NumShards: uint64(sharder.NumShards()),
+				ToShard:   uint64(shard),
+			}
+			var buffer bytes.Buffer
+			clientConn, err := a.getClientConnIfNecessary(fromShard, false)
+			if err != nil {
+				return 0, err
+			}
+			if clientConn != nil {
+				apiPullDiffClient, err := pfs.NewInternalApiClient(clientConn).PullDiff(ctx, pullDiffRequest)
+				if err != nil {
+					return 0, err
+				}
+				if err := protoutil.WriteFromStreamingBytesClient(apiPullDiffClient, &buffer); err != nil {
+					return 0, err
+				}
+			} else if err := a.pullShardDiff(ctx, pullDiffRequest, &buffer); err != nil {
+				return 0, err
+			}
+			shardNumFiles, err := a.pushShardDiff(ctx, commit, shard, &buffer)
+			if err != nil {
+				return 0, err
+			}
+			numFiles += shardNumFiles
+		}
+	}
+	if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+		// an open write commit is committed and replicated by its writer
+		return numFiles, nil
+	}
+	if err := a.driver.Commit(ctx, commit, commitInfo.Message, shards); err != nil {
+		return 0, err
+	}
+	for shard := range shards {
+		if err := a.commitShardToReplicas(ctx, commit, shard, nil); err != nil {
+			return 0, err
+		}
+	}
+	return numFiles, nil
+}
+
+// shardDiffHeader is the first entry of the diff pullShardDiff writes.
+type shardDiffHeader struct {
+	// DeletedDirs are the topmost directories the commit deleted.
+	DeletedDirs []string
+	// DeletedFiles are the files the commit deleted that map to the shard
+	// the diff is pulled for.
+	DeletedFiles []string
+}
+
+// pullShardDiff writes the changes pullDiffRequest.Commit made on the local
+// shard pullDiffRequest.Shard to diff as a tar stream, keeping only the files
+// that pullDiffRequest.NumShards shards map to pullDiffRequest.ToShard.
+func (a *combinedAPIServer) pullShardDiff(ctx context.Context, pullDiffRequest *pfs.PullDiffRequest, diff io.Writer) error {
+	commit := pullDiffRequest.Commit
+	shard := int(pullDiffRequest.Shard)
+	toShard := int(pullDiffRequest.ToShard)
+	sharder, err := a.getSharder().WithNumShards(int(pullDiffRequest.NumShards))
+	if err != nil {
+		return err
+	}
+	changes, err := a.driver.ListChangedFiles(ctx, nil, commit, shard)
+	if err != nil {
+		return err
+	}
+	sort.Sort(changesByPath(changes))
+	mapsToShard := func(name string) (bool, error) {
+		pathShard, err := sharder.GetShard(&pfs.Path{Commit: commit, Path: name})
+		if err != nil {
+			return false, err
+		}
+		return pathShard == toShard, nil
+	}
+	header := &shardDiffHeader{}
+	deleted := make(map[string]bool)
+	for _, change := range changes {
+		if change.ChangeType != pfs.ChangeType_CHANGE_TYPE_DELETED {
+			continue
+		}
+		name := cleanPath(change.Path.Path)
+		deleted[name] = true
+		if underDeleted(name, deleted) {
+			continue
+		}
+		if change.FileType == pfs.FileType_FILE_TYPE_DIR {
+			header.DeletedDirs = append(header.DeletedDirs, name)
+			continue
+		}
+		ok, err := mapsToShard(name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			header.DeletedFiles = append(header.DeletedFiles, name)
+		}
+	}
+	value, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	tarWriter := tar.NewWriter(diff)
+	if err := tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Size: int64(len(value))}); err != nil {
+		return err
+	}
+	if _, err := tarWriter.Write(value); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if change.ChangeType == pfs.ChangeType_CHANGE_TYPE_DELETED {
+			continue
+		}
+		name := cleanPath(change.Path.Path)
+		if change.FileType == pfs.FileType_FILE_TYPE_DIR {
+			if err := tarWriter.WriteHeader(&tar.Header{Name: name + "/", Typeflag: tar.TypeDir}); err != nil {
+				return err
+			}
+			continue
+		}
+		ok, err := mapsToShard(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		path := &pfs.Path{Commit: commit, Path: name}
+		fileInfo, ok, err := a.driver.GetFileInfo(ctx, path, shard)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("pachyderm: file %s not found", name)
+		}
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(fileInfo.SizeBytes)}); err != nil {
+			return err
+		}
+		file, err := a.driver.GetFile(ctx, path, shard)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(tarWriter, io.NewSectionReader(file, 0, int64(fileInfo.SizeBytes))); err != nil {
+			_ = file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return tarWriter.Close()
+}
+
+// pushShardDiff applies a diff written by pullShardDiff to commit on the
+// local shard and returns the number of files it wrote.
+func (a *combinedAPIServer) pushShardDiff(ctx context.Context, commit *pfs.Commit, shard int, diff io.Reader) (uint64, error) {
+	shards := map[int]bool{shard: true}
+	// getFileType returns FILE_TYPE_NONE if name doesn't exist
+	getFileType := func(name string) (pfs.FileType, error) {
+		fileInfo, ok, err := a.driver.GetFileInfo(ctx, &pfs.Path{Commit: commit, Path: name}, shard)
+		if err != nil || !ok {
+			return pfs.FileType_FILE_TYPE_NONE, err
+		}
+		return fileInfo.FileType, nil
+	}
+	tarReader := tar.NewReader(diff)
+	if _, err := tarReader.Next(); err != nil {
+		return 0, err
+	}
+	header := &shardDiffHeader{}
+	if err := json.NewDecoder(tarReader).Decode(header); err != nil {
+		return 0, err
+	}
+	// every old shard has the directories, the first diff deletes them
+	for _, name := range header.DeletedDirs {
+		fileType, err := getFileType(name)
+		if err != nil {
+			return 0, err
+		}
+		if fileType != pfs.FileType_FILE_TYPE_DIR {
+			continue
+		}
+		if err := a.driver.DeleteFile(ctx, &pfs.Path{Commit: commit, Path: name}, shards); err != nil {
+			return 0, err
+		}
+	}
+	for _, name := range header.DeletedFiles {
+		fileType, err := getFileType(name)
+		if err != nil {
+			return 0, err
+		}
+		if fileType == pfs.FileType_FILE_TYPE_NONE || fileType == pfs.FileType_FILE_TYPE_DIR {
+			continue
+		}
+		if err := a.driver.DeleteFile(ctx, &pfs.Path{Commit: commit, Path: name}, shards); err != nil {
+			return 0, err
+		}
+	}
+	var numFiles uint64
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		path := &pfs.Path{Commit: commit, Path: cleanPath(tarHeader.Name)}
+		fileType, err := getFileType(path.Path)
+		if err != nil {
+			return 0, err
+		}
+		switch tarHeader.Typeflag {
+		case tar.TypeDir:
+			if fileType == pfs.FileType_FILE_TYPE_DIR {
+				continue
+			}
+			if fileType != pfs.FileType_FILE_TYPE_NONE {
+				if err := a.driver.DeleteFile(ctx, path, shards); err != nil {
+					return 0, err
+				}
+			}
+			if err := a.driver.MakeDirectory(ctx, path, shards); err != nil {
+				return 0, err
+			}
+		case tar.TypeReg:
+			if fileType != pfs.FileType_FILE_TYPE_NONE {
+				if err := a.driver.DeleteFile(ctx, path, shards); err != nil {
+					return 0, err
+				}
+			}
+			if err := a.driver.PutFile(ctx, path, shard, 0, tarReader); err != nil {
+				return 0, err
+			}
+			numFiles++
+		default:
+			return 0, fmt.Errorf("pachyderm: diff entry %s has unsupported type %c", tarHeader.Name, tarHeader.Typeflag)
+		}
+	}
+	return numFiles, nil
+}
+
+// runOperation journals operation and runs do, if do fails the operation is
+// rolled back on every server.
+// An operation whose rollback fails stays in the journal and is rolled back by
+// Recover.
+func (a *combinedAPIServer) runOperation(ctx context.Context, operation *pfs.Operation, do func() error) error {
+	operation.Id = newID()
+	operation.Started = protoutil.TimeToTimestamp(time.Now().UTC())
+	if err := a.driver.StartOperation(ctx, operation); err != nil {
+		return err
+	}
+	if err := do(); err != nil {
+		// do may have failed because ctx is done, the rollback must still run
+		rollbackCtx, cancel := a.hopContext(context.Background())
+		defer cancel()
+		if _, rollbackErr := a.Rollback(rollbackCtx, &pfs.RollbackRequest{Operation: operation}); rollbackErr != nil {
+			return fmt.Errorf("pachyderm: %v, rolling back operation %s failed: %v", err, operation.Id, rollbackErr)
+		}
+		if finishErr := a.driver.FinishOperation(rollbackCtx, operation); finishErr != nil {
+			return fmt.Errorf("pachyderm: %v, finishing operation %s failed: %v", err, operation.Id, finishErr)
+		}
+		return err
+	}
+	return a.driver.FinishOperation(ctx, operation)
+}
+
+// rollback undoes operation on the local shards, shards it was never applied
+// to are left alone.
+func (a *combinedAPIServer) rollback(ctx context.Context, operation *pfs.Operation) error {
+	// a rolled back commit is no longer a read commit
+	defer a.forgetReadCommits()
+	ok, err := a.hasRepository(ctx, operation.Repository)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	masterShards, err := a.getMasterShards()
+	if err != nil {
+		return err
+	}
+	replicaShards, err := a.getReplicaShards()
+	if err != nil {
+		return err
+	}
+	switch operation.OperationType {
+	case pfs.OperationType_OPERATION_TYPE_INIT_REPOSITORY:
+		shards, err := a.getDriverShards()
+		if err != nil {
+			return err
+		}
+		return a.driver.DeleteRepository(ctx, operation.Repository, shards)
+	case pfs.OperationType_OPERATION_TYPE_MAKE_DIRECTORY:
+		if operation.Path == nil {
+			return nil
+		}
+		for shard := range masterShards {
+			commitInfo, ok, err := a.driver.GetCommitInfo(ctx, operation.Commit, shard)
+			if err != nil {
+				return err
+			}
+			if !ok || commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_WRITE {
+				continue
+			}
+			_, ok, err = a.driver.GetFileInfo(ctx, operation.Path, shard)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := a.driver.DeleteFile(ctx, operation.Path, map[int]bool{shard: true}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case pfs.OperationType_OPERATION_TYPE_BRANCH:
+		return a.driver.DeleteCommit(ctx, operation.Commit, masterShards)
+	case pfs.OperationType_OPERATION_TYPE_COMMIT:
+		if err := a.driver.Uncommit(ctx, operation.Commit, masterShards); err != nil {
+			return err
+		}
+		if err := a.driver.DeleteCommit(ctx, operation.Commit, replicaShards); err != nil {
+			return err
+		}
+		if operation.Branch == "" {
+			return nil
+		}
+		errMoved := fmt.Errorf("pachyderm: branch %s moved", operation.Branch)
+		// a branch another commit moved is left alone
+		if err := a.setBranch(ctx, operation.Repository, operation.Branch, operation.BranchCommit, func(branchCommit *pfs.Commit, ok bool) error {
+			if !ok || branchCommit.Id != operation.Commit.Id {
+				return errMoved
+			}
+			return nil
+		}); err != nil && err != errMoved {
+			return err
+		}
+		return nil
+	case pfs.OperationType_OPERATION_TYPE_DELETE_COMMITS:
+		return a.deleteLocalCommits(ctx, operation.Commits)
+	default:
+		return fmt.Errorf("pachyderm: unknown operation type %v", operation.OperationType)
+	}
+}
+
+// getNewCommit returns the commit a branch or merge will make, it checks that
+// newCommit doesn't exist because rolling back deletes it.
+func (a *combinedAPIServer) getNewCommit(ctx context.Context, repository *pfs.Repository, newCommit *pfs.Commit) (*pfs.Commit, error) {
+	if newCommit == nil {
+		return &pfs.Commit{
+			Repository: repository,
+			Id:         newID(),
+		}, nil
+	}
+	getCommitInfoResponse, err := a.GetCommitInfo(ctx, &pfs.GetCommitInfoRequest{Commit: newCommit})
+	if err != nil {
+		return nil, err
+	}
+	if getCommitInfoResponse.CommitInfo != nil {
+		return nil, fmt.Errorf("pachyderm: commit %s already exists", newCommit.Id)
+	}
+	return newCommit, nil
+}
+
+// squashCommits squashes commits, oldest first, on the local shards and their
+// replicas and, unless redirect is set, on every other server.
+func (a *combinedAPIServer) squashCommits(ctx context.Context, commits []*pfs.Commit, message string, redirect bool) error {
+	shards, err := a.getMasterShards()
+	if err != nil {
+		return err
+	}
+	children := make(map[int][]*pfs.Commit)
+	for shard := range shards {
+		if children[shard], err = a.getSquashChildren(ctx, commits, shard); err != nil {
+			return err
+		}
+	}
+	if err := a.driver.SquashCommits(ctx, commits, message, shards); err != nil {
+		return err
+	}
+	a.forgetReadCommits()
+	if err := a.commitToReplicas(ctx, commits[len(commits)-1], commits); err != nil {
+		return err
+	}
+	// the replicas get the children with their new parent
+	for shard, shardChildren := range children {
+		for _, child := range shardChildren {
+			if err := a.commitShardToReplicas(ctx, child, shard, []*pfs.Commit{child}); err != nil {
+				return err
+			}
+		}
+	}
+	if redirect {
+		return nil
+	}
+	clientConns, err := a.router.GetAllClientConns()
+	if err != nil {
+		return err
+	}
+	return a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+		_, err := pfs.NewApiClient(clientConn).SquashCommits(
+			ctx,
+			&pfs.SquashCommitsRequest{
+				Message:  message,
+				Redirect: true,
+				Commits:  commits,
+			},
+		)
+		return err
+	})
+}
+
+// deleteCommits deletes commits of repository from every server in one
+// operation. A deletion can't be undone so rolling it back finishes it.
+func (a *combinedAPIServer) deleteCommits(ctx context.Context, repository *pfs.Repository, commits []*pfs.Commit) error {
+	return a.runOperation(
+		ctx,
+		&pfs.Operation{
+			OperationType: pfs.OperationType_OPERATION_TYPE_DELETE_COMMITS,
+			Repository:    repository,
+			Commits:       commits,
+		},
+		func() error {
+			if err := a.deleteLocalCommits(ctx, commits); err != nil {
+				return err
+			}
+			clientConns, err := a.router.GetAllClientConns()
+			if err != nil {
+				return err
+			}
+			return a.fanOut(ctx, clientConns, func(ctx context.Context, clientConn *grpc.ClientConn) error {
+				_, err := pfs.NewApiClient(clientConn).DeleteCommit(
+					ctx,
+					&pfs.DeleteCommitRequest{
+						Redirect: true,
+						Commits:  commits,
+					},
+				)
+				return err
+			})
+		},
+	)
+}
+
+// deleteLocalCommits deletes commits from the local shards, replicas are
+// deleted along with masters so that a later failover doesn't bring a commit
+// back.
+func (a *combinedAPIServer) deleteLocalCommits(ctx context.Context, commits []*pfs.Commit) error {
+	shards, err := a.getAllShards(true)
+	if err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		if err := a.driver.DeleteCommit(ctx, commit, shards); err != nil {
+			return err
+		}
+	}
+	a.forgetReadCommits()
+	// the deletion gets a version so that failover can tell who has it
+	masterShards, err := a.getMasterShards()
+	if err != nil {
+		return err
+	}
+	for shard := range masterShards {
+		if err := a.replicate(
+			ctx,
+			&pfs.PushDiffRequest{
+				Shard:    uint64(shard),
+				Replaces: commits,
+			},
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSquashCommits returns the commits from from to to, oldest first, it
+// checks that no branch points at a commit that would be deleted.
+func (a *combinedAPIServer) getSquashCommits(ctx context.Context, from *pfs.Commit, to *pfs.Commit) ([]*pfs.Commit, error) {
+	from, err := a.resolveCommit(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	to, err = a.resolveCommit(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	var commits []*pfs.Commit
+	for commit := to; ; {
+		getCommitInfoResponse, err := a.GetCommitInfo(ctx, &pfs.GetCommitInfoRequest{Commit: commit})
+		if err != nil {
+			return nil, err
+		}
+		commitInfo := getCommitInfoResponse.CommitInfo
+		if commitInfo == nil {
+			return nil, fmt.Errorf("pachyderm: commit %s not found", commit.Id)
+		}
+		if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+			return nil, fmt.Errorf("pachyderm: commit %s is not a read commit", commit.Id)
+		}
+		commits = append([]*pfs.Commit{commitInfo.Commit}, commits...)
+		if commitInfo.Commit.Id == from.Id {
+			break
+		}
+		if commitInfo.ParentCommit == nil {
+			return nil, fmt.Errorf("pachyderm: commit %s is not an ancestor of %s", from.Id, to.Id)
+		}
+		commit = commitInfo.ParentCommit
+	}
+	squashed := make(map[string]bool)
+	for _, commit := range commits[:len(commits)-1] {
+		if ReservedCommitIDs[commit.Id] {
+			return nil, fmt.Errorf("pachyderm: commit %s can't be squashed", commit.Id)
+		}
+		squashed[commit.Id] = true
+	}
+	branchInfos, err := a.driver.ListBranches(ctx, to.Repository)
+	if err != nil {
+		return nil, err
+	}
+	for _, branchInfo := range branchInfos {
+		if squashed[branchInfo.Commit.Id] {
+			return nil, fmt.Errorf("pachyderm: branch %s points at %s, which would be squashed", branchInfo.Name, branchInfo.Commit.Id)
+		}
+	}
+	return commits, nil
+}
+
+// getSquashChildren returns the read commits on shard, other than the ones
+// being squashed, whose parent is one of the commits squashed away.
+func (a *combinedAPIServer) getSquashChildren(ctx context.Context, commits []*pfs.Commit, shard int) ([]*pfs.Commit, error) {
+	squashed := make(map[string]bool)
+	for _, commit := range commits[:len(commits)-1] {
+		squashed[commit.Id] = true
+	}
+	commitInfos, err := a.driver.ListCommits(ctx, commits[0].Repository, shard)
+	if err != nil {
+		return nil, err
+	}
+	var children []*pfs.Commit
+	for _, commitInfo := range commitInfos {
+		if squashed[commitInfo.Commit.Id] || commitInfo.Commit.Id == commits[len(commits)-1].Id || commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+			continue
+		}
+		if (commitInfo.ParentCommit != nil && squashed[commitInfo.ParentCommit.Id]) ||
+			(commitInfo.MergeParentCommit != nil && squashed[commitInfo.MergeParentCommit.Id]) {
+			children = append(children, commitInfo.Commit)
+		}
+	}
+	return children, nil
+}
+
+// getDeleteCommit returns the commit to delete, it checks that no branch or
+// other commit refers to it.
+func (a *combinedAPIServer) getDeleteCommit(ctx context.Context, commit *pfs.Commit) (*pfs.Commit, error) {
+	getCommitInfoResponse, err := a.GetCommitInfo(ctx, &pfs.GetCommitInfoRequest{Commit: commit})
+	if err != nil {
+		return nil, err
+	}
+	if getCommitInfoResponse.CommitInfo == nil {
+		return nil, fmt.Errorf("pachyderm: commit %s not found", commit.Id)
+	}
+	commit = getCommitInfoResponse.CommitInfo.Commit
+	if ReservedCommitIDs[commit.Id] {
+		return nil, fmt.Errorf("pachyderm: commit %s can't be deleted", commit.Id)
+	}
+	branchInfos, err := a.driver.ListBranches(ctx, commit.Repository)
+	if err != nil {
+		return nil, err
+	}
+	for _, branchInfo := range branchInfos {
+		if branchInfo.Commit.Id == commit.Id {
+			return nil, fmt.Errorf("pachyderm: branch %s points at %s", branchInfo.Name, commit.Id)
+		}
+	}
+	listCommitsResponse, err := a.ListCommits(ctx, &pfs.ListCommitsRequest{Repository: commit.Repository})
+	if err != nil {
+		return nil, err
+	}
+	for _, commitInfo := range listCommitsResponse.CommitInfo {
+		for _, parent := range []*pfs.Commit{commitInfo.ParentCommit, commitInfo.MergeParentCommit} {
+			if parent != nil && parent.Id == commit.Id {
+				return nil, fmt.Errorf("pachyderm: commit %s is a child of %s", commitInfo.Commit.Id, commit.Id)
+			}
+		}
+	}
+	return commit, nil
+}
+
+// garbageCollect deletes or squashes the commits of repository that its
+// retention policy doesn't keep and adds them to garbageCollectResponse.
+// Commits are visited newest first so that deleting or squashing a commit
+// can free its parent in the same pass, the changes are planned from a single
+// listing and then applied together.
+func (a *combinedAPIServer) garbageCollect(ctx context.Context, repository *pfs.Repository, dryRun bool, garbageCollectResponse *pfs.GarbageCollectResponse) error {
+	retentionPolicy, err := a.driver.GetRetentionPolicy(ctx, repository)
+	if err != nil {
+		return err
+	}
+	if retentionPolicy == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	listCommitsResponse, err := a.ListCommits(ctx, &pfs.ListCommitsRequest{Repository: repository})
+	if err != nil {
+		return err
+	}
+	branchInfos, err := a.driver.ListBranches(ctx, repository)
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]bool)
+	for commitID := range ReservedCommitIDs {
+		kept[commitID] = true
+	}
+	for _, branchInfo := range branchInfos {
+		kept[branchInfo.Commit.Id] = true
+	}
+	commitInfos := make(map[string]*pfs.CommitInfo)
+	// commit id -> ids of the commits it is the parent of
+	children := make(map[string]map[string]bool)
+	mergeParents := make(map[string]bool)
+	for _, commitInfo := range listCommitsResponse.CommitInfo {
+		commitInfos[commitInfo.Commit.Id] = commitInfo
+		if commitInfo.ParentCommit != nil {
+			if children[commitInfo.ParentCommit.Id] == nil {
+				children[commitInfo.ParentCommit.Id] = make(map[string]bool)
+			}
+			children[commitInfo.ParentCommit.Id][commitInfo.Commit.Id] = true
+		}
+		if commitInfo.MergeParentCommit != nil {
+			mergeParents[commitInfo.MergeParentCommit.Id] = true
+		}
+	}
+	var deleted []*pfs.Commit
+	// commit id -> the commits squashed into it, oldest first, ending with it
+	squashes := make(map[string][]*pfs.Commit)
+	var squashedInto []string
+	deleteCommit := func(commitInfo *pfs.CommitInfo) {
+		deleted = append(deleted, commitInfo.Commit)
+		garbageCollectResponse.Deleted = append(garbageCollectResponse.Deleted, commitInfo.Commit)
+		if commitInfo.ParentCommit != nil {
+			delete(children[commitInfo.ParentCommit.Id], commitInfo.Commit.Id)
+		}
+	}
+	// the deletions go first, the commits squashed away were planned without
+	// the deleted children
+	apply := func() error {
+		if dryRun {
+			return nil
+		}
+		if len(deleted) > 0 {
+			if err := a.deleteCommits(ctx, repository, deleted); err != nil {
+				return err
+			}
+		}
+		for _, commitID := range squashedInto {
+			if err := a.squashCommits(ctx, squashes[commitID], commitInfos[commitID].Message, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if retentionPolicy.WriteCommitTimeout != nil {
+		writeCommitTimeout := protoutil.ProtoDurationToDuration(retentionPolicy.WriteCommitTimeout)
+		for _, commitInfo := range listCommitsResponse.CommitInfo {
+			commitID := commitInfo.Commit.Id
+			if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_WRITE || kept[commitID] || len(children[commitID]) != 0 || mergeParents[commitID] {
+				continue
+			}
+			if commitInfo.Created == nil || now.Sub(protoutil.TimestampToTime(commitInfo.Created)) <= writeCommitTimeout {
+				continue
+			}
+			deleteCommit(commitInfo)
+		}
+	}
+	if retentionPolicy.KeepLast == 0 && retentionPolicy.KeepNewerThan == nil {
+		return apply()
+	}
+	var numReadCommits uint64
+	for _, commitInfo := range listCommitsResponse.CommitInfo {
+		if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+			continue
+		}
+		numReadCommits++
+		if numReadCommits <= retentionPolicy.KeepLast {
+			kept[commitInfo.Commit.Id] = true
+		}
+		if retentionPolicy.KeepNewerThan != nil && commitInfo.Finished != nil &&
+			now.Sub(protoutil.TimestampToTime(commitInfo.Finished)) <= protoutil.ProtoDurationToDuration(retentionPolicy.KeepNewerThan) {
+			kept[commitInfo.Commit.Id] = true
+		}
+	}
+	for _, commitInfo := range listCommitsResponse.CommitInfo {
+		commitID := commitInfo.Commit.Id
+		if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ || kept[commitID] || mergeParents[commitID] {
+			continue
+		}
+		if len(children[commitID]) == 0 {
+			deleteCommit(commitInfo)
+			continue
+		}
+		if len(children[commitID]) != 1 {
+			continue
+		}
+		var child *pfs.CommitInfo
+		for childID := range children[commitID] {
+			// the CommitInfos may be shared, child takes a new parent below
+			childCopy := *commitInfos[childID]
+			child = &childCopy
+			commitInfos[childID] = child
+		}
+		// the child's contents include everything in commitInfo so it can
+		// take commitInfo's place, write commits can't be squashed
+		if child.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+			continue
+		}
+		if squashes[child.Commit.Id] == nil {
+			squashes[child.Commit.Id] = []*pfs.Commit{child.Commit}
+			squashedInto = append(squashedInto, child.Commit.Id)
+		}
+		squashes[child.Commit.Id] = append([]*pfs.Commit{commitInfo.Commit}, squashes[child.Commit.Id]...)
+		garbageCollectResponse.Squashed = append(garbageCollectResponse.Squashed, commitInfo.Commit)
+		delete(children, commitID)
+		child.ParentCommit = commitInfo.ParentCommit
+		if commitInfo.ParentCommit != nil {
+			delete(children[commitInfo.ParentCommit.Id], commitID)
+			children[commitInfo.ParentCommit.Id][child.Commit.Id] = true
+		}
+	}
+	return apply()
+}
+
+// exportFile writes fileInfo and, for a regular file, its content to tarWriter.
+func (a *combinedAPIServer) exportFile(ctx context.Context, tarWriter *tar.Writer, fileInfo *pfs.FileInfo) error {
+	header := &tar.Header{
+		Name:    cleanPath(fileInfo.Path.Path),
+		Mode:    int64(fileInfo.Perm),
+		ModTime: protoutil.TimestampToTime(fileInfo.LastModified),
+	}
+	if fileInfo.FileType == pfs.FileType_FILE_TYPE_DIR {
+		header.Name += "/"
+		header.Typeflag = tar.TypeDir
+		return tarWriter.WriteHeader(header)
+	}
+	header.Typeflag = tar.TypeReg
+	header.Size = int64(fileInfo.SizeBytes)
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	shard, clientConn, err := a.getShardAndClientConnIfNecessary(fileInfo.Path, false)
+	if err != nil {
+		return err
+	}
+	if clientConn != nil {
+		return getRemoteFile(ctx, clientConn, fileInfo.Path, tarWriter)
+	}
+	file, err := a.driver.GetFile(ctx, fileInfo.Path, shard)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tarWriter, io.NewSectionReader(file, 0, int64(fileInfo.SizeBytes))); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// importDirectory makes path and its missing parents, dirs records the
+// directories that exist so that each is only checked once.
+func (a *combinedAPIServer) importDirectory(ctx context.Context, path *pfs.Path, dirs map[string]bool) error {
+	if dirs[path.Path] {
+		return nil
+	}
+	if err := a.importDirectory(ctx, &pfs.Path{Commit: path.Commit, Path: cleanPath(filepath.Dir(path.Path))}, dirs); err != nil {
+		return err
+	}
+	getFileInfoResponse, err := a.GetFileInfo(ctx, &pfs.GetFileInfoRequest{Path: path})
+	if err != nil {
+		return err
+	}
+	if getFileInfoResponse.FileInfo == nil {
+		if _, err := a.handleMakeDirectory(ctx, &pfs.MakeDirectoryRequest{Path: path}); err != nil {
+			return err
+		}
+	} else if getFileInfoResponse.FileInfo.FileType != pfs.FileType_FILE_TYPE_DIR {
+		return fmt.Errorf("pachyderm: %s is not a directory", path.Path)
+	}
+	dirs[path.Path] = true
+	return nil
+}
+
+// importFile writes reader to path, replacing the file at path if there is one.
+func (a *combinedAPIServer) importFile(ctx context.Context, path *pfs.Path, reader io.Reader) error {
+	getFileInfoResponse, err := a.GetFileInfo(ctx, &pfs.GetFileInfoRequest{Path: path})
+	if err != nil {
+		return err
+	}
+	if getFileInfoResponse.FileInfo != nil {
+		if _, err := a.handleDeleteFile(ctx, &pfs.DeleteFileRequest{Path: path}); err != nil {
+			return err
+		}
+	}
+	shard, clientConn, err := a.getShardAndClientConnIfNecessary(path, false)
+	if err != nil {
+		return err
+	}
+	if clientConn != nil {
+		return putRemoteFile(ctx, clientConn, path, reader)
+	}
+	return a.driver.PutFile(ctx, path, shard, 0, reader)
+}
+
+// getRemoteFile writes the file at path, which is on the server at clientConn,
+// to writer.
+func getRemoteFile(ctx context.Context, clientConn *grpc.ClientConn, path *pfs.Path, writer io.Writer) error {
+	apiGetFileClient, err := pfs.NewApiClient(clientConn).GetFile(
+		ctx,
+		&pfs.GetFileRequest{
+			Path:      path,
+			SizeBytes: pfsutil.GetAll,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return protoutil.WriteFromStreamingBytesClient(apiGetFileClient, writer)
+}
+
+// putRemoteFile streams reader to path, which is on the server at clientConn,
+// in chunks of pfsutil.PutFileChunkSize bytes.
+func putRemoteFile(ctx context.Context, clientConn *grpc.ClientConn, path *pfs.Path, reader io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	apiPutFileStreamClient, err := pfs.NewApiClient(clientConn).PutFileStream(ctx)
+	if err != nil {
+		return err
+	}
+	// the first request carries the path, an empty file still sends it
+	putFileRequest := &pfs.PutFileRequest{Path: path}
+	buffer := make([]byte, pfsutil.PutFileChunkSize)
+	for {
+		n, err := io.ReadFull(reader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			// returning cancels the stream so the server doesn't keep a
+			// partial file
+			return err
+		}
+		if n > 0 || putFileRequest.Path != nil {
+			putFileRequest.Value = buffer[:n]
+			if sendErr := apiPutFileStreamClient.Send(putFileRequest); sendErr == io.EOF {
+				// the server has failed, CloseAndRecv returns its error
+				break
+			} else if sendErr != nil {
+				return sendErr
+			}
+			putFileRequest = &pfs.PutFileRequest{}
+		}
+		if err != nil {
+			break
+		}
+	}
+	_, err = apiPutFileStreamClient.CloseAndRecv()
+	return err
+}
+
+// getMissingPath returns the shallowest directory of path that doesn't exist
+// on the local shards, it returns nil if path already exists.
+func (a *combinedAPIServer) getMissingPath(ctx context.Context, path *pfs.Path) (*pfs.Path, error) {
+	shards, err := a.getAllShards(false)
+	if err != nil {
+		return nil, err
+	}
+	for shard := range shards {
+		var missingPath *pfs.Path
+		for name := cleanPath(path.Path); name != "" && name != "."; name = filepath.Dir(name) {
+			parentPath := &pfs.Path{
+				Commit: path.Commit,
+				Path:   name,
+			}
+			_, ok, err := a.driver.GetFileInfo(ctx, parentPath, shard)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				break
+			}
+			missingPath = parentPath
+		}
+		return missingPath, nil
+	}
+	return path, nil
+}
+
+func (a *combinedAPIServer) hasRepository(ctx context.Context, repository *pfs.Repository) (bool, error) {
+	repositories, err := a.driver.ListRepositories(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, other := range repositories {
+		if other.Name == repository.Name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func checkRetentionPolicy(retentionPolicy *pfs.RetentionPolicy) error {
+	if retentionPolicy == nil {
+		return nil
+	}
+	for _, duration := range []*google_protobuf.Duration{retentionPolicy.KeepNewerThan, retentionPolicy.WriteCommitTimeout} {
+		if duration != nil && protoutil.ProtoDurationToDuration(duration) < 0 {
+			return fmt.Errorf("pachyderm: retention policy durations must not be negative")
+		}
+	}
+	return nil
+}
+
+func checkBranchName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.Contains(name, "/") {
+		return fmt.Errorf("pachyderm: invalid branch name %q", name)
+	}
+	return nil
+}
+
+// conflicts returns true if path or anything within it is in changed.
+func conflicts(path string, changed map[string]bool) bool {
+	if changed[path] {
+		return true
+	}
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for changedPath := range changed {
+		if strings.HasPrefix(changedPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeFileInfos calls send with the FileInfos returned by nexts, each of
+// which returns FileInfos sorted by path and then nil, in path order.
+// Directories exist on every shard so each is only sent once.
+func mergeFileInfos(nexts []func() (*pfs.FileInfo, error), send func(*pfs.FileInfo) error) error {
+	heads := make([]*pfs.FileInfo, len(nexts))
+	for i, next := range nexts {
+		head, err := next()
+		if err != nil {
+			return err
+		}
+		heads[i] = head
+	}
+	for {
+		var first *pfs.FileInfo
+		for _, head := range heads {
+			if head != nil && (first == nil || head.Path.Path < first.Path.Path) {
+				first = head
+			}
+		}
+		if first == nil {
+			return nil
+		}
+		if err := send(first); err != nil {
+			return err
+		}
+		for i, head := range heads {
+			if head != nil && head.Path.Path == first.Path.Path {
+				next, err := nexts[i]()
+				if err != nil {
+					return err
+				}
+				heads[i] = next
+			}
+		}
+	}
+}
+
+// underDeleted returns true if a directory above name is in deleted.
+func underDeleted(name string, deleted map[string]bool) bool {
+	for dir := filepath.Dir(name); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if deleted[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPattern(pattern string) error {
+	for _, element := range strings.Split(pattern, "/") {
+		if _, err := filepath.Match(element, ""); err != nil {
+			return fmt.Errorf("pachyderm: invalid pattern %s", pattern)
+		}
+	}
+	return nil
+}
+
+// matchPattern returns true if name matches pattern, or if prefix is true
+// if something below name could match pattern.
+func matchPattern(pattern string, name string, prefix bool) bool {
+	return matchElements(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"), prefix)
+}
+
+func matchElements(pattern []string, name []string, prefix bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElements(pattern[1:], name[i:], prefix) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return prefix
+		}
+		if ok, _ := filepath.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func newID() string {
+	return strings.Replace(uuid.NewV4().String(), "-", "", -1)
+}
+
+// cleanPath returns p without leading or trailing slashes.
+func cleanPath(p string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+p), "/")
+}
+
+// mergeCommitInfos combines the CommitInfos of a commit from two sets of shards,
+// every shard holds the same metadata but only part of the files.
+func mergeCommitInfos(commitInfo *pfs.CommitInfo, other *pfs.CommitInfo) *pfs.CommitInfo {
+	if commitInfo == nil {
+		return other
+	}
+	merged := *commitInfo
+	if other.CommitType == pfs.CommitType_COMMIT_TYPE_WRITE {
+		// the commit is not finished until every shard is committed
+		merged.CommitType = other.CommitType
+		merged.Finished = nil
+	} else if merged.Finished != nil && other.Finished != nil && protoutil.TimestampLess(merged.Finished, other.Finished) {
+		merged.Finished = other.Finished
+	}
+	if merged.Created == nil || (other.Created != nil && protoutil.TimestampLess(other.Created, merged.Created)) {
+		merged.Created = other.Created
+	}
+	merged.SizeBytes += other.SizeBytes
+	return &merged
+}
+
+// mergeRepositoryInfos combines the RepositoryInfos of two sets of shards,
+// commits exist on every shard while files are spread across them.
+func mergeRepositoryInfos(repositoryInfo *pfs.RepositoryInfo, other *pfs.RepositoryInfo) *pfs.RepositoryInfo {
+	if repositoryInfo == nil {
+		return other
+	}
+	created := repositoryInfo.Created
+	if created == nil || (other.Created != nil && protoutil.TimestampLess(other.Created, created)) {
+		created = other.Created
+	}
+	commitCount := repositoryInfo.CommitCount
+	if other.CommitCount > commitCount {
+		commitCount = other.CommitCount
+	}
+	return &pfs.RepositoryInfo{
+		Repository:  repositoryInfo.Repository,
+		Created:     created,
+		CommitCount: commitCount,
+		SizeBytes:   repositoryInfo.SizeBytes + other.SizeBytes,
+	}
+}
+
+type newestFirst []*pfs.CommitInfo
+
+func (n newestFirst) Len() int {
+	return len(n)
+}
+
+func (n newestFirst) Less(i, j int) bool {
+	if n[i].Created != nil && n[j].Created != nil {
+		if protoutil.TimestampLess(n[j].Created, n[i].Created) {
+			return true
+		}
+		if protoutil.TimestampLess(n[i].Created, n[j].Created) {
+			return false
+		}
+	}
+	return n[i].Commit.Id < n[j].Commit.Id
+}
+
+func (n newestFirst) Swap(i, j int) {
+	n[i], n[j] = n[j], n[i]
+}
+
+// newestOperationFirst sorts operations by when they were started, newest
+// first.
+type newestOperationFirst []*pfs.Operation
+
+func (n newestOperationFirst) Len() int {
+	return len(n)
+}
+
+func (n newestOperationFirst) Less(i, j int) bool {
+	return protoutil.TimestampLess(n[j].Started, n[i].Started)
+}
+
+func (n newestOperationFirst) Swap(i, j int) {
+	n[i], n[j] = n[j], n[i]
+}
+
+// putFileReader reads the values of the requests sent to PutFileStream.
+type putFileReader struct {
+	apiPutFileStreamServer pfs.Api_PutFileStreamServer
+	value                  []byte
+	size                   int64
+}
+
+func (p *putFileReader) Read(buffer []byte) (int, error) {
+	for len(p.value) == 0 {
+		putFileRequest, err := p.apiPutFileStreamServer.Recv()
+		if err != nil {
+			return 0, err
+		}
+		p.value = putFileRequest.Value
+	}
+	n := copy(buffer, p.value)
+	p.value = p.value[n:]
+	p.size += int64(n)
+	return n, nil
+}
+
+type importTarReader struct {
+	apiImportTarServer pfs.Api_ImportTarServer
+	value              []byte
+}
+
+func (i *importTarReader) Read(buffer []byte) (int, error) {
+	for len(i.value) == 0 {
+		importTarRequest, err := i.apiImportTarServer.Recv()
+		if err != nil {
+			return 0, err
+		}
+		i.value = importTarRequest.Value
+	}
+	n := copy(buffer, i.value)
+	i.value = i.value[n:]
+	return n, nil
+}
+
+type changesByPath []*pfs.Change
+
+func (c changesByPath) Len() int {
+	return len(c)
+}
+
+func (c changesByPath) Less(i, j int) bool {
+	return c[i].Path.Path < c[j].Path.Path
+}
+
+func (c changesByPath) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+
+type byPath []*pfs.FileInfo
+
+func (b byPath) Len() int {
+	return len(b)
+}
+
+func (b byPath) Less(i, j int) bool {
+	return b[i].Path.Path < b[j].Path.Path
+}
+
+func (b byPath) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+func (b *byPath) Push(x interface{}) {
+	*b = append(*b, x.(*pfs.FileInfo))
+}
+
+func (b *byPath) Pop() interface{} {
+	fileInfo := (*b)[len(*b)-1]
+	*b = (*b)[:len(*b)-1]
+	return fileInfo
 }
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"time"
+
 	"github.com/pachyderm/pachyderm/src/pfs"
 	"github.com/pachyderm/pachyderm/src/pfs/drive"
+	"github.com/pachyderm/pachyderm/src/pfs/role"
 	"github.com/pachyderm/pachyderm/src/pfs/route"
 )
 
 const (
 	// InitialCommitID is the initial id before any commits are made in a repository.
 	InitialCommitID = "scratch"
+	// InitialBranch is the branch created by InitRepository, it points at the initial commit.
+	InitialBranch = "master"
 )
 
 var (
@@ -21,17 +26,43 @@ var (
 type CombinedAPIServer interface {
 	pfs.ApiServer
 	pfs.InternalApiServer
+	// Replica backfills a shard from its master so that a Roler can make
+	// the server one of its replicas.
+	role.Server
+	// Recover rolls back the operations that were interrupted by a restart,
+	// retrying a while for the other servers to be reached. Operations that
+	// still can't be rolled back are left for the next Recover and returned
+	// in the error. It can be run while the server is serving.
+	Recover() error
+	// WatchNumShards switches to the number of shards in discovery whenever a
+	// Reshard sets it, until cancel is closed.
+	WatchNumShards(cancel chan bool) error
+	// CollectGarbage garbage collects every repository, it does nothing on
+	// servers that aren't the master of the lowest shard that has a master.
+	CollectGarbage() error
 }
 
 // NewCombinedAPIServer returns a new CombinedAPIServer.
+//
+// Every unary call the server makes to another server is given at most
+// hopTimeout, zero means calls are only bounded by the context of the
+// request. Streams are only bounded by the context of the request.
+//
+// Calls to every other server, or every replica of a shard, are made
+// concurrently, at most fanOutParallelism at once, less than 1 means no
+// limit.
 func NewCombinedAPIServer(
 	sharder route.Sharder,
 	router route.Router,
 	driver drive.Driver,
+	hopTimeout time.Duration,
+	fanOutParallelism int,
 ) CombinedAPIServer {
 	return newCombinedAPIServer(
 		sharder,
 		router,
 		driver,
+		hopTimeout,
+		fanOutParallelism,
 	)
 }
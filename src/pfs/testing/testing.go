@@ -6,16 +6,20 @@ import (
 	"os"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/pachyderm/pachyderm/src/pfs"
 	"github.com/pachyderm/pachyderm/src/pfs/drive"
 	"github.com/pachyderm/pachyderm/src/pfs/drive/btrfs"
+	"github.com/pachyderm/pachyderm/src/pfs/drive/local"
+	"github.com/pachyderm/pachyderm/src/pfs/drive/memory"
 	"github.com/pachyderm/pachyderm/src/pfs/route"
 	"github.com/pachyderm/pachyderm/src/pfs/server"
 	"github.com/pachyderm/pachyderm/src/pkg/discovery"
 	"github.com/pachyderm/pachyderm/src/pkg/grpctest"
 	"github.com/pachyderm/pachyderm/src/pkg/grpcutil"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
 
@@ -25,6 +29,30 @@ const (
 	// we can do about that?
 	testShardsPerServer = 8
 	testNumServers      = 8
+	// testMirrorNumServers is the size of the second cluster RunMirrorTest
+	// starts, it has a different number of shards than the first.
+	testMirrorNumServers = 3
+	testHopTimeout       = time.Minute
+	// testFanOutParallelism is less than testNumServers so that fan outs
+	// are bounded.
+	testFanOutParallelism = 4
+	testVirtualNodes      = 16
+	// testBenchLatency is how long RunMemoryBench's servers take to make a
+	// change, like a remote server would.
+	testBenchLatency = time.Millisecond
+)
+
+// sharding picks the sharder of a test cluster.
+type sharding int
+
+const (
+	shardByPath sharding = iota
+	// shardByPathReshardable uses a consistent hash sharder and the servers
+	// master as many shards again for a reshard.
+	shardByPathReshardable
+	shardByParentDirectory
+	// shardByTopDirectory shards by the first element of the directory.
+	shardByTopDirectory
 )
 
 var (
@@ -43,11 +71,117 @@ func RunTest(
 ) {
 	discoveryClient, err := getEtcdClient()
 	require.NoError(t, err)
+	runTest(t, discoveryClient, getDriver, testNumServers, shardByPath, f)
+}
+
+// RunMemoryTest is like RunTest, but uses in-memory drivers and discovery,
+// so it needs neither etcd nor a driver root.
+func RunMemoryTest(
+	t *testing.T,
+	f func(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient),
+) {
+	runTest(t, discovery.NewMockClient(), getMemoryDriver, testNumServers, shardByPath, f)
+}
+
+// RunDirectoryShardingTest is like RunMemoryTest, but the servers map the
+// files of a directory to one shard, if top by the first element of the
+// directory.
+func RunDirectoryShardingTest(
+	t *testing.T,
+	top bool,
+	f func(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient),
+) {
+	sharding := shardByParentDirectory
+	if top {
+		sharding = shardByTopDirectory
+	}
+	runTest(t, discovery.NewMockClient(), getMemoryDriver, testNumServers, sharding, f)
+}
+
+// RunReshardTest is like RunMemoryTest, but the servers use a consistent hash
+// sharder and the cluster can be resharded to twice as many shards.
+func RunReshardTest(
+	t *testing.T,
+	f func(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient),
+) {
+	runTest(t, discovery.NewMockClient(), getMemoryDriver, testNumServers, shardByPathReshardable, f)
+}
+
+// RunReplicaTest is like RunMemoryTest, but f is also given the addresser and
+// the servers of the cluster, so that it can move roles around.
+func RunReplicaTest(
+	t *testing.T,
+	f func(t *testing.T, apiClient pfs.ApiClient, addresser route.Addresser, servers map[string]server.CombinedAPIServer),
+) {
+	addresser := route.NewDiscoveryAddresser(discovery.NewMockClient(), testNamespace())
+	var combinedAPIServers map[string]server.CombinedAPIServer
 	grpctest.Run(
 		t,
 		testNumServers,
 		func(servers map[string]*grpc.Server) {
-			registerFunc(t, discoveryClient, servers)
+			var err error
+			combinedAPIServers, err = registerFunc(t, addresser, getMemoryDriver, testFanOutParallelism, shardByPath, servers)
+			require.NoError(t, err)
+		},
+		func(t *testing.T, clientConns map[string]*grpc.ClientConn) {
+			var clientConn *grpc.ClientConn
+			for _, c := range clientConns {
+				clientConn = c
+				break
+			}
+			f(
+				t,
+				pfs.NewApiClient(
+					clientConn,
+				),
+				addresser,
+				combinedAPIServers,
+			)
+		},
+	)
+}
+
+// RunMirrorTest is like RunMemoryTest, but f is given clients of two clusters
+// with different numbers of shards.
+func RunMirrorTest(
+	t *testing.T,
+	f func(t *testing.T, apiClient pfs.ApiClient, otherAPIClient pfs.ApiClient),
+) {
+	runTest(
+		t,
+		discovery.NewMockClient(),
+		getMemoryDriver,
+		testNumServers,
+		shardByPath,
+		func(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+			runTest(
+				t,
+				discovery.NewMockClient(),
+				getMemoryDriver,
+				testMirrorNumServers,
+				shardByPath,
+				func(t *testing.T, otherAPIClient pfs.ApiClient, otherInternalAPIClient pfs.InternalApiClient) {
+					f(t, apiClient, otherAPIClient)
+				},
+			)
+		},
+	)
+}
+
+func runTest(
+	t *testing.T,
+	discoveryClient discovery.Client,
+	driverFunc func(tb testing.TB, namespace string) drive.Driver,
+	numServers int,
+	sharding sharding,
+	f func(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient),
+) {
+	grpctest.Run(
+		t,
+		numServers,
+		func(servers map[string]*grpc.Server) {
+			_, err := registerFunc(t, route.NewDiscoveryAddresser(discoveryClient, testNamespace()), driverFunc, testFanOutParallelism, sharding, servers)
+			require.NoError(t, err)
 		},
 		func(t *testing.T, clientConns map[string]*grpc.ClientConn) {
 			var clientConn *grpc.ClientConn
@@ -78,7 +212,8 @@ func RunBench(
 		b,
 		testNumServers,
 		func(servers map[string]*grpc.Server) {
-			registerFunc(b, discoveryClient, servers)
+			_, err := registerFunc(b, route.NewDiscoveryAddresser(discoveryClient, testNamespace()), getDriver, testFanOutParallelism, shardByPath, servers)
+			require.NoError(b, err)
 		},
 		func(b *testing.B, clientConns map[string]*grpc.ClientConn) {
 			var clientConn *grpc.ClientConn
@@ -96,51 +231,143 @@ func RunBench(
 	)
 }
 
-func registerFunc(tb testing.TB, discoveryClient discovery.Client, servers map[string]*grpc.Server) error {
-	addresser := route.NewDiscoveryAddresser(
-		discoveryClient,
-		testNamespace(),
+// RunMemoryBench is like RunBench, but uses in-memory drivers with
+// testBenchLatency added and discovery, and servers that make at most
+// fanOutParallelism concurrent calls to the other servers.
+func RunMemoryBench(
+	b *testing.B,
+	fanOutParallelism int,
+	f func(b *testing.B, apiClient pfs.ApiClient),
+) {
+	discoveryClient := discovery.NewMockClient()
+	grpctest.RunB(
+		b,
+		testNumServers,
+		func(servers map[string]*grpc.Server) {
+			_, err := registerFunc(b, route.NewDiscoveryAddresser(discoveryClient, testNamespace()), getLatencyDriver, fanOutParallelism, shardByPath, servers)
+			require.NoError(b, err)
+		},
+		func(b *testing.B, clientConns map[string]*grpc.ClientConn) {
+			var clientConn *grpc.ClientConn
+			for _, c := range clientConns {
+				clientConn = c
+				break
+			}
+			f(
+				b,
+				pfs.NewApiClient(
+					clientConn,
+				),
+			)
+		},
 	)
+}
+
+// registerFunc registers servers that master testShardsPerServer shards each
+// and replicate those of the next two servers, it returns them by address.
+func registerFunc(
+	tb testing.TB,
+	addresser route.Addresser,
+	driverFunc func(tb testing.TB, namespace string) drive.Driver,
+	fanOutParallelism int,
+	sharding sharding,
+	servers map[string]*grpc.Server,
+) (map[string]server.CombinedAPIServer, error) {
+	numShards := testShardsPerServer * len(servers)
+	sharder := route.NewSharder(numShards)
+	maxShards := numShards
+	switch sharding {
+	case shardByPathReshardable:
+		sharder = route.NewConsistentHashSharder(numShards, testVirtualNodes)
+		maxShards *= 2
+	case shardByParentDirectory:
+		sharder = route.NewDirectorySharder(sharder, 0)
+	case shardByTopDirectory:
+		sharder = route.NewDirectorySharder(sharder, 1)
+	}
 	i := 0
 	for address := range servers {
-		for j := 0; j < testShardsPerServer; j++ {
-			if err := addresser.SetMasterAddress((i*testShardsPerServer)+j, address, 0); err != nil {
-				return err
-			}
-			if err := addresser.SetReplicaAddress((((i+1)%len(servers))*testShardsPerServer)+j, address, 0); err != nil {
-				return err
-			}
-			if err := addresser.SetReplicaAddress((((i+2)%len(servers))*testShardsPerServer)+j, address, 0); err != nil {
-				return err
+		for offset := 0; offset < maxShards; offset += numShards {
+			for j := 0; j < testShardsPerServer; j++ {
+				if err := addresser.SetMasterAddress(offset+(i*testShardsPerServer)+j, address, 0); err != nil {
+					return nil, err
+				}
+				if err := addresser.SetReplicaAddress(offset+(((i+1)%len(servers))*testShardsPerServer)+j, address, 0); err != nil {
+					return nil, err
+				}
+				if err := addresser.SetReplicaAddress(offset+(((i+2)%len(servers))*testShardsPerServer)+j, address, 0); err != nil {
+					return nil, err
+				}
 			}
 		}
 		i++
 	}
+	combinedAPIServers := make(map[string]server.CombinedAPIServer)
 	for address, s := range servers {
 		combinedAPIServer := server.NewCombinedAPIServer(
-			route.NewSharder(
-				testShardsPerServer*testNumServers,
-			),
+			sharder,
 			route.NewRouter(
 				addresser,
 				grpcutil.NewDialer(),
 				address,
 			),
-			getDriver(tb, address),
+			driverFunc(tb, address),
+			testHopTimeout,
+			fanOutParallelism,
 		)
 		pfs.RegisterApiServer(s, combinedAPIServer)
 		pfs.RegisterInternalApiServer(s, combinedAPIServer)
+		combinedAPIServers[address] = combinedAPIServer
 	}
-	return nil
+	return combinedAPIServers, nil
 }
 
 func getDriver(tb testing.TB, namespace string) drive.Driver {
-	driver, err := btrfs.NewDriver(getBtrfsRootDir(tb), namespace)
+	var driver drive.Driver
+	var err error
+	switch driverType := os.Getenv("PFS_DRIVER_TYPE"); driverType {
+	case "", "btrfs":
+		driver, err = btrfs.NewDriver(getDriverRootDir(tb), namespace)
+	case "local":
+		driver, err = local.NewDriver(getDriverRootDir(tb), namespace)
+	default:
+		tb.Fatalf("unknown value for PFS_DRIVER_TYPE: %s", driverType)
+	}
 	require.NoError(tb, err)
 	return driver
 }
 
-func getBtrfsRootDir(tb testing.TB) string {
+func getMemoryDriver(tb testing.TB, namespace string) drive.Driver {
+	return memory.NewDriver()
+}
+
+func getLatencyDriver(tb testing.TB, namespace string) drive.Driver {
+	return &latencyDriver{memory.NewDriver()}
+}
+
+// latencyDriver waits for testBenchLatency before the changes that every
+// server makes once per call, so that a fan out costs what it would with
+// remote servers.
+type latencyDriver struct {
+	drive.Driver
+}
+
+func (d *latencyDriver) MakeDirectory(ctx context.Context, path *pfs.Path, shards map[int]bool) error {
+	time.Sleep(testBenchLatency)
+	return d.Driver.MakeDirectory(ctx, path, shards)
+}
+
+func (d *latencyDriver) Branch(ctx context.Context, commit *pfs.Commit, newCommit *pfs.Commit, branch string, message string, shards map[int]bool) (*pfs.Commit, error) {
+	time.Sleep(testBenchLatency)
+	return d.Driver.Branch(ctx, commit, newCommit, branch, message, shards)
+}
+
+func (d *latencyDriver) Commit(ctx context.Context, commit *pfs.Commit, message string, shards map[int]bool) error {
+	time.Sleep(testBenchLatency)
+	return d.Driver.Commit(ctx, commit, message, shards)
+}
+
+func getDriverRootDir(tb testing.TB) string {
 	// TODO(pedge)
 	rootDir := os.Getenv("PFS_DRIVER_ROOT")
 	if rootDir == "" {
@@ -1,21 +1,31 @@
 package testing
 
 import (
+	"archive/tar"
 	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"go.pedge.io/protolog/logrus"
 
 	"github.com/pachyderm/pachyderm/src/pfs"
 	"github.com/pachyderm/pachyderm/src/pfs/fuse"
+	"github.com/pachyderm/pachyderm/src/pfs/mirror"
 	"github.com/pachyderm/pachyderm/src/pfs/pfsutil"
+	"github.com/pachyderm/pachyderm/src/pfs/route"
+	"github.com/pachyderm/pachyderm/src/pfs/server"
+	"github.com/pachyderm/pachyderm/src/pkg/protoutil"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -31,140 +41,1712 @@ func TestBtrfs(t *testing.T) {
 	RunTest(t, testSimple)
 }
 
+func TestMemory(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testSimple)
+}
+
+func TestDeleteFile(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testDeleteFile)
+}
+
+func TestListChangedFiles(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testListChangedFiles)
+}
+
+func TestRepositories(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testRepositories)
+}
+
+func TestCommitInfo(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testCommitInfo)
+}
+
+func TestBranches(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testBranches)
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testMerge)
+}
+
+func TestPutFileStream(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testPutFileStream)
+}
+
+func TestListFilesRecursive(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testListFilesRecursive)
+}
+
+func TestChecksums(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testChecksums)
+}
+
+func TestListFileHistory(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testListFileHistory)
+}
+
+func TestRollback(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testRollback)
+}
+
+func TestSquashCommits(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testSquashCommits)
+}
+
+func TestGarbageCollect(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testGarbageCollect)
+}
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+	RunMemoryTest(t, testExportImport)
+}
+
+func TestMirror(t *testing.T) {
+	t.Parallel()
+	RunMirrorTest(t, testMirror)
+}
+
+func TestParentDirectorySharding(t *testing.T) {
+	t.Parallel()
+	RunDirectoryShardingTest(t, false, testListFilesRecursive)
+}
+
+func TestTopDirectorySharding(t *testing.T) {
+	t.Parallel()
+	RunDirectoryShardingTest(t, true, testListFilesRecursive)
+}
+
+func TestReshard(t *testing.T) {
+	t.Parallel()
+	RunReshardTest(t, testReshard)
+}
+
+func TestReplicaReads(t *testing.T) {
+	t.Parallel()
+	RunReplicaTest(t, testReplicaReads)
+}
+
+func TestBackfill(t *testing.T) {
+	t.Parallel()
+	RunReplicaTest(t, testBackfill)
+}
+
 func TestFuseMount(t *testing.T) {
 	t.Skip()
 	t.Parallel()
 	RunTest(t, testMount)
 }
 
-func TestFuseMountBig(t *testing.T) {
-	t.Skip()
-	if testing.Short() {
-		t.Skip()
+func TestFuseMountBig(t *testing.T) {
+	t.Skip()
+	if testing.Short() {
+		t.Skip()
+	}
+	t.Parallel()
+	RunTest(t, testMountBig)
+
+}
+
+func BenchmarkFuse(b *testing.B) {
+	RunBench(b, benchMount)
+}
+
+func BenchmarkFanOutSerial(b *testing.B) {
+	RunMemoryBench(b, 1, benchFanOut)
+}
+
+func BenchmarkFanOutParallel(b *testing.B) {
+	RunMemoryBench(b, 0, benchFanOut)
+}
+
+func testSimple(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, "scratch")
+	require.NoError(t, err)
+	require.NotNil(t, getCommitInfoResponse)
+	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.Commit.Id)
+	require.Equal(t, pfs.CommitType_COMMIT_TYPE_READ, getCommitInfoResponse.CommitInfo.CommitType)
+	require.Nil(t, getCommitInfoResponse.CommitInfo.ParentCommit)
+	scratchCommitInfo := getCommitInfoResponse.CommitInfo
+
+	listCommitsResponse, err := pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(listCommitsResponse.CommitInfo))
+	require.Equal(t, scratchCommitInfo, listCommitsResponse.CommitInfo[0])
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	require.NotNil(t, branchResponse)
+	newCommitID := branchResponse.Commit.Id
+
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, newCommitID)
+	require.NoError(t, err)
+	require.NotNil(t, getCommitInfoResponse)
+	require.Equal(t, newCommitID, getCommitInfoResponse.CommitInfo.Commit.Id)
+	require.Equal(t, pfs.CommitType_COMMIT_TYPE_WRITE, getCommitInfoResponse.CommitInfo.CommitType)
+	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+	newCommitInfo := getCommitInfoResponse.CommitInfo
+
+	listCommitsResponse, err = pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(listCommitsResponse.CommitInfo))
+	require.Equal(t, newCommitInfo, listCommitsResponse.CommitInfo[0])
+	require.Equal(t, scratchCommitInfo, listCommitsResponse.CommitInfo[1])
+
+	err = pfsutil.MakeDirectory(apiClient, repositoryName, newCommitID, "a/b")
+	require.NoError(t, err)
+	err = pfsutil.MakeDirectory(apiClient, repositoryName, newCommitID, "a/c")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < testSize; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, iErr := pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+				fmt.Sprintf("a/b/file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
+			require.NoError(t, iErr)
+			_, iErr = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+				fmt.Sprintf("a/c/file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
+			require.NoError(t, iErr)
+		}()
+	}
+	wg.Wait()
+
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, newCommitID)
+	require.NoError(t, err)
+	require.NotNil(t, getCommitInfoResponse)
+	require.Equal(t, newCommitID, getCommitInfoResponse.CommitInfo.Commit.Id)
+	require.Equal(t, pfs.CommitType_COMMIT_TYPE_READ, getCommitInfoResponse.CommitInfo.CommitType)
+	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+
+	wg = sync.WaitGroup{}
+	for i := 0; i < testSize; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buffer := bytes.NewBuffer(nil)
+			iErr := pfsutil.GetFile(apiClient, repositoryName, newCommitID,
+				fmt.Sprintf("a/b/file%d", i), 0, pfsutil.GetAll, buffer)
+			require.NoError(t, iErr)
+			require.Equal(t, fmt.Sprintf("hello%d", i), buffer.String())
+			buffer = bytes.NewBuffer(nil)
+			iErr = pfsutil.GetFile(apiClient, repositoryName, newCommitID,
+				fmt.Sprintf("a/c/file%d", i), 0, pfsutil.GetAll, buffer)
+			require.NoError(t, iErr)
+			require.Equal(t, fmt.Sprintf("hello%d", i), buffer.String())
+		}()
+	}
+	wg.Wait()
+
+	listFilesResponse, err := pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "a/b", 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, testSize, len(listFilesResponse.FileInfo))
+	listFilesResponse, err = pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "a/c", 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, testSize, len(listFilesResponse.FileInfo))
+
+	var fileInfos [7][]*pfs.FileInfo
+	wg = sync.WaitGroup{}
+	for i := 0; i < 7; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			listFilesResponse, iErr := pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "a/b", uint64(i), 7)
+			require.NoError(t, iErr)
+			fileInfos[i] = listFilesResponse.FileInfo
+		}()
+	}
+	wg.Wait()
+	count := 0
+	for i := 0; i < 7; i++ {
+		count += len(fileInfos[i])
+	}
+	require.Equal(t, testSize, count)
+}
+
+func testDeleteFile(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	newCommitID := branchResponse.Commit.Id
+
+	err = pfsutil.MakeDirectory(apiClient, repositoryName, newCommitID, "a/b")
+	require.NoError(t, err)
+	for i := 0; i < testSize; i++ {
+		_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+			fmt.Sprintf("a/b/file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+		_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+			fmt.Sprintf("a/file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < testSize; i += 2 {
+		err = pfsutil.DeleteFile(apiClient, repositoryName, newCommitID, fmt.Sprintf("a/file%d", i))
+		require.NoError(t, err)
+	}
+	err = pfsutil.DeleteFile(apiClient, repositoryName, newCommitID, "a/file0")
+	require.Error(t, err)
+	err = pfsutil.DeleteFile(apiClient, repositoryName, newCommitID, "a/b")
+	require.NoError(t, err)
+
+	listFilesResponse, err := pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "a", 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, testSize/2, len(listFilesResponse.FileInfo))
+	for _, fileInfo := range listFilesResponse.FileInfo {
+		require.Equal(t, pfs.FileType_FILE_TYPE_REGULAR, fileInfo.FileType)
+	}
+	getFileInfoResponse, err := pfsutil.GetFileInfo(apiClient, repositoryName, newCommitID, "a/b")
+	require.NoError(t, err)
+	require.Nil(t, getFileInfoResponse.FileInfo)
+
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+	err = pfsutil.DeleteFile(apiClient, repositoryName, newCommitID, "a/file1")
+	require.Error(t, err)
+}
+
+func testListChangedFiles(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	parentCommitID := branchResponse.Commit.Id
+	err = pfsutil.MakeDirectory(apiClient, repositoryName, parentCommitID, "a")
+	require.NoError(t, err)
+	for i := 0; i < testSize; i++ {
+		_, err = pfsutil.PutFile(apiClient, repositoryName, parentCommitID,
+			fmt.Sprintf("a/file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+	err = pfsutil.Commit(apiClient, repositoryName, parentCommitID, "")
+	require.NoError(t, err)
+
+	branchResponse, err = pfsutil.Branch(apiClient, repositoryName, parentCommitID, "")
+	require.NoError(t, err)
+	newCommitID := branchResponse.Commit.Id
+	err = pfsutil.MakeDirectory(apiClient, repositoryName, newCommitID, "b")
+	require.NoError(t, err)
+	for i := 0; i < testSize; i++ {
+		switch i % 3 {
+		case 0:
+			_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+				fmt.Sprintf("a/file%d", i), 0, strings.NewReader(fmt.Sprintf("goodbye%d", i)))
+		case 1:
+			err = pfsutil.DeleteFile(apiClient, repositoryName, newCommitID, fmt.Sprintf("a/file%d", i))
+		case 2:
+			_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+				fmt.Sprintf("b/file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
+		}
+		require.NoError(t, err)
+	}
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+
+	listChangedFilesResponse, err := pfsutil.ListChangedFiles(apiClient, repositoryName, "", newCommitID, 0, 1)
+	require.NoError(t, err)
+	counts := make(map[pfs.ChangeType]int)
+	for _, change := range listChangedFilesResponse.Change {
+		counts[change.ChangeType]++
+	}
+	require.Equal(t, (testSize+2)/3, counts[pfs.ChangeType_CHANGE_TYPE_MODIFIED])
+	require.Equal(t, (testSize+1)/3, counts[pfs.ChangeType_CHANGE_TYPE_DELETED])
+	// the directory b is only reported once even though it is on every shard
+	require.Equal(t, testSize/3+1, counts[pfs.ChangeType_CHANGE_TYPE_ADDED])
+
+	listChangedFilesResponse, err = pfsutil.ListChangedFiles(apiClient, repositoryName, newCommitID, newCommitID, 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(listChangedFilesResponse.Change))
+
+	count := 0
+	for shard := 0; shard < testShardsPerServer*testNumServers; shard++ {
+		listChangedFilesResponse, err = pfsutil.ListChangedFiles(apiClient, repositoryName, parentCommitID, newCommitID, uint64(shard), uint64(testShardsPerServer*testNumServers))
+		require.NoError(t, err)
+		for _, change := range listChangedFilesResponse.Change {
+			if change.FileType != pfs.FileType_FILE_TYPE_DIR {
+				count++
+			}
+		}
+	}
+	require.Equal(t, testSize, count)
+}
+
+func testRepositories(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+	otherRepositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+	err = pfsutil.InitRepository(apiClient, otherRepositoryName)
+	require.NoError(t, err)
+
+	listRepositoriesResponse, err := pfsutil.ListRepositories(apiClient)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(listRepositoriesResponse.Repository))
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	newCommitID := branchResponse.Commit.Id
+	for i := 0; i < testSize; i++ {
+		_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+			fmt.Sprintf("file%d", i), 0, strings.NewReader("hello"))
+		require.NoError(t, err)
+	}
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+
+	inspectRepositoryResponse, err := pfsutil.InspectRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+	repositoryInfo := inspectRepositoryResponse.RepositoryInfo
+	require.NotNil(t, repositoryInfo)
+	require.Equal(t, repositoryName, repositoryInfo.Repository.Name)
+	require.NotNil(t, repositoryInfo.Created)
+	require.Equal(t, uint64(2), repositoryInfo.CommitCount)
+	require.Equal(t, uint64(testSize*len("hello")), repositoryInfo.SizeBytes)
+
+	err = pfsutil.DeleteRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+	err = pfsutil.DeleteRepository(apiClient, repositoryName)
+	require.Error(t, err)
+
+	listRepositoriesResponse, err = pfsutil.ListRepositories(apiClient)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(listRepositoriesResponse.Repository))
+	require.Equal(t, otherRepositoryName, listRepositoriesResponse.Repository[0].Name)
+	inspectRepositoryResponse, err = pfsutil.InspectRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Nil(t, inspectRepositoryResponse.RepositoryInfo)
+}
+
+func testCommitInfo(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "branch message")
+	require.NoError(t, err)
+	newCommitID := branchResponse.Commit.Id
+	for i := 0; i < testSize; i++ {
+		_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+			fmt.Sprintf("file%d", i), 0, strings.NewReader("hello"))
+		require.NoError(t, err)
+	}
+
+	getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, newCommitID)
+	require.NoError(t, err)
+	commitInfo := getCommitInfoResponse.CommitInfo
+	require.Equal(t, pfs.CommitType_COMMIT_TYPE_WRITE, commitInfo.CommitType)
+	require.NotNil(t, commitInfo.Created)
+	require.Nil(t, commitInfo.Finished)
+	require.Equal(t, "branch message", commitInfo.Message)
+	require.Equal(t, uint64(testSize*len("hello")), commitInfo.SizeBytes)
+
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "commit message")
+	require.NoError(t, err)
+
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, newCommitID)
+	require.NoError(t, err)
+	commitInfo = getCommitInfoResponse.CommitInfo
+	require.Equal(t, pfs.CommitType_COMMIT_TYPE_READ, commitInfo.CommitType)
+	require.NotNil(t, commitInfo.Finished)
+	require.False(t, protoutil.TimestampLess(commitInfo.Finished, commitInfo.Created))
+	require.Equal(t, "commit message", commitInfo.Message)
+	require.Equal(t, uint64(testSize*len("hello")), commitInfo.SizeBytes)
+
+	listCommitsResponse, err := pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(listCommitsResponse.CommitInfo))
+	require.Equal(t, commitInfo, listCommitsResponse.CommitInfo[0])
+	require.Equal(t, "scratch", listCommitsResponse.CommitInfo[1].Commit.Id)
+}
+
+func testBranches(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	listBranchesResponse, err := pfsutil.ListBranches(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(listBranchesResponse.BranchInfo))
+	require.Equal(t, "master", listBranchesResponse.BranchInfo[0].Name)
+	require.Equal(t, "scratch", listBranchesResponse.BranchInfo[0].Commit.Id)
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
+	newCommitID := branchResponse.Commit.Id
+	getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, newCommitID)
+	require.NoError(t, err)
+	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+	require.Equal(t, "master", getCommitInfoResponse.CommitInfo.Branch)
+	for i := 0; i < testSize; i++ {
+		_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
+			fmt.Sprintf("file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+
+	// master now points at the new commit
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, "master")
+	require.NoError(t, err)
+	require.Equal(t, newCommitID, getCommitInfoResponse.CommitInfo.Commit.Id)
+	for i := 0; i < testSize; i++ {
+		buffer := bytes.NewBuffer(nil)
+		err = pfsutil.GetFile(apiClient, repositoryName, "master", fmt.Sprintf("file%d", i), 0, pfsutil.GetAll, buffer)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("hello%d", i), buffer.String())
+	}
+	listFilesResponse, err := pfsutil.ListFiles(apiClient, repositoryName, "master", "", 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, testSize, len(listFilesResponse.FileInfo))
+
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "old", "scratch")
+	require.NoError(t, err)
+	listFilesResponse, err = pfsutil.ListFiles(apiClient, repositoryName, "old", "", 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(listFilesResponse.FileInfo))
+	listBranchesResponse, err = pfsutil.ListBranches(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(listBranchesResponse.BranchInfo))
+
+	// branches must point at read commits and can't hide commits
+	branchResponse, err = pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "write", branchResponse.Commit.Id)
+	require.Error(t, err)
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "scratch", "master")
+	require.Error(t, err)
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "a/b", "master")
+	require.Error(t, err)
+
+	// of two commits branched from the same head only the first to be
+	// committed moves the branch
+	firstResponse, err := pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
+	secondResponse, err := pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, firstResponse.Commit.Id, "")
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, secondResponse.Commit.Id, "")
+	require.Error(t, err)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, "master")
+	require.NoError(t, err)
+	require.Equal(t, firstResponse.Commit.Id, getCommitInfoResponse.CommitInfo.Commit.Id)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, secondResponse.Commit.Id)
+	require.NoError(t, err)
+	require.Equal(t, pfs.CommitType_COMMIT_TYPE_WRITE, getCommitInfoResponse.CommitInfo.CommitType)
+
+	err = pfsutil.DeleteBranch(apiClient, repositoryName, "old")
+	require.NoError(t, err)
+	err = pfsutil.DeleteBranch(apiClient, repositoryName, "old")
+	require.Error(t, err)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, "old")
+	require.NoError(t, err)
+	require.Nil(t, getCommitInfoResponse.CommitInfo)
+
+	// a branch deleted while a commit on it is open stays deleted
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "old", "master")
+	require.NoError(t, err)
+	branchResponse, err = pfsutil.Branch(apiClient, repositoryName, "old", "")
+	require.NoError(t, err)
+	err = pfsutil.DeleteBranch(apiClient, repositoryName, "old")
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, branchResponse.Commit.Id, "")
+	require.NoError(t, err)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, "old")
+	require.NoError(t, err)
+	require.Nil(t, getCommitInfoResponse.CommitInfo)
+}
+
+func testMerge(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	// commitFiles commits files on a new commit branched from commitID
+	commitFiles := func(commitID string, files map[string]string) string {
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, commitID, "")
+		require.NoError(t, err)
+		for path, content := range files {
+			_, err = pfsutil.PutFile(apiClient, repositoryName, branchResponse.Commit.Id, path, 0, strings.NewReader(content))
+			require.NoError(t, err)
+		}
+		err = pfsutil.Commit(apiClient, repositoryName, branchResponse.Commit.Id, "")
+		require.NoError(t, err)
+		return branchResponse.Commit.Id
+	}
+	getFile := func(commitID string, path string) string {
+		buffer := bytes.NewBuffer(nil)
+		err := pfsutil.GetFile(apiClient, repositoryName, commitID, path, 0, pfsutil.GetAll, buffer)
+		require.NoError(t, err)
+		return buffer.String()
+	}
+
+	commitFiles("master", map[string]string{"shared": "base", "same": "base"})
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "experiment", "master")
+	require.NoError(t, err)
+	theirsID := commitFiles("experiment", map[string]string{"shared": "theirs", "same": "same", "new": "new"})
+	oursID := commitFiles("master", map[string]string{"shared": "ours", "same": "same", "other": "other"})
+
+	// both changed shared so the default policy fails without making a commit
+	listCommitsResponse, err := pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	numCommits := len(listCommitsResponse.CommitInfo)
+	_, err = pfsutil.Merge(apiClient, repositoryName, "master", "experiment", pfs.ConflictPolicy_CONFLICT_POLICY_NONE, "")
+	require.Error(t, err)
+	_, err = pfsutil.Merge(apiClient, repositoryName, "master", "experiment", pfs.ConflictPolicy_CONFLICT_POLICY_FAIL, "")
+	require.Error(t, err)
+	listCommitsResponse, err = pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, numCommits, len(listCommitsResponse.CommitInfo))
+
+	mergeResponse, err := pfsutil.Merge(apiClient, repositoryName, oursID, theirsID, pfs.ConflictPolicy_CONFLICT_POLICY_THEIRS, "")
+	require.NoError(t, err)
+	require.Equal(t, "theirs", getFile(mergeResponse.Commit.Id, "shared"))
+	require.Equal(t, "new", getFile(mergeResponse.Commit.Id, "new"))
+	require.Equal(t, "other", getFile(mergeResponse.Commit.Id, "other"))
+
+	mergeResponse, err = pfsutil.Merge(apiClient, repositoryName, "master", "experiment", pfs.ConflictPolicy_CONFLICT_POLICY_OURS, "merge experiment")
+	require.NoError(t, err)
+	mergeID := mergeResponse.Commit.Id
+	require.Equal(t, "ours", getFile(mergeID, "shared"))
+	require.Equal(t, "same", getFile(mergeID, "same"))
+	require.Equal(t, "new", getFile(mergeID, "new"))
+	require.Equal(t, "other", getFile(mergeID, "other"))
+	err = pfsutil.Commit(apiClient, repositoryName, mergeID, "")
+	require.NoError(t, err)
+
+	// committing the merge advances master and records both parents
+	getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, "master")
+	require.NoError(t, err)
+	require.Equal(t, mergeID, getCommitInfoResponse.CommitInfo.Commit.Id)
+	require.Equal(t, oursID, getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+	require.Equal(t, theirsID, getCommitInfoResponse.CommitInfo.MergeParentCommit.Id)
+	require.Equal(t, "merge experiment", getCommitInfoResponse.CommitInfo.Message)
+
+	// merging again only takes what experiment changed since the last merge
+	theirsID = commitFiles("experiment", map[string]string{"new": "newer"})
+	mergeResponse, err = pfsutil.Merge(apiClient, repositoryName, "master", "experiment", pfs.ConflictPolicy_CONFLICT_POLICY_FAIL, "")
+	require.NoError(t, err)
+	require.Equal(t, "ours", getFile(mergeResponse.Commit.Id, "shared"))
+	require.Equal(t, "newer", getFile(mergeResponse.Commit.Id, "new"))
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, mergeResponse.Commit.Id)
+	require.NoError(t, err)
+	require.Equal(t, theirsID, getCommitInfoResponse.CommitInfo.MergeParentCommit.Id)
+}
+
+func testPutFileStream(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	newCommitID := branchResponse.Commit.Id
+
+	// big spans several chunks and doesn't end on a chunk boundary, it's
+	// put to a few paths so that it lands on more than one shard
+	big := bytes.Repeat([]byte("pachyderm"), 3*pfsutil.PutFileChunkSize/9+1)
+	numBig := 8
+	for i := 0; i < numBig; i++ {
+		written, err := pfsutil.PutFile(apiClient, repositoryName, newCommitID, fmt.Sprintf("big%d", i), 0, bytes.NewReader(big))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(big)), written)
+	}
+	written, err := pfsutil.PutFile(apiClient, repositoryName, newCommitID, "empty", 0, bytes.NewReader(nil))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), written)
+	_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID, "offset", 0, strings.NewReader("hello"))
+	require.NoError(t, err)
+	_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID, "offset", 5, strings.NewReader(" world"))
+	require.NoError(t, err)
+	_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID, "/leading", 0, strings.NewReader("foo"))
+	require.Error(t, err)
+	_, err = pfsutil.PutFile(apiClient, repositoryName, "scratch", "readonly", 0, strings.NewReader("foo"))
+	require.Error(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+
+	for i := 0; i < numBig; i++ {
+		buffer := bytes.NewBuffer(nil)
+		err = pfsutil.GetFile(apiClient, repositoryName, newCommitID, fmt.Sprintf("big%d", i), 0, pfsutil.GetAll, buffer)
+		require.NoError(t, err)
+		require.Equal(t, big, buffer.Bytes())
+	}
+	getFileInfoResponse, err := pfsutil.GetFileInfo(apiClient, repositoryName, newCommitID, "empty")
+	require.NoError(t, err)
+	require.NotNil(t, getFileInfoResponse.FileInfo)
+	require.Equal(t, uint64(0), getFileInfoResponse.FileInfo.SizeBytes)
+	buffer := bytes.NewBuffer(nil)
+	err = pfsutil.GetFile(apiClient, repositoryName, newCommitID, "offset", 0, pfsutil.GetAll, buffer)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", buffer.String())
+}
+
+func testListFilesRecursive(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	newCommitID := branchResponse.Commit.Id
+	for _, dir := range []string{"logs", "logs/2015-01", "logs/2015-01/a", "logs/2015-02", "logs/2016-01"} {
+		err = pfsutil.MakeDirectory(apiClient, repositoryName, newCommitID, dir)
+		require.NoError(t, err)
+	}
+	files := []string{"top.json", "logs/2015-01/a/x.json", "logs/2015-01/b.json", "logs/2015-01/c.txt", "logs/2015-02/d.json"}
+	for i := 0; i < testSize; i++ {
+		files = append(files, fmt.Sprintf("logs/2016-01/file%d.json", i))
+	}
+	for _, file := range files {
+		_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID, file, 0, strings.NewReader(file))
+		require.NoError(t, err)
+	}
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+
+	listPaths := func(path string, recursive bool, pattern string) []string {
+		var paths []string
+		err := pfsutil.ListFilesStream(apiClient, repositoryName, newCommitID, path, recursive, pattern, 0, 1,
+			func(fileInfo *pfs.FileInfo) error {
+				paths = append(paths, fileInfo.Path.Path)
+				return nil
+			},
+		)
+		require.NoError(t, err)
+		return paths
+	}
+
+	// everything is listed once, directories included, in path order
+	paths := listPaths("", true, "")
+	require.Equal(t, len(files)+5, len(paths))
+	require.True(t, sort.StringsAreSorted(paths))
+	listFilesResponse, err := apiClient.ListFiles(
+		context.Background(),
+		&pfs.ListFilesRequest{
+			Path: &pfs.Path{
+				Commit: &pfs.Commit{
+					Repository: &pfs.Repository{Name: repositoryName},
+					Id:         newCommitID,
+				},
+			},
+			Recursive: true,
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, len(paths), len(listFilesResponse.FileInfo))
+	for i, fileInfo := range listFilesResponse.FileInfo {
+		require.Equal(t, paths[i], fileInfo.Path.Path)
+	}
+
+	require.Equal(t, []string{"logs/2015-01/a/x.json", "logs/2015-01/b.json", "logs/2015-02/d.json"}, listPaths("", true, "logs/2015-*/**/*.json"))
+	require.Equal(t, []string{"logs/2015-01/a", "logs/2015-01/b.json", "logs/2015-01/c.txt"}, listPaths("logs/2015-01", false, ""))
+	require.Equal(t, []string{"top.json"}, listPaths("", false, "*.json"))
+	require.Equal(t, testSize, len(listPaths("logs", true, "logs/2016-01/*")))
+	require.Nil(t, listPaths("", true, "missing/**"))
+
+	err = pfsutil.ListFilesStream(apiClient, repositoryName, newCommitID, "", true, "[", 0, 1, func(*pfs.FileInfo) error { return nil })
+	require.Error(t, err)
+}
+
+func testChecksums(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	newCommitID := branchResponse.Commit.Id
+	for i := 0; i < testSize; i++ {
+		_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID, fmt.Sprintf("file%d", i), 0, strings.NewReader(fmt.Sprintf("content%d", i)))
+		require.NoError(t, err)
+	}
+	getFileInfoResponse, err := pfsutil.GetFileInfo(apiClient, repositoryName, newCommitID, "file0")
+	require.NoError(t, err)
+	require.Nil(t, getFileInfoResponse.FileInfo.Checksum)
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+
+	for i := 0; i < testSize; i++ {
+		checksum := sha256.Sum256([]byte(fmt.Sprintf("content%d", i)))
+		getFileInfoResponse, err := pfsutil.GetFileInfo(apiClient, repositoryName, newCommitID, fmt.Sprintf("file%d", i))
+		require.NoError(t, err)
+		require.Equal(t, checksum[:], getFileInfoResponse.FileInfo.Checksum)
+	}
+	listFilesResponse, err := pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "", 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, testSize, len(listFilesResponse.FileInfo))
+	for _, fileInfo := range listFilesResponse.FileInfo {
+		var i int
+		_, err := fmt.Sscanf(fileInfo.Path.Path, "file%d", &i)
+		require.NoError(t, err)
+		checksum := sha256.Sum256([]byte(fmt.Sprintf("content%d", i)))
+		require.Equal(t, checksum[:], fileInfo.Checksum)
+	}
+}
+
+func testListFileHistory(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	// every commit rewrites file0 and file1, only file0 changes after the first
+	var commitIDs []string
+	commitID := "scratch"
+	for i := 0; i < testSize; i++ {
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, commitID, "")
+		require.NoError(t, err)
+		commitID = branchResponse.Commit.Id
+		_, err = pfsutil.PutFile(apiClient, repositoryName, commitID, "file0", 0, strings.NewReader(fmt.Sprintf("content%d", i)))
+		require.NoError(t, err)
+		_, err = pfsutil.PutFile(apiClient, repositoryName, commitID, "file1", 0, strings.NewReader("content"))
+		require.NoError(t, err)
+		err = pfsutil.Commit(apiClient, repositoryName, commitID, "")
+		require.NoError(t, err)
+		commitIDs = append(commitIDs, commitID)
+	}
+
+	listFileHistoryResponse, err := pfsutil.ListFileHistory(apiClient, repositoryName, commitID, "file0")
+	require.NoError(t, err)
+	require.Equal(t, testSize, len(listFileHistoryResponse.FileRevision))
+	for i, fileRevision := range listFileHistoryResponse.FileRevision {
+		require.Equal(t, commitIDs[testSize-1-i], fileRevision.Commit.Id)
+		require.Equal(t, uint64(len(fmt.Sprintf("content%d", testSize-1-i))), fileRevision.SizeBytes)
+		require.NotNil(t, fileRevision.Finished)
+	}
+	listFileHistoryResponse, err = pfsutil.ListFileHistory(apiClient, repositoryName, commitID, "file1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(listFileHistoryResponse.FileRevision))
+	require.Equal(t, commitIDs[0], listFileHistoryResponse.FileRevision[0].Commit.Id)
+	require.Equal(t, pfs.ChangeType_CHANGE_TYPE_ADDED, listFileHistoryResponse.FileRevision[0].ChangeType)
+}
+
+func testRollback(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+	repository := &pfs.Repository{Name: repositoryName}
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+	// rolling back a second init would delete the repository
+	err = pfsutil.InitRepository(apiClient, repositoryName)
+	require.Error(t, err)
+	getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, "master")
+	require.NoError(t, err)
+	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.Commit.Id)
+
+	// a branch that only reached one server is removed
+	partial := &pfs.Commit{Repository: repository, Id: "partial"}
+	_, err = apiClient.Branch(
+		context.Background(),
+		&pfs.BranchRequest{
+			Commit:    &pfs.Commit{Repository: repository, Id: "scratch"},
+			NewCommit: partial,
+			Redirect:  true,
+		},
+	)
+	require.NoError(t, err)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, partial.Id)
+	require.NoError(t, err)
+	require.NotNil(t, getCommitInfoResponse.CommitInfo)
+	_, err = internalAPIClient.Rollback(
+		context.Background(),
+		&pfs.RollbackRequest{
+			Operation: &pfs.Operation{
+				OperationType: pfs.OperationType_OPERATION_TYPE_BRANCH,
+				Repository:    repository,
+				Commit:        partial,
+			},
+		},
+	)
+	require.NoError(t, err)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, partial.Id)
+	require.NoError(t, err)
+	require.Nil(t, getCommitInfoResponse.CommitInfo)
+
+	// a directory is removed along with its new parents
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
+	commitID := branchResponse.Commit.Id
+	err = pfsutil.MakeDirectory(apiClient, repositoryName, commitID, "a/b")
+	require.NoError(t, err)
+	_, err = internalAPIClient.Rollback(
+		context.Background(),
+		&pfs.RollbackRequest{
+			Operation: &pfs.Operation{
+				OperationType: pfs.OperationType_OPERATION_TYPE_MAKE_DIRECTORY,
+				Repository:    repository,
+				Commit:        branchResponse.Commit,
+				Path:          &pfs.Path{Commit: branchResponse.Commit, Path: "a"},
+			},
+		},
+	)
+	require.NoError(t, err)
+	getFileInfoResponse, err := pfsutil.GetFileInfo(apiClient, repositoryName, commitID, "a")
+	require.NoError(t, err)
+	require.Nil(t, getFileInfoResponse.FileInfo)
+
+	// a commit is uncommitted and its branch moved back
+	_, err = pfsutil.PutFile(apiClient, repositoryName, commitID, "file", 0, strings.NewReader("content"))
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, commitID, "")
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, commitID, "")
+	require.Error(t, err)
+	_, err = internalAPIClient.Rollback(
+		context.Background(),
+		&pfs.RollbackRequest{
+			Operation: &pfs.Operation{
+				OperationType: pfs.OperationType_OPERATION_TYPE_COMMIT,
+				Repository:    repository,
+				Commit:        branchResponse.Commit,
+				Branch:        "master",
+				BranchCommit:  &pfs.Commit{Repository: repository, Id: "scratch"},
+			},
+		},
+	)
+	require.NoError(t, err)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, commitID)
+	require.NoError(t, err)
+	require.Equal(t, pfs.CommitType_COMMIT_TYPE_WRITE, getCommitInfoResponse.CommitInfo.CommitType)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, "master")
+	require.NoError(t, err)
+	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.Commit.Id)
+	buffer := bytes.NewBuffer(nil)
+	err = pfsutil.GetFile(apiClient, repositoryName, commitID, "file", 0, pfsutil.GetAll, buffer)
+	require.NoError(t, err)
+	require.Equal(t, "content", buffer.String())
+	err = pfsutil.Commit(apiClient, repositoryName, commitID, "")
+	require.NoError(t, err)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, "master")
+	require.NoError(t, err)
+	require.Equal(t, commitID, getCommitInfoResponse.CommitInfo.Commit.Id)
+
+	// a deletion that only reached one server is finished
+	branchResponse, err = pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, branchResponse.Commit.Id, "")
+	require.NoError(t, err)
+	_, err = apiClient.DeleteCommit(
+		context.Background(),
+		&pfs.DeleteCommitRequest{
+			Redirect: true,
+			Commits:  []*pfs.Commit{branchResponse.Commit},
+		},
+	)
+	require.NoError(t, err)
+	_, err = internalAPIClient.Rollback(
+		context.Background(),
+		&pfs.RollbackRequest{
+			Operation: &pfs.Operation{
+				OperationType: pfs.OperationType_OPERATION_TYPE_DELETE_COMMITS,
+				Repository:    repository,
+				Commits:       []*pfs.Commit{branchResponse.Commit},
+			},
+		},
+	)
+	require.NoError(t, err)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, branchResponse.Commit.Id)
+	require.NoError(t, err)
+	require.Nil(t, getCommitInfoResponse.CommitInfo)
+}
+
+func testSquashCommits(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	// every commit on master adds a file
+	numCommits := 5
+	var commitIDs []string
+	for i := 0; i < numCommits; i++ {
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "master", "")
+		require.NoError(t, err)
+		commitID := branchResponse.Commit.Id
+		for j := 0; j < testSize; j++ {
+			_, err = pfsutil.PutFile(apiClient, repositoryName, commitID, fmt.Sprintf("file%d-%d", i, j), 0, strings.NewReader(fmt.Sprintf("hello%d-%d", i, j)))
+			require.NoError(t, err)
+		}
+		err = pfsutil.Commit(apiClient, repositoryName, commitID, "")
+		require.NoError(t, err)
+		commitIDs = append(commitIDs, commitID)
+	}
+
+	_, err = pfsutil.SquashCommits(apiClient, repositoryName, commitIDs[3], commitIDs[1], "")
+	require.Error(t, err)
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "old", commitIDs[2])
+	require.NoError(t, err)
+	_, err = pfsutil.SquashCommits(apiClient, repositoryName, commitIDs[1], commitIDs[3], "")
+	require.Error(t, err)
+	err = pfsutil.DeleteBranch(apiClient, repositoryName, "old")
+	require.NoError(t, err)
+	// a child of a squashed commit becomes a child of the squashed commit
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, commitIDs[2], "")
+	require.NoError(t, err)
+	sideCommitID := branchResponse.Commit.Id
+	_, err = pfsutil.PutFile(apiClient, repositoryName, sideCommitID, "side", 0, strings.NewReader("side"))
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, sideCommitID, "")
+	require.NoError(t, err)
+
+	squashCommitsResponse, err := pfsutil.SquashCommits(apiClient, repositoryName, commitIDs[1], commitIDs[3], "squashed")
+	require.NoError(t, err)
+	require.Equal(t, commitIDs[3], squashCommitsResponse.Commit.Id)
+	for _, commitID := range commitIDs[1:3] {
+		getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, commitID)
+		require.NoError(t, err)
+		require.Nil(t, getCommitInfoResponse.CommitInfo)
+	}
+	getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, commitIDs[3])
+	require.NoError(t, err)
+	require.Equal(t, commitIDs[0], getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+	require.Equal(t, "squashed", getCommitInfoResponse.CommitInfo.Message)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, commitIDs[4])
+	require.NoError(t, err)
+	require.Equal(t, commitIDs[3], getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, sideCommitID)
+	require.NoError(t, err)
+	require.Equal(t, commitIDs[3], getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+	buffer := bytes.NewBuffer(nil)
+	err = pfsutil.GetFile(apiClient, repositoryName, sideCommitID, "side", 0, pfsutil.GetAll, buffer)
+	require.NoError(t, err)
+	require.Equal(t, "side", buffer.String())
+	listCommitsResponse, err := pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, numCommits, len(listCommitsResponse.CommitInfo))
+	for i := 0; i < numCommits; i++ {
+		for j := 0; j < testSize; j++ {
+			buffer := bytes.NewBuffer(nil)
+			err = pfsutil.GetFile(apiClient, repositoryName, "master", fmt.Sprintf("file%d-%d", i, j), 0, pfsutil.GetAll, buffer)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("hello%d-%d", i, j), buffer.String())
+		}
+	}
+	listChangedFilesResponse, err := pfsutil.ListChangedFiles(apiClient, repositoryName, "", commitIDs[3], 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, 3*testSize, len(listChangedFilesResponse.Change))
+
+	// the squashed commit can be branched from and squashed again
+	branchResponse, err = pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, branchResponse.Commit.Id, "")
+	require.NoError(t, err)
+	_, err = pfsutil.SquashCommits(apiClient, repositoryName, "scratch", "master", "")
+	require.Error(t, err)
+	_, err = pfsutil.SquashCommits(apiClient, repositoryName, commitIDs[0], "master", "")
+	require.NoError(t, err)
+	listCommitsResponse, err = pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(listCommitsResponse.CommitInfo))
+	require.Equal(t, branchResponse.Commit.Id, listCommitsResponse.CommitInfo[0].Commit.Id)
+	require.Equal(t, "scratch", listCommitsResponse.CommitInfo[0].ParentCommit.Id)
+	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, sideCommitID)
+	require.NoError(t, err)
+	require.Equal(t, branchResponse.Commit.Id, getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+}
+
+func testGarbageCollect(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	// every commit on master adds a file
+	numCommits := 5
+	var commitIDs []string
+	for i := 0; i < numCommits; i++ {
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "master", "")
+		require.NoError(t, err)
+		commitID := branchResponse.Commit.Id
+		for j := 0; j < testSize; j++ {
+			_, err = pfsutil.PutFile(apiClient, repositoryName, commitID, fmt.Sprintf("file%d-%d", i, j), 0, strings.NewReader(fmt.Sprintf("hello%d-%d", i, j)))
+			require.NoError(t, err)
+		}
+		err = pfsutil.Commit(apiClient, repositoryName, commitID, "")
+		require.NoError(t, err)
+		commitIDs = append(commitIDs, commitID)
+	}
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
+	abandonedCommitID := branchResponse.Commit.Id
+
+	// commits that something refers to can't be deleted
+	err = pfsutil.DeleteCommit(apiClient, repositoryName, "scratch")
+	require.Error(t, err)
+	err = pfsutil.DeleteCommit(apiClient, repositoryName, commitIDs[2])
+	require.Error(t, err)
+	err = pfsutil.DeleteCommit(apiClient, repositoryName, commitIDs[4])
+	require.Error(t, err)
+	branchResponse, err = pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
+	err = pfsutil.DeleteCommit(apiClient, repositoryName, branchResponse.Commit.Id)
+	require.NoError(t, err)
+	getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, branchResponse.Commit.Id)
+	require.NoError(t, err)
+	require.Nil(t, getCommitInfoResponse.CommitInfo)
+
+	// nothing is collected without a retention policy
+	garbageCollectResponse, err := pfsutil.GarbageCollect(apiClient, repositoryName, false)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(garbageCollectResponse.Deleted))
+	require.Equal(t, 0, len(garbageCollectResponse.Squashed))
+
+	err = pfsutil.SetRetentionPolicy(
+		apiClient,
+		repositoryName,
+		&pfs.RetentionPolicy{
+			KeepLast:           2,
+			WriteCommitTimeout: protoutil.DurationToProtoDuration(time.Nanosecond),
+		},
+	)
+	require.NoError(t, err)
+	getRetentionPolicyResponse, err := pfsutil.GetRetentionPolicy(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), getRetentionPolicyResponse.RetentionPolicy.KeepLast)
+
+	checkGarbageCollectResponse := func(garbageCollectResponse *pfs.GarbageCollectResponse) {
+		require.Equal(t, 1, len(garbageCollectResponse.Deleted))
+		require.Equal(t, abandonedCommitID, garbageCollectResponse.Deleted[0].Id)
+		require.Equal(t, 3, len(garbageCollectResponse.Squashed))
+		for i, commit := range garbageCollectResponse.Squashed {
+			require.Equal(t, commitIDs[2-i], commit.Id)
+		}
+	}
+	garbageCollectResponse, err = pfsutil.GarbageCollect(apiClient, repositoryName, true)
+	require.NoError(t, err)
+	checkGarbageCollectResponse(garbageCollectResponse)
+	listCommitsResponse, err := pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, numCommits+2, len(listCommitsResponse.CommitInfo))
+
+	garbageCollectResponse, err = pfsutil.GarbageCollect(apiClient, repositoryName, false)
+	require.NoError(t, err)
+	checkGarbageCollectResponse(garbageCollectResponse)
+	listCommitsResponse, err = pfsutil.ListCommits(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(listCommitsResponse.CommitInfo))
+	require.Equal(t, commitIDs[4], listCommitsResponse.CommitInfo[0].Commit.Id)
+	require.Equal(t, commitIDs[3], listCommitsResponse.CommitInfo[1].Commit.Id)
+	require.Equal(t, "scratch", listCommitsResponse.CommitInfo[1].ParentCommit.Id)
+	for i := 0; i < numCommits; i++ {
+		for j := 0; j < testSize; j++ {
+			buffer := bytes.NewBuffer(nil)
+			err = pfsutil.GetFile(apiClient, repositoryName, "master", fmt.Sprintf("file%d-%d", i, j), 0, pfsutil.GetAll, buffer)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("hello%d-%d", i, j), buffer.String())
+		}
+	}
+
+	// the repository is now within its retention policy
+	garbageCollectResponse, err = pfsutil.GarbageCollect(apiClient, repositoryName, false)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(garbageCollectResponse.Deleted))
+	require.Equal(t, 0, len(garbageCollectResponse.Squashed))
+
+	err = pfsutil.SetRetentionPolicy(apiClient, repositoryName, nil)
+	require.NoError(t, err)
+	getRetentionPolicyResponse, err = pfsutil.GetRetentionPolicy(apiClient, repositoryName)
+	require.NoError(t, err)
+	require.Nil(t, getRetentionPolicyResponse.RetentionPolicy)
+}
+
+func testExportImport(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+	require.NoError(t, err)
+	commitID := branchResponse.Commit.Id
+	err = pfsutil.MakeDirectory(apiClient, repositoryName, commitID, "a/b")
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for i := 0; i < testSize; i++ {
+		for _, dir := range []string{"", "a/", "a/b/"} {
+			name := fmt.Sprintf("%sfile%d", dir, i)
+			files[name] = fmt.Sprintf("hello%s", name)
+			_, err = pfsutil.PutFile(apiClient, repositoryName, commitID, name, 0, strings.NewReader(files[name]))
+			require.NoError(t, err)
+		}
+	}
+	// only read commits can be exported
+	err = pfsutil.ExportCommit(apiClient, repositoryName, commitID, "", ioutil.Discard)
+	require.Error(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, commitID, "")
+	require.NoError(t, err)
+
+	archive := bytes.NewBuffer(nil)
+	err = pfsutil.ExportCommit(apiClient, repositoryName, commitID, "", archive)
+	require.NoError(t, err)
+	entries := readTar(t, bytes.NewReader(archive.Bytes()))
+	require.Equal(t, len(files)+2, len(entries))
+	require.Equal(t, "", entries["a/"])
+	require.Equal(t, "", entries["a/b/"])
+	for name, value := range files {
+		require.Equal(t, value, entries[name])
 	}
-	t.Parallel()
-	RunTest(t, testMountBig)
 
-}
+	buffer := bytes.NewBuffer(nil)
+	err = pfsutil.ExportCommit(apiClient, repositoryName, commitID, "a/b", buffer)
+	require.NoError(t, err)
+	entries = readTar(t, buffer)
+	require.Equal(t, testSize+1, len(entries))
+	for name, value := range entries {
+		require.True(t, strings.HasPrefix(name, "a/b/"))
+		require.Equal(t, files[name], value)
+	}
+	buffer = bytes.NewBuffer(nil)
+	err = pfsutil.ExportCommit(apiClient, repositoryName, commitID, "a/file0", buffer)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a/file0": files["a/file0"]}, readTar(t, buffer))
 
-func BenchmarkFuse(b *testing.B) {
-	RunBench(b, benchMount)
+	// importing into another repository reproduces the commit
+	otherRepositoryName := TestRepositoryName()
+	err = pfsutil.InitRepository(apiClient, otherRepositoryName)
+	require.NoError(t, err)
+	branchResponse, err = pfsutil.Branch(apiClient, otherRepositoryName, "scratch", "")
+	require.NoError(t, err)
+	otherCommitID := branchResponse.Commit.Id
+	_, err = pfsutil.PutFile(apiClient, otherRepositoryName, otherCommitID, "file0", 0, strings.NewReader("a file longer than the imported one"))
+	require.NoError(t, err)
+	importTarResponse, err := pfsutil.ImportTar(apiClient, otherRepositoryName, otherCommitID, bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(files)), importTarResponse.NumFiles)
+	err = pfsutil.Commit(apiClient, otherRepositoryName, otherCommitID, "")
+	require.NoError(t, err)
+	for name, value := range files {
+		buffer := bytes.NewBuffer(nil)
+		err = pfsutil.GetFile(apiClient, otherRepositoryName, otherCommitID, name, 0, pfsutil.GetAll, buffer)
+		require.NoError(t, err)
+		require.Equal(t, value, buffer.String())
+	}
+	_, err = pfsutil.ImportTar(apiClient, otherRepositoryName, otherCommitID, bytes.NewReader(archive.Bytes()))
+	require.Error(t, err)
+
+	// parent directories don't need entries of their own
+	buffer = bytes.NewBuffer(nil)
+	tarWriter := tar.NewWriter(buffer)
+	err = tarWriter.WriteHeader(&tar.Header{Name: "c/d/file", Mode: 0666, Size: 5})
+	require.NoError(t, err)
+	_, err = tarWriter.Write([]byte("hello"))
+	require.NoError(t, err)
+	err = tarWriter.WriteHeader(&tar.Header{Name: "c/link", Typeflag: tar.TypeSymlink, Linkname: "d/file"})
+	require.NoError(t, err)
+	err = tarWriter.Close()
+	require.NoError(t, err)
+	branchResponse, err = pfsutil.Branch(apiClient, otherRepositoryName, otherCommitID, "")
+	require.NoError(t, err)
+	// unsupported entries fail the import after the entries before them
+	_, err = pfsutil.ImportTar(apiClient, otherRepositoryName, branchResponse.Commit.Id, buffer)
+	require.Error(t, err)
+	getFileInfoResponse, err := pfsutil.GetFileInfo(apiClient, otherRepositoryName, branchResponse.Commit.Id, "c/d")
+	require.NoError(t, err)
+	require.Equal(t, pfs.FileType_FILE_TYPE_DIR, getFileInfoResponse.FileInfo.FileType)
+	buffer = bytes.NewBuffer(nil)
+	err = pfsutil.GetFile(apiClient, otherRepositoryName, branchResponse.Commit.Id, "c/d/file", 0, pfsutil.GetAll, buffer)
+	require.NoError(t, err)
+	require.Equal(t, "hello", buffer.String())
 }
 
-func testSimple(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+func testMirror(t *testing.T, apiClient pfs.ApiClient, otherAPIClient pfs.ApiClient) {
 	repositoryName := TestRepositoryName()
 
 	err := pfsutil.InitRepository(apiClient, repositoryName)
 	require.NoError(t, err)
+	commit := func(parentID string, change func(commitID string)) string {
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, parentID, "")
+		require.NoError(t, err)
+		change(branchResponse.Commit.Id)
+		err = pfsutil.Commit(apiClient, repositoryName, branchResponse.Commit.Id, "")
+		require.NoError(t, err)
+		return branchResponse.Commit.Id
+	}
+	putFiles := func(commitID string, prefix string, value string) {
+		for i := 0; i < testSize; i++ {
+			_, err := pfsutil.PutFile(apiClient, repositoryName, commitID, fmt.Sprintf("%sfile%d", prefix, i), 0, strings.NewReader(value))
+			require.NoError(t, err)
+		}
+	}
+	baseID := commit("master", func(commitID string) {
+		err := pfsutil.MakeDirectory(apiClient, repositoryName, commitID, "a/b")
+		require.NoError(t, err)
+		putFiles(commitID, "a/b/", "base")
+		putFiles(commitID, "", "base")
+	})
+	commit("master", func(commitID string) {
+		putFiles(commitID, "", "ours")
+		err := pfsutil.DeleteFile(apiClient, repositoryName, commitID, "a")
+		require.NoError(t, err)
+		_, err = pfsutil.PutFile(apiClient, repositoryName, commitID, "a", 0, strings.NewReader("a is a file now"))
+		require.NoError(t, err)
+	})
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "other", baseID)
+	require.NoError(t, err)
+	theirsID := commit("other", func(commitID string) {
+		putFiles(commitID, "", "theirs")
+		err := pfsutil.MakeDirectory(apiClient, repositoryName, commitID, "c")
+		require.NoError(t, err)
+	})
+	mergeResponse, err := pfsutil.Merge(apiClient, repositoryName, "master", theirsID, pfs.ConflictPolicy_CONFLICT_POLICY_THEIRS, "merge")
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, mergeResponse.Commit.Id, "")
+	require.NoError(t, err)
+	// write commits aren't copied
+	_, err = pfsutil.Branch(apiClient, repositoryName, "master", "")
+	require.NoError(t, err)
 
-	getCommitInfoResponse, err := pfsutil.GetCommitInfo(apiClient, repositoryName, "scratch")
+	checkMirrored := func(fromAPIClient pfs.ApiClient, toAPIClient pfs.ApiClient) {
+		fromListCommitsResponse, err := pfsutil.ListCommits(fromAPIClient, repositoryName)
+		require.NoError(t, err)
+		toListCommitsResponse, err := pfsutil.ListCommits(toAPIClient, repositoryName)
+		require.NoError(t, err)
+		toCommitInfos := make(map[string]*pfs.CommitInfo)
+		for _, commitInfo := range toListCommitsResponse.CommitInfo {
+			toCommitInfos[commitInfo.Commit.Id] = commitInfo
+		}
+		for _, commitInfo := range fromListCommitsResponse.CommitInfo {
+			if commitInfo.CommitType != pfs.CommitType_COMMIT_TYPE_READ {
+				continue
+			}
+			toCommitInfo := toCommitInfos[commitInfo.Commit.Id]
+			require.NotNil(t, toCommitInfo)
+			require.Equal(t, pfs.CommitType_COMMIT_TYPE_READ, toCommitInfo.CommitType)
+			require.Equal(t, commitInfo.ParentCommit, toCommitInfo.ParentCommit)
+			require.Equal(t, commitInfo.MergeParentCommit, toCommitInfo.MergeParentCommit)
+			require.Equal(t, commitInfo.Message, toCommitInfo.Message)
+			fromArchive := bytes.NewBuffer(nil)
+			err = pfsutil.ExportCommit(fromAPIClient, repositoryName, commitInfo.Commit.Id, "", fromArchive)
+			require.NoError(t, err)
+			toArchive := bytes.NewBuffer(nil)
+			err = pfsutil.ExportCommit(toAPIClient, repositoryName, commitInfo.Commit.Id, "", toArchive)
+			require.NoError(t, err)
+			require.Equal(t, readTar(t, fromArchive), readTar(t, toArchive))
+		}
+		fromListBranchesResponse, err := pfsutil.ListBranches(fromAPIClient, repositoryName)
+		require.NoError(t, err)
+		toListBranchesResponse, err := pfsutil.ListBranches(toAPIClient, repositoryName)
+		require.NoError(t, err)
+		require.Equal(t, fromListBranchesResponse.BranchInfo, toListBranchesResponse.BranchInfo)
+	}
+	mirrorer := mirror.NewMirrorer(apiClient, otherAPIClient)
+	commits, err := mirrorer.Mirror(repositoryName)
 	require.NoError(t, err)
-	require.NotNil(t, getCommitInfoResponse)
-	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.Commit.Id)
-	require.Equal(t, pfs.CommitType_COMMIT_TYPE_READ, getCommitInfoResponse.CommitInfo.CommitType)
-	require.Nil(t, getCommitInfoResponse.CommitInfo.ParentCommit)
-	scratchCommitInfo := getCommitInfoResponse.CommitInfo
+	require.Equal(t, 4, len(commits))
+	checkMirrored(apiClient, otherAPIClient)
 
-	listCommitsResponse, err := pfsutil.ListCommits(apiClient, repositoryName)
+	// only new commits are copied
+	commits, err = mirrorer.Mirror(repositoryName)
 	require.NoError(t, err)
-	require.Equal(t, 1, len(listCommitsResponse.CommitInfo))
-	require.Equal(t, scratchCommitInfo, listCommitsResponse.CommitInfo[0])
+	require.Equal(t, 0, len(commits))
+	newID := commit("master", func(commitID string) {
+		putFiles(commitID, "c/", "new")
+	})
+	commits, err = mirrorer.Mirror(repositoryName)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(commits))
+	require.Equal(t, newID, commits[0].Id)
+	checkMirrored(apiClient, otherAPIClient)
 
-	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch")
+	// and back again
+	commits, err = mirror.NewMirrorer(otherAPIClient, apiClient).Mirror(repositoryName)
 	require.NoError(t, err)
-	require.NotNil(t, branchResponse)
-	newCommitID := branchResponse.Commit.Id
+	require.Equal(t, 0, len(commits))
 
-	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, newCommitID)
+	// a branch that moved on both sides is left alone
+	branchResponse, err := pfsutil.Branch(otherAPIClient, repositoryName, "master", "")
 	require.NoError(t, err)
-	require.NotNil(t, getCommitInfoResponse)
-	require.Equal(t, newCommitID, getCommitInfoResponse.CommitInfo.Commit.Id)
-	require.Equal(t, pfs.CommitType_COMMIT_TYPE_WRITE, getCommitInfoResponse.CommitInfo.CommitType)
-	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.ParentCommit.Id)
-	newCommitInfo := getCommitInfoResponse.CommitInfo
+	err = pfsutil.Commit(otherAPIClient, repositoryName, branchResponse.Commit.Id, "")
+	require.NoError(t, err)
+	commit("master", func(commitID string) {
+		putFiles(commitID, "c/", "diverged")
+	})
+	commits, err = mirrorer.Mirror(repositoryName)
+	require.Error(t, err)
+	require.Equal(t, 1, len(commits))
+	getCommitInfoResponse, err := pfsutil.GetCommitInfo(otherAPIClient, repositoryName, "master")
+	require.NoError(t, err)
+	require.Equal(t, branchResponse.Commit.Id, getCommitInfoResponse.CommitInfo.Commit.Id)
+}
 
-	listCommitsResponse, err = pfsutil.ListCommits(apiClient, repositoryName)
+func testReshard(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
+	repositoryName := TestRepositoryName()
+	numShards := uint64(testShardsPerServer * testNumServers)
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
 	require.NoError(t, err)
-	require.Equal(t, 2, len(listCommitsResponse.CommitInfo))
-	require.Equal(t, newCommitInfo, listCommitsResponse.CommitInfo[0])
-	require.Equal(t, scratchCommitInfo, listCommitsResponse.CommitInfo[1])
 
-	err = pfsutil.MakeDirectory(apiClient, repositoryName, newCommitID, "a/b")
+	// commitFiles commits files on a new commit branched from commitID and
+	// deletes deleted from it
+	commitFiles := func(commitID string, files map[string]string, deleted ...string) string {
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, commitID, "")
+		require.NoError(t, err)
+		for _, path := range deleted {
+			err = pfsutil.DeleteFile(apiClient, repositoryName, branchResponse.Commit.Id, path)
+			require.NoError(t, err)
+		}
+		err = pfsutil.MakeDirectory(apiClient, repositoryName, branchResponse.Commit.Id, "dir")
+		require.NoError(t, err)
+		for path, content := range files {
+			_, err = pfsutil.PutFile(apiClient, repositoryName, branchResponse.Commit.Id, path, 0, strings.NewReader(content))
+			require.NoError(t, err)
+		}
+		err = pfsutil.Commit(apiClient, repositoryName, branchResponse.Commit.Id, "")
+		require.NoError(t, err)
+		return branchResponse.Commit.Id
+	}
+	// checkFiles checks that commitID has exactly files, each listed once
+	checkFiles := func(commitID string, files map[string]string) {
+		var paths []string
+		err := pfsutil.ListFilesStream(apiClient, repositoryName, commitID, "", true, "", 0, 1,
+			func(fileInfo *pfs.FileInfo) error {
+				if fileInfo.FileType != pfs.FileType_FILE_TYPE_DIR {
+					paths = append(paths, fileInfo.Path.Path)
+				}
+				return nil
+			},
+		)
+		require.NoError(t, err)
+		require.Equal(t, len(files), len(paths))
+		for _, path := range paths {
+			content, ok := files[path]
+			require.True(t, ok, path)
+			buffer := bytes.NewBuffer(nil)
+			err = pfsutil.GetFile(apiClient, repositoryName, commitID, path, 0, pfsutil.GetAll, buffer)
+			require.NoError(t, err)
+			require.Equal(t, content, buffer.String())
+		}
+	}
+
+	first := make(map[string]string)
+	for i := 0; i < testSize; i++ {
+		first[fmt.Sprintf("dir/file%d", i)] = fmt.Sprintf("first %d", i)
+	}
+	firstID := commitFiles("master", first)
+	second := make(map[string]string)
+	changed := make(map[string]string)
+	var deleted []string
+	for i := 0; i < testSize; i++ {
+		path := fmt.Sprintf("dir/file%d", i)
+		switch i % 3 {
+		case 0:
+			changed[path] = fmt.Sprintf("second %d", i)
+			second[path] = changed[path]
+		case 1:
+			second[path] = first[path]
+		default:
+			deleted = append(deleted, path)
+		}
+	}
+	secondID := commitFiles(firstID, changed, deleted...)
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "master", secondID)
 	require.NoError(t, err)
-	err = pfsutil.MakeDirectory(apiClient, repositoryName, newCommitID, "a/c")
+	err = pfsutil.CreateBranch(apiClient, repositoryName, "experiment", firstID)
+	require.NoError(t, err)
+	theirsID := commitFiles("experiment", map[string]string{"dir/theirs": "theirs"})
+	mergeResponse, err := pfsutil.Merge(apiClient, repositoryName, "master", "experiment", pfs.ConflictPolicy_CONFLICT_POLICY_OURS, "")
+	require.NoError(t, err)
+	err = pfsutil.Commit(apiClient, repositoryName, mergeResponse.Commit.Id, "")
 	require.NoError(t, err)
+	theirs := map[string]string{"dir/theirs": "theirs"}
+	merged := map[string]string{"dir/theirs": "theirs"}
+	for path, content := range first {
+		theirs[path] = content
+	}
+	for path, content := range second {
+		merged[path] = content
+	}
 
-	var wg sync.WaitGroup
+	// an open write commit is migrated and can be written afterwards
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, mergeResponse.Commit.Id, "")
+	require.NoError(t, err)
+	writeID := branchResponse.Commit.Id
+	open := make(map[string]string)
+	for path, content := range merged {
+		open[path] = content
+	}
 	for i := 0; i < testSize; i++ {
-		i := i
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			_, iErr := pfsutil.PutFile(apiClient, repositoryName, newCommitID,
-				fmt.Sprintf("a/b/file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
-			require.NoError(t, iErr)
-			_, iErr = pfsutil.PutFile(apiClient, repositoryName, newCommitID,
-				fmt.Sprintf("a/c/file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
-			require.NoError(t, iErr)
-		}()
+		path := fmt.Sprintf("dir/open%d", i)
+		open[path] = fmt.Sprintf("open %d", i)
+		_, err = pfsutil.PutFile(apiClient, repositoryName, writeID, path, 0, strings.NewReader(open[path]))
+		require.NoError(t, err)
 	}
-	wg.Wait()
 
-	err = pfsutil.Commit(apiClient, repositoryName, newCommitID)
+	// another reshard stops this one, which doesn't abort the other's
+	_, err = internalAPIClient.RunReshardPhase(
+		context.Background(),
+		&pfs.ReshardPhaseRequest{
+			ReshardPhase: pfs.ReshardPhase_RESHARD_PHASE_START,
+			NumShards:    2 * numShards,
+			ReshardId:    "other",
+		},
+	)
+	require.NoError(t, err)
+	_, err = pfsutil.Reshard(apiClient, 2*numShards)
+	require.Error(t, err)
+	_, err = pfsutil.Reshard(apiClient, 2*numShards)
+	require.Error(t, err)
+	_, err = internalAPIClient.RunReshardPhase(
+		context.Background(),
+		&pfs.ReshardPhaseRequest{
+			ReshardPhase: pfs.ReshardPhase_RESHARD_PHASE_ABORT,
+			NumShards:    2 * numShards,
+			ReshardId:    "other",
+		},
+	)
 	require.NoError(t, err)
 
-	getCommitInfoResponse, err = pfsutil.GetCommitInfo(apiClient, repositoryName, newCommitID)
+	_, err = pfsutil.Reshard(apiClient, numShards-1)
+	require.Error(t, err)
+	reshardResponse, err := pfsutil.Reshard(apiClient, 2*numShards)
 	require.NoError(t, err)
-	require.NotNil(t, getCommitInfoResponse)
-	require.Equal(t, newCommitID, getCommitInfoResponse.CommitInfo.Commit.Id)
-	require.Equal(t, pfs.CommitType_COMMIT_TYPE_READ, getCommitInfoResponse.CommitInfo.CommitType)
-	require.Equal(t, "scratch", getCommitInfoResponse.CommitInfo.ParentCommit.Id)
+	// the new shards get some of the files each commit wrote, not all of
+	// them, theirs and the merge each wrote dir/theirs
+	require.True(t, reshardResponse.NumFiles > 0)
+	require.True(t, reshardResponse.NumFiles < uint64(len(first)+len(changed)+2+testSize))
+	reshardResponse, err = pfsutil.Reshard(apiClient, 2*numShards)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), reshardResponse.NumFiles)
+
+	checkFiles(firstID, first)
+	checkFiles(secondID, second)
+	checkFiles(theirsID, theirs)
+	checkFiles(mergeResponse.Commit.Id, merged)
+	listChangedFilesResponse, err := pfsutil.ListChangedFiles(apiClient, repositoryName, firstID, secondID, 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, len(changed)+len(deleted), len(listChangedFilesResponse.Change))
+	// the old shards no longer hold the files that moved
+	var size uint64
+	for _, content := range merged {
+		size += uint64(len(content))
+	}
+	commitInfo, err := pfsutil.GetCommitInfo(apiClient, repositoryName, mergeResponse.Commit.Id)
+	require.NoError(t, err)
+	require.Equal(t, size, commitInfo.CommitInfo.SizeBytes)
 
-	wg = sync.WaitGroup{}
 	for i := 0; i < testSize; i++ {
-		i := i
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			buffer := bytes.NewBuffer(nil)
-			iErr := pfsutil.GetFile(apiClient, repositoryName, newCommitID,
-				fmt.Sprintf("a/b/file%d", i), 0, pfsutil.GetAll, buffer)
-			require.NoError(t, iErr)
-			require.Equal(t, fmt.Sprintf("hello%d", i), buffer.String())
-			buffer = bytes.NewBuffer(nil)
-			iErr = pfsutil.GetFile(apiClient, repositoryName, newCommitID,
-				fmt.Sprintf("a/c/file%d", i), 0, pfsutil.GetAll, buffer)
-			require.NoError(t, iErr)
-			require.Equal(t, fmt.Sprintf("hello%d", i), buffer.String())
-		}()
+		path := fmt.Sprintf("dir/written%d", i)
+		open[path] = fmt.Sprintf("written %d", i)
+		_, err = pfsutil.PutFile(apiClient, repositoryName, writeID, path, 0, strings.NewReader(open[path]))
+		require.NoError(t, err)
 	}
-	wg.Wait()
+	err = pfsutil.Commit(apiClient, repositoryName, writeID, "")
+	require.NoError(t, err)
+	checkFiles(writeID, open)
 
-	listFilesResponse, err := pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "a/b", 0, 1)
+	// writes go to the new shards
+	third := map[string]string{"dir/file0": "third"}
+	thirdID := commitFiles("master", third, "dir")
+	checkFiles(thirdID, third)
+	checkFiles(mergeResponse.Commit.Id, merged)
+}
+
+func testReplicaReads(t *testing.T, apiClient pfs.ApiClient, addresser route.Addresser, servers map[string]server.CombinedAPIServer) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
 	require.NoError(t, err)
-	require.Equal(t, testSize, len(listFilesResponse.FileInfo))
-	listFilesResponse, err = pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "a/c", 0, 1)
+
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
 	require.NoError(t, err)
-	require.Equal(t, testSize, len(listFilesResponse.FileInfo))
+	newCommitID := branchResponse.Commit.Id
+	for i := 0; i < testSize; i++ {
+		_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID, fmt.Sprintf("file%d", i), 0, strings.NewReader(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
 
-	var fileInfos [7][]*pfs.FileInfo
-	wg = sync.WaitGroup{}
-	for i := 0; i < 7; i++ {
-		i := i
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			listFilesResponse, iErr := pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "a/b", uint64(i), 7)
-			require.NoError(t, iErr)
-			fileInfos[i] = listFilesResponse.FileInfo
-		}()
+	getFile := func(i int, readConsistency pfs.ReadConsistency) (string, error) {
+		path := fmt.Sprintf("file%d", i)
+		var buffer bytes.Buffer
+		if err := pfsutil.GetFileWithReadConsistency(apiClient, repositoryName, newCommitID, path, 0, pfsutil.GetAll, readConsistency, &buffer); err != nil {
+			return "", err
+		}
+		getFileInfoResponse, err := pfsutil.GetFileInfoWithReadConsistency(apiClient, repositoryName, newCommitID, path, readConsistency)
+		if err != nil {
+			return "", err
+		}
+		if getFileInfoResponse.FileInfo == nil || getFileInfoResponse.FileInfo.SizeBytes != uint64(buffer.Len()) {
+			return "", fmt.Errorf("wrong file info %v for %s", getFileInfoResponse.FileInfo, path)
+		}
+		return buffer.String(), nil
 	}
-	wg.Wait()
-	count := 0
-	for i := 0; i < 7; i++ {
-		count += len(fileInfos[i])
+
+	// replicas don't have write commits, their reads go to the masters
+	for i := 0; i < testSize; i++ {
+		value, err := getFile(i, pfs.ReadConsistency_READ_CONSISTENCY_ANY)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("hello%d", i), value)
+	}
+
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
+	require.NoError(t, err)
+	for i := 0; i < testSize; i++ {
+		for _, readConsistency := range []pfs.ReadConsistency{
+			pfs.ReadConsistency_READ_CONSISTENCY_NONE,
+			pfs.ReadConsistency_READ_CONSISTENCY_ANY,
+			pfs.ReadConsistency_READ_CONSISTENCY_MASTER,
+		} {
+			value, err := getFile(i, readConsistency)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("hello%d", i), value)
+		}
+	}
+
+	// without masters the replicas still serve the read commit
+	for shard := 0; shard < testShardsPerServer*testNumServers; shard++ {
+		require.NoError(t, addresser.DeleteMasterAddress(shard))
+	}
+	for i := 0; i < testSize; i++ {
+		value, err := getFile(i, pfs.ReadConsistency_READ_CONSISTENCY_ANY)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("hello%d", i), value)
+		_, err = getFile(i, pfs.ReadConsistency_READ_CONSISTENCY_MASTER)
+		require.Error(t, err)
+	}
+}
+
+func testBackfill(t *testing.T, apiClient pfs.ApiClient, addresser route.Addresser, servers map[string]server.CombinedAPIServer) {
+	repositoryName := TestRepositoryName()
+
+	err := pfsutil.InitRepository(apiClient, repositoryName)
+	require.NoError(t, err)
+
+	// commitFiles overwrites every file with value on top of parentID and
+	// returns the commit, the values all have the same length
+	commitFiles := func(parentID string, value string) string {
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, parentID, "")
+		require.NoError(t, err)
+		commitID := branchResponse.Commit.Id
+		for i := 0; i < testSize; i++ {
+			_, err = pfsutil.PutFile(apiClient, repositoryName, commitID, fmt.Sprintf("file%d", i), 0, strings.NewReader(value))
+			require.NoError(t, err)
+		}
+		require.NoError(t, pfsutil.Commit(apiClient, repositoryName, commitID, ""))
+		return commitID
+	}
+	values := make(map[string]string)
+	firstID := commitFiles("scratch", "value1")
+	values[firstID] = "value1"
+	secondID := commitFiles(firstID, "value2")
+	values[secondID] = "value2"
+
+	// every shard gets a new replica, which backfills the commits so far
+	shardToMasterAddress, err := addresser.GetShardToMasterAddress()
+	require.NoError(t, err)
+	shardToReplicaAddresses, err := addresser.GetShardToReplicaAddresses()
+	require.NoError(t, err)
+	newReplicaAddresses := make(map[int]string)
+	for shard, masterAddress := range shardToMasterAddress {
+		for address, server := range servers {
+			if address == masterAddress || shardToReplicaAddresses[shard][address] {
+				continue
+			}
+			require.NoError(t, server.Replica(shard))
+			newReplicaAddresses[shard] = address
+			break
+		}
+		require.NotEqual(t, "", newReplicaAddresses[shard])
+	}
+	// getVersions returns the versions of shard and the highest of them
+	getVersions := func(shard int) (map[string]uint64, uint64) {
+		versions, err := addresser.GetShardVersions(shard)
+		require.NoError(t, err)
+		var max uint64
+		for _, version := range versions {
+			if version > max {
+				max = version
+			}
+		}
+		return versions, max
+	}
+	// requireCaughtUp checks whether the new replicas have the highest
+	// version of their shards
+	requireCaughtUp := func(caughtUp bool) {
+		for shard, address := range newReplicaAddresses {
+			versions, max := getVersions(shard)
+			require.Equal(t, caughtUp, versions[address] == max)
+		}
+	}
+	// the new replicas aren't advertised yet so they miss this commit
+	thirdID := commitFiles(secondID, "value3")
+	values[thirdID] = "value3"
+	requireCaughtUp(false)
+	for shard, address := range newReplicaAddresses {
+		for oldAddress := range shardToReplicaAddresses[shard] {
+			require.NoError(t, addresser.DeleteReplicaAddress(shard, oldAddress))
+		}
+		require.NoError(t, addresser.SetReplicaAddress(shard, address, 0))
+	}
+	// pushing this one makes them pull the one they missed
+	fourthID := commitFiles(thirdID, "value4")
+	values[fourthID] = "value4"
+	requireCaughtUp(true)
+
+	// deleting a commit is one more version of every shard
+	fifthID := commitFiles(fourthID, "value5")
+	prevVersions := make(map[int]uint64)
+	for shard := range newReplicaAddresses {
+		_, prevVersions[shard] = getVersions(shard)
+	}
+	require.NoError(t, pfsutil.DeleteCommit(apiClient, repositoryName, fifthID))
+	for shard := range newReplicaAddresses {
+		_, max := getVersions(shard)
+		require.Equal(t, prevVersions[shard]+1, max)
+	}
+	requireCaughtUp(true)
+
+	// without masters only the new replicas have the commits
+	for shard := range shardToMasterAddress {
+		require.NoError(t, addresser.DeleteMasterAddress(shard))
+	}
+	for commitID, value := range values {
+		for i := 0; i < testSize; i++ {
+			var buffer bytes.Buffer
+			err := pfsutil.GetFile(apiClient, repositoryName, commitID, fmt.Sprintf("file%d", i), 0, pfsutil.GetAll, &buffer)
+			require.NoError(t, err)
+			require.Equal(t, value, buffer.String())
+		}
 	}
-	require.Equal(t, testSize, count)
 }
 
 func testMount(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.InternalApiClient) {
@@ -184,7 +1766,7 @@ func testMount(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.Inte
 	_, err = os.Stat(filepath.Join(directory, "scratch"))
 	require.NoError(t, err)
 
-	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch")
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
 	require.NoError(t, err)
 	require.NotNil(t, branchResponse)
 	newCommitID := branchResponse.Commit.Id
@@ -209,7 +1791,7 @@ func testMount(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.Inte
 	_, err = pfsutil.PutFile(apiClient, repositoryName, newCommitID, "big2", 0, bytes.NewReader(bigValue))
 	require.NoError(t, err)
 
-	err = pfsutil.Commit(apiClient, repositoryName, newCommitID)
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
 	require.NoError(t, err)
 
 	fInfo, err := os.Stat(filepath.Join(directory, newCommitID, "foo"))
@@ -250,7 +1832,7 @@ func testMountBig(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.I
 	_, err = os.Stat(filepath.Join(directory, "scratch"))
 	require.NoError(t, err)
 
-	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch")
+	branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
 	require.NoError(t, err)
 	require.NotNil(t, branchResponse)
 	newCommitID := branchResponse.Commit.Id
@@ -271,7 +1853,7 @@ func testMountBig(t *testing.T, apiClient pfs.ApiClient, internalAPIClient pfs.I
 	}
 	wg.Wait()
 
-	err = pfsutil.Commit(apiClient, repositoryName, newCommitID)
+	err = pfsutil.Commit(apiClient, repositoryName, newCommitID, "")
 	require.NoError(t, err)
 
 	wg = sync.WaitGroup{}
@@ -316,7 +1898,7 @@ func benchMount(b *testing.B, apiClient pfs.ApiClient) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch")
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
 		if err != nil {
 			b.Error(err)
 		}
@@ -335,8 +1917,49 @@ func benchMount(b *testing.B, apiClient pfs.ApiClient) {
 			}(j)
 		}
 		wg.Wait()
-		if err := pfsutil.Commit(apiClient, repositoryName, newCommitID); err != nil {
+		if err := pfsutil.Commit(apiClient, repositoryName, newCommitID, ""); err != nil {
 			b.Error(err)
 		}
 	}
 }
+
+// benchFanOut times the operations that call every server.
+func benchFanOut(b *testing.B, apiClient pfs.ApiClient) {
+	repositoryName := TestRepositoryName()
+	if err := pfsutil.InitRepository(apiClient, repositoryName); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		branchResponse, err := pfsutil.Branch(apiClient, repositoryName, "scratch", "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		newCommitID := branchResponse.Commit.Id
+		if err := pfsutil.MakeDirectory(apiClient, repositoryName, newCommitID, "a"); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := pfsutil.ListFiles(apiClient, repositoryName, newCommitID, "a", 0, 1); err != nil {
+			b.Fatal(err)
+		}
+		if err := pfsutil.Commit(apiClient, repositoryName, newCommitID, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func readTar(t *testing.T, reader io.Reader) map[string]string {
+	result := make(map[string]string)
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return result
+		}
+		require.NoError(t, err)
+		value, err := ioutil.ReadAll(tarReader)
+		require.NoError(t, err)
+		result[header.Name] = string(value)
+	}
+}
@@ -0,0 +1,56 @@
+package concurrent
+
+import (
+	"strings"
+	"sync"
+)
+
+// Errors is the error Run returns when more than one function fails.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Run calls every function in funcs with at most parallelism of them running
+// at once, a parallelism less than 1 runs them all at once. It waits for all
+// of them to return. If one function fails its error is returned, if more
+// fail an Errors with all of them is returned.
+func Run(parallelism int, funcs ...func() error) error {
+	if parallelism < 1 || parallelism > len(funcs) {
+		parallelism = len(funcs)
+	}
+	semaphore := make(chan struct{}, parallelism)
+	errs := make([]error, len(funcs))
+	var waitGroup sync.WaitGroup
+	for i, f := range funcs {
+		i := i
+		f := f
+		semaphore <- struct{}{}
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			errs[i] = f()
+			<-semaphore
+		}()
+	}
+	waitGroup.Wait()
+	var result Errors
+	for _, err := range errs {
+		if err != nil {
+			result = append(result, err)
+		}
+	}
+	switch len(result) {
+	case 0:
+		return nil
+	case 1:
+		return result[0]
+	default:
+		return result
+	}
+}
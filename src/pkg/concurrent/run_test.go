@@ -0,0 +1,51 @@
+package concurrent
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBoundsParallelism(t *testing.T) {
+	var running int32
+	var maxRunning int32
+	funcs := make([]func() error, 16)
+	for i := range funcs {
+		funcs[i] = func() error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		}
+	}
+	require.NoError(t, Run(4, funcs...))
+	require.Equal(t, int32(4), atomic.LoadInt32(&maxRunning))
+	maxRunning = 0
+	require.NoError(t, Run(0, funcs...))
+	require.True(t, atomic.LoadInt32(&maxRunning) > 4)
+}
+
+func TestRunReturnsErrors(t *testing.T) {
+	errFoo := errors.New("foo")
+	errBar := errors.New("bar")
+	var called int32
+	succeed := func() error {
+		atomic.AddInt32(&called, 1)
+		return nil
+	}
+	require.Equal(t, errFoo, Run(2, succeed, func() error { return errFoo }, succeed))
+	require.Equal(t, int32(2), atomic.LoadInt32(&called))
+	err := Run(2, func() error { return errFoo }, succeed, func() error { return errBar })
+	require.Equal(t, Errors{errFoo, errBar}, err)
+	require.Equal(t, "foo; bar", err.Error())
+	require.NoError(t, Run(2))
+}
@@ -1,5 +1,10 @@
 package discovery
 
+var (
+	// HoldTTL is the ttl, in seconds, of the keys Hold sets.
+	HoldTTL uint64 = 20
+)
+
 type Client interface {
 	// Close closes the underlying connection.
 	Close() error
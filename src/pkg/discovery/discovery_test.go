@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/stretchr/testify/require"
@@ -15,6 +16,42 @@ func TestMockClient(t *testing.T) {
 	runTest(t, NewMockClient())
 }
 
+func TestMockCheckAndSet(t *testing.T) {
+	t.Parallel()
+	client := NewMockClient()
+	require.NoError(t, client.CheckAndSet("foo", "one", 0, ""))
+	require.Error(t, client.CheckAndSet("foo", "two", 0, ""))
+	require.Error(t, client.CheckAndSet("foo", "two", 0, "three"))
+	require.NoError(t, client.CheckAndSet("foo", "two", 0, "one"))
+	value, ok, err := client.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "two", value)
+}
+
+func TestMockHold(t *testing.T) {
+	t.Parallel()
+	client := NewMockClient()
+	cancel := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- client.Hold("foo", "one", "", cancel)
+	}()
+	// the hold is taken before Hold blocks
+	for {
+		value, ok, err := client.Get("foo")
+		require.NoError(t, err)
+		if ok {
+			require.Equal(t, "one", value)
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	require.Error(t, client.Hold("foo", "two", "", cancel))
+	close(cancel)
+	require.NoError(t, <-done)
+}
+
 func TestEtcdClient(t *testing.T) {
 	t.Parallel()
 	client, err := getEtcdClient()
@@ -9,10 +9,6 @@ import (
 	"github.com/coreos/go-etcd/etcd"
 )
 
-var (
-	holdTTL uint64 = 20
-)
-
 type etcdClient struct {
 	client *etcd.Client
 }
@@ -145,27 +141,26 @@ func (c *etcdClient) Delete(key string) error {
 func (c *etcdClient) CheckAndSet(key string, value string, ttl uint64, oldValue string) error {
 	var err error
 	if oldValue == "" {
-		_, err = c.client.Create(key, value, holdTTL)
+		_, err = c.client.Create(key, value, HoldTTL)
 	} else {
-		_, err = c.client.CompareAndSwap(key, value, holdTTL, oldValue, 0)
+		_, err = c.client.CompareAndSwap(key, value, HoldTTL, oldValue, 0)
 	}
 	return err
 }
 
 func (c *etcdClient) Hold(key string, value string, oldValue string, cancel chan bool) error {
-	if err := c.CheckAndSet(key, value, holdTTL, oldValue); err != nil {
+	if err := c.CheckAndSet(key, value, HoldTTL, oldValue); err != nil {
 		return err
 	}
 	go func() {
 		for {
 			select {
 			case <-cancel:
-				break
-			case <-time.After(time.Second * time.Duration(holdTTL/2)):
-				if err := c.CheckAndSet(key, value, holdTTL, value); err != nil {
-					break
+				return
+			case <-time.After(time.Second * time.Duration(HoldTTL/2)):
+				if err := c.CheckAndSet(key, value, HoldTTL, value); err != nil {
+					return
 				}
-
 			}
 		}
 	}()
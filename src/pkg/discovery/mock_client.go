@@ -59,7 +59,8 @@ func (c *mockClient) GetAll(keyPrefix string) (map[string]string, error) {
 		if (record.expires != time.Time{}) && now.After(record.expires) {
 			delete(c.records, key)
 		}
-		if strings.HasPrefix(key, keyPrefix) && !record.directory {
+		// match etcd, which returns the key itself or the keys in its directory
+		if (key == keyPrefix || strings.HasPrefix(key, strings.TrimSuffix(keyPrefix, "/")+"/")) && !record.directory {
 			result[key] = record.data
 		}
 	}
@@ -117,6 +118,17 @@ func (c *mockClient) CheckAndSet(key string, value string, ttl uint64, oldValue
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	oldRecord, ok := c.records[key]
+	if ok && (oldRecord.expires != time.Time{}) && time.Now().After(oldRecord.expires) {
+		delete(c.records, key)
+		ok = false
+	}
+	// match etcd, where an empty oldValue means the key must not exist
+	if oldValue == "" {
+		if ok {
+			return fmt.Errorf("pachyderm: key %s already exists", key)
+		}
+		return c.unsafeSet(key, value, ttl)
+	}
 	if !ok {
 		return fmt.Errorf("pachyderm: key %s not found", key)
 	}
@@ -153,6 +165,17 @@ func (c *mockClient) unsafeSet(key string, value string, ttl uint64) error {
 }
 
 func (c *mockClient) Hold(key string, value string, oldValue string, cancel chan bool) error {
-	// TODO jdoliner
-	return nil
+	if err := c.CheckAndSet(key, value, HoldTTL, oldValue); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-cancel:
+			return nil
+		case <-time.After(time.Second * time.Duration(HoldTTL/2)):
+			if err := c.CheckAndSet(key, value, HoldTTL, value); err != nil {
+				return err
+			}
+		}
+	}
 }
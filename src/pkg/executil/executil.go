@@ -11,6 +11,7 @@ import (
 	"sync"
 
 	"go.pedge.io/protolog"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -27,6 +28,7 @@ func SetDebug(d bool) {
 }
 
 type RunOptions struct {
+	ctx    context.Context
 	stdin  io.Reader
 	stdout io.Writer
 	stderr io.Writer
@@ -44,6 +46,19 @@ func RunStdout(stdout io.Writer, args ...string) error {
 	return RunWithOptions(RunOptions{stdout: stdout}, args...)
 }
 
+// RunContext is like Run but kills the command if ctx is done before it exits.
+func RunContext(ctx context.Context, args ...string) error {
+	return RunWithOptions(RunOptions{ctx: ctx}, args...)
+}
+
+func RunStdinContext(ctx context.Context, stdin io.Reader, args ...string) error {
+	return RunWithOptions(RunOptions{ctx: ctx, stdin: stdin}, args...)
+}
+
+func RunStdoutContext(ctx context.Context, stdout io.Writer, args ...string) error {
+	return RunWithOptions(RunOptions{ctx: ctx, stdout: stdout}, args...)
+}
+
 func RunWithOptions(runOptions RunOptions, args ...string) error {
 	if len(args) == 0 {
 		return errors.New("run called with no args")
@@ -64,7 +79,7 @@ func RunWithOptions(runOptions RunOptions, args ...string) error {
 	cmd.Stderr = stderr
 	argsString := strings.Join(args, " ")
 	//protolog.Debug(&RunningCommand{Args: argsString})
-	if err := cmd.Run(); err != nil {
+	if err := run(runOptions.ctx, cmd); err != nil {
 		if debugStderr != nil {
 			data, _ := ioutil.ReadAll(debugStderr)
 			if data != nil && len(data) > 0 {
@@ -75,3 +90,27 @@ func RunWithOptions(runOptions RunOptions, args ...string) error {
 	}
 	return nil
 }
+
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	if ctx == nil {
+		return cmd.Run()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		_ = cmd.Process.Kill()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -22,6 +22,17 @@ func TimestampToTime(timestamp *google_protobuf.Timestamp) time.Time {
 	).UTC()
 }
 
+func DurationToProtoDuration(duration time.Duration) *google_protobuf.Duration {
+	return &google_protobuf.Duration{
+		Seconds: int64(duration / time.Second),
+		Nanos:   int32(duration % time.Second),
+	}
+}
+
+func ProtoDurationToDuration(protoDuration *google_protobuf.Duration) time.Duration {
+	return time.Duration(protoDuration.Seconds)*time.Second + time.Duration(protoDuration.Nanos)
+}
+
 func TimestampLess(i *google_protobuf.Timestamp, j *google_protobuf.Timestamp) bool {
 	if i == nil {
 		return true
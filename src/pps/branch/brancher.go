@@ -102,7 +102,7 @@ func (b *brancher) getOutputCommitID(
 	}
 	return b.branch(
 		outputRepositoryName,
-		"scratch",
+		"master",
 	)
 }
 
@@ -115,6 +115,7 @@ func (b *brancher) CommitOutstanding() error {
 			b.pfsAPIClient,
 			repositoryName,
 			commitID,
+			"",
 		); err != nil {
 			return err
 		}
@@ -161,6 +162,7 @@ func (b *brancher) branch(
 		b.pfsAPIClient,
 		repositoryName,
 		commitID,
+		"",
 	)
 	if err != nil {
 		return "", err